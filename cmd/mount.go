@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -401,6 +402,23 @@ func (h *storageHolder) Shutdown() {
 	object.Shutdown(h.ObjectStorage)
 }
 
+// checkStorage fails fast if the object storage can't be accessed, with a hint for the cause.
+func checkStorage(blob object.ObjectStorage) error {
+	err := object.Check(blob)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, object.ErrPermission):
+		return fmt.Errorf("%s, please check the access key and secret key, and their permissions to the bucket", err)
+	case errors.Is(err, object.ErrBucketNotFound):
+		return fmt.Errorf("%s, please check the bucket, which is created by `juicefs format`", err)
+	case errors.Is(err, object.ErrUnreachable):
+		return fmt.Errorf("%s, please check the endpoint of the bucket and the network (DNS, proxy and firewall)", err)
+	}
+	logger.Warnf("Check %s: %s", blob, err)
+	return nil
+}
+
 func NewReloadableStorage(format *meta.Format, cli meta.Meta, patch func(*meta.Format)) (object.ObjectStorage, error) {
 	if patch != nil {
 		patch(format)
@@ -632,6 +650,9 @@ func mount(c *cli.Context) error {
 			return fmt.Errorf("object storage: %s", err)
 		}
 		logger.Infof("Data use %s", blob)
+		if err = checkStorage(blob); err != nil {
+			return fmt.Errorf("object storage: %s", err)
+		}
 
 	}
 
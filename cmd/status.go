@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/juicedata/juicefs/pkg/meta"
+	"github.com/juicedata/juicefs/pkg/object"
 	"github.com/juicedata/juicefs/pkg/utils"
 
 	"github.com/urfave/cli/v2"
@@ -42,7 +43,10 @@ S3-gateway and WebDAV) that are connected with the metadata engine.
 NOTE: Read-only session is not listed since it cannot register itself in the metadata.
 
 Examples:
-$ juicefs status redis://localhost`,
+$ juicefs status redis://localhost
+
+# Count the objects in the object storage
+$ juicefs status redis://localhost --usage`,
 		Flags: []cli.Flag{
 			&cli.Uint64Flag{
 				Name:    "session",
@@ -54,6 +58,10 @@ $ juicefs status redis://localhost`,
 				Aliases: []string{"m"},
 				Usage:   "show more statistic information, may take a long time",
 			},
+			&cli.BoolFlag{
+				Name:  "usage",
+				Usage: "show the number and total size of the objects in the object storage, may take a long time",
+			},
 		},
 	}
 }
@@ -77,6 +85,8 @@ type statistic struct {
 	TrashSliceSize           int64 `json:",omitempty"`
 	PendingDeletedSliceCount int64 `json:",omitempty"`
 	PendingDeletedSliceSize  int64 `json:",omitempty"`
+	ObjectCount              int64 `json:",omitempty"`
+	ObjectSize               int64 `json:",omitempty"`
 }
 
 func printJson(v interface{}) {
@@ -95,6 +105,13 @@ func status(ctx *cli.Context) error {
 	if err != nil {
 		logger.Fatalf("load setting: %s", err)
 	}
+	var blob object.ObjectStorage
+	if ctx.Bool("usage") {
+		if blob, err = createStorage(*format); err != nil {
+			logger.Fatalf("create object storage: %s", err)
+		}
+		defer object.Shutdown(blob)
+	}
 	format.RemoveSecret()
 
 	if sid := ctx.Uint64("session"); sid != 0 {
@@ -159,6 +176,13 @@ func status(ctx *cli.Context) error {
 		stat.PendingDeletedFileCount, stat.PendingDeletedFileSize = pendingDeletedFileSpinner.Current()
 	}
 
+	if blob != nil {
+		logger.Infof("Counting the objects in %s", blob)
+		if stat.ObjectCount, stat.ObjectSize, err = object.Usage(ctx.Context, blob, 10); err != nil {
+			logger.Fatalf("usage of %s: %s", blob, err)
+		}
+	}
+
 	printJson(&sections{format, sessions, stat})
 	return nil
 }
@@ -203,6 +203,10 @@ func syncActionFlags() []cli.Flag {
 			Name:  "check-new",
 			Usage: "verify integrity of newly copied files",
 		},
+		&cli.BoolFlag{
+			Name:  "check-checksum",
+			Usage: "compare the checksums of existing files with the same size, and copy the changed ones (the files are read to calculate the checksums if the Content-MD5 is not stored by the object storage)",
+		},
 		&cli.Int64Flag{
 			Name:  "max-failure",
 			Value: -1,
@@ -233,6 +237,14 @@ func syncStorageFlags() []cli.Flag {
 			Value: 1,
 			Usage: "list the top N level of directories in parallel",
 		},
+		&cli.IntFlag{
+			Name:  "list-window",
+			Usage: "sort the listed keys within a window of N objects, for object storages returning keys slightly out of order (0 means disabled)",
+		},
+		&cli.BoolFlag{
+			Name:  "check-listing",
+			Usage: "fail the listing on any duplicated or out of order key, and report where it occurred",
+		},
 		&cli.BoolFlag{
 			Name:  "no-https",
 			Usage: "donot use HTTPS",
@@ -351,7 +363,7 @@ func createSyncStorage(uri string, conf *sync.Config) (object.ObjectStorage, err
 	name := strings.ToLower(u.Scheme)
 
 	var endpoint string
-	if name == "file" {
+	if name == "file" || name == "nfsmount" {
 		endpoint = u.Path
 	} else if name == "hdfs" {
 		endpoint = u.Host
@@ -406,7 +418,7 @@ func createSyncStorage(uri string, conf *sync.Config) (object.ObjectStorage, err
 		}
 	}
 	switch name {
-	case "file", "nfs":
+	case "file", "nfs", "nfsmount":
 	case "minio":
 		if strings.Count(u.Path, "/") > 1 {
 			// skip bucket name
@@ -0,0 +1,475 @@
+//go:build !noazure
+// +build !noazure
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const dfsAPIVersion = "2021-06-08"
+
+// abfs talks to Azure Data Lake Storage Gen2 (hierarchical namespace enabled), directories are
+// managed through the Data Lake filesystem API while the data is read and written by the blob API.
+type abfs struct {
+	*wasb
+	endpoint    string // https://<account>.dfs.<suffix>
+	accountName string
+	accountKey  []byte
+}
+
+func (a *abfs) WithContext(ctx context.Context) ObjectStorage {
+	na := *a
+	na.wasb = a.wasb.WithContext(ctx).(*wasb)
+	return &na
+}
+
+func (a *abfs) String() string {
+	return fmt.Sprintf("abfs://%s/", a.cName)
+}
+
+// sign signs the request with the shared key of the storage account.
+func (a *abfs) sign(req *http.Request) {
+	var xms []string
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			xms = append(xms, lk)
+		}
+	}
+	sort.Strings(xms)
+	var headers strings.Builder
+	for _, k := range xms {
+		headers.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	resource := "/" + a.accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var params []string
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		vs := query[k]
+		sort.Strings(vs)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(vs, ",")
+	}
+	var contentLength string
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	toSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + headers.String() + resource
+	h := hmac.New(sha256.New, a.accountKey)
+	_, _ = h.Write([]byte(toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.accountName, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+}
+
+func (a *abfs) request(method, path string, query url.Values, headers map[string]string) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if a.sasToken != "" {
+		sas, err := url.ParseQuery(a.sasToken)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAS token: %s", err)
+		}
+		for k, vs := range sas {
+			query[k] = vs
+		}
+	}
+	uri := fmt.Sprintf("%s/%s", a.endpoint, a.cName)
+	if path != "" {
+		uri += "/" + (&url.URL{Path: path}).EscapedPath()
+	}
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(a.ctx, method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", dfsAPIVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	switch {
+	case a.sasToken != "":
+	case a.tokenCred != nil:
+		token, err := a.tokenCred.GetToken(a.ctx, policy.TokenRequestOptions{Scopes: []string{wasbTokenScope}})
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	default:
+		a.sign(req)
+	}
+	return a.hc.Do(req)
+}
+
+func (a *abfs) Create() error {
+	resp, err := a.request("PUT", "", url.Values{"resource": {"filesystem"}}, nil)
+	if err != nil {
+		return err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return parseError(resp)
+	}
+	return nil
+}
+
+func (a *abfs) Head(key string) (Object, error) {
+	resp, err := a.request("HEAD", strings.TrimSuffix(key, "/"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %v", resp.StatusCode)
+	}
+	mtime, _ := time.Parse(time.RFC1123, resp.Header.Get("Last-Modified"))
+	isDir := resp.Header.Get("x-ms-resource-type") == "directory"
+	if isDir && !strings.HasSuffix(key, "/") && key != "" {
+		key += "/"
+	}
+	return &checksumObj{
+		obj{key, resp.ContentLength, mtime, isDir, ""},
+		strings.Trim(resp.Header.Get("ETag"), "\""),
+		"",
+		resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+func (a *abfs) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if !strings.HasSuffix(key, "/") {
+		return a.wasb.Put(key, in, getters...)
+	}
+	// directories are real objects in a hierarchical namespace
+	resp, err := a.request("PUT", strings.TrimSuffix(key, "/"), url.Values{"resource": {"directory"}}, nil)
+	if err != nil {
+		return err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return parseError(resp)
+	}
+	return nil
+}
+
+// Delete removes a file or an empty directory.
+func (a *abfs) Delete(key string, getters ...AttrGetter) error {
+	resp, err := a.request("DELETE", strings.TrimSuffix(key, "/"), url.Values{"recursive": {"false"}}, nil)
+	if err != nil {
+		return err
+	}
+	defer cleanup(resp)
+	attrs := applyGetters(getters...)
+	attrs.SetRequestID(resp.Header.Get("x-ms-request-id"))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return parseError(resp)
+	}
+	return nil
+}
+
+// DeleteMulti deletes the paths one by one, the Blob Batch API is not supported with hierarchical namespace.
+func (a *abfs) DeleteMulti(keys []string) ([]string, error) {
+	return deleteEach(a, keys)
+}
+
+// Move renames a file or a directory (with all its children) atomically.
+func (a *abfs) Move(dst, src string) error {
+	source := fmt.Sprintf("/%s/%s", a.cName, (&url.URL{Path: strings.TrimSuffix(src, "/")}).EscapedPath())
+	resp, err := a.request("PUT", strings.TrimSuffix(dst, "/"), url.Values{"mode": {"legacy"}}, map[string]string{"x-ms-rename-source": source})
+	if err != nil {
+		return err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return parseError(resp)
+	}
+	return nil
+}
+
+type dfsPath struct {
+	Name          string      `json:"name"`
+	IsDirectory   interface{} `json:"isDirectory"`
+	ContentLength interface{} `json:"contentLength"`
+	LastModified  string      `json:"lastModified"`
+	ETag          string      `json:"etag"`
+}
+
+// listDir lists the direct children of a directory, the results are sorted by name.
+func (a *abfs) listDir(dir, continuation string) ([]Object, string, error) {
+	query := url.Values{"resource": {"filesystem"}, "recursive": {"false"}, "maxResults": {"5000"}}
+	if dir != "" {
+		query.Set("directory", dir)
+	}
+	if continuation != "" {
+		query.Set("continuation", continuation)
+	}
+	resp, err := a.request("GET", "", query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", parseError(resp)
+	}
+	var result struct {
+		Paths []dfsPath `json:"paths"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("decode listing of %s: %s", dir, err)
+	}
+	objs := make([]Object, 0, len(result.Paths))
+	for _, p := range result.Paths {
+		isDir := fmt.Sprint(p.IsDirectory) == "true"
+		size, _ := strconv.ParseInt(fmt.Sprint(p.ContentLength), 10, 64)
+		mtime, _ := time.Parse(time.RFC1123, p.LastModified)
+		key := p.Name
+		if isDir {
+			key += "/"
+		}
+		objs = append(objs, &checksumObj{obj{key, size, mtime, isDir, ""}, strings.Trim(p.ETag, "\""), "", ""})
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	return objs, resp.Header.Get("x-ms-continuation"), nil
+}
+
+// children lists all the direct children of a directory, sorted by key.
+func (a *abfs) children(dir string) ([]Object, error) {
+	var objs []Object
+	var continuation string
+	for {
+		page, next, err := a.listDir(dir, continuation)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, page...)
+		if next == "" {
+			break
+		}
+		continuation = next
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	return objs, nil
+}
+
+// walk calls fn with the objects under the directory dir with prefix after marker in order, by listing the
+// subdirectories in depth first order, until fn returns false. The subdirectories before marker are skipped.
+func (a *abfs) walk(dir, prefix, marker string, fn func(Object) bool) (bool, error) {
+	objs, err := a.children(strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return false, err
+	}
+	for _, o := range objs {
+		key := o.Key()
+		inMarker := marker != "" && strings.HasPrefix(marker, key)
+		if marker != "" && key <= marker && !inMarker || !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+			continue
+		}
+		more := true
+		if strings.HasPrefix(key, prefix) && key > marker {
+			more = fn(o)
+		}
+		if more && o.IsDir() {
+			m := marker
+			if !inMarker {
+				m = "" // all of it is after marker
+			}
+			if more, err = a.walk(key, prefix, m, fn); err != nil {
+				return false, err
+			}
+		}
+		if !more {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// List lists the directory of prefix with the delimiter "/", or all the objects under it by walking the
+// subdirectories otherwise.
+func (a *abfs) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if delimiter != "/" && delimiter != "" {
+		return nil, notSupported
+	}
+	if limit <= 0 {
+		limit = maxResults
+	}
+	dir := prefix[:strings.LastIndexByte(prefix, '/')+1]
+	var objs []Object
+	if dir != "" && dir == prefix && (marker == "" || delimiter == "" && marker < dir) {
+		if o, err := a.Head(dir); err == nil && o.IsDir() {
+			objs = append(objs, o)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	add := func(o Object) bool {
+		objs = append(objs, o)
+		return int64(len(objs)) < limit
+	}
+	if int64(len(objs)) >= limit {
+		return objs, nil
+	}
+	if delimiter == "" {
+		_, err := a.walk(dir, prefix, marker, add)
+		return objs, err
+	}
+	children, err := a.children(strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range children {
+		if !strings.HasPrefix(o.Key(), prefix) || (marker != "" && o.Key() <= marker) {
+			continue
+		}
+		if !add(o) {
+			break
+		}
+	}
+	return objs, nil
+}
+
+// ListAll walks all the subdirectories under prefix, so every directory is listed once.
+func (a *abfs) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	dir := prefix[:strings.LastIndexByte(prefix, '/')+1]
+	var self Object
+	if dir != "" && dir == prefix && marker < dir {
+		o, err := a.Head(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil && o.IsDir() {
+			self = o
+		}
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		if self != nil {
+			out <- self
+		}
+		_, err := a.walk(dir, prefix, marker, func(o Object) bool {
+			out <- o
+			return true
+		})
+		if err != nil {
+			logger.Errorf("list %s: %s", prefix, err)
+			out <- nil
+		}
+	}()
+	return out, nil
+}
+
+// parseConnectionString parses the key-value pairs of an Azure connection string.
+func parseConnectionString(connString string) map[string]string {
+	kv := make(map[string]string)
+	for _, part := range strings.Split(connString, ";") {
+		if p := strings.SplitN(part, "=", 2); len(p) == 2 {
+			kv[strings.TrimSpace(p[0])] = strings.TrimSpace(p[1])
+		}
+	}
+	return kv
+}
+
+func newAbfs(endpoint, accountName, accountKey, token string) (ObjectStorage, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = fmt.Sprintf("https://%s", endpoint)
+	}
+	uri, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endpoint: %v, error: %v", endpoint, err)
+	}
+	// the blob API shares the credentials and the container with the Data Lake API
+	hostParts := strings.SplitN(uri.Host, ".", 2)
+	if len(hostParts) > 1 {
+		hostParts[1] = strings.TrimPrefix(strings.TrimPrefix(hostParts[1], "dfs."), "blob.")
+		uri.Host = strings.Join(hostParts, ".")
+	}
+	s, err := newWasb(uri.String(), accountName, accountKey, token)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := s.(*wasb)
+	if !ok {
+		return nil, fmt.Errorf("%w: anonymous access to abfs", notSupported)
+	}
+	if connString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connString != "" {
+		kv := parseConnectionString(connString)
+		accountName, accountKey = kv["AccountName"], kv["AccountKey"]
+	}
+	blobURL, err := url.Parse(w.container.URL())
+	if err != nil {
+		return nil, err
+	}
+	a := &abfs{
+		wasb:        w,
+		endpoint:    fmt.Sprintf("%s://%s", blobURL.Scheme, strings.Replace(blobURL.Host, ".blob.", ".dfs.", 1)),
+		accountName: accountName,
+	}
+	if w.sasToken == "" && w.tokenCred == nil {
+		if a.accountKey, err = base64.StdEncoding.DecodeString(accountKey); err != nil {
+			return nil, fmt.Errorf("invalid account key: %s", err)
+		}
+	}
+	return a, nil
+}
+
+func init() {
+	Register("abfs", newAbfs)
+}
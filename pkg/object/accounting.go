@@ -0,0 +1,170 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// SupportAccounting is implemented by the object storages counting the bytes transferred, see WithAccounting.
+type SupportAccounting interface {
+	// Transferred returns the bytes read from and written to the object storage since it's created or reset.
+	Transferred() (read, written int64)
+	// ResetTransferred resets the counters to zero, and returns the bytes transferred before.
+	ResetTransferred() (read, written int64)
+}
+
+type accounting struct {
+	read, written int64
+}
+
+type accounted struct {
+	ObjectStorage
+	*accounting // shared by the ones bound to contexts
+}
+
+// WithAccounting counts the bytes read from (by Get) and written to (by Put and UploadPart) the object storage, which
+// are returned by Transferred, e.g. to attribute the egress of a run to each object storage. The bytes of Get are
+// counted as they are read by the caller rather than the requested range. The bytes written are counted once even
+// if the body of Put is read more than once (e.g. to calculate the checksum, or to retry), the failed uploads are
+// counted too.
+func WithAccounting(s ObjectStorage) ObjectStorage {
+	return &accounted{s, &accounting{}}
+}
+
+func accountingOf(store ObjectStorage) SupportAccounting {
+	for s := store; s != nil; s = unwrap(s) {
+		if a, ok := s.(SupportAccounting); ok {
+			return a
+		}
+	}
+	return nil
+}
+
+// Transferred returns the bytes read and written through the accounting wrapper of store (WithAccounting), it
+// returns ErrNotSupported if there is none.
+func Transferred(store ObjectStorage) (read, written int64, err error) {
+	if a := accountingOf(store); a != nil {
+		read, written = a.Transferred()
+		return read, written, nil
+	}
+	return 0, 0, notSupported
+}
+
+// ResetTransferred resets the counters of the accounting wrapper of store, and returns the bytes transferred before.
+func ResetTransferred(store ObjectStorage) (read, written int64, err error) {
+	if a := accountingOf(store); a != nil {
+		read, written = a.ResetTransferred()
+		return read, written, nil
+	}
+	return 0, 0, notSupported
+}
+
+func (a *accounted) Transferred() (read, written int64) {
+	return atomic.LoadInt64(&a.read), atomic.LoadInt64(&a.written)
+}
+
+func (a *accounted) ResetTransferred() (read, written int64) {
+	return atomic.SwapInt64(&a.read, 0), atomic.SwapInt64(&a.written, 0)
+}
+
+func (a *accounted) WithContext(ctx context.Context) ObjectStorage {
+	return &accounted{WithContext(a.ObjectStorage, ctx), a.accounting}
+}
+
+func (a *accounted) String() string {
+	return fmt.Sprintf("%s(accounted)", a.ObjectStorage)
+}
+
+type accountedReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *accountedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func (a *accounted) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	in, err := a.ObjectStorage.Get(key, off, limit, getters...)
+	if err != nil {
+		return nil, err
+	}
+	return &accountedReadCloser{in, &a.read}, nil
+}
+
+type accountedReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *accountedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// accountedReadSeeker counts the bytes beyond the furthest offset read, so the bytes read again after seeking
+// back are not counted.
+type accountedReadSeeker struct {
+	io.ReadSeeker
+	n        *int64
+	off, max int64
+}
+
+func (r *accountedReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.off += int64(n)
+	if r.off > r.max {
+		atomic.AddInt64(r.n, r.off-r.max)
+		r.max = r.off
+	}
+	return n, err
+}
+
+func (r *accountedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	off, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.off = off
+	}
+	return off, err
+}
+
+func (a *accounted) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if rs, ok := in.(io.ReadSeeker); ok {
+		off, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		in = &accountedReadSeeker{rs, &a.written, off, off}
+	} else {
+		in = &accountedReader{in, &a.written}
+	}
+	return a.ObjectStorage.Put(key, in, getters...)
+}
+
+func (a *accounted) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	atomic.AddInt64(&a.written, int64(len(body)))
+	return a.ObjectStorage.UploadPart(key, uploadID, num, body)
+}
+
+var _ SupportAccounting = &accounted{}
@@ -0,0 +1,96 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// checksummed reads the seekable body twice like the object storages calculating the checksum before uploading.
+type checksummed struct {
+	ObjectStorage
+}
+
+func (c *checksummed) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if rs, ok := in.(io.ReadSeeker); ok {
+		if _, err := io.Copy(io.Discard, rs); err != nil {
+			return err
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return c.ObjectStorage.Put(key, in, getters...)
+}
+
+func TestAccounting(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	testStorage(t, WithAccounting(m))
+
+	s := WithPrefix(WithAccounting(&checksummed{m}), "p/")
+	check := func(read, written int64) {
+		t.Helper()
+		if r, w, err := Transferred(s); err != nil || r != read || w != written {
+			t.Fatalf("transferred: %d read, %d written (%v), expected %d and %d", r, w, err, read, written)
+		}
+	}
+	if err := s.Put("a", bytes.NewReader(make([]byte, 100))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	check(0, 100)
+	if err := s.Put("b", struct{ io.Reader }{strings.NewReader("hello")}); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	check(0, 105)
+
+	// only the bytes read by the caller are counted
+	in, err := s.Get("a", 0, 100)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if _, err = in.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	_ = in.Close()
+	check(10, 105)
+	if d, err := get(WithContext(s, context.TODO()), "a", 0, -1); err != nil || len(d) != 100 {
+		t.Fatalf("get: %d bytes %v", len(d), err)
+	}
+	check(110, 105)
+
+	up, err := s.CreateMultipartUpload("c")
+	if err != nil {
+		t.Fatalf("create multipart upload: %s", err)
+	}
+	if _, err = s.UploadPart("c", up.UploadID, 1, make([]byte, 20)); err != nil {
+		t.Fatalf("upload part: %s", err)
+	}
+	check(110, 125)
+
+	if r, w, err := ResetTransferred(s); err != nil || r != 110 || w != 125 {
+		t.Fatalf("reset: %d %d %v", r, w, err)
+	}
+	check(0, 0)
+	if _, _, err := Transferred(m); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("transferred of storage without accounting: %v", err)
+	}
+}
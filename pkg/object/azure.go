@@ -20,69 +20,322 @@
 package object
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	blob2 "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/juicedata/juicefs/pkg/utils"
+	"golang.org/x/net/http/httpproxy"
 )
 
+const wasbTokenScope = "https://storage.azure.com/.default"
+
 type wasb struct {
 	DefaultObjectStorage
 	container *container.Client
 	azblobCli *azblob.Client
 	sc        string
 	cName     string
-	marker    string
+	sasToken  string
+	tokenCred azcore.TokenCredential
+	creds     *credentialRefresher // renews the credentials from the CredentialProvider, nil for the static ones
+	hc        *http.Client
+	ctx       context.Context
+
+	maxRetries int
+
+	disableChecksum bool
+	// don't infer the Content-Type from the key, the blobs are put as application/octet-stream by default
+	disableContentType bool
+	listSnapshots      bool
+	pageSize           int64         // the page size of listing, 0 means following the limit of List
+	listTimeout        time.Duration // the timeout of listing a page, 0 means no timeout other than the requests
+	// copy the large blobs in blocks by Put Block From URL (UploadPartCopy), rather than by an asynchronous copy
+	uploadPartCopy bool
+
+	partSize          int64 // the size of blocks staged by uploaders
+	uploadConcurrency int
+	// the data of unknown length is buffered to calculate the MD5 up to it, larger ones are uploaded as a stream
+	putThreshold int64
+
+	markers *listMarkers // the continuation tokens where the pages of List stopped, shared by WithContext
+}
+
+// wasbRetryable returns true for the errors of throttling, server side failures and transient network errors.
+func wasbRetryable(err error) bool {
+	var e *azcore.ResponseError
+	if errors.As(err, &e) {
+		switch e.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return e.ErrorCode == string(bloberror.ServerBusy) || e.ErrorCode == string(bloberror.OperationTimedOut)
+	}
+	return errors.Is(err, errListTimeout) || isTransientNetError(err)
+}
+
+func (b *wasb) retry(fn func() error) error {
+	return withRetry(b.ctx, b.maxRetries, wasbRetryable, fn)
+}
+
+var errListTimeout = errors.New("list page timed out")
+
+// nextPage gets the first page of a new pager with retries. Each try times out after list-timeout if it's set,
+// so a stalled page fails fast and is retried, rather than blocking the whole listing.
+func nextPage[T any](b *wasb, newPager func() *runtime.Pager[T]) (page T, err error) {
+	err = b.retry(func() error {
+		ctx, cancel := b.ctx, context.CancelFunc(func() {})
+		if b.listTimeout > 0 {
+			ctx, cancel = context.WithTimeout(b.ctx, b.listTimeout)
+		}
+		defer cancel()
+		page, err = newPager().NextPage(ctx)
+		if err != nil && ctx.Err() == context.DeadlineExceeded && b.ctx.Err() == nil {
+			err = fmt.Errorf("%w after %s: %s", errListTimeout, b.listTimeout, err)
+		}
+		return err
+	})
+	return
+}
+
+// WithContext returns a copy of the storage whose requests are bound to the context,
+// so they can be canceled or have a deadline.
+func (b *wasb) WithContext(ctx context.Context) ObjectStorage {
+	nb := *b
+	nb.ctx = ctx
+	return &nb
+}
+
+// Check gets the properties of the container, which fails if the credentials or the container is wrong.
+func (b *wasb) Check() error {
+	_, err := b.container.GetProperties(b.ctx, nil)
+	if bloberror.HasCode(err, bloberror.ContainerNotFound) {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, err)
+	}
+	return err
 }
 
 func (b *wasb) String() string {
 	return fmt.Sprintf("wasb://%s/", b.cName)
 }
 
+func (b *wasb) BucketName() string {
+	return b.cName
+}
+
+// how long Create waits for the container created or deleted by others to be usable, and the interval to check it
+var wasbContainerWait, wasbContainerPoll = 2 * time.Minute, time.Second
+
+// Create creates the container, it's safe to be called by many clients at the same time. If the container is
+// being created by another client, it waits until the container can be read, and if it's being deleted (or
+// conflicts in other ways), it tries to create it again, until wasbContainerWait.
 func (b *wasb) Create() error {
-	_, err := b.container.Create(ctx, nil)
-	if err != nil {
-		if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.ContainerAlreadyExists) {
+	deadline := time.Now().Add(wasbContainerWait)
+	for {
+		err := b.retry(func() error {
+			_, err := b.container.Create(b.ctx, nil)
+			return err
+		})
+		if err == nil {
 			return nil
 		}
+		var e *azcore.ResponseError
+		if !errors.As(err, &e) || e.StatusCode != http.StatusConflict {
+			return err
+		}
+		if bloberror.HasCode(err, bloberror.ContainerAlreadyExists, bloberror.ResourceAlreadyExists) {
+			err = b.retry(func() error {
+				_, err := b.container.GetProperties(b.ctx, nil)
+				return err
+			})
+			if err == nil {
+				return nil
+			} else if !bloberror.HasCode(err, bloberror.ContainerNotFound, bloberror.ContainerBeingDeleted) {
+				// it can't be read with the credential (e.g. a SAS of blobs), but it exists
+				logger.Debugf("Get properties of existing container %s: %s", b.cName, err)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s is not usable after %s: %w", b.cName, wasbContainerWait, err)
+		}
+		logger.Infof("Container %s is being created or deleted by others: %s, wait for it", b.cName, e.ErrorCode)
+		select {
+		case <-b.ctx.Done():
+			return b.ctx.Err()
+		case <-time.After(wasbContainerPoll):
+		}
 	}
-	return err
+}
+
+// the suffix of a key to address a snapshot of the blob, e.g. "a/b?snapshot=2024-01-01T00:00:00.0000000Z"
+const snapshotSuffix = "?snapshot="
+
+// the suffix of the snapshots in the listing, NUL (which can't be in the names of blobs) makes them sorted right
+// after the base blob and before the other blobs
+const snapshotListSuffix = "\x00snapshot="
+
+// blobClient returns the client of the blob, or of its snapshot if the key ends with "?snapshot=<id>" or
+// "\x00snapshot=<id>" (as listed).
+func (b *wasb) blobClient(key string) (*blob2.Client, error) {
+	for _, suffix := range []string{snapshotListSuffix, snapshotSuffix} {
+		if i := strings.LastIndex(key, suffix); i >= 0 {
+			return b.container.NewBlobClient(key[:i]).WithSnapshot(key[i+len(suffix):])
+		}
+	}
+	return b.container.NewBlobClient(key), nil
+}
+
+// Snapshot creates a read-only snapshot of the blob and returns its ID, which can be read by
+// Head and Get with the key "<key>?snapshot=<id>". Snapshots are immutable and are billed for
+// the blocks that differ from the base blob, they are deleted together with the base blob.
+func (b *wasb) Snapshot(key string) (string, error) {
+	var resp blob2.CreateSnapshotResponse
+	err := b.retry(func() (err error) {
+		resp, err = b.container.NewBlobClient(key).CreateSnapshot(b.ctx, nil)
+		return
+	})
+	if err != nil {
+		if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
+			err = ErrNotFound
+		}
+		return "", err
+	}
+	return aws.StringValue(resp.Snapshot), nil
 }
 
 func (b *wasb) Head(key string) (Object, error) {
-	properties, err := b.container.NewBlobClient(key).GetProperties(ctx, nil)
+	cli, err := b.blobClient(key)
+	if err != nil {
+		return nil, err
+	}
+	var properties blob2.GetPropertiesResponse
+	err = b.retry(func() (err error) {
+		properties, err = cli.GetProperties(b.ctx, nil)
+		return
+	})
 	if err != nil {
 		if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
-			err = os.ErrNotExist
+			err = ErrNotFound
 		}
 		return nil, err
 	}
 
-	return &obj{
-		key,
-		*properties.ContentLength,
-		*properties.LastModified,
-		strings.HasSuffix(key, "/"),
-		*properties.AccessTier,
-	}, nil
+	o := &wasbObj{checksumObj: checksumObj{
+		obj{
+			key,
+			*properties.ContentLength,
+			*properties.LastModified,
+			strings.HasSuffix(key, "/"),
+			aws.StringValue(properties.AccessTier),
+		},
+		etag2Str(properties.ETag),
+		hex.EncodeToString(properties.ContentMD5),
+		aws.StringValue(properties.ContentType),
+	}}
+	if properties.ExpiresOn != nil {
+		o.expiry = *properties.ExpiresOn
+	}
+	return o, nil
+}
+
+// wasbObj is the object returned by Head, with the expiry set by PutWithExpiry.
+type wasbObj struct {
+	checksumObj
+	expiry time.Time
+}
+
+func (o *wasbObj) Expiry() time.Time { return o.expiry }
+
+// PutWithExpiry puts the blob and sets its expiry, which is only supported by the accounts with hierarchical
+// namespace (ADLS Gen2). The blob is deleted if the expiry can't be set, so it's never left without expiry.
+func (b *wasb) PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	if err := b.Put(key, in, getters...); err != nil {
+		return err
+	}
+	err := b.retry(func() error {
+		_, err := b.container.NewBlockBlobClient(key).SetExpiry(b.ctx, blockblob.ExpiryTypeAbsolute(expiry), nil)
+		return err
+	})
+	if err != nil {
+		_ = b.Delete(key)
+		return fmt.Errorf("set expiry of %s (hierarchical namespace is required): %w", key, err)
+	}
+	return nil
+}
+
+// Exists gets the properties of the blob without parsing them, only 404 of the blob is translated into false,
+// a missing container or other errors are returned.
+func (b *wasb) Exists(key string) (bool, error) {
+	cli, err := b.blobClient(key)
+	if err != nil {
+		return false, err
+	}
+	err = b.retry(func() (err error) {
+		_, err = cli.GetProperties(b.ctx, nil)
+		return
+	})
+	var e *azcore.ResponseError
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotFound && e.ErrorCode != string(bloberror.ContainerNotFound) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (b *wasb) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
-	download, err := b.container.NewBlobClient(key).DownloadStream(ctx, &azblob.DownloadStreamOptions{Range: blob2.HTTPRange{Offset: off, Count: limit}})
+	if limit <= 0 {
+		limit = blob2.CountToEnd // read the rest of the blob from off
+	}
+	cli, err := b.blobClient(key)
 	if err != nil {
 		return nil, err
 	}
+	var download blob2.DownloadStreamResponse
+	err = b.retry(func() (err error) {
+		download, err = cli.DownloadStream(b.ctx, &blob2.DownloadStreamOptions{Range: blob2.HTTPRange{Offset: off, Count: limit}})
+		return
+	})
+	if err != nil {
+		if e, ok := err.(*azcore.ResponseError); ok {
+			switch e.ErrorCode {
+			case string(bloberror.BlobNotFound):
+				err = ErrNotFound
+			case string(bloberror.BlobArchived):
+				err = fmt.Errorf("%w: %s, restore it first", ErrArchived, key)
+			}
+		}
+		return nil, err
+	}
 	attrs := applyGetters(getters...)
 	// TODO fire another property request to get the actual storage class
 	attrs.SetRequestID(aws.StringValue(download.RequestID)).SetStorageClass(b.sc)
@@ -91,7 +344,7 @@ func (b *wasb) Get(key string, off, limit int64, getters ...AttrGetter) (io.Read
 
 func str2Tier(tier string) *blob2.AccessTier {
 	for _, v := range blob2.PossibleAccessTierValues() {
-		if string(v) == tier {
+		if strings.EqualFold(string(v), tier) {
 			return &v
 		}
 	}
@@ -99,93 +352,1069 @@ func str2Tier(tier string) *blob2.AccessTier {
 }
 
 func (b *wasb) Put(key string, data io.Reader, getters ...AttrGetter) error {
-	options := azblob.UploadStreamOptions{}
+	return b.put(key, data, nil, getters...)
+}
+
+// PutIfNotExists uploads the blob with If-None-Match: *, so an existing blob is never overwritten.
+func (b *wasb) PutIfNotExists(key string, data io.Reader, getters ...AttrGetter) error {
+	cond := &blob2.AccessConditions{ModifiedAccessConditions: &blob2.ModifiedAccessConditions{IfNoneMatch: to.Ptr(azcore.ETagAny)}}
+	err := b.put(key, data, cond, getters...)
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+		return fmt.Errorf("%w: %s", ErrExists, key)
+	}
+	return err
+}
+
+func (b *wasb) put(key string, data io.Reader, cond *blob2.AccessConditions, getters ...AttrGetter) error {
+	var body io.ReadSeeker
+	if !b.disableChecksum {
+		if r, ok := data.(io.ReadSeeker); ok {
+			body = r
+		} else {
+			threshold := b.putThreshold
+			if threshold <= 0 {
+				threshold = defaultPutThreshold
+			}
+			buf, err := io.ReadAll(io.LimitReader(data, threshold+1))
+			if err != nil {
+				return err
+			}
+			if int64(len(buf)) <= threshold {
+				body = bytes.NewReader(buf)
+			} else {
+				data = io.MultiReader(bytes.NewReader(buf), data)
+			}
+		}
+	}
+	attrs := applyGetters(getters...)
+	var headers blob2.HTTPHeaders
+	if ct := attrs.contentTypeOf(key, !b.disableContentType); ct != "" {
+		headers.BlobContentType = &ct
+	}
+	meta, err := wasbMetadata(attrs.metadata)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if size, err := body.Seek(0, io.SeekEnd); err != nil {
+			return err
+		} else if size <= blockblob.MaxUploadBlobBytes {
+			if _, err = body.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			sum, err := contentMD5(body)
+			if err != nil {
+				return err
+			}
+			headers.BlobContentMD5 = sum
+			options := blockblob.UploadOptions{
+				TransactionalContentMD5: sum,
+				HTTPHeaders:             &headers,
+				Metadata:                meta,
+				AccessConditions:        cond,
+			}
+			if b.sc != "" {
+				options.Tier = str2Tier(b.sc)
+			}
+			var resp blockblob.UploadResponse
+			err = b.retry(func() (err error) {
+				if _, err = body.Seek(0, io.SeekStart); err != nil {
+					return
+				}
+				resp, err = b.container.NewBlockBlobClient(key).Upload(b.ctx, streaming.NopCloser(body), &options)
+				return
+			})
+			attrs.SetRequestID(aws.StringValue(resp.RequestID)).SetStorageClass(b.sc)
+			return checkMD5Mismatch(key, err)
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		data = body
+	}
+	options := azblob.UploadStreamOptions{HTTPHeaders: &headers, Metadata: meta, AccessConditions: cond, BlockSize: b.partSize, Concurrency: b.uploadConcurrency}
 	if b.sc != "" {
 		options.AccessTier = str2Tier(b.sc)
 	}
-	resp, err := b.azblobCli.UploadStream(ctx, b.cName, key, data, &options)
-	attrs := applyGetters(getters...)
+	// the data can't be rewound, so it's not retried
+	resp, err := b.azblobCli.UploadStream(b.ctx, b.cName, key, data, &options)
 	attrs.SetRequestID(aws.StringValue(resp.RequestID)).SetStorageClass(b.sc)
 	return err
 }
 
+// contentMD5 calculates the MD5 of the content and rewinds it.
+func contentMD5(in io.ReadSeeker) ([]byte, error) {
+	h := md5.New()
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+	if _, err := io.CopyBuffer(h, in, *buf); err != nil {
+		return nil, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func checkMD5Mismatch(key string, err error) error {
+	if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.MD5Mismatch) {
+		return fmt.Errorf("%w: upload %s: %s", ErrChecksumMismatch, key, err)
+	}
+	return err
+}
+
+var metaKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// normalizeMetaKey converts the key of metadata into a valid C# identifier required by Azure,
+// '-' and '.' are replaced by '_'.
+func normalizeMetaKey(k string) (string, error) {
+	nk := strings.NewReplacer("-", "_", ".", "_").Replace(strings.TrimSpace(k))
+	if !metaKeyRegexp.MatchString(nk) {
+		return "", fmt.Errorf("invalid metadata key %q: it must be a valid C# identifier", k)
+	}
+	return nk, nil
+}
+
+// wasbMetadata returns the metadata of blobs with the keys normalized, nil for no metadata.
+func wasbMetadata(meta map[string]string) (map[string]*string, error) {
+	if len(meta) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]*string, len(meta))
+	for k, v := range meta {
+		nk, err := normalizeMetaKey(k)
+		if err != nil {
+			return nil, err
+		}
+		m[nk] = aws.String(v)
+	}
+	return m, nil
+}
+
+func (b *wasb) SetMeta(key string, meta map[string]string) error {
+	m, err := wasbMetadata(meta)
+	if err != nil {
+		return err
+	}
+	err = b.retry(func() error {
+		_, err := b.container.NewBlobClient(key).SetMetadata(b.ctx, m, nil)
+		return err
+	})
+	if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (b *wasb) GetMeta(key string) (map[string]string, error) {
+	var properties blob2.GetPropertiesResponse
+	err := b.retry(func() (err error) {
+		properties, err = b.container.NewBlobClient(key).GetProperties(b.ctx, nil)
+		return
+	})
+	if err != nil {
+		if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	meta := make(map[string]string, len(properties.Metadata))
+	for k, v := range properties.Metadata {
+		meta[k] = aws.StringValue(v)
+	}
+	return meta, nil
+}
+
+const (
+	wasbMaxTags         = 10
+	wasbMaxTagKeyLen    = 128
+	wasbMaxTagValueLen  = 256
+	wasbTagAllowedChars = "+-./:=_ "
+)
+
+func validTagChars(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(wasbTagAllowedChars, c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTags checks the tags against the limits of blob index tags: at most 10 tags, the key has
+// 1-128 characters and the value has 0-256 characters, only alphanumeric and '+-./:=_ ' are allowed.
+func validateTags(tags map[string]string) error {
+	if len(tags) > wasbMaxTags {
+		return fmt.Errorf("too many tags: %d > %d", len(tags), wasbMaxTags)
+	}
+	for k, v := range tags {
+		if len(k) == 0 || len(k) > wasbMaxTagKeyLen || !validTagChars(k) {
+			return fmt.Errorf("invalid tag key %q", k)
+		}
+		if len(v) > wasbMaxTagValueLen || !validTagChars(v) {
+			return fmt.Errorf("invalid value %q of tag %s", v, k)
+		}
+	}
+	return nil
+}
+
+func (b *wasb) SetTags(key string, tags map[string]string) error {
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+	err := b.retry(func() error {
+		_, err := b.container.NewBlobClient(key).SetTags(b.ctx, tags, nil)
+		return err
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (b *wasb) GetTags(key string) (map[string]string, error) {
+	var resp blob2.GetTagsResponse
+	err := b.retry(func() (err error) {
+		resp, err = b.container.NewBlobClient(key).GetTags(b.ctx, nil)
+		return
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	tags := make(map[string]string, len(resp.BlobTagSet))
+	for _, t := range resp.BlobTagSet {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return tags, nil
+}
+
+// Restore rehydrates an archived blob into the given tier (Hot or Cool). It returns once the
+// request is accepted, the rehydration itself is asynchronous and may take up to 15 hours,
+// the blob stays in Archive tier (Get returns ErrArchived) until it's done.
+func (b *wasb) Restore(key string, tier string) error {
+	t := str2Tier(tier)
+	if t == nil || *t == blob2.AccessTierArchive {
+		return fmt.Errorf("invalid tier %q to rehydrate blob %s", tier, key)
+	}
+	err := b.retry(func() error {
+		_, err := b.container.NewBlobClient(key).SetTier(b.ctx, *t, nil)
+		return err
+	})
+	if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// SetImmutabilityPolicy keeps the blob from being modified or deleted until the given time, the container
+// must have version-level immutability enabled. A locked policy can only be extended afterwards.
+func (b *wasb) SetImmutabilityPolicy(key string, until time.Time, locked bool) error {
+	mode := blob2.ImmutabilityPolicySettingUnlocked
+	if locked {
+		mode = blob2.ImmutabilityPolicySettingLocked
+	}
+	err := b.retry(func() error {
+		_, err := b.container.NewBlobClient(key).SetImmutabilityPolicy(b.ctx, until, &blob2.SetImmutabilityPolicyOptions{Mode: &mode})
+		return err
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// the error code of Azure for the blobs under legal hold, which is not defined by bloberror
+const blobImmutableDueToLegalHold bloberror.Code = "BlobImmutableDueToLegalHold"
+
+// SetLegalHold sets or clears the legal hold of the blob, a blob under legal hold can't be modified
+// or deleted until the hold is cleared, regardless of its immutability policy.
+func (b *wasb) SetLegalHold(key string, hold bool) error {
+	err := b.retry(func() error {
+		_, err := b.container.NewBlobClient(key).SetLegalHold(b.ctx, hold, nil)
+		return err
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// the actions of lifecycle management policy for the storage classes (access tiers)
+var wasbTierActions = map[string]string{"Cool": "tierToCool", "Cold": "tierToCold", "Archive": "tierToArchive"}
+var wasbRuleName = regexp.MustCompile(`^[a-zA-Z0-9]{1,256}$`)
+
+type wasbPolicyAction struct {
+	DaysAfterModificationGreaterThan int `json:"daysAfterModificationGreaterThan"`
+}
+
+type wasbPolicyRule struct {
+	Enabled    bool   `json:"enabled"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Definition struct {
+		Filters struct {
+			BlobTypes   []string `json:"blobTypes"`
+			PrefixMatch []string `json:"prefixMatch,omitempty"`
+		} `json:"filters"`
+		Actions struct {
+			BaseBlob map[string]wasbPolicyAction `json:"baseBlob"`
+		} `json:"actions"`
+	} `json:"definition"`
+}
+
+type wasbPolicy struct {
+	Properties struct {
+		Policy struct {
+			Rules []json.RawMessage `json:"rules"`
+		} `json:"policy"`
+	} `json:"properties"`
+}
+
+// managementPolicy requests the lifecycle management policy of the storage account by Azure Resource Manager, which
+// needs Azure AD credentials, and the subscription and resource group of the account in AZURE_SUBSCRIPTION_ID and
+// AZURE_RESOURCE_GROUP. The endpoint can be changed by AZURE_RESOURCE_MANAGER_ENDPOINT for other clouds.
+func (b *wasb) managementPolicy(method string, body []byte) (*http.Response, error) {
+	sub, group := os.Getenv("AZURE_SUBSCRIPTION_ID"), os.Getenv("AZURE_RESOURCE_GROUP")
+	if b.tokenCred == nil || sub == "" || group == "" {
+		return nil, fmt.Errorf("%w: lifecycle of Azure needs Azure AD credentials, AZURE_SUBSCRIPTION_ID and AZURE_RESOURCE_GROUP", notSupported)
+	}
+	endpoint := strings.TrimSuffix(os.Getenv("AZURE_RESOURCE_MANAGER_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = "https://management.azure.com"
+	}
+	token, err := b.tokenCred.GetToken(b.ctx, policy.TokenRequestOptions{Scopes: []string{endpoint + "/.default"}})
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(b.azblobCli.URL())
+	if err != nil {
+		return nil, err
+	}
+	account := strings.SplitN(u.Host, ".", 2)[0]
+	uri := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s/managementPolicies/default?api-version=2023-01-01",
+		endpoint, sub, group, account)
+	req, err := http.NewRequestWithContext(b.ctx, method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		defer cleanup(resp)
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("%s management policy of %s: %s %s", method, account, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// containerRules returns the raw rules of the management policy, and the ones for the container, which have only
+// one prefix within the container. The rules of the other containers are kept as they are by SetLifecycle.
+func (b *wasb) containerRules() ([]json.RawMessage, map[int]*wasbPolicyRule, error) {
+	resp, err := b.managementPolicy("GET", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cleanup(resp)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, nil
+	}
+	var p wasbPolicy
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, nil, fmt.Errorf("decode management policy: %s", err)
+	}
+	owned := make(map[int]*wasbPolicyRule)
+	for i, raw := range p.Properties.Policy.Rules {
+		var r wasbPolicyRule
+		if json.Unmarshal(raw, &r) == nil && len(r.Definition.Filters.PrefixMatch) == 1 &&
+			strings.HasPrefix(r.Definition.Filters.PrefixMatch[0], b.cName+"/") {
+			owned[i] = &r
+		}
+	}
+	return p.Properties.Policy.Rules, owned, nil
+}
+
+// SetLifecycle replaces the rules of the container in the lifecycle management policy of the storage account, the
+// storage classes can be Cool, Cold or Archive, and the days are counted from the last modification of blobs.
+func (b *wasb) SetLifecycle(rules []LifecycleRule) error {
+	classes := make(map[string]string)
+	for sc := range wasbTierActions {
+		classes[strings.ToLower(sc)] = sc
+	}
+	rules, err := normalizeLifecycle(rules, classes)
+	if err != nil {
+		return err
+	}
+	var result []json.RawMessage
+	for _, r := range rules {
+		if !wasbRuleName.MatchString(r.ID) {
+			return fmt.Errorf("lifecycle rule %s: the ID of Azure can only contain letters and numbers", r.ID)
+		}
+		var rule wasbPolicyRule
+		rule.Enabled, rule.Name, rule.Type = true, r.ID, "Lifecycle"
+		rule.Definition.Filters.BlobTypes = []string{"blockBlob"}
+		rule.Definition.Filters.PrefixMatch = []string{b.cName + "/" + r.Prefix}
+		rule.Definition.Actions.BaseBlob = make(map[string]wasbPolicyAction)
+		for _, t := range r.Transitions {
+			rule.Definition.Actions.BaseBlob[wasbTierActions[t.StorageClass]] = wasbPolicyAction{t.Days}
+		}
+		if r.ExpirationDays > 0 {
+			rule.Definition.Actions.BaseBlob["delete"] = wasbPolicyAction{r.ExpirationDays}
+		}
+		raw, _ := json.Marshal(rule)
+		result = append(result, raw)
+	}
+	all, owned, err := b.containerRules()
+	if err != nil {
+		return err
+	}
+	for i, raw := range all {
+		if owned[i] == nil {
+			result = append(result, raw)
+		}
+	}
+	method := "PUT"
+	var p wasbPolicy
+	p.Properties.Policy.Rules = result
+	body, _ := json.Marshal(p)
+	if len(result) == 0 {
+		method, body = "DELETE", nil // a policy can't be empty
+	}
+	resp, err := b.managementPolicy(method, body)
+	if err == nil {
+		cleanup(resp)
+	}
+	return err
+}
+
+// GetLifecycle returns the rules of the container in the lifecycle management policy of the storage account.
+func (b *wasb) GetLifecycle() ([]LifecycleRule, error) {
+	all, owned, err := b.containerRules()
+	if err != nil {
+		return nil, err
+	}
+	var rules []LifecycleRule
+	for i := range all {
+		r := owned[i]
+		if r == nil {
+			continue
+		}
+		rule := LifecycleRule{ID: r.Name, Prefix: strings.TrimPrefix(r.Definition.Filters.PrefixMatch[0], b.cName+"/")}
+		for sc, action := range wasbTierActions {
+			if a, ok := r.Definition.Actions.BaseBlob[action]; ok {
+				rule.Transitions = append(rule.Transitions, LifecycleTransition{a.DaysAfterModificationGreaterThan, sc})
+			}
+		}
+		sortTransitions(rule.Transitions)
+		if a, ok := r.Definition.Actions.BaseBlob["delete"]; ok {
+			rule.ExpirationDays = a.DaysAfterModificationGreaterThan
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SignGet returns a SAS URL to read the blob. Signing requires the account key (shared key credential),
+// it fails when authorized with a SAS token or an Azure AD token.
+func (b *wasb) SignGet(key string, expire time.Duration) (string, error) {
+	return b.sign(key, sas.BlobPermissions{Read: true}, expire)
+}
+
+// SignPut returns a SAS URL to upload the blob, the request must have the header "x-ms-blob-type: BlockBlob".
+// Signing requires the account key (shared key credential).
+func (b *wasb) SignPut(key string, expire time.Duration) (string, error) {
+	return b.sign(key, sas.BlobPermissions{Create: true, Write: true}, expire)
+}
+
+func (b *wasb) sign(key string, permissions sas.BlobPermissions, expire time.Duration) (string, error) {
+	u, err := b.container.NewBlobClient(key).GetSASURL(permissions, time.Now().Add(expire), nil)
+	if err == bloberror.MissingSharedKeyCredential {
+		return "", fmt.Errorf("%w: SAS signing requires the account key", notSupported)
+	}
+	return u, err
+}
+
+const (
+	// the max size of a blob that can be copied synchronously by CopyFromURL
+	wasbSyncCopyLimit = 256 << 20
+	// how long to wait for an asynchronous copy before aborting it
+	wasbAsyncCopyTimeout = 24 * time.Hour
+)
+
 func (b *wasb) Copy(dst, src string) error {
+	return b.CopyWithOptions(dst, src, CopyOptions{})
+}
+
+// CopyWithOptions copies the blob within the container. The metadata and the properties are always copied
+// by Azure unless they are specified, but the tags are not, so the tags of the source are set explicitly.
+func (b *wasb) CopyWithOptions(dst, src string, opts CopyOptions) error {
 	dstCli := b.container.NewBlobClient(dst)
 	srcCli := b.container.NewBlobClient(src)
-	options := &blob2.CopyFromURLOptions{}
+	var properties blob2.GetPropertiesResponse
+	err := b.retry(func() (err error) {
+		properties, err = srcCli.GetProperties(b.ctx, nil)
+		return
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			err = ErrNotFound
+		}
+		return err
+	}
+	var meta map[string]*string
+	var tags map[string]string
+	switch opts.MetadataDirective {
+	case "", MetadataCopy:
+		if properties.TagCount != nil && *properties.TagCount > 0 {
+			if tags, err = b.GetTags(src); err != nil {
+				return err
+			}
+		}
+	case MetadataReplace:
+		meta = make(map[string]*string, len(opts.Metadata))
+		for k, v := range opts.Metadata {
+			nk, err := normalizeMetaKey(k)
+			if err != nil {
+				return err
+			}
+			meta[nk] = aws.String(v)
+		}
+		if err = validateTags(opts.Tags); err != nil {
+			return err
+		}
+		tags = opts.Tags
+	default:
+		return fmt.Errorf("invalid metadata directive: %s", opts.MetadataDirective)
+	}
+	if properties.ContentLength != nil && *properties.ContentLength > wasbSyncCopyLimit {
+		err = b.copyAsync(dst, src, dstCli, srcCli, meta, tags)
+	} else {
+		err = b.copySync(dstCli, srcCli, meta, tags)
+	}
+	if err != nil || opts.MetadataDirective != MetadataReplace {
+		return err
+	}
+	// the metadata of the source is copied if no metadata is specified
+	if len(meta) == 0 && len(properties.Metadata) > 0 {
+		err = b.retry(func() error {
+			_, err := dstCli.SetMetadata(b.ctx, meta, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.ContentType != "" {
+		// all the HTTP headers are replaced, keep the others of the source
+		headers := blob2.HTTPHeaders{
+			BlobContentType:        aws.String(opts.ContentType),
+			BlobContentEncoding:    properties.ContentEncoding,
+			BlobContentLanguage:    properties.ContentLanguage,
+			BlobContentDisposition: properties.ContentDisposition,
+			BlobCacheControl:       properties.CacheControl,
+			BlobContentMD5:         properties.ContentMD5,
+		}
+		err = b.retry(func() error {
+			_, err := dstCli.SetHTTPHeaders(b.ctx, headers, nil)
+			return err
+		})
+	}
+	return err
+}
+
+func (b *wasb) copySync(dstCli, srcCli *blob2.Client, meta map[string]*string, tags map[string]string) error {
+	options := &blob2.CopyFromURLOptions{Metadata: meta, BlobTags: tags}
 	if b.sc != "" {
 		options.Tier = str2Tier(b.sc)
 	}
-	srcSASUrl, err := srcCli.GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(10*time.Second), nil)
-	if err != nil {
+	return b.retry(func() error {
+		// the short-lived authorization of the source is renewed for every attempt
+		srcSASUrl, auth, err := b.copySource(srcCli)
+		if err != nil {
+			return err
+		}
+		options.CopySourceAuthorization = auth
+		_, err = dstCli.CopyFromURL(b.ctx, srcSASUrl, options)
 		return err
+	})
+}
+
+// copySource returns the URL and the authorization of the source blob of a synchronous copy (Copy Blob From URL
+// or Put Block From URL), which must be readable within a few seconds.
+func (b *wasb) copySource(srcCli *blob2.Client) (string, *string, error) {
+	// the URL of the source blob already carries the SAS token
+	if b.tokenCred != nil {
+		// a SAS URL can't be signed without the account key, authorize the source with the bearer token instead
+		token, err := b.tokenCred.GetToken(b.ctx, policy.TokenRequestOptions{Scopes: []string{wasbTokenScope}})
+		if err != nil {
+			return "", nil, err
+		}
+		return srcCli.URL(), aws.String("Bearer " + token.Token), nil
+	} else if b.sasToken == "" {
+		srcSASUrl, err := srcCli.GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(10*time.Second), nil)
+		return srcSASUrl, nil, err
 	}
-	_, err = dstCli.CopyFromURL(ctx, srcSASUrl, options)
-	return err
+	return srcCli.URL(), nil, nil
 }
 
-func (b *wasb) Delete(key string, getters ...AttrGetter) error {
-	resp, err := b.container.NewBlobClient(key).Delete(ctx, nil)
+// copyAsync starts a server-side copy and polls the destination until the copy is finished.
+func (b *wasb) copyAsync(dst, src string, dstCli, srcCli *blob2.Client, meta map[string]*string, tags map[string]string) error {
+	cctx, cancel := context.WithTimeout(b.ctx, wasbAsyncCopyTimeout)
+	defer cancel()
+	options := &blob2.StartCopyFromURLOptions{Metadata: meta, BlobTags: tags}
+	if b.sc != "" {
+		options.Tier = str2Tier(b.sc)
+	}
+	// the source in the same account is authorized by the credential of the destination,
+	// except for shared key where the SAS URL must stay valid until the copy is finished
+	source := srcCli.URL()
+	if b.tokenCred == nil && b.sasToken == "" {
+		var err error
+		if source, err = srcCli.GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(wasbAsyncCopyTimeout), nil); err != nil {
+			return err
+		}
+	}
+	var resp blob2.StartCopyFromURLResponse
+	err := b.retry(func() (err error) {
+		resp, err = dstCli.StartCopyFromURL(cctx, source, options)
+		return
+	})
 	if err != nil {
-		if e, ok := err.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
-			err = nil
+		return err
+	}
+	status, desc := resp.CopyStatus, (*string)(nil)
+	interval := time.Second
+	for status != nil && *status == blob2.CopyStatusTypePending {
+		select {
+		case <-cctx.Done():
+			if resp.CopyID != nil {
+				// abort it even if the context of the caller is canceled
+				_, _ = dstCli.AbortCopyFromURL(ctx, *resp.CopyID, nil)
+			}
+			return fmt.Errorf("copy %s to %s: %s", src, dst, cctx.Err())
+		case <-time.After(interval):
+		}
+		var properties blob2.GetPropertiesResponse
+		err := b.retry(func() (err error) {
+			properties, err = dstCli.GetProperties(cctx, nil)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		status, desc = properties.CopyStatus, properties.CopyStatusDescription
+		if interval < 10*time.Second {
+			interval *= 2
 		}
 	}
+	if status != nil && *status != blob2.CopyStatusTypeSuccess {
+		return fmt.Errorf("copy %s to %s is %s: %s", src, dst, *status, aws.StringValue(desc))
+	}
+	return nil
+}
+
+func (b *wasb) Delete(key string, getters ...AttrGetter) error {
+	var resp blob2.DeleteResponse
+	err := b.retry(func() (err error) {
+		resp, err = b.container.NewBlobClient(key).Delete(b.ctx, nil)
+		return
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		err = nil
+	} else if bloberror.HasCode(err, bloberror.BlobImmutableDueToPolicy, blobImmutableDueToLegalHold) {
+		err = fmt.Errorf("%w: delete %s: %s", ErrObjectLocked, key, err)
+	}
 	attrs := applyGetters(getters...)
 	attrs.SetRequestID(aws.StringValue(resp.RequestID))
 	return err
 }
 
-func (b *wasb) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
-	if delimiter != "" {
-		return nil, notSupported
+// the max number of sub-requests in a blob batch
+const wasbMaxBatchSize = 256
+
+// DeleteMulti deletes the blobs with the Blob Batch API, 256 blobs per request.
+func (b *wasb) DeleteMulti(keys []string) ([]string, error) {
+	var failed []string
+	var lastErr error
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > wasbMaxBatchSize {
+			batch = batch[:wasbMaxBatchSize]
+		}
+		keys = keys[len(batch):]
+		if f, err := b.deleteBatch(batch); err != nil {
+			failed = append(failed, f...)
+			lastErr = err
+		}
 	}
-	// todo
-	if marker != "" {
-		if b.marker == "" {
-			// last page
-			return nil, nil
+	return failed, lastErr
+}
+
+func (b *wasb) deleteBatch(keys []string) ([]string, error) {
+	bb, err := b.container.NewBatchBuilder()
+	if err != nil {
+		return keys, err
+	}
+	for _, key := range keys {
+		if err = bb.Delete(key, nil); err != nil {
+			return keys, err
 		}
-		marker = b.marker
 	}
+	var resp container.SubmitBatchResponse
+	err = b.retry(func() (err error) {
+		resp, err = b.container.SubmitBatch(b.ctx, bb, nil)
+		return
+	})
+	if err != nil {
+		return keys, err
+	}
+	var failed []string
+	for _, item := range resp.Responses {
+		if item.Error == nil {
+			continue
+		}
+		if e, ok := item.Error.(*azcore.ResponseError); ok && e.ErrorCode == string(bloberror.BlobNotFound) {
+			continue
+		}
+		err = item.Error
+		if item.ContentID != nil && *item.ContentID < len(keys) {
+			failed = append(failed, keys[*item.ContentID])
+		}
+	}
+	return failed, err
+}
+
+func blobItem2Obj(blob *container.BlobItem) Object {
+	var tier string
+	if blob.Properties.AccessTier != nil {
+		tier = string(*blob.Properties.AccessTier)
+	}
+	key := *blob.Name
+	if blob.Snapshot != nil && *blob.Snapshot != "" {
+		key += snapshotListSuffix + *blob.Snapshot
+	}
+	return &checksumObj{
+		obj{
+			key,
+			*blob.Properties.ContentLength,
+			*blob.Properties.LastModified,
+			strings.HasSuffix(*blob.Name, "/"),
+			tier,
+		},
+		etag2Str(blob.Properties.ETag),
+		hex.EncodeToString(blob.Properties.ContentMD5),
+		aws.StringValue(blob.Properties.ContentType),
+	}
+}
+
+func etag2Str(etag *azcore.ETag) string {
+	if etag == nil {
+		return ""
+	}
+	return strings.Trim(string(*etag), "\"")
+}
 
+// fullPageSize returns the page size to list all the blobs.
+func (b *wasb) fullPageSize() int64 {
+	if b.pageSize > 0 {
+		return b.pageSize
+	}
+	return 5000
+}
+
+// listBlobs lists one page of blobs starting at the continuation token returned by Azure,
+// the returned token is empty when there is no more page. With a delimiter, the blob prefixes
+// of the level are returned as directories.
+func (b *wasb) listBlobs(prefix, delimiter, token string, limit int64) ([]Object, string, error) {
+	objs, next, snapshot, err := b.listPage(prefix, delimiter, token, limit)
+	// the snapshots are listed before their base blob, the next page is included if the page ends with a snapshot,
+	// so the snapshots are sorted after the base blob within a page
+	for err == nil && snapshot && next != "" {
+		var more []Object
+		more, next, snapshot, err = b.listPage(prefix, delimiter, next, limit)
+		objs = append(objs, more...)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if delimiter != "" || b.listSnapshots {
+		sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	}
+	return objs, next, nil
+}
+
+// listPage lists a page of Azure, and tells whether the last blob in it is a snapshot.
+func (b *wasb) listPage(prefix, delimiter, token string, limit int64) ([]Object, string, bool, error) {
+	if limit > 5000 {
+		limit = 5000 // the maximum page size of Azure
+	}
 	limit32 := int32(limit)
+	var marker *string
+	if token != "" {
+		marker = &token
+	}
+	// snapshots are listed as "<key>\x00snapshot=<id>" if asked
+	include := container.ListBlobsInclude{Snapshots: b.listSnapshots}
+	var objs []Object
+	var items []*container.BlobItem
+	var next *string
+	if delimiter == "" {
+		page, err := nextPage(b, func() *runtime.Pager[container.ListBlobsFlatResponse] {
+			return b.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix, Marker: marker, MaxResults: &limit32, Include: include})
+		})
+		if err != nil {
+			return nil, "", false, err
+		}
+		if page.Segment != nil {
+			items = page.Segment.BlobItems
+		}
+		next = page.NextMarker
+	} else {
+		page, err := nextPage(b, func() *runtime.Pager[container.ListBlobsHierarchyResponse] {
+			return b.container.NewListBlobsHierarchyPager(delimiter, &container.ListBlobsHierarchyOptions{Prefix: &prefix, Marker: marker, MaxResults: &limit32, Include: include})
+		})
+		if err != nil {
+			return nil, "", false, err
+		}
+		if page.Segment != nil {
+			items = page.Segment.BlobItems
+			for _, p := range page.Segment.BlobPrefixes {
+				objs = append(objs, &obj{*p.Name, 0, time.Unix(0, 0), true, ""})
+			}
+		}
+		next = page.NextMarker
+	}
+	for _, blob := range items {
+		objs = append(objs, blobItem2Obj(blob))
+	}
+	snapshot := len(items) > 0 && aws.StringValue(items[len(items)-1].Snapshot) != ""
+	return objs, aws.StringValue(next), snapshot, nil
+}
 
-	pager := b.azblobCli.NewListBlobsFlatPager(b.cName, &azblob.ListBlobsFlatOptions{Prefix: &prefix, Marker: &marker, MaxResults: &(limit32)})
-	page, err := pager.NextPage(ctx)
+// List returns the blobs after marker (a key). Azure can't start listing from a key, so the continuation token
+// of Azure where the previous page stopped is used if marker is the last key of it, otherwise the pages before
+// marker are skipped by following the continuation tokens, use ListAll for a full listing.
+func (b *wasb) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if limit <= 0 {
+		limit = maxResults
+	}
+	var objs []Object
+	token := b.markers.get(prefix, delimiter, marker)
+	skipping := marker != "" && token == ""
+	for {
+		// ask for the rest only, so the page stops at the continuation token of Azure
+		size := limit - int64(len(objs))
+		if skipping && len(objs) == 0 && b.pageSize > 0 {
+			// large pages save the requests to skip the blobs before marker, even if a few blobs are asked
+			size = b.pageSize
+		}
+		page, next, err := b.listBlobs(prefix, delimiter, token, size)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page {
+			if marker == "" || o.Key() > marker {
+				objs = append(objs, o)
+			}
+		}
+		if int64(len(objs)) >= limit {
+			if int64(len(objs)) == limit && next != "" {
+				b.markers.put(prefix, delimiter, objs[limit-1].Key(), next)
+			}
+			return objs[:limit], nil
+		}
+		if next == "" {
+			return objs, nil
+		}
+		token = next
+	}
+}
+
+// ListWithDelimiter uses the hierarchy listing of Azure, the blob prefixes are returned as common prefixes.
+func (b *wasb) ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	return listCommonPrefixes(b, prefix, delimiter, marker, limit)
+}
+
+func (b *wasb) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	objs, token, err := b.listBlobs(prefix, "", "", b.fullPageSize())
 	if err != nil {
 		return nil, err
 	}
-	if pager.More() {
-		b.marker = *page.NextMarker
-	} else {
-		b.marker = ""
-	}
-	var n int
-	if page.Segment != nil {
-		n = len(page.Segment.BlobItems)
-	}
-	objs := make([]Object, n)
-	for i := 0; i < n; i++ {
-		blob := page.Segment.BlobItems[i]
-		mtime := blob.Properties.LastModified
-		objs[i] = &obj{
-			*blob.Name,
-			*blob.Properties.ContentLength,
-			*mtime,
-			strings.HasSuffix(*blob.Name, "/"),
-			string(*blob.Properties.AccessTier),
+	out := make(chan Object, 5000)
+	go func() {
+		defer close(out)
+		for {
+			for _, o := range objs {
+				// Azure can't start listing from a key, skip the ones before marker
+				if o.Key() > marker {
+					out <- o
+				}
+			}
+			if token == "" {
+				return
+			}
+			if objs, token, err = b.listBlobs(prefix, "", token, b.fullPageSize()); err != nil {
+				logger.Errorf("Fail to list %s: %s", b, err)
+				out <- nil
+				return
+			}
 		}
+	}()
+	return out, nil
+}
+
+// ListAllResumable resumes from the continuation token of the page having the last object listed, so the
+// pages before it are not listed again.
+func (b *wasb) ListAllResumable(prefix string, token ResumeToken, followLink bool) (<-chan ListedObject, error) {
+	state, err := token.parse(prefix)
+	if err != nil {
+		return nil, err
 	}
-	return objs, nil
+	page := state.Page
+	objs, next, err := b.listBlobs(prefix, "", page, b.fullPageSize())
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan ListedObject, 5000)
+	go func() {
+		defer close(out)
+		for {
+			for _, o := range objs {
+				if o.Key() > state.Marker {
+					out <- ListedObject{o, (&resumeState{Prefix: prefix, Marker: o.Key(), Page: page}).token()}
+				}
+			}
+			if next == "" {
+				return
+			}
+			page = next
+			if objs, next, err = b.listBlobs(prefix, "", page, b.fullPageSize()); err != nil {
+				logger.Errorf("Fail to list %s: %s", b, err)
+				out <- ListedObject{}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (b *wasb) Limits() Limits {
+	limits := Limits{
+		IsSupportMultipartUpload: true,
+		MinPartSize:              5 << 20,
+		MaxPartSize:              4000 << 20,
+		MaxPartCount:             50000,
+		MaxObjectSize:            50000 * (4000 << 20), // about 190.7 TiB
+		PartSize:                 b.partSize,
+		UploadConcurrency:        b.uploadConcurrency,
+	}
+	if b.uploadPartCopy {
+		// the larger blobs are copied in blocks, otherwise by an asynchronous copy of Copy
+		limits.IsSupportUploadPartCopy = true
+		limits.MaxCopySize = wasbSyncCopyLimit
+	}
+	return limits
+}
+
+// blockID returns the base64 encoded ID of a block, all the IDs of a blob must have the same length.
+func blockID(uploadID string, num int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", uploadID, num)))
+}
+
+func (b *wasb) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	// there is no upload session for block blobs, the ID only makes block IDs of different uploads unique
+	uploadID := fmt.Sprintf("%016x", rand.Uint64())
+	return &MultipartUpload{UploadID: uploadID, MinPartSize: 5 << 20, MaxCount: 50000}, nil
+}
+
+// UploadPart stages a block of the blob. Blocks have no ETag, the ETag of the part is the hex encoded MD5 of the block
+// computed by Azure (like the ETag of a part of S3), the blocks are committed by their numbers in CompleteUpload.
+func (b *wasb) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	id := blockID(uploadID, num)
+	var options blockblob.StageBlockOptions
+	if !b.disableChecksum {
+		sum := md5.Sum(body)
+		options.TransactionalValidation = blob2.TransferValidationTypeMD5(sum[:])
+	}
+	var resp blockblob.StageBlockResponse
+	err := b.retry(func() (err error) {
+		resp, err = b.container.NewBlockBlobClient(key).StageBlock(b.ctx, id, streaming.NopCloser(bytes.NewReader(body)), &options)
+		return
+	})
+	if err != nil {
+		return nil, checkMD5Mismatch(key, err)
+	}
+	return &Part{Num: num, Size: len(body), ETag: hex.EncodeToString(resp.ContentMD5)}, nil
+}
+
+// UploadPartCopy stages a block copied from a range of srcKey by Azure (Put Block From URL), so a large blob can
+// be copied in blocks concurrently rather than by a single asynchronous copy.
+func (b *wasb) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	id := blockID(uploadID, num)
+	var resp blockblob.StageBlockFromURLResponse
+	err := b.retry(func() error {
+		// the short-lived authorization of the source is renewed for every attempt
+		source, auth, err := b.copySource(b.container.NewBlobClient(srcKey))
+		if err != nil {
+			return err
+		}
+		options := &blockblob.StageBlockFromURLOptions{
+			CopySourceAuthorization: auth,
+			Range:                   blob2.HTTPRange{Offset: off, Count: size},
+		}
+		resp, err = b.container.NewBlockBlobClient(key).StageBlockFromURL(b.ctx, id, source, options)
+		return err
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	return &Part{Num: num, Size: int(size), ETag: hex.EncodeToString(resp.ContentMD5)}, nil
+}
+
+// AbortUpload does nothing, the uncommitted blocks will be garbage collected by Azure after 7 days.
+func (b *wasb) AbortUpload(key string, uploadID string) {}
+
+func (b *wasb) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Num < parts[j].Num })
+	ids := make([]string, len(parts))
+	for i, p := range parts {
+		ids[i] = blockID(uploadID, p.Num)
+	}
+	options := &blockblob.CommitBlockListOptions{}
+	if !b.disableContentType {
+		options.HTTPHeaders = &blob2.HTTPHeaders{BlobContentType: to.Ptr(utils.GuessMimeType(key))}
+	}
+	if b.sc != "" {
+		options.Tier = str2Tier(b.sc)
+	}
+	return b.retry(func() error {
+		_, err := b.container.NewBlockBlobClient(key).CommitBlockList(b.ctx, ids, options)
+		return err
+	})
 }
 
 func (b *wasb) SetStorageClass(sc string) error {
+	if sc != "" {
+		tier := str2Tier(sc)
+		if tier == nil {
+			return fmt.Errorf("invalid access tier %q for Azure, valid values: %v", sc, blob2.PossibleAccessTierValues())
+		}
+		sc = string(*tier)
+	}
 	b.sc = sc
 	return nil
 }
 
-func autoWasbEndpoint(containerName, accountName, scheme string, credential *azblob.SharedKeyCredential) (string, error) {
+func autoWasbEndpoint(containerName, accountName, scheme string, newClient func(serviceURL string) (*azblob.Client, error)) (string, error) {
 	baseURLs := []string{"blob.core.windows.net", "blob.core.chinacloudapi.cn"}
 	endpoint := ""
 	for _, baseURL := range baseURLs {
@@ -193,13 +1422,16 @@ func autoWasbEndpoint(containerName, accountName, scheme string, credential *azb
 			logger.Debugf("Attempt to resolve domain name %s failed: %s", baseURL, err)
 			continue
 		}
-		client, err := azblob.NewClientWithSharedKeyCredential(fmt.Sprintf("%s://%s.%s", scheme, accountName, baseURL), credential, nil)
+		client, err := newClient(fmt.Sprintf("%s://%s.%s", scheme, accountName, baseURL))
 		if err != nil {
 			return "", err
 		}
-		if _, err = client.ServiceClient().GetProperties(ctx, nil); err != nil {
-			logger.Debugf("Try to get containers properties at %s failed: %s", baseURL, err)
-			continue
+		// a SAS token may be scoped to the container only, so probe the container instead of the service
+		if _, err = client.ServiceClient().NewContainerClient(containerName).GetProperties(ctx, nil); err != nil {
+			if e, ok := err.(*azcore.ResponseError); !ok || e.ErrorCode != string(bloberror.ContainerNotFound) {
+				logger.Debugf("Try to get container properties at %s failed: %s", baseURL, err)
+				continue
+			}
 		}
 		endpoint = baseURL
 		break
@@ -211,6 +1443,156 @@ func autoWasbEndpoint(containerName, accountName, scheme string, credential *azb
 	return endpoint, nil
 }
 
+// wasbCredential selects how to authenticate with Azure: a SAS token, an Azure AD token
+// (managed identity or service principal) when no account key is given and AZURE_CLIENT_ID
+// is set, or the shared key of the storage account.
+func wasbCredential(containerName, accountName, accountKey, sasToken string, hc *http.Client) (func(serviceURL string) (*azblob.Client, error), azcore.TokenCredential, error) {
+	options := wasbClientOptions(hc)
+	if sasToken != "" {
+		if accountKey != "" {
+			return nil, nil, fmt.Errorf("both SAS token and account key are specified for container %s: the SAS token takes precedence, unset the account key (--secret-key)", containerName)
+		}
+		return func(serviceURL string) (*azblob.Client, error) {
+			return azblob.NewClientWithNoCredential(withSASToken(serviceURL, sasToken), options)
+		}, nil, nil
+	}
+	if accountKey == "" && os.Getenv("AZURE_CLIENT_ID") != "" {
+		cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: options.ClientOptions})
+		if err != nil {
+			return nil, nil, fmt.Errorf("create Azure AD credential: %s", err)
+		}
+		return func(serviceURL string) (*azblob.Client, error) {
+			return azblob.NewClient(serviceURL, cred, options)
+		}, cred, nil
+	}
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(serviceURL string) (*azblob.Client, error) {
+		return azblob.NewClientWithSharedKeyCredential(serviceURL, credential, options)
+	}, nil, nil
+}
+
+// providerToken is an Azure AD token credential backed by a CredentialProvider.
+type providerToken struct {
+	r *credentialRefresher
+}
+
+func (t *providerToken) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	cred, _ := t.r.current()
+	return azcore.AccessToken{Token: cred.Token, ExpiresOn: cred.Expiration}, nil
+}
+
+// SetCredentialProvider authenticates with the Azure AD token (Token) or the shared key of the storage
+// account (AccessKey is the account name, SecretKey is the account key) from the provider, which are renewed
+// in background before they expire. SAS tokens are not supported, since they are part of the URL.
+func (b *wasb) SetCredentialProvider(p CredentialProvider) error {
+	if b.sasToken != "" {
+		return fmt.Errorf("%w: can't renew the SAS token of %s", notSupported, b)
+	}
+	var keyCred *azblob.SharedKeyCredential
+	r, err := newCredentialRefresher(p, func(c *Credentials) {
+		if keyCred != nil {
+			if err := keyCred.SetAccountKey(c.SecretKey); err != nil {
+				logger.Errorf("Update the account key of %s: %s", b, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	cred, _ := r.current()
+	serviceURL := b.azblobCli.URL()
+	options := wasbClientOptions(b.hc)
+	var client *azblob.Client
+	if cred.Token != "" {
+		b.tokenCred = &providerToken{r}
+		client, err = azblob.NewClient(serviceURL, b.tokenCred, options)
+	} else {
+		accountName := cred.AccessKey
+		if accountName == "" {
+			accountName = strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(serviceURL, "https://"), "http://"), ".", 2)[0]
+		}
+		if keyCred, err = azblob.NewSharedKeyCredential(accountName, cred.SecretKey); err == nil {
+			client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, keyCred, options)
+		}
+	}
+	if err != nil {
+		r.stop()
+		return err
+	}
+	b.creds.stop()
+	b.creds = r
+	b.azblobCli = client
+	b.container = client.ServiceClient().NewContainerClient(b.cName)
+	return nil
+}
+
+// Shutdown stops renewing the credentials from the CredentialProvider.
+func (b *wasb) Shutdown() {
+	b.creds.stop()
+}
+
+// wasbHTTPClient returns the client used by all the requests to Azure, so connections are pooled
+// across blob clients. The proxy is taken from HTTP_PROXY/HTTPS_PROXY/NO_PROXY, and the timeout of
+// each request can be changed by AZURE_STORAGE_TIMEOUT (e.g. "30s").
+func wasbHTTPClient() (*http.Client, error) {
+	tr := httpClient.Transport.(*http.Transport).Clone()
+	proxy := httpproxy.FromEnvironment().ProxyFunc()
+	tr.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+	hc := &http.Client{Transport: tr, Timeout: httpClient.Timeout}
+	if v := os.Getenv("AZURE_STORAGE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_TIMEOUT %q: %s", v, err)
+		}
+		hc.Timeout = timeout
+	}
+	return hc, nil
+}
+
+// wasbClientOptions returns the options of the Azure clients. The retries of the SDK are disabled, the failed
+// requests are retried by wasb.retry only (up to AZURE_STORAGE_MAX_RETRIES times), otherwise the attempts of both
+// multiply. The upload of a stream (UploadStream) can't be rewound, so it's not retried.
+func wasbClientOptions(hc *http.Client) *azblob.ClientOptions {
+	return &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: hc, Retry: policy.RetryOptions{MaxRetries: -1}}}
+}
+
+// wasbConnectionString returns the connection string in AZURE_STORAGE_CONNECTION_STRING, or in the file of
+// AZURE_STORAGE_CREDENTIAL_FILE (e.g. mounted from a secret), where the fields can also be put in separate lines,
+// and the lines starting with "#" are ignored.
+func wasbConnectionString() (string, error) {
+	if connString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connString != "" {
+		return connString, nil
+	}
+	path := os.Getenv("AZURE_STORAGE_CREDENTIAL_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read credential file of Azure: %s", err)
+	}
+	var fields []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.Trim(strings.TrimSpace(line), ";"); line != "" && !strings.HasPrefix(line, "#") {
+			fields = append(fields, line)
+		}
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no connection string in credential file of Azure %s", path)
+	}
+	return strings.Join(fields, ";"), nil
+}
+
+// withSASToken appends the SAS token as the query string of a service URL.
+func withSASToken(serviceURL, sasToken string) string {
+	return fmt.Sprintf("%s/?%s", strings.TrimSuffix(serviceURL, "/"), strings.TrimPrefix(sasToken, "?"))
+}
+
 func newWasb(endpoint, accountName, accountKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("https://%s", endpoint)
@@ -221,36 +1603,122 @@ func newWasb(endpoint, accountName, accountKey, token string) (ObjectStorage, er
 	}
 	hostParts := strings.SplitN(uri.Host, ".", 2)
 	containerName := hostParts[0]
+	query := uri.Query()
+	disableChecksum := strings.EqualFold(query.Get("disable-checksum"), "true")
+	// Azure only verifies Content-MD5
+	switch algo := query.Get("checksum-algorithm"); {
+	case algo == "", strings.EqualFold(algo, "md5"):
+	case strings.EqualFold(algo, "none"):
+		disableChecksum = true
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q for Azure, should be MD5 or none", algo)
+	}
+	if disableChecksum {
+		logger.Infof("MD5 checksum is disabled")
+	}
+	query.Del("disable-checksum")
+	query.Del("checksum-algorithm")
+	disableContentType := strings.EqualFold(query.Get("disable-content-type"), "true")
+	query.Del("disable-content-type")
+	listSnapshots := strings.EqualFold(query.Get("list-snapshots"), "true")
+	query.Del("list-snapshots")
+	uploadPartCopy := strings.EqualFold(query.Get("upload-part-copy"), "true")
+	query.Del("upload-part-copy")
+	anonymous := strings.EqualFold(query.Get("anonymous"), "true")
+	query.Del("anonymous")
+	var pageSize int64
+	if v := query.Get("list-page-size"); v != "" {
+		if pageSize, err = strconv.ParseInt(v, 10, 64); err != nil || pageSize <= 0 || pageSize > 5000 {
+			return nil, fmt.Errorf("invalid list-page-size %q, should be between 1 and 5000", v)
+		}
+	}
+	query.Del("list-page-size")
+	var listTimeout time.Duration
+	if v := query.Get("list-timeout"); v != "" {
+		if listTimeout, err = time.ParseDuration(v); err != nil || listTimeout <= 0 {
+			return nil, fmt.Errorf("invalid list-timeout %q, should be a positive duration like 30s", v)
+		}
+	}
+	query.Del("list-timeout")
+	partSize, uploadConcurrency, err := parseUploadOptions(query, (&wasb{}).Limits())
+	if err != nil {
+		return nil, err
+	}
+	query.Del("part-size")
+	query.Del("upload-concurrency")
+	putThreshold, err := parsePutThreshold(query, blockblob.MaxUploadBlobBytes)
+	if err != nil {
+		return nil, err
+	}
+	query.Del("put-threshold")
+	hc, err := wasbHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	b := &wasb{cName: containerName, hc: hc, ctx: ctx, disableChecksum: disableChecksum, disableContentType: disableContentType, listSnapshots: listSnapshots, uploadPartCopy: uploadPartCopy, maxRetries: retryCountFromEnv("AZURE_STORAGE_MAX_RETRIES", 3), pageSize: pageSize, listTimeout: listTimeout, partSize: partSize, uploadConcurrency: uploadConcurrency, putThreshold: putThreshold, markers: newListMarkers()}
+
 	// Connection string support: DefaultEndpointsProtocol=[http|https];AccountName=***;AccountKey=***;EndpointSuffix=[core.windows.net|core.chinacloudapi.cn]
-	if connString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connString != "" {
-		var client *azblob.Client
-		if client, err = azblob.NewClientFromConnectionString(connString, nil); err != nil {
+	// The account key in the arguments takes precedence over the connection string.
+	var connString string
+	if accountKey == "" && !anonymous {
+		if connString, err = wasbConnectionString(); err != nil {
+			return nil, err
+		}
+	}
+	if connString != "" {
+		if b.azblobCli, err = azblob.NewClientFromConnectionString(connString, wasbClientOptions(hc)); err != nil {
 			return nil, err
 		}
-		return &wasb{container: client.ServiceClient().NewContainerClient(containerName), azblobCli: client, cName: containerName}, nil
+		b.container = b.azblobCli.ServiceClient().NewContainerClient(containerName)
+		return b, nil
 	}
 
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
+	// SAS token support: https://<container>.<endpoint>?<sas-token> or AZURE_STORAGE_SAS_TOKEN
+	if query.Get("sig") != "" {
+		b.sasToken = query.Encode()
+	} else {
+		b.sasToken = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	var newClient func(serviceURL string) (*azblob.Client, error)
+	if anonymous {
+		// public containers can be read without credentials
+		if accountKey != "" || b.sasToken != "" {
+			return nil, fmt.Errorf("anonymous access to container %s can't be used with an account key or SAS token", containerName)
+		}
+		newClient = func(serviceURL string) (*azblob.Client, error) {
+			return azblob.NewClientWithNoCredential(serviceURL, wasbClientOptions(hc))
+		}
+	} else if newClient, b.tokenCred, err = wasbCredential(containerName, accountName, accountKey, b.sasToken, hc); err != nil {
 		return nil, err
 	}
+
 	var domain string
 	if len(hostParts) > 1 {
 		domain = hostParts[1]
 		if !strings.HasPrefix(hostParts[1], "blob") {
 			domain = fmt.Sprintf("blob.%s", hostParts[1])
 		}
-	} else if domain, err = autoWasbEndpoint(containerName, accountName, uri.Scheme, credential); err != nil {
+	} else if domain, err = autoWasbEndpoint(containerName, accountName, uri.Scheme, newClient); err != nil {
 		return nil, fmt.Errorf("Unable to get endpoint of container %s: %s", containerName, err)
 	}
 
-	client, err := azblob.NewClientWithSharedKeyCredential(fmt.Sprintf("%s://%s.%s", uri.Scheme, accountName, domain), credential, nil)
-	if err != nil {
+	if b.azblobCli, err = newClient(fmt.Sprintf("%s://%s.%s", uri.Scheme, accountName, domain)); err != nil {
 		return nil, err
 	}
-	return &wasb{container: client.ServiceClient().NewContainerClient(containerName), azblobCli: client, cName: containerName}, nil
+	b.container = b.azblobCli.ServiceClient().NewContainerClient(containerName)
+	if anonymous {
+		return withAnonymous(b), nil
+	}
+	return b, nil
 }
 
 func init() {
 	Register("wasb", newWasb)
+	statusCodeFuncs = append(statusCodeFuncs, func(err error) int {
+		var e *azcore.ResponseError
+		if errors.As(err, &e) {
+			return e.StatusCode
+		}
+		return 0
+	})
 }
@@ -20,12 +20,19 @@
 package object
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/kothar/go-backblaze.v0"
@@ -33,14 +40,18 @@ import (
 
 type b2client struct {
 	DefaultObjectStorage
-	bucket     *backblaze.Bucket
-	nextMarker string
+	bucket *backblaze.Bucket
+	api    *b2API
 }
 
 func (c *b2client) String() string {
 	return fmt.Sprintf("b2://%s/", c.bucket.Name)
 }
 
+func (c *b2client) BucketName() string {
+	return c.bucket.Name
+}
+
 func (c *b2client) Create() error {
 	return nil
 }
@@ -110,48 +121,283 @@ func (c *b2client) Copy(dst, src string) error {
 	return err
 }
 
+// Delete deletes all the versions of the key, including the hide markers, since deleting
+// only the latest version would expose the previous one.
 func (c *b2client) Delete(key string, getters ...AttrGetter) error {
-	f, err := c.getFileInfo(key)
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "not_found") {
+	start, startID := key, ""
+	for {
+		resp, err := c.bucket.ListFileVersions(start, startID, 100)
+		if err != nil {
+			return err
+		}
+		for _, f := range resp.Files {
+			if f.Name != key {
+				return nil
+			}
+			if _, err = c.bucket.DeleteFileVersion(f.Name, f.ID); err != nil {
+				if e, ok := err.(*backblaze.B2Error); ok && e.Status == http.StatusNotFound {
+					continue
+				}
+				return err
+			}
+		}
+		if resp.NextFileName != key {
 			return nil
 		}
-		return err
+		start, startID = resp.NextFileName, resp.NextFileID
 	}
-	_, err = c.bucket.DeleteFileVersion(key, f.ID)
-	return err
 }
 
+// List uses the marker as startFileName of b2_list_file_names, which is inclusive,
+// so one more file is listed and the marker itself is skipped.
 func (c *b2client) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
-	if limit > 1000 {
+	if limit <= 0 || limit > 1000 {
 		limit = 1000
 	}
-	if marker == "" && c.nextMarker != "" {
-		marker = c.nextMarker
-		c.nextMarker = ""
+	start := marker
+	if start < prefix {
+		start = prefix
 	}
-	resp, err := c.bucket.ListFileNamesWithPrefix(marker, int(limit), prefix, delimiter)
+	resp, err := c.bucket.ListFileNamesWithPrefix(start, int(limit)+1, prefix, delimiter)
 	if err != nil {
 		return nil, err
 	}
 
-	n := len(resp.Files)
-	objs := make([]Object, n)
-	for i := 0; i < n; i++ {
-		f := resp.Files[i]
-		objs[i] = &obj{
+	objs := make([]Object, 0, len(resp.Files))
+	for _, f := range resp.Files {
+		if marker != "" && f.Name <= marker {
+			continue
+		}
+		objs = append(objs, &obj{
 			f.Name,
 			f.ContentLength,
 			time.Unix(f.UploadTimestamp/1000, 0),
 			strings.HasSuffix(f.Name, "/"),
 			"",
+		})
+		if len(objs) == int(limit) {
+			break
 		}
 	}
-	c.nextMarker = resp.NextFileName
 	return objs, nil
 }
 
-// TODO: support multipart upload using S3 client
+func (c *b2client) Limits() Limits {
+	return Limits{
+		IsSupportMultipartUpload: true,
+		IsSupportUploadPartCopy:  true,
+		MinPartSize:              5 << 20,
+		MaxPartSize:              5 << 30,
+		MaxPartCount:             10000,
+		MaxObjectSize:            10 << 40,
+	}
+}
+
+// CreateMultipartUpload starts a large file, whose fileId is used as the upload ID.
+func (c *b2client) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	var resp struct {
+		FileID string `json:"fileId"`
+	}
+	err := c.api.call("b2_start_large_file", map[string]string{
+		"bucketId":    c.bucket.ID,
+		"fileName":    key,
+		"contentType": "b2/x-auto",
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &MultipartUpload{UploadID: resp.FileID, MinPartSize: 5 << 20, MaxCount: 10000}, nil
+}
+
+// UploadPart uploads the part with its SHA1, which is returned as the ETag and used to finish the large file.
+func (c *b2client) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	var target struct {
+		UploadURL          string `json:"uploadUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	if err := c.api.call("b2_get_upload_part_url", map[string]string{"fileId": uploadID}, &target); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, target.UploadURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(body)
+	req.Header.Set("Authorization", target.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(num))
+	req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(sum[:]))
+	var resp struct {
+		ContentSha1 string `json:"contentSha1"`
+	}
+	if err = c.api.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &Part{Num: num, Size: len(body), ETag: resp.ContentSha1}, nil
+}
+
+func (c *b2client) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	f, err := c.getFileInfo(srcKey)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		ContentSha1 string `json:"contentSha1"`
+	}
+	err = c.api.call("b2_copy_part", map[string]interface{}{
+		"sourceFileId": f.ID,
+		"largeFileId":  uploadID,
+		"partNumber":   num,
+		"range":        fmt.Sprintf("bytes=%d-%d", off, off+size-1),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Part{Num: num, Size: int(size), ETag: resp.ContentSha1}, nil
+}
+
+func (c *b2client) AbortUpload(key string, uploadID string) {
+	if err := c.api.call("b2_cancel_large_file", map[string]string{"fileId": uploadID}, nil); err != nil {
+		logger.Warnf("Cancel large file %s (%s): %s", key, uploadID, err)
+	}
+}
+
+func (c *b2client) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	sha1s := make([]string, len(parts))
+	for i, p := range parts {
+		sha1s[i] = p.ETag
+	}
+	return c.api.call("b2_finish_large_file", map[string]interface{}{
+		"fileId":        uploadID,
+		"partSha1Array": sha1s,
+	}, nil)
+}
+
+// ListUploads lists the unfinished large files, the marker is the fileId to start with.
+func (c *b2client) ListUploads(marker string) ([]*PendingPart, string, error) {
+	req := map[string]interface{}{"bucketId": c.bucket.ID, "maxFileCount": 100}
+	if marker != "" {
+		req["startFileId"] = marker
+	}
+	var resp struct {
+		Files []struct {
+			FileID          string `json:"fileId"`
+			FileName        string `json:"fileName"`
+			UploadTimestamp int64  `json:"uploadTimestamp"`
+		} `json:"files"`
+		NextFileID string `json:"nextFileId"`
+	}
+	if err := c.api.call("b2_list_unfinished_large_files", req, &resp); err != nil {
+		return nil, "", err
+	}
+	parts := make([]*PendingPart, len(resp.Files))
+	for i, f := range resp.Files {
+		parts[i] = &PendingPart{f.FileName, f.FileID, time.UnixMilli(f.UploadTimestamp)}
+	}
+	return parts, resp.NextFileID, nil
+}
+
+const b2APIHost = "https://api.backblazeb2.com"
+
+// b2Error is the error returned by B2 native API.
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+func (e *b2Error) StatusCode() int {
+	return e.Status
+}
+
+type b2Auth struct {
+	AuthorizationToken string `json:"authorizationToken"`
+	APIURL             string `json:"apiUrl"`
+}
+
+// b2API calls the B2 native API (v2) for the large files, which are not supported by go-backblaze.
+type b2API struct {
+	sync.Mutex
+	host           string
+	keyID          string
+	applicationKey string
+	auth           *b2Auth
+}
+
+func (a *b2API) authorize() (*b2Auth, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.auth != nil {
+		return a.auth, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, a.host+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(a.keyID, a.applicationKey)
+	var auth b2Auth
+	if err = a.do(req, &auth); err != nil {
+		return nil, fmt.Errorf("authorize account: %w", err)
+	}
+	a.auth = &auth
+	return a.auth, nil
+}
+
+func (a *b2API) do(req *http.Request, result interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &b2Error{Status: resp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Code == "" {
+			e.Code, e.Message = http.StatusText(resp.StatusCode), string(data)
+		}
+		return e
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// call calls the API with the request encoded as JSON, the account is authorized again once the token expires.
+func (a *b2API) call(name string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		auth, err := a.authorize()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+name, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		err = a.do(req, result)
+		var e *b2Error
+		if i == 0 && errors.As(err, &e) && e.Status == http.StatusUnauthorized {
+			a.Lock()
+			if a.auth == auth {
+				a.auth = nil
+			}
+			a.Unlock()
+			continue
+		}
+		return err
+	}
+}
 
 func newB2(endpoint, keyID, applicationKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
@@ -184,7 +430,7 @@ func newB2(endpoint, keyID, applicationKey, token string) (ObjectStorage, error)
 	if bucket == nil {
 		return nil, fmt.Errorf("can't find bucket %s with provided Key ID", name)
 	}
-	return &b2client{bucket: bucket}, nil
+	return &b2client{bucket: bucket, api: &b2API{host: b2APIHost, keyID: keyID, applicationKey: applicationKey}}, nil
 }
 
 func init() {
@@ -47,6 +47,10 @@ func (q *bosclient) String() string {
 	return fmt.Sprintf("bos://%s/", q.bucket)
 }
 
+func (q *bosclient) BucketName() string {
+	return q.bucket
+}
+
 func (q *bosclient) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
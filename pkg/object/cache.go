@@ -0,0 +1,244 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheItemLimit is the max size of the data cached for one Get, larger reads go to the object storage directly.
+const cacheItemLimit = 4 << 20
+
+type cacheItem struct {
+	id   string // name of the cache file
+	key  string
+	size int64
+	crc  uint32
+}
+
+// diskCache keeps the cached data in files of dir, the index is only kept in memory.
+type diskCache struct {
+	sync.Mutex
+	dir      string
+	capacity int64
+	used     int64
+	lru      *list.List               // of *cacheItem, the most recently used ones first
+	items    map[string]*list.Element // by id
+	keys     map[string][]string      // ids of ranges by key
+	version  uint64                   // increased by every invalidation
+}
+
+type cached struct {
+	ObjectStorage
+	*diskCache
+}
+
+// WithCache caches the data returned by Get in the local directory dir, up to size bytes in total and evicts
+// the least recently used data when it's full. A whole small object or a range of a large one is cached as
+// one item (at most 4 MiB), and the items of a key are invalidated when it's modified through this object
+// storage (Put, Copy, Delete, CompleteUpload), the changes made by others are not noticed. The cache files
+// are verified by CRC32C when read, the broken ones are dropped and read from the object storage again.
+// The object storage is returned as is if the directory can't be created.
+func WithCache(s ObjectStorage, dir string, size int64) ObjectStorage {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Warnf("Disable cache of %s in %s: %s", s, dir, err)
+		return s
+	}
+	// the index is lost after restart, remove the cache files left by the previous process
+	if names, err := filepath.Glob(filepath.Join(dir, "*.cache")); err == nil {
+		for _, name := range names {
+			_ = os.Remove(name)
+		}
+	}
+	return &cached{s, &diskCache{
+		dir:      dir,
+		capacity: size,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		keys:     make(map[string][]string),
+	}}
+}
+
+func (c *cached) WithContext(ctx context.Context) ObjectStorage {
+	return &cached{WithContext(c.ObjectStorage, ctx), c.diskCache}
+}
+
+func (c *cached) String() string {
+	return fmt.Sprintf("%s(cached)", c.ObjectStorage)
+}
+
+func cacheID(key string, off, limit int64) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", key, off, limit)))
+	return hex.EncodeToString(h[:])
+}
+
+func (d *diskCache) path(id string) string {
+	return filepath.Join(d.dir, id+".cache")
+}
+
+// load returns the cached data, or nil if it's not cached or the cache file is broken.
+func (d *diskCache) load(id string) []byte {
+	d.Lock()
+	e, ok := d.items[id]
+	if !ok {
+		d.Unlock()
+		return nil
+	}
+	d.lru.MoveToFront(e)
+	item := *e.Value.(*cacheItem)
+	d.Unlock()
+
+	data, err := os.ReadFile(d.path(id))
+	if err == nil && int64(len(data)) == item.size && crc32.Checksum(data, crc32c) == item.crc {
+		return data
+	}
+	logger.Warnf("Drop broken cache of %s: %d bytes, %v", item.key, len(data), err)
+	d.Lock()
+	if e, ok := d.items[id]; ok {
+		d.remove(e)
+	}
+	d.Unlock()
+	return nil
+}
+
+// store saves the data into cache, unless the object is invalidated after version.
+func (d *diskCache) store(id, key string, data []byte, version uint64) {
+	if int64(len(data)) > d.capacity {
+		return
+	}
+	p := d.path(id)
+	tmp := fmt.Sprintf("%s.tmp%d", p, rand.Int())
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Debugf("Write cache of %s: %s", key, err)
+		_ = os.Remove(tmp)
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.version != version {
+		_ = os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+	if e, ok := d.items[id]; ok {
+		d.used -= e.Value.(*cacheItem).size
+		d.lru.Remove(e)
+	} else {
+		d.keys[key] = append(d.keys[key], id)
+	}
+	d.items[id] = d.lru.PushFront(&cacheItem{id, key, int64(len(data)), crc32.Checksum(data, crc32c)})
+	d.used += int64(len(data))
+	for d.used > d.capacity {
+		d.remove(d.lru.Back())
+	}
+}
+
+// remove drops an item, the lock must be held.
+func (d *diskCache) remove(e *list.Element) {
+	item := e.Value.(*cacheItem)
+	d.lru.Remove(e)
+	delete(d.items, item.id)
+	d.used -= item.size
+	ids := d.keys[item.key]
+	for i, id := range ids {
+		if id == item.id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(d.keys, item.key)
+	} else {
+		d.keys[item.key] = ids
+	}
+	_ = os.Remove(d.path(item.id))
+}
+
+// invalidate drops all the cached ranges of the key.
+func (d *diskCache) invalidate(key string) {
+	d.Lock()
+	defer d.Unlock()
+	d.version++
+	for _, id := range append([]string(nil), d.keys[key]...) {
+		d.remove(d.items[id])
+	}
+}
+
+func (c *cached) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if limit > cacheItemLimit {
+		return c.ObjectStorage.Get(key, off, limit, getters...)
+	}
+	id := cacheID(key, off, limit)
+	if data := c.load(id); data != nil {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	c.Lock()
+	version := c.version
+	c.Unlock()
+	in, err := c.ObjectStorage.Get(key, off, limit, getters...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(in, cacheItemLimit+1))
+	if err != nil {
+		_ = in.Close()
+		return nil, err
+	}
+	if len(data) > cacheItemLimit { // too large to cache
+		return &bufferedReadCloser{bufio.NewReader(io.MultiReader(bytes.NewReader(data), in)), in}, nil
+	}
+	_ = in.Close()
+	c.store(id, key, data, version)
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *cached) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	defer c.invalidate(key)
+	return c.ObjectStorage.Put(key, in, getters...)
+}
+
+func (c *cached) Copy(dst, src string) error {
+	defer c.invalidate(dst)
+	return c.ObjectStorage.Copy(dst, src)
+}
+
+func (c *cached) Delete(key string, getters ...AttrGetter) error {
+	defer c.invalidate(key)
+	return c.ObjectStorage.Delete(key, getters...)
+}
+
+func (c *cached) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	defer c.invalidate(key)
+	return c.ObjectStorage.CompleteUpload(key, uploadID, parts)
+}
+
+var _ ObjectStorage = &cached{}
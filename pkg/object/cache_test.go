@@ -0,0 +1,149 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type countedGets struct {
+	ObjectStorage
+	gets int32
+}
+
+func (c *countedGets) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return c.ObjectStorage.Get(key, off, limit, getters...)
+}
+
+func TestCache(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	b := &countedGets{ObjectStorage: m}
+	dir := t.TempDir()
+	s := WithCache(b, dir, 10)
+
+	_ = s.Put("a", bytes.NewReader([]byte("hello")))
+	for i := 0; i < 3; i++ {
+		if d, err := get(s, "a", 0, -1); err != nil || d != "hello" {
+			t.Fatalf("get: %q %v", d, err)
+		}
+		if d, err := get(s, "a", 1, 3); err != nil || d != "ell" {
+			t.Fatalf("get range: %q %v", d, err)
+		}
+	}
+	if b.gets != 2 {
+		t.Fatalf("the object and the range should be read once: %d", b.gets)
+	}
+
+	// invalidated by Put
+	_ = s.Put("a", bytes.NewReader([]byte("world")))
+	if d, _ := get(s, "a", 0, -1); d != "world" {
+		t.Fatalf("get after put: %q", d)
+	}
+	if d, _ := get(s, "a", 1, 3); d != "orl" {
+		t.Fatalf("get range after put: %q", d)
+	}
+
+	// broken cache files
+	names, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(names) != 2 {
+		t.Fatalf("cache files: %v", names)
+	}
+	_ = os.WriteFile(names[0], []byte("xxxxx"), 0600)
+	_ = os.Truncate(names[1], 1)
+	if d, _ := get(s, "a", 0, -1); d != "world" {
+		t.Fatalf("get with broken cache: %q", d)
+	}
+	if d, _ := get(s, "a", 1, 3); d != "orl" {
+		t.Fatalf("get range with broken cache: %q", d)
+	}
+
+	// evicted
+	_ = s.Put("b", bytes.NewReader([]byte("123456")))
+	_, _ = get(s, "b", 0, -1)
+	c := s.(*cached)
+	if c.used > c.capacity {
+		t.Fatalf("used %d is over capacity %d", c.used, c.capacity)
+	}
+	names, _ = filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(names) != c.lru.Len() {
+		t.Fatalf("%d cache files for %d items", len(names), c.lru.Len())
+	}
+
+	// invalidated by Copy and Delete
+	_ = s.Copy("b", "a")
+	if d, _ := get(s, "b", 0, -1); d != "world" {
+		t.Fatalf("get after copy: %q", d)
+	}
+	_ = s.Delete("b")
+	if _, err := s.Get("b", 0, -1); err == nil {
+		t.Fatalf("get after delete should fail")
+	}
+
+	// too large to cache
+	large := bytes.Repeat([]byte("x"), cacheItemLimit+1)
+	_ = s.Put("large", bytes.NewReader(large))
+	gets := b.gets
+	for i := 0; i < 2; i++ {
+		if d, err := get(s, "large", 0, -1); err != nil || d != string(large) {
+			t.Fatalf("get large object: %d bytes, %v", len(d), err)
+		}
+	}
+	if b.gets != gets+2 {
+		t.Fatalf("large object should not be cached")
+	}
+
+	if s2 := WithCache(m, "/dev/null/cache", 10); s2 != m {
+		t.Fatalf("cache should be disabled with invalid directory")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	s := WithCache(m, t.TempDir(), 1<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("k%d", j%10)
+				if (i+j)%3 == 0 {
+					_ = s.Put(key, bytes.NewReader(bytes.Repeat([]byte{byte(j)}, 100)))
+				} else {
+					_, _ = get(s, key, int64(j%5), 50)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	// no stale data after all the writes are done
+	for j := 0; j < 10; j++ {
+		key := fmt.Sprintf("k%d", j)
+		expected, _ := get(m, key, 1, 50)
+		if d, _ := get(s, key, 1, 50); d != expected {
+			t.Fatalf("stale data of %s", key)
+		}
+	}
+}
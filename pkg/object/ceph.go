@@ -313,6 +313,7 @@ func newCeph(endpoint, cluster, user, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("ceph://%s", endpoint)
 	}
+	// rados://<pool-name> is an alias of ceph://<pool-name>
 	uri, err := url.ParseRequestURI(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid endpoint %s: %s", endpoint, err)
@@ -355,4 +356,5 @@ func newCeph(endpoint, cluster, user, token string) (ObjectStorage, error) {
 
 func init() {
 	Register("ceph", newCeph)
+	Register("rados", newCeph)
 }
@@ -18,17 +18,40 @@ package object
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 const checksumAlgr = "Crc32c"
 
 var crc32c = crc32.MakeTable(crc32.Castagnoli)
 
+// checksumHash returns the hash of the checksum algorithm (CRC32, CRC32C, SHA1, SHA256 or MD5) stored by object
+// storages, or nil if the algorithm is unknown.
+func checksumHash(algo string) hash.Hash {
+	switch strings.ToUpper(algo) {
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "CRC32C":
+		return crc32.New(crc32c)
+	case "SHA1":
+		return sha1.New()
+	case "SHA256":
+		return sha256.New()
+	case "MD5":
+		return md5.New()
+	}
+	return nil
+}
+
 func generateChecksum(in io.ReadSeeker) string {
 	if b, ok := in.(*bytes.Reader); ok {
 		v := reflect.ValueOf(b)
@@ -0,0 +1,539 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/juicedata/juicefs/pkg/compress"
+)
+
+// Layout of the objects compressed by WithCompression (version 2), all integers are big endian:
+//
+//	frames:  length (4 bytes) | data, for each frame
+//	end:     0 (4 bytes)
+//	index:   the length of each frame (4 bytes each), the same as before the data
+//	trailer: size (8 bytes) | frames (4 bytes) | version (1 byte, 2) | algorithm (1 byte) | "JFSZ"
+//
+// The data is split into frames of 4 MiB, only the last one can be shorter, and each frame is compressed
+// separately, so a range of the object can be read by decompressing only the frames covering it (located by
+// the index), while the whole object is read in one pass by the lengths before the frames. A frame that is
+// not smaller after compressed is stored as is, with the highest bit of its length set. The algorithm is one
+// of 1 (gzip), 2 (zstd) or 3 (lz4).
+//
+// The compressed objects are tagged by the user defined metadata "jfs_compression" (the name of the
+// algorithm), the objects without it are read as they are, like the ones written before compression is
+// enabled, or the ones of a single frame which is not smaller after compressed.
+const (
+	compMagic      = "JFSZ"
+	compVersion    = 2
+	compTrailerLen = 8 + 4 + 1 + 1 + len(compMagic)
+	compFrameSize  = 4 << 20
+	compRawFrame   = 1 << 31
+	compMetaKey    = "jfs_compression"
+	// the tail read to get the trailer with the index of up to 16K frames (64 GiB) in one request
+	compTailSize = 64 << 10
+)
+
+var compAlgorithms = []string{"none", "gzip", "zstd", "lz4"}
+
+type gzipCompressor struct{}
+
+func (g gzipCompressor) Name() string            { return "gzip" }
+func (g gzipCompressor) CompressBound(l int) int { return l + l/1000 + 64 }
+
+func (g gzipCompressor) Compress(dst, src []byte) (int, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if buf.Len() > len(dst) {
+		return 0, fmt.Errorf("buffer too short: %d < %d", len(dst), buf.Len())
+	}
+	return copy(dst, buf.Bytes()), nil
+}
+
+func (g gzipCompressor) Decompress(dst, src []byte) (int, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, dst)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+func newCompressor(algo byte) compress.Compressor {
+	switch algo {
+	case 1:
+		return gzipCompressor{}
+	case 2, 3:
+		return compress.NewCompressor(compAlgorithms[algo])
+	}
+	return nil
+}
+
+type compressed struct {
+	ObjectStorage
+	algo byte
+	c    compress.Compressor
+}
+
+// WithCompression compresses the objects by the algorithm (gzip, zstd or lz4) on Put and decompresses
+// them on Get, the objects that are not compressed (without the metadata tag) are read as they are. Head and
+// List return the size of the data, which costs two more requests for each compressed object (read lazily by
+// Size() for List). The object storage must keep the user defined metadata given on Put. Multipart upload is
+// not supported.
+func WithCompression(s ObjectStorage, algo string) (ObjectStorage, error) {
+	if algo == "" || strings.EqualFold(algo, "none") {
+		return s, nil
+	}
+	for i, name := range compAlgorithms {
+		if strings.EqualFold(algo, name) && i > 0 {
+			inner := s
+			for w := unwrap(inner); w != nil; w = unwrap(inner) {
+				inner = w
+			}
+			_, ok1 := s.(SupportMetadata)
+			_, ok2 := inner.(SupportMetadata)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("%w: compression needs the metadata of %s", notSupported, s)
+			}
+			return &compressed{s, byte(i), newCompressor(byte(i))}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compress algorithm: %s", algo)
+}
+
+func (c *compressed) WithContext(ctx context.Context) ObjectStorage {
+	return &compressed{WithContext(c.ObjectStorage, ctx), c.algo, c.c}
+}
+
+func (c *compressed) String() string {
+	return fmt.Sprintf("%s(%s)", c.ObjectStorage, compAlgorithms[c.algo])
+}
+
+// Limits disables multipart upload, the frames can't span the parts.
+func (c *compressed) Limits() Limits {
+	l := c.ObjectStorage.Limits()
+	l.IsSupportMultipartUpload = false
+	l.IsSupportUploadPartCopy = false
+	return l
+}
+
+func (c *compressed) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return nil, notSupported
+}
+
+// compressFrame returns the record of a frame, which is the frame itself if it's not smaller after compressed.
+func (c *compressed) compressFrame(frame []byte) ([]byte, uint32, error) {
+	buf := make([]byte, c.c.CompressBound(len(frame)))
+	n, err := c.c.Compress(buf, frame)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n >= len(frame) {
+		return frame, uint32(len(frame)) | compRawFrame, nil
+	}
+	return buf[:n], uint32(n), nil
+}
+
+// compWriter writes the frames, the index and the trailer of a compressed object.
+type compWriter struct {
+	w      io.Writer
+	algo   byte
+	size   int64
+	frames int
+	index  []byte
+}
+
+func (w *compWriter) add(size int, record []byte, length uint32) error {
+	l := binary.BigEndian.AppendUint32(nil, length)
+	if _, err := w.w.Write(l); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	w.index = append(w.index, l...)
+	w.size += int64(size)
+	w.frames++
+	return nil
+}
+
+func (w *compWriter) finish() error {
+	tail := make([]byte, 4, 4+len(w.index)+compTrailerLen)
+	tail = append(tail, w.index...)
+	tail = binary.BigEndian.AppendUint64(tail, uint64(w.size))
+	tail = binary.BigEndian.AppendUint32(tail, uint32(w.frames))
+	tail = append(tail, compVersion, w.algo)
+	tail = append(tail, compMagic...)
+	_, err := w.w.Write(tail)
+	return err
+}
+
+// Put compresses the data one frame at a time, so only one frame is kept in memory. The object of a single
+// frame is stored as is if it's not smaller after compressed.
+func (c *compressed) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	frame, err := readPart(in, compFrameSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	record, length, err := c.compressFrame(frame)
+	if err != nil {
+		return fmt.Errorf("compress %s: %s", key, err)
+	}
+	getters = append(getters, withMetadata(map[string]string{compMetaKey: compAlgorithms[c.algo]}))
+	if len(frame) < compFrameSize {
+		if length&compRawFrame != 0 {
+			return c.ObjectStorage.Put(key, bytes.NewReader(frame), getters[:len(getters)-1]...)
+		}
+		var buf bytes.Buffer
+		w := &compWriter{w: &buf, algo: c.algo}
+		if err = w.add(len(frame), record, length); err == nil {
+			err = w.finish()
+		}
+		if err != nil {
+			return err
+		}
+		return c.ObjectStorage.Put(key, bytes.NewReader(buf.Bytes()), getters...)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := &compWriter{w: pw, algo: c.algo}
+		err := w.add(len(frame), record, length)
+		for err == nil {
+			var frame []byte
+			if frame, err = readPart(in, compFrameSize); err != nil && err != io.EOF {
+				break
+			}
+			if len(frame) == 0 {
+				err = w.finish()
+				break
+			}
+			if record, length, err = c.compressFrame(frame); err != nil {
+				err = fmt.Errorf("compress %s: %s", key, err)
+				break
+			}
+			err = w.add(len(frame), record, length)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	err = c.ObjectStorage.Put(key, pr, getters...)
+	_ = pr.Close() // stop the compression if the upload failed
+	return err
+}
+
+type compTrailer struct {
+	algo   byte
+	size   int64
+	frames []uint32 // the length of frames
+}
+
+func parseCompTrailer(key string, t []byte) (*compTrailer, error) {
+	if len(t) < compTrailerLen || string(t[len(t)-len(compMagic):]) != compMagic {
+		return nil, fmt.Errorf("invalid trailer of compressed object %s", key)
+	}
+	t = t[len(t)-compTrailerLen:]
+	if t[12] != compVersion {
+		return nil, fmt.Errorf("unknown version of compressed object %s: %d", key, t[12])
+	}
+	info := &compTrailer{
+		algo:   t[13],
+		size:   int64(binary.BigEndian.Uint64(t)),
+		frames: make([]uint32, binary.BigEndian.Uint32(t[8:])),
+	}
+	if info.algo == 0 || int(info.algo) >= len(compAlgorithms) {
+		return nil, fmt.Errorf("unknown compress algorithm of object %s: %d", key, info.algo)
+	}
+	if int64(len(info.frames)) != (info.size+compFrameSize-1)/compFrameSize {
+		return nil, fmt.Errorf("invalid trailer of compressed object %s: %d frames of %d bytes", key, len(info.frames), info.size)
+	}
+	return info, nil
+}
+
+// algorithmOf returns the compress algorithm tagged to the object, or 0 if it's not compressed.
+func (c *compressed) algorithmOf(key string) (byte, error) {
+	meta, err := c.ObjectStorage.(SupportMetadata).GetMeta(key)
+	if err != nil {
+		return 0, err
+	}
+	name, ok := meta[compMetaKey]
+	if !ok {
+		return 0, nil
+	}
+	for i, n := range compAlgorithms {
+		if i > 0 && n == name {
+			return byte(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compress algorithm of object %s: %s", key, name)
+}
+
+// readTail reads the trailer of a compressed object of stored size, with the index if withIndex is true.
+func (c *compressed) readTail(key string, stored int64, withIndex bool, getters ...AttrGetter) (*compTrailer, error) {
+	read := func(off, limit int64) ([]byte, error) {
+		if off < 0 {
+			off, limit = 0, stored
+		}
+		in, err := c.ObjectStorage.Get(key, off, limit, getters...)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		return io.ReadAll(in)
+	}
+	n := int64(compTrailerLen)
+	if withIndex {
+		n = compTailSize
+	}
+	tail, err := read(stored-n, n)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseCompTrailer(key, tail)
+	if err != nil || !withIndex {
+		return info, err
+	}
+	tail = tail[:len(tail)-compTrailerLen]
+	if need := 4 * len(info.frames); len(tail) < need {
+		if tail, err = read(stored-int64(compTrailerLen+need), int64(need)); err != nil {
+			return nil, err
+		}
+	}
+	if len(tail) < 4*len(info.frames) {
+		return nil, fmt.Errorf("invalid index of compressed object %s", key)
+	}
+	tail = tail[len(tail)-4*len(info.frames):]
+	for i := range info.frames {
+		info.frames[i] = binary.BigEndian.Uint32(tail[i*4:])
+	}
+	return info, nil
+}
+
+// plainSize returns the size of the data in an object of stored size.
+func (c *compressed) plainSize(key string, stored int64) (int64, error) {
+	if algo, err := c.algorithmOf(key); err != nil || algo == 0 {
+		return stored, err
+	}
+	info, err := c.readTail(key, stored, false)
+	if err != nil {
+		return 0, err
+	}
+	return info.size, nil
+}
+
+// compObj is a listed object whose size is the size of the data, which is read on the first call of Size().
+type compObj struct {
+	Object
+	c    *compressed
+	once sync.Once
+	size int64
+}
+
+func (o *compObj) Size() int64 {
+	o.once.Do(func() {
+		var err error
+		if o.size, err = o.c.plainSize(o.Key(), o.Object.Size()); err != nil {
+			logger.Warnf("Get the size of compressed object %s: %s", o.Key(), err)
+			o.size = o.Object.Size()
+		}
+	})
+	return o.size
+}
+
+func (c *compressed) listed(o Object) Object {
+	if o == nil || o.IsDir() || o.IsSymlink() {
+		return o
+	}
+	return &compObj{Object: o, c: c}
+}
+
+func (c *compressed) Head(key string) (Object, error) {
+	o, err := c.ObjectStorage.Head(key)
+	if err != nil || o.IsDir() || o.IsSymlink() {
+		return o, err
+	}
+	size, err := c.plainSize(key, o.Size())
+	if err != nil {
+		return nil, err
+	}
+	co := &compObj{Object: o, size: size}
+	co.once.Do(func() {})
+	return co, nil
+}
+
+func (c *compressed) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := c.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+	for i, o := range objs {
+		objs[i] = c.listed(o)
+	}
+	return objs, err
+}
+
+func (c *compressed) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	in, err := c.ObjectStorage.ListAll(prefix, marker, followLink)
+	if err != nil {
+		return in, err
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		for o := range in {
+			out <- c.listed(o)
+		}
+	}()
+	return out, nil
+}
+
+type bufferedReadCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+type decompressReader struct {
+	io.ReadCloser
+	c     compress.Compressor
+	frame int64 // the index of the next frame
+	skip  int64
+	left  int64 // -1 means all the frames
+	buf   []byte
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.left == 0 {
+			return 0, io.EOF
+		}
+		var l [4]byte
+		if _, err := io.ReadFull(r.ReadCloser, l[:]); err != nil {
+			return 0, fmt.Errorf("read frame %d: %s", r.frame, err)
+		}
+		length := binary.BigEndian.Uint32(l[:])
+		if length == 0 { // the end of frames
+			if r.left > 0 {
+				return 0, fmt.Errorf("read frame %d: %s", r.frame, io.ErrUnexpectedEOF)
+			}
+			r.left = 0
+			return 0, io.EOF
+		}
+		src := make([]byte, length&^compRawFrame)
+		if _, err := io.ReadFull(r.ReadCloser, src); err != nil {
+			return 0, fmt.Errorf("read frame %d: %s", r.frame, err)
+		}
+		if length&compRawFrame != 0 {
+			r.buf = src
+		} else {
+			r.buf = make([]byte, compFrameSize)
+			n, err := r.c.Decompress(r.buf, src)
+			if err != nil {
+				return 0, fmt.Errorf("decompress frame %d: %s", r.frame, err)
+			}
+			r.buf = r.buf[:n]
+		}
+		if r.skip > int64(len(r.buf)) {
+			return 0, fmt.Errorf("invalid frame %d: %d bytes", r.frame, len(r.buf))
+		}
+		r.buf = r.buf[r.skip:]
+		r.skip = 0
+		r.frame++
+		if r.left > 0 {
+			if int64(len(r.buf)) > r.left {
+				r.buf = r.buf[:r.left]
+			}
+			r.left -= int64(len(r.buf))
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Get reads the objects not tagged as compressed as they are. The whole compressed object is read in one pass,
+// a range of it is read by the frames covering it, after reading the index.
+func (c *compressed) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	algo, err := c.algorithmOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if algo == 0 {
+		return c.ObjectStorage.Get(key, off, limit, getters...)
+	}
+	if off == 0 && limit <= 0 {
+		in, err := c.ObjectStorage.Get(key, 0, -1, getters...)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressReader{ReadCloser: in, c: newCompressor(algo), left: -1}, nil
+	}
+
+	o, err := c.ObjectStorage.Head(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := c.readTail(key, o.Size(), true, getters...)
+	if err != nil {
+		return nil, err
+	}
+	if off > info.size {
+		off = info.size
+	}
+	if limit <= 0 || off+limit > info.size {
+		limit = info.size - off
+	}
+	if limit == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	first, last := off/compFrameSize, (off+limit-1)/compFrameSize
+	var start, length int64
+	for i, l := range info.frames[:last+1] {
+		l := 4 + int64(l&^compRawFrame)
+		if int64(i) < first {
+			start += l
+		} else {
+			length += l
+		}
+	}
+	in, err := c.ObjectStorage.Get(key, start, length, getters...)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{
+		ReadCloser: in,
+		c:          newCompressor(info.algo),
+		frame:      first,
+		skip:       off - first*compFrameSize,
+		left:       limit,
+	}, nil
+}
+
+var _ ObjectStorage = &compressed{}
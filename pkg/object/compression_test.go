@@ -0,0 +1,115 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestWithCompression(t *testing.T) {
+	read := func(s ObjectStorage, key string, off, limit int64) []byte {
+		in, err := s.Get(key, off, limit)
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		defer in.Close()
+		d, err := io.ReadAll(in)
+		if err != nil {
+			t.Fatalf("read %s: %s", key, err)
+		}
+		return d
+	}
+	checkRanges := func(s ObjectStorage, key string, data []byte) {
+		t.Helper()
+		if !bytes.Equal(read(s, key, 0, -1), data) {
+			t.Fatalf("%s: content of %s is not expected", s, key)
+		}
+		if o, err := s.Head(key); err != nil || o.Size() != int64(len(data)) {
+			t.Fatalf("%s: head %s: %+v %v", s, key, o, err)
+		}
+		for i := 0; i < 10; i++ {
+			off := rand.Int63n(int64(len(data)) + 1)
+			limit := rand.Int63n(compFrameSize+10) - 1
+			expected := data[off:]
+			if limit > 0 && limit < int64(len(expected)) {
+				expected = expected[:limit]
+			}
+			if !bytes.Equal(read(s, key, off, limit), expected) {
+				t.Fatalf("%s: read %d-%d of %s is not expected", s, off, limit, key)
+			}
+		}
+	}
+	random := make([]byte, compFrameSize+100)
+	rand.Read(random)
+	for _, algo := range []string{"gzip", "zstd", "lz4"} {
+		m, _ := newMem("", "", "", "")
+		s, err := WithCompression(m, algo)
+		if err != nil {
+			t.Fatalf("with compression %s: %s", algo, err)
+		}
+		testStorage(t, s)
+
+		// compressible data across frames, with an incompressible frame
+		data := append(bytes.Repeat([]byte("juicefs "), (2*compFrameSize+100)/8), random[:compFrameSize]...)
+		if err := s.Put("large", bytes.NewReader(data)); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		if o, _ := m.Head("large"); o.Size() >= int64(len(data)) {
+			t.Fatalf("%s: data is not compressed: %d bytes", algo, o.Size())
+		}
+		checkRanges(s, "large", data)
+		if objs, err := s.List("", "", "", 10, true); err != nil || listKeys(objs) != "large" || objs[0].Size() != int64(len(data)) {
+			t.Fatalf("%s: list: %s %v", algo, listKeys(objs), err)
+		}
+
+		// incompressible object of a single frame is stored as is without the tag
+		_ = s.Put("random", bytes.NewReader(random[:1000]))
+		if meta, _ := m.(SupportMetadata).GetMeta("random"); len(meta) != 0 || !bytes.Equal(read(m, "random", 0, -1), random[:1000]) {
+			t.Fatalf("%s: incompressible data should be stored as is: %v", algo, meta)
+		}
+		checkRanges(s, "random", random[:1000])
+		_ = s.Put("random", bytes.NewReader(random))
+		checkRanges(s, "random", random)
+
+		// objects written without compression, even the ones looking like compressed
+		for _, plain := range []string{"plain text", "plain text" + compMagic} {
+			_ = m.Put("plain", bytes.NewReader([]byte(plain)))
+			checkRanges(s, "plain", []byte(plain))
+		}
+		if err := MkdirMarker(s, "dir"); err != nil || len(read(s, "dir/", 0, -1)) != 0 || len(read(s, "dir/", 0, 10)) != 0 {
+			t.Fatalf("%s: directory marker should be empty: %v", algo, err)
+		}
+		// compressed by another algorithm
+		other, _ := WithCompression(m, "zstd")
+		checkRanges(other, "large", data)
+	}
+
+	m, _ := newMem("", "", "", "")
+	if s, err := WithCompression(m, "none"); err != nil || s != m {
+		t.Fatalf("none should not wrap the object storage: %v", err)
+	}
+	if _, err := WithCompression(m, "bzip2"); err == nil {
+		t.Fatalf("unknown algorithm should fail")
+	}
+	if _, err := WithCompression(withoutMultipart{m}, "lz4"); !errors.Is(err, notSupported) {
+		t.Fatalf("object storage without metadata should not be supported: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CopyTooLargeError is returned by CopyLarge if the source is larger than what a single Copy can copy, and the
+// object storage can't copy it in parts on the server side either. It matches ErrNotSupported by errors.Is.
+type CopyTooLargeError struct {
+	Key     string
+	Size    int64
+	MaxSize int64 // the largest object copied by a single Copy
+}
+
+func (e *CopyTooLargeError) Error() string {
+	return fmt.Sprintf("copy %s of %d bytes (larger than %d) in parts: %s", e.Key, e.Size, e.MaxSize, notSupported)
+}
+
+func (e *CopyTooLargeError) Unwrap() error {
+	return notSupported
+}
+
+// CopyLarge copies src to dst within the object storage without downloading it. The objects not larger than
+// MaxCopySize of the limits are copied by Copy, larger ones are copied in parts by UploadPartCopy (UploadPartCopy
+// of S3, Put Block From URL of Azure) concurrently, which are retried if failed and aborted at the end if any of
+// them fails. A CopyTooLargeError is returned if the object storage can't copy in parts.
+//
+// Unlike Copy, the metadata of src is not copied to the copies made in parts.
+func CopyLarge(store ObjectStorage, dst, src string) error {
+	limits := store.Limits()
+	if limits.MaxCopySize <= 0 {
+		return store.Copy(dst, src)
+	}
+	o, err := store.Head(src)
+	if err != nil {
+		return err
+	}
+	size := o.Size()
+	if size <= limits.MaxCopySize {
+		return store.Copy(dst, src)
+	}
+	tooLarge := &CopyTooLargeError{Key: src, Size: size, MaxSize: limits.MaxCopySize}
+	if !limits.IsSupportMultipartUpload || !limits.IsSupportUploadPartCopy {
+		return tooLarge
+	}
+	up, err := store.CreateMultipartUpload(dst)
+	if errors.Is(err, notSupported) {
+		return tooLarge
+	} else if err != nil {
+		return err
+	}
+	err = copyParts(store, dst, src, size, up)
+	if err != nil {
+		store.AbortUpload(dst, up.UploadID)
+		if errors.Is(err, notSupported) {
+			return tooLarge
+		}
+	}
+	return err
+}
+
+// copyParts copies src of size into the parts of the upload, and completes it.
+func copyParts(store ObjectStorage, dst, src string, size int64, up *MultipartUpload) error {
+	limits := store.Limits()
+	partSize := (&UploadOptions{}).partSize(store, up)
+	if n := int64(up.MaxCount); n > 0 && partSize*n < size {
+		partSize = (size + n - 1) / n
+		if limits.MaxPartSize > 0 && partSize > limits.MaxPartSize {
+			return fmt.Errorf("%s is too large: more than %d parts of %d bytes", src, n, limits.MaxPartSize)
+		}
+	}
+	concurrency := limits.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	var mu sync.Mutex
+	var parts []*Part
+	var firstErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for num, off := 1, int64(0); off < size && !failed(); num, off = num+1, off+partSize {
+		n := partSize
+		if off+n > size {
+			n = size - off
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(num int, off, n int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var part *Part
+			err := withRetry(context.Background(), 3, DefaultShouldRetry, func() (err error) {
+				part, err = store.UploadPartCopy(dst, up.UploadID, num, src, off, n)
+				return
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("copy part %d of %s to %s: %w", num, src, dst, err)
+				}
+				return
+			}
+			parts = append(parts, part)
+		}(num, off, n)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Num < parts[j].Num })
+	return withRetry(context.Background(), 3, DefaultShouldRetry, func() error {
+		return store.CompleteUpload(dst, up.UploadID, parts)
+	})
+}
@@ -0,0 +1,197 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// limitedCopy fails Copy for the objects larger than max, and counts the parts copied.
+type limitedCopy struct {
+	ObjectStorage
+	max      int64
+	noParts  bool
+	copied   int32
+	partCopy int32
+}
+
+func (l *limitedCopy) Limits() Limits {
+	limits := l.ObjectStorage.Limits()
+	limits.MaxCopySize = l.max
+	limits.PartSize = 1 << 20
+	limits.IsSupportUploadPartCopy = !l.noParts
+	return limits
+}
+
+func (l *limitedCopy) Copy(dst, src string) error {
+	o, err := l.Head(src)
+	if err != nil {
+		return err
+	}
+	if o.Size() > l.max {
+		return fmt.Errorf("%s is too large to copy", src)
+	}
+	atomic.AddInt32(&l.copied, 1)
+	return l.ObjectStorage.Copy(dst, src)
+}
+
+func (l *limitedCopy) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	atomic.AddInt32(&l.partCopy, 1)
+	return l.ObjectStorage.UploadPartCopy(key, uploadID, num, srcKey, off, size)
+}
+
+func TestCopyLarge(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	s := &limitedCopy{ObjectStorage: m, max: 2 << 20}
+	large := make([]byte, 5<<20+123)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	_ = s.Put("small", bytes.NewReader([]byte("small")))
+	_ = s.Put("large", bytes.NewReader(large))
+
+	if err := CopyLarge(s, "small2", "small"); err != nil || s.copied != 1 || s.partCopy != 0 {
+		t.Fatalf("small object should be copied by Copy: %v, %d copies, %d parts", err, s.copied, s.partCopy)
+	}
+	if err := CopyLarge(s, "large2", "large"); err != nil {
+		t.Fatalf("copy large: %s", err)
+	}
+	if s.copied != 1 || s.partCopy != 6 {
+		t.Fatalf("large object should be copied in 6 parts: %d copies, %d parts", s.copied, s.partCopy)
+	}
+	if d, err := get(s, "large2", 0, -1); err != nil || d != string(large) {
+		t.Fatalf("the copy of large is corrupted: %v", err)
+	}
+	if err := CopyLarge(s, "x", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("copy missing object: %v", err)
+	}
+
+	if err := Move(s, "large3", "large2"); err != nil {
+		t.Fatalf("move large: %s", err)
+	}
+	if d, err := get(s, "large3", 0, -1); err != nil || d != string(large) {
+		t.Fatalf("the moved large is corrupted: %v", err)
+	}
+	if _, err := s.Head("large2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("the source of move should be deleted: %v", err)
+	}
+
+	s.noParts = true
+	err := CopyLarge(s, "large4", "large")
+	var tooLarge *CopyTooLargeError
+	if !errors.As(err, &tooLarge) || !errors.Is(err, ErrNotSupported) || tooLarge.Size != int64(len(large)) {
+		t.Fatalf("copy large without part copy should fail with CopyTooLargeError: %v", err)
+	}
+	if _, err := s.Head("large4"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("nothing should be copied: %v", err)
+	}
+}
+
+// countedToken issues a new token for every request.
+type countedToken struct{ n atomic.Int32 }
+
+func (c *countedToken) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: fmt.Sprintf("token-%d", c.n.Add(1))}, nil
+}
+
+func TestAzureCopyLarge(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	const size = 600<<20 + 1
+	var mu sync.Mutex
+	var ranges []string
+	var committed string
+	auths := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", fmt.Sprint(size))
+		case r.URL.Query().Get("comp") == "block":
+			if !strings.HasSuffix(r.Header.Get("x-ms-copy-source"), "/test/src") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			auth := r.Header.Get("x-ms-copy-source-authorization")
+			if auths[auth] {
+				w.WriteHeader(http.StatusBadRequest) // reused authorization
+				return
+			}
+			auths[auth] = true
+			if len(auths) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			ranges = append(ranges, r.Header.Get("x-ms-source-range"))
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Query().Get("comp") == "blocklist":
+			committed = r.URL.Path + " " + fmt.Sprint(strings.Count(string(body), "<Latest>"))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			// the asynchronous copy of the whole blob should not be used
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	// only the retries of wasb, which renew the authorization of the source
+	client, err := azblob.NewClientWithNoCredential(srv.URL, &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: srv.Client(), Retry: policy.RetryOptions{MaxRetries: -1}}})
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, tokenCred: &countedToken{}, maxRetries: 1}
+	if l := s.Limits(); l.IsSupportUploadPartCopy || l.MaxCopySize != 0 {
+		t.Fatalf("copying in blocks should be opt-in: %+v", l)
+	}
+	s.uploadPartCopy = true
+	if err = CopyLarge(s, "dst", "src"); err != nil {
+		t.Fatalf("copy large: %s", err)
+	}
+	if len(ranges) != 76 || committed != "/test/dst 76" || len(auths) != 77 {
+		t.Fatalf("should be copied in 76 blocks of 8 MiB: %d blocks, committed %q", len(ranges), committed)
+	}
+	var total int64
+	seen := make(map[int64]bool)
+	for _, r := range ranges {
+		var start, end int64
+		if _, err := fmt.Sscanf(r, "bytes=%d-%d", &start, &end); err != nil {
+			t.Fatalf("invalid source range %q", r)
+		}
+		seen[start] = true
+		total += end - start + 1
+	}
+	if total != size || !seen[0] || !seen[75*8<<20] {
+		t.Fatalf("the blocks should cover the blob: %d bytes of %v", total, ranges)
+	}
+}
@@ -52,6 +52,10 @@ func (c *COS) String() string {
 	return fmt.Sprintf("cos://%s/", strings.Split(c.endpoint, ".")[0])
 }
 
+func (c *COS) BucketName() string {
+	return strings.Split(c.endpoint, ".")[0]
+}
+
 func (c *COS) Create() error {
 	_, err := c.c.Bucket.Put(ctx, nil)
 	if err != nil && isExists(err) {
@@ -67,6 +71,7 @@ func (c *COS) Limits() Limits {
 		MinPartSize:              1 << 20,
 		MaxPartSize:              5 << 30,
 		MaxPartCount:             10000,
+		MaxObjectSize:            10000 * (5 << 30),
 	}
 }
 
@@ -276,6 +281,20 @@ func (c *COS) SetStorageClass(sc string) error {
 	return nil
 }
 
+// cosBucketMatches returns whether the bucket (name-appid) is the one given by name, with or without the AppID,
+// the bucket name can have hyphens itself, like my-data-1250000000.
+func cosBucketMatches(bucket, name string) bool {
+	if bucket == name {
+		return true
+	}
+	i := strings.LastIndex(bucket, "-")
+	if i <= 0 || bucket[:i] != name {
+		return false
+	}
+	_, err := strconv.ParseUint(bucket[i+1:], 10, 64)
+	return err == nil
+}
+
 func autoCOSEndpoint(bucketName, accessKey, secretKey, token string) (string, error) {
 	client := cos.NewClient(nil, &http.Client{
 		Transport: &cos.AuthorizationTransport{
@@ -291,8 +310,7 @@ func autoCOSEndpoint(bucketName, accessKey, secretKey, token string) (string, er
 	}
 
 	for _, b := range s.Buckets {
-		// fmt.Printf("%#v\n", b)
-		if b.Name == bucketName {
+		if cosBucketMatches(b.Name, bucketName) {
 			return fmt.Sprintf("https://%s.cos.%s.myqcloud.com", b.Name, b.Region), nil
 		}
 	}
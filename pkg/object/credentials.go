@@ -0,0 +1,158 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"sync"
+	"time"
+)
+
+// Credentials are the (temporary) credentials to access an object storage.
+type Credentials struct {
+	AccessKey  string
+	SecretKey  string
+	Token      string
+	Expiration time.Time // zero means never expire
+}
+
+// CredentialProvider provides new credentials when the old ones are about to expire,
+// for example, from an IAM role or a token service.
+type CredentialProvider interface {
+	Retrieve() (*Credentials, error)
+}
+
+// SupportCredentialProvider is implemented by the object storages that can renew their credentials,
+// so they keep working after the temporary credentials used to create them expire.
+type SupportCredentialProvider interface {
+	// SetCredentialProvider switches the object storage to the credentials from the provider,
+	// it should be called before the object storage is used.
+	SetCredentialProvider(p CredentialProvider) error
+}
+
+// SetCredentialProvider makes the object storage renew its credentials from the provider in background,
+// a few minutes before they expire.
+func SetCredentialProvider(store ObjectStorage, p CredentialProvider) error {
+	if s, ok := store.(SupportCredentialProvider); ok {
+		return s.SetCredentialProvider(p)
+	}
+	if inner := unwrap(store); inner != nil {
+		return SetCredentialProvider(inner, p)
+	}
+	return notSupported
+}
+
+// credentialRefreshBefore is how long before the expiration the credentials are renewed.
+var credentialRefreshBefore = 5 * time.Minute
+
+// credentialRefresher keeps the current credentials from the provider and renews them in background.
+type credentialRefresher struct {
+	sync.Mutex
+	p         CredentialProvider
+	cred      *Credentials
+	version   int
+	onRefresh func(*Credentials)
+	renewNow  chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// newCredentialRefresher retrieves the first credentials, and starts to renew them in background
+// if they expire. onRefresh (optional) is called with the renewed credentials.
+func newCredentialRefresher(p CredentialProvider, onRefresh func(*Credentials)) (*credentialRefresher, error) {
+	cred, err := p.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	r := &credentialRefresher{p: p, cred: cred, onRefresh: onRefresh, renewNow: make(chan struct{}, 1), done: make(chan struct{})}
+	if !cred.Expiration.IsZero() {
+		go r.refresh()
+	}
+	return r, nil
+}
+
+func (r *credentialRefresher) current() (*Credentials, int) {
+	r.Lock()
+	defer r.Unlock()
+	return r.cred, r.version
+}
+
+// nextRefresh returns how long to wait before renewing the credentials which expire at exp.
+func nextRefresh(exp time.Time) time.Duration {
+	left := time.Until(exp)
+	wait := left - credentialRefreshBefore
+	if wait < left/2 { // short-lived credentials
+		wait = left / 2
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// renew asks to renew the credentials right away, e.g. they are rejected by the object storage before the
+// expiration. The requests during a renewal are merged into one.
+func (r *credentialRefresher) renew() {
+	select {
+	case r.renewNow <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops renewing the credentials, the current ones are kept. It's safe to call on nil or more than once.
+func (r *credentialRefresher) stop() {
+	if r != nil {
+		r.stopOnce.Do(func() { close(r.done) })
+	}
+}
+
+func (r *credentialRefresher) refresh() {
+	cred, _ := r.current()
+	for fails := 0; ; {
+		wait := nextRefresh(cred.Expiration)
+		if fails > 0 {
+			wait = backoff(fails)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.renewNow:
+			timer.Stop()
+		case <-r.done:
+			timer.Stop()
+			return
+		}
+		c, err := r.p.Retrieve()
+		if err != nil {
+			fails++
+			logger.Warnf("Renew credentials (expire at %s): %s", cred.Expiration, err)
+			continue
+		}
+		fails = 0
+		cred = c
+		r.Lock()
+		r.cred = c
+		r.version++
+		r.Unlock()
+		logger.Debugf("Renewed credentials, expire at %s", c.Expiration)
+		if r.onRefresh != nil {
+			r.onRefresh(c)
+		}
+		if c.Expiration.IsZero() {
+			return
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+)
+
+// tokenService issues short-lived tokens and checks them.
+type tokenService struct {
+	sync.Mutex
+	ttl    time.Duration
+	issued int
+	tokens map[string]time.Time
+}
+
+func (s *tokenService) Retrieve() (*Credentials, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.issued++
+	token := fmt.Sprintf("token-%d", s.issued)
+	exp := time.Now().Add(s.ttl)
+	s.tokens[token] = exp
+	return &Credentials{AccessKey: "ak", SecretKey: "sk", Token: token, Expiration: exp}, nil
+}
+
+func (s *tokenService) valid(token string) bool {
+	s.Lock()
+	defer s.Unlock()
+	exp, ok := s.tokens[token]
+	return ok && time.Now().Before(exp)
+}
+
+func (s *tokenService) handler(getToken func(r *http.Request) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if !s.valid(getToken(r)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// keepPutting puts objects until the first token has been expired for a while.
+func keepPutting(t *testing.T, s ObjectStorage, ts *tokenService) {
+	end := time.Now().Add(ts.ttl * 2)
+	for i := 0; time.Now().Before(end); i++ {
+		if err := s.Put(fmt.Sprintf("k%d", i), bytes.NewReader([]byte("data"))); err != nil {
+			t.Fatalf("put after %d requests: %s", i, err)
+		}
+		time.Sleep(ts.ttl / 20)
+	}
+	ts.Lock()
+	issued := ts.issued
+	ts.Unlock()
+	if issued < 2 {
+		t.Fatalf("token should be renewed")
+	}
+	if ts.valid("token-1") {
+		t.Fatalf("the first token should be expired")
+	}
+}
+
+func TestWasbCredentialProvider(t *testing.T) {
+	ts := &tokenService{ttl: 2 * time.Second, tokens: make(map[string]time.Time)}
+	srv := httptest.NewTLSServer(ts.handler(func(r *http.Request) string {
+		return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", hc: srv.Client(), ctx: ctx, disableChecksum: true}
+	if err = SetCredentialProvider(WithPrefix(s, "p/"), ts); err != nil {
+		t.Fatalf("set credential provider: %s", err)
+	}
+	keepPutting(t, s, ts)
+}
+
+func TestS3CredentialProvider(t *testing.T) {
+	ts := &tokenService{ttl: 2 * time.Second, tokens: make(map[string]time.Time)}
+	srv := httptest.NewServer(ts.handler(func(r *http.Request) string {
+		return r.Header.Get("X-Amz-Security-Token")
+	}))
+	defer srv.Close()
+	ses, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+		Credentials:      credentials.NewStaticCredentials("ak", "sk", "expired"),
+	})
+	if err != nil {
+		t.Fatalf("create session: %s", err)
+	}
+	s := &s3client{bucket: "test", s3: s3.New(ses), ses: ses, disableChecksum: true}
+	if err = SetCredentialProvider(s, ts); err != nil {
+		t.Fatalf("set credential provider: %s", err)
+	}
+	keepPutting(t, s, ts)
+
+	if err = SetCredentialProvider(WithReadOnly(s), ts); err != nil {
+		t.Fatalf("set credential provider through wrapper: %s", err)
+	}
+	Shutdown(WithReadOnly(s))
+	ts.Lock()
+	issued := ts.issued
+	ts.Unlock()
+	time.Sleep(ts.ttl)
+	ts.Lock()
+	renewed := ts.issued - issued
+	ts.Unlock()
+	if renewed > 0 {
+		t.Fatalf("credentials are renewed %d times after shutdown", renewed)
+	}
+	m, _ := newMem("", "", "", "")
+	if err = SetCredentialProvider(m, ts); err != notSupported {
+		t.Fatalf("mem should not support credential provider: %v", err)
+	}
+}
+
+func TestOBSCredentialProvider(t *testing.T) {
+	ts := &tokenService{ttl: 2 * time.Second, tokens: make(map[string]time.Time)}
+	srv := httptest.NewServer(ts.handler(func(r *http.Request) string {
+		if token := r.Header.Get("X-Obs-Security-Token"); token != "" {
+			return token
+		}
+		return r.Header.Get("X-Amz-Security-Token")
+	}))
+	defer srv.Close()
+	c, err := obs.New("ak", "sk", srv.URL, obs.WithSecurityToken("expired"), obs.WithMaxRetryCount(0))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &obsClient{bucket: "test", c: c}
+	if err = SetCredentialProvider(s, ts); err != nil {
+		t.Fatalf("set credential provider: %s", err)
+	}
+	keepPutting(t, s, ts)
+}
+
+func TestOSSCredentialProvider(t *testing.T) {
+	ts := &tokenService{ttl: 2 * time.Second, tokens: make(map[string]time.Time)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if !ts.valid(r.Header.Get("X-Oss-Security-Token")) {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+	client, err := oss.New(srv.URL, "ak", "sk", oss.SecurityToken("expired"))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	bucket, _ := client.Bucket("test")
+	s := &ossClient{client: client, bucket: bucket}
+	if err = SetCredentialProvider(s, ts); err != nil {
+		t.Fatalf("set credential provider: %s", err)
+	}
+	keepPutting(t, s, ts)
+}
+
+func TestOSSRenewRejectedToken(t *testing.T) {
+	ts := &tokenService{ttl: time.Hour, tokens: make(map[string]time.Time)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if token := r.Header.Get("X-Oss-Security-Token"); token == "token-1" || !ts.valid(token) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Error><Code>InvalidAccessKeyId</Code><Message>The OSS Access Key Id you provided does not exist in our records.</Message></Error>`))
+		}
+	}))
+	defer srv.Close()
+	client, _ := oss.New(srv.URL, "ak", "sk")
+	bucket, _ := client.Bucket("test")
+	s := &ossClient{client: client, bucket: bucket}
+	if err := SetCredentialProvider(s, ts); err != nil {
+		t.Fatalf("set credential provider: %s", err)
+	}
+	if err := s.Put("a", bytes.NewReader([]byte("a"))); err == nil {
+		t.Fatalf("the rejected token should fail")
+	}
+	for i := 0; ; i++ {
+		if err := s.Put("a", bytes.NewReader([]byte("a"))); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatalf("the rejected token should be renewed: %s", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOSSRAMRole(t *testing.T) {
+	defer func(ecs, emr string) { ossMetadataURL, emrMetaServiceURL = ecs, emr }(ossMetadataURL, emrMetaServiceURL)
+	exp := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	var fetched int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Ram/security-credentials/":
+			_, _ = w.Write([]byte("role1"))
+		case "/Ram/security-credentials/role1":
+			fetched++
+			_ = json.NewEncoder(w).Encode(stsCred{AccessKeyId: "ak", AccessKeySecret: "sk", SecurityToken: fmt.Sprintf("token-%d", fetched),
+				Expiration: exp.Format("2006-01-02T15:04:05Z"), Code: "Success"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ossMetadataURL = srv.URL + "/Ram/security-credentials/"
+	emrMetaServiceURL = srv.URL + "/emr/"
+
+	for _, role := range []string{"", "role1"} {
+		cred, err := (&ossRAMRole{role: role}).Retrieve()
+		if err != nil {
+			t.Fatalf("retrieve credentials of role %q: %s", role, err)
+		}
+		if cred.AccessKey != "ak" || cred.SecretKey != "sk" || cred.Token != fmt.Sprintf("token-%d", fetched) || !cred.Expiration.Equal(exp) {
+			t.Fatalf("credentials of role %q: %+v", role, cred)
+		}
+	}
+	if _, err := (&ossRAMRole{role: "role2"}).Retrieve(); err == nil {
+		t.Fatalf("role2 should not exist")
+	}
+
+	emr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.TrimPrefix(r.URL.Path, "/emr/")))
+	}))
+	defer emr.Close()
+	ossMetadataURL = srv.URL + "/none/"
+	emrMetaServiceURL = emr.URL + "/emr/"
+	cred, err := (&ossRAMRole{}).Retrieve()
+	if err != nil || cred.AccessKey != "role-access-key-id" || cred.Token != "role-security-token" {
+		t.Fatalf("credentials of EMR: %+v %v", cred, err)
+	}
+	if left := time.Until(cred.Expiration); left <= 0 || left > emrCredTTL {
+		t.Fatalf("credentials of EMR should be renewed periodically: expire at %s", cred.Expiration)
+	}
+
+	srv.Close()
+	emr.Close()
+	_, err = (&ossRAMRole{}).Retrieve()
+	if err == nil || !strings.Contains(err.Error(), "metadata service") {
+		t.Fatalf("unreachable metadata service should fail clearly: %v", err)
+	}
+}
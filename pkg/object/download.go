@@ -0,0 +1,142 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type DownloadOptions struct {
+	ChunkSize   int64 // the size of each ranged Get, 0 means choosing it from the limits of the object storage
+	Concurrency int   // the number of chunks downloaded concurrently, 4 by default
+	MaxRetries  int   // the max retries of each chunk, 3 by default
+}
+
+func (o *DownloadOptions) chunkSize(store ObjectStorage) int64 {
+	size := o.ChunkSize
+	if size <= 0 {
+		size = defaultUploadPartSize
+		// the object storages with large parts are usually fine with large ranges
+		if min := int64(store.Limits().MinPartSize); size < min {
+			size = min
+		}
+	}
+	return size
+}
+
+// getChunk reads the range of the object into buf, it fails if less data is returned.
+func getChunk(store ObjectStorage, key string, off int64, buf []byte) error {
+	in, err := store.Get(key, off, int64(len(buf)))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err = io.ReadFull(in, buf); err != nil {
+		return fmt.Errorf("read %d bytes at %d of %s: %w", len(buf), off, key, err)
+	}
+	return nil
+}
+
+// Download reads the object into w by concurrent ranged Gets, each chunk is retried if failed. The first chunk is
+// used to probe whether the object storage supports ranged Get, the rest of the object is read from the same
+// stream if the range is ignored. It reads the object by one Get if it's not larger than one chunk.
+func Download(store ObjectStorage, key string, w io.WriterAt, opts DownloadOptions) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	o, err := store.Head(key)
+	if err != nil {
+		return err
+	}
+	size := o.Size()
+	chunk := opts.chunkSize(store)
+	retryAll := func(error) bool { return true }
+	if size <= chunk {
+		return withRetry(context.Background(), opts.MaxRetries, retryAll, func() error {
+			in, err := store.Get(key, 0, -1)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			_, err = io.Copy(io.NewOffsetWriter(w, 0), in)
+			return err
+		})
+	}
+
+	// probe ranged Get with the first chunk, which is retried with the others if failed
+	var n int64
+	if in, err := store.Get(key, 0, chunk); err == nil {
+		n, err = io.Copy(io.NewOffsetWriter(w, 0), io.LimitReader(in, chunk+1))
+		if err == nil && n > chunk {
+			logger.Debugf("%s ignores the range of Get, read %s in one stream", store, key)
+			_, err = io.Copy(io.NewOffsetWriter(w, n), in)
+			_ = in.Close()
+			return err
+		}
+		_ = in.Close()
+		if err != nil || n < chunk {
+			n = 0
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Concurrency)
+	for off := n; off < size; off += chunk {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		length := chunk
+		if off+length > size {
+			length = size - off
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(off, length int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			buf := make([]byte, length)
+			err := withRetry(context.Background(), opts.MaxRetries, retryAll, func() error {
+				return getChunk(store, key, off, buf)
+			})
+			if err == nil {
+				_, err = w.WriteAt(buf, off)
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(off, length)
+	}
+	wg.Wait()
+	return firstErr
+}
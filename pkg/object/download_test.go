@@ -0,0 +1,109 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyGets counts the Gets, fails the first Get of every range if flaky and ignores the range if noRange.
+type flakyGets struct {
+	ObjectStorage
+	sync.Mutex
+	gets    int
+	flaky   bool
+	noRange bool
+	tried   map[int64]bool
+}
+
+func (f *flakyGets) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	f.Lock()
+	f.gets++
+	if f.flaky && !f.tried[off] {
+		f.tried[off] = true
+		f.Unlock()
+		return nil, errors.New("injected failure")
+	}
+	f.Unlock()
+	if f.noRange {
+		off, limit = 0, -1
+	}
+	return f.ObjectStorage.Get(key, off, limit, getters...)
+}
+
+func TestDownload(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	m, _ := newMem("", "", "", "")
+	data := make([]byte, 10<<10+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	_ = m.Put("large", bytes.NewReader(data))
+	_ = m.Put("small", bytes.NewReader(data[:100]))
+	download := func(s ObjectStorage, key string, expected []byte) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+		if err != nil {
+			t.Fatalf("create: %s", err)
+		}
+		defer f.Close()
+		if err = Download(s, key, f, DownloadOptions{ChunkSize: 1 << 10, Concurrency: 3}); err != nil {
+			t.Fatalf("download %s from %s: %s", key, s, err)
+		}
+		if d, _ := os.ReadFile(f.Name()); !bytes.Equal(d, expected) {
+			t.Fatalf("content of %s is not expected: %d bytes", key, len(d))
+		}
+	}
+
+	s := &flakyGets{ObjectStorage: m, tried: make(map[int64]bool)}
+	download(s, "small", data[:100])
+	if s.gets != 1 {
+		t.Fatalf("small object should be read by one Get: %d", s.gets)
+	}
+	s.gets = 0
+	download(s, "large", data)
+	if s.gets != 11 {
+		t.Fatalf("large object should be read by 11 Gets: %d", s.gets)
+	}
+
+	s.gets = 0
+	s.flaky = true
+	download(s, "large", data)
+	if s.gets != 22 {
+		t.Fatalf("every chunk should be retried once: %d", s.gets)
+	}
+
+	s = &flakyGets{ObjectStorage: m, noRange: true}
+	download(s, "large", data)
+	if s.gets != 1 {
+		t.Fatalf("object storage without ranged Get should be read by one Get: %d", s.gets)
+	}
+
+	f, _ := os.Create(filepath.Join(t.TempDir(), "out"))
+	defer f.Close()
+	if err := Download(m, "missing", f, DownloadOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("download missing object: %v", err)
+	}
+}
@@ -175,6 +175,10 @@ func (d *dragonfly) String() string {
 	return fmt.Sprintf("dragonfly://%s/", d.bucket)
 }
 
+func (d *dragonfly) BucketName() string {
+	return d.bucket
+}
+
 // Create creates the object if it does not exist.
 func (d *dragonfly) Create() error {
 	if _, err := d.List("", "", "", 1, false); err == nil {
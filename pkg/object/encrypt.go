@@ -18,12 +18,14 @@ package object
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -144,17 +146,18 @@ const (
 	CHACHA20_RSA  = "chacha20-rsa"
 )
 
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 func NewDataEncryptor(keyEncryptor Encryptor, algo string) (Encryptor, error) {
 	switch algo {
 	case "", AES256GCM_RSA:
-		aead := func(key []byte) (cipher.AEAD, error) {
-			block, err := aes.NewCipher(key)
-			if err != nil {
-				return nil, err
-			}
-			return cipher.NewGCM(block)
-		}
-		return &dataEncryptor{keyEncryptor, 32, aead}, nil
+		return &dataEncryptor{keyEncryptor, 32, newAESGCM}, nil
 	case CHACHA20_RSA:
 		return &dataEncryptor{keyEncryptor, chacha20poly1305.KeySize, chacha20poly1305.New}, nil
 	}
@@ -225,6 +228,10 @@ func NewEncrypted(o ObjectStorage, enc Encryptor) ObjectStorage {
 	return &encrypted{o, enc}
 }
 
+func (e *encrypted) WithContext(ctx context.Context) ObjectStorage {
+	return &encrypted{WithContext(e.ObjectStorage, ctx), e.enc}
+}
+
 func (e *encrypted) String() string {
 	return fmt.Sprintf("%s(encrypted)", e.ObjectStorage)
 }
@@ -247,7 +254,7 @@ func (e *encrypted) Get(key string, off, limit int64, getters ...AttrGetter) (io
 	if off > l {
 		off = l
 	}
-	if limit == -1 || off+limit > l {
+	if limit <= 0 || off+limit > l {
 		limit = l - off
 	}
 	data := plain[off : off+limit]
@@ -267,3 +274,342 @@ func (e *encrypted) Put(key string, in io.Reader, getters ...AttrGetter) error {
 }
 
 var _ ObjectStorage = &encrypted{}
+
+// Unlike NewEncrypted, which seals the whole object with a random key wrapped by RSA (the format of the
+// data of a volume), WithEncryption seals the object in blocks with a key given by the caller, so that
+// a range of the object can be read and decrypted without downloading the whole object.
+//
+// Layout of the objects written by WithEncryption (version 1):
+//
+//	header: "JFE" | version (1 byte, 1) | nonce (12 bytes)
+//	body:   block 0 | block 1 | ... | block n-1
+//
+// The plaintext is split into blocks of 64 KiB, only the last one can be shorter, and an empty object
+// still has one empty block. Each block is sealed by AES-256-GCM into its plaintext plus a 16 bytes tag,
+// using the nonce of the object with its last 4 bytes XORed by the block index (big endian), and one
+// byte of additional data which is 1 for the last block and 0 for the others, so reordered or truncated
+// blocks are detected, and the last block is found without knowing the size of the object. The AES key is the SHA-256 of the key given to WithEncryption.
+//
+// The random nonce is kept in the header instead of the user defined metadata, so it works with all the
+// object storages, including the ones that don't support metadata.
+const (
+	encMagic     = "JFE"
+	encVersion   = 1
+	encNonceSize = 12
+	encHeaderLen = len(encMagic) + 1 + encNonceSize
+	encBlockSize = 64 << 10
+	encTagSize   = 16
+	encSealedLen = encBlockSize + encTagSize
+)
+
+type blockEncrypted struct {
+	ObjectStorage
+	aead cipher.AEAD
+}
+
+// WithEncryption encrypts the objects on client side with AES-256-GCM, in blocks so that a range of the
+// object can be read without downloading the whole object. Head and List return the size of the plaintext.
+// Multipart upload is not supported.
+func WithEncryption(s ObjectStorage, key []byte) ObjectStorage {
+	k := sha256.Sum256(key)
+	aead, _ := newAESGCM(k[:]) // never fails with a 32 bytes key
+	return &blockEncrypted{s, aead}
+}
+
+// encPlainSize returns the size of the plaintext and the number of blocks of an object of size.
+func encPlainSize(size int64) (int64, int64, error) {
+	body := size - int64(encHeaderLen)
+	if body < encTagSize {
+		return 0, 0, fmt.Errorf("invalid size of encrypted object: %d", size)
+	}
+	n := (body + encSealedLen - 1) / encSealedLen
+	if body-(n-1)*encSealedLen < encTagSize {
+		return 0, 0, fmt.Errorf("invalid size of encrypted object: %d", size)
+	}
+	return body - n*encTagSize, n, nil
+}
+
+func encBlockNonce(nonce []byte, index uint32) []byte {
+	n := make([]byte, encNonceSize)
+	copy(n, nonce)
+	binary.BigEndian.PutUint32(n[encNonceSize-4:], binary.BigEndian.Uint32(n[encNonceSize-4:])^index)
+	return n
+}
+
+func encAdditional(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func (e *blockEncrypted) WithContext(ctx context.Context) ObjectStorage {
+	return &blockEncrypted{WithContext(e.ObjectStorage, ctx), e.aead}
+}
+
+func (e *blockEncrypted) String() string {
+	return fmt.Sprintf("%s(encrypted)", e.ObjectStorage)
+}
+
+// Limits disables multipart upload, the blocks can't span the parts.
+func (e *blockEncrypted) Limits() Limits {
+	l := e.ObjectStorage.Limits()
+	l.IsSupportMultipartUpload = false
+	l.IsSupportUploadPartCopy = false
+	return l
+}
+
+func (e *blockEncrypted) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return nil, notSupported
+}
+
+type encObj struct {
+	Object
+	size int64
+}
+
+func (o *encObj) Size() int64 { return o.size }
+
+type encFile struct {
+	File
+	size int64
+}
+
+func (f *encFile) Size() int64 { return f.size }
+
+// plain returns the object with the size of plaintext, the objects that are not encrypted are returned as is.
+func (e *blockEncrypted) plain(o Object) Object {
+	if o == nil || o.IsDir() || o.IsSymlink() {
+		return o
+	}
+	size, _, err := encPlainSize(o.Size())
+	if err != nil {
+		logger.Debugf("%s: %s", o.Key(), err)
+		return o
+	}
+	if f, ok := o.(File); ok {
+		return &encFile{f, size}
+	}
+	return &encObj{o, size}
+}
+
+func (e *blockEncrypted) Head(key string) (Object, error) {
+	o, err := e.ObjectStorage.Head(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.plain(o), nil
+}
+
+func (e *blockEncrypted) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := e.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+	for i, o := range objs {
+		objs[i] = e.plain(o)
+	}
+	return objs, err
+}
+
+func (e *blockEncrypted) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	r, err := e.ObjectStorage.ListAll(prefix, marker, followLink)
+	if err != nil {
+		return r, err
+	}
+	r2 := make(chan Object, 10240)
+	go func() {
+		for o := range r {
+			r2 <- e.plain(o)
+		}
+		close(r2)
+	}()
+	return r2, nil
+}
+
+type encryptReader struct {
+	aead     cipher.AEAD
+	in       io.Reader
+	nonce    []byte
+	index    uint32
+	cur      []byte
+	buf      []byte
+	started  bool
+	finished bool
+	err      error
+}
+
+// seal encrypts the next block into buf, it reads one more block ahead to know whether it's the last one.
+func (r *encryptReader) seal() error {
+	var err error
+	if !r.started {
+		r.started = true
+		if r.cur, err = readPart(r.in, encBlockSize); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	if r.finished {
+		return io.EOF
+	}
+	next, err := readPart(r.in, encBlockSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	last := len(next) == 0
+	r.buf = r.aead.Seal(nil, encBlockNonce(r.nonce, r.index), r.cur, encAdditional(last))
+	r.index++
+	r.cur = next
+	r.finished = last
+	return nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.seal()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (e *blockEncrypted) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	header := make([]byte, encHeaderLen)
+	copy(header, encMagic)
+	header[len(encMagic)] = encVersion
+	nonce := header[len(encMagic)+1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	r := &encryptReader{aead: e.aead, in: in, nonce: nonce, buf: header}
+	return e.ObjectStorage.Put(key, r, getters...)
+}
+
+type decryptReader struct {
+	io.ReadCloser
+	aead   cipher.AEAD
+	nonce  []byte
+	index  uint32
+	skip   int64
+	left   int64 // -1 means until the last block
+	more   bool  // the previous block is not the last one
+	sealed []byte
+	buf    []byte
+}
+
+// open decrypts a block, a full one could be the last one of the object, only a short one must be.
+func (r *decryptReader) open(n int) ([]byte, bool, error) {
+	nonce := encBlockNonce(r.nonce, r.index)
+	if n == len(r.sealed) {
+		if plain, err := r.aead.Open(nil, nonce, r.sealed, encAdditional(false)); err == nil {
+			return plain, false, nil
+		}
+	}
+	plain, err := r.aead.Open(nil, nonce, r.sealed[:n], encAdditional(true))
+	return plain, true, err
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.left == 0 {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.ReadCloser, r.sealed)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 && !r.more {
+			// the range starts after the end of the object
+			r.left = 0
+			return 0, io.EOF
+		}
+		plain, last, err := r.open(n)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt block %d: %s", r.index, err)
+		}
+		if r.skip < int64(len(plain)) {
+			r.buf = plain[r.skip:]
+		}
+		r.index++
+		r.skip = 0
+		r.more = !last
+		if r.left > 0 && int64(len(r.buf)) > r.left {
+			r.buf = r.buf[:r.left]
+		}
+		if last {
+			r.left = int64(len(r.buf))
+		}
+		if r.left > 0 {
+			r.left -= int64(len(r.buf))
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (e *blockEncrypted) readHeader(in io.Reader) ([]byte, error) {
+	header := make([]byte, encHeaderLen)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, fmt.Errorf("read header: %s", err)
+	}
+	if string(header[:len(encMagic)]) != encMagic || header[len(encMagic)] != encVersion {
+		return nil, fmt.Errorf("invalid header of encrypted object: %x", header[:len(encMagic)+1])
+	}
+	return header[len(encMagic)+1:], nil
+}
+
+// Get reads the header and the covering blocks in one request if the range starts in the first block,
+// otherwise in two requests, the size of the object is not needed.
+func (e *blockEncrypted) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if off < 0 {
+		off = 0
+	}
+	first := off / encBlockSize
+	start := int64(encHeaderLen) + first*encSealedLen
+	end := int64(-1)
+	if limit > 0 {
+		end = int64(encHeaderLen) + ((off+limit-1)/encBlockSize+1)*encSealedLen
+	} else {
+		limit = -1
+	}
+
+	var err error
+	var nonce []byte
+	var in io.ReadCloser
+	if first == 0 {
+		if in, err = e.ObjectStorage.Get(key, 0, end, getters...); err != nil {
+			return nil, err
+		}
+		if nonce, err = e.readHeader(in); err != nil {
+			_ = in.Close()
+			return nil, err
+		}
+	} else {
+		h, err := e.ObjectStorage.Get(key, 0, int64(encHeaderLen), getters...)
+		if err != nil {
+			return nil, err
+		}
+		nonce, err = e.readHeader(h)
+		_ = h.Close()
+		if err != nil {
+			return nil, err
+		}
+		if end > 0 {
+			end -= start
+		}
+		if in, err = e.ObjectStorage.Get(key, start, end, getters...); err != nil {
+			return nil, err
+		}
+	}
+	return &decryptReader{
+		ReadCloser: in,
+		aead:       e.aead,
+		nonce:      nonce,
+		index:      uint32(first),
+		skip:       off - first*encBlockSize,
+		left:       limit,
+		sealed:     make([]byte, encSealedLen),
+	}, nil
+}
+
+var _ ObjectStorage = &blockEncrypted{}
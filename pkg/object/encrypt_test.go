@@ -23,6 +23,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"io"
+	mrand "math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -225,3 +226,69 @@ func TestEncryptedStore(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWithEncryption(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	s := WithEncryption(m, []byte("secret"))
+	testStorage(t, s)
+
+	read := func(s ObjectStorage, key string, off, limit int64) ([]byte, error) {
+		in, err := s.Get(key, off, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		return io.ReadAll(in)
+	}
+	for _, size := range []int{0, 1, encBlockSize - 1, encBlockSize, encBlockSize + 1, 3*encBlockSize + 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := s.Put("k", bytes.NewReader(data)); err != nil {
+			t.Fatalf("put %d bytes: %s", size, err)
+		}
+		if o, err := s.Head("k"); err != nil || o.Size() != int64(size) {
+			t.Fatalf("head of %d bytes: %+v %v", size, o, err)
+		}
+		if objs, err := s.List("", "", "", 10, true); err != nil || len(objs) != 1 || objs[0].Size() != int64(size) {
+			t.Fatalf("list of %d bytes: %+v %v", size, objs, err)
+		}
+		// short data could be found in the ciphertext by chance
+		if raw, _ := read(m, "k", 0, -1); bytes.Contains(raw, data) && size >= 16 {
+			t.Fatalf("data of %d bytes is not encrypted", size)
+		}
+		for i := 0; i < 20; i++ {
+			off := mrand.Int63n(int64(size) + 1)
+			limit := mrand.Int63n(int64(size)+1) - 1
+			expected := data[off:]
+			if limit > 0 && limit < int64(len(expected)) {
+				expected = expected[:limit]
+			}
+			if d, err := read(s, "k", off, limit); err != nil || !bytes.Equal(d, expected) {
+				t.Fatalf("read %d-%d of %d bytes: %d bytes %v", off, limit, size, len(d), err)
+			}
+		}
+	}
+
+	for _, off := range []int64{3*encBlockSize + 5, 3*encBlockSize + 6, 5 * encBlockSize} {
+		if d, err := read(s, "k", off, 10); err != nil || len(d) != 0 {
+			t.Fatalf("read after the end at %d: %d bytes %v", off, len(d), err)
+		}
+	}
+	if d, err := read(WithEncryption(m, []byte("wrong")), "k", 0, -1); err == nil {
+		t.Fatalf("read with wrong key should fail: %d bytes", len(d))
+	}
+	raw, _ := read(m, "k", 0, -1)
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)/2] ^= 1
+	_ = m.Put("k", bytes.NewReader(tampered))
+	if _, err := read(s, "k", 0, -1); err == nil {
+		t.Fatalf("read of tampered object should fail")
+	}
+	// drop the last block
+	_ = m.Put("k", bytes.NewReader(raw[:encHeaderLen+3*encSealedLen]))
+	for _, off := range []int64{0, encBlockSize + 1} {
+		if _, err := read(s, "k", off, -1); err == nil {
+			t.Fatalf("read of truncated object from %d should fail", off)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// InjectedError is an error injected by WithFaultInjection, which carries an HTTP status code like the errors
+// of the SDKs, so it's classified by DefaultShouldRetry in the same way.
+type InjectedError struct {
+	Code int
+}
+
+func (e *InjectedError) Error() string {
+	return fmt.Sprintf("injected fault: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+func (e *InjectedError) StatusCode() int { return e.Code }
+
+// ErrThrottled is an injected throttling error (429 Too Many Requests), which is retried by WithRetry.
+var ErrThrottled error = &InjectedError{http.StatusTooManyRequests}
+
+// FaultConfig configures the faults injected by WithFaultInjection.
+type FaultConfig struct {
+	// Methods are the names of the methods to inject the faults into (e.g. "Get", "Put"), all of them if empty.
+	Methods []string
+	// Probability of a call to be faulty, from 0 (never) to 1 (always).
+	Probability float64
+	// Seed of the random numbers, the same seed makes the same calls faulty if they are made in the same order.
+	Seed int64
+
+	// Latency is added before the faulty calls.
+	Latency time.Duration
+	// Err is returned by the faulty calls without calling the object storage if it's not nil, e.g. ErrThrottled.
+	Err error
+	// Truncate cuts the body returned by a faulty Get after TruncateAt bytes, reading more of it fails with
+	// io.ErrUnexpectedEOF like a broken connection.
+	Truncate   bool
+	TruncateAt int64
+}
+
+type faultState struct {
+	sync.Mutex
+	rand *rand.Rand
+}
+
+type faulty struct {
+	ObjectStorage
+	cfg     FaultConfig
+	methods map[string]bool
+	state   *faultState // shared by the ones bound to contexts
+}
+
+// WithFaultInjection injects errors, latency or truncated bodies of Get into the calls of the object storage by
+// cfg, to test how the callers (e.g. WithRetry) behave when the object storage fails. It's for testing only and
+// should never be used in production.
+func WithFaultInjection(s ObjectStorage, cfg FaultConfig) ObjectStorage {
+	f := &faulty{ObjectStorage: s, cfg: cfg, state: &faultState{rand: rand.New(rand.NewSource(cfg.Seed))}}
+	if len(cfg.Methods) > 0 {
+		f.methods = make(map[string]bool, len(cfg.Methods))
+		for _, m := range cfg.Methods {
+			f.methods[m] = true
+		}
+	}
+	return f
+}
+
+func (f *faulty) WithContext(ctx context.Context) ObjectStorage {
+	return &faulty{WithContext(f.ObjectStorage, ctx), f.cfg, f.methods, f.state}
+}
+
+func (f *faulty) String() string {
+	return fmt.Sprintf("%s(faulty)", f.ObjectStorage)
+}
+
+// isFaulty decides whether the call of method is faulty, and adds the latency to it.
+func (f *faulty) isFaulty(method string) bool {
+	if f.methods != nil && !f.methods[method] {
+		return false
+	}
+	f.state.Lock()
+	faulty := f.state.rand.Float64() < f.cfg.Probability
+	f.state.Unlock()
+	if faulty && f.cfg.Latency > 0 {
+		time.Sleep(f.cfg.Latency)
+	}
+	return faulty
+}
+
+// inject returns the error of the call of method if it's faulty.
+func (f *faulty) inject(method string) error {
+	if f.isFaulty(method) {
+		return f.cfg.Err
+	}
+	return nil
+}
+
+func (f *faulty) Create() error {
+	if err := f.inject("Create"); err != nil {
+		return err
+	}
+	return f.ObjectStorage.Create()
+}
+
+func (f *faulty) Head(key string) (Object, error) {
+	if err := f.inject("Head"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.Head(key)
+}
+
+// truncatedReader fails with io.ErrUnexpectedEOF after n bytes.
+type truncatedReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *truncatedReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.n -= int64(n)
+	return n, err
+}
+
+func (f *faulty) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	faulty := f.isFaulty("Get")
+	if faulty && f.cfg.Err != nil {
+		return nil, f.cfg.Err
+	}
+	in, err := f.ObjectStorage.Get(key, off, limit, getters...)
+	if err == nil && faulty && f.cfg.Truncate {
+		in = &truncatedReader{in, f.cfg.TruncateAt}
+	}
+	return in, err
+}
+
+func (f *faulty) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if err := f.inject("Put"); err != nil {
+		return err
+	}
+	return f.ObjectStorage.Put(key, in, getters...)
+}
+
+func (f *faulty) Copy(dst, src string) error {
+	if err := f.inject("Copy"); err != nil {
+		return err
+	}
+	return f.ObjectStorage.Copy(dst, src)
+}
+
+func (f *faulty) Delete(key string, getters ...AttrGetter) error {
+	if err := f.inject("Delete"); err != nil {
+		return err
+	}
+	return f.ObjectStorage.Delete(key, getters...)
+}
+
+func (f *faulty) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if err := f.inject("List"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+}
+
+func (f *faulty) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	if err := f.inject("ListAll"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.ListAll(prefix, marker, followLink)
+}
+
+func (f *faulty) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	if err := f.inject("CreateMultipartUpload"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.CreateMultipartUpload(key)
+}
+
+func (f *faulty) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	if err := f.inject("UploadPart"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.UploadPart(key, uploadID, num, body)
+}
+
+func (f *faulty) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	if err := f.inject("UploadPartCopy"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.UploadPartCopy(key, uploadID, num, srcKey, off, size)
+}
+
+func (f *faulty) AbortUpload(key string, uploadID string) {
+	if f.inject("AbortUpload") != nil {
+		return
+	}
+	f.ObjectStorage.AbortUpload(key, uploadID)
+}
+
+func (f *faulty) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	if err := f.inject("CompleteUpload"); err != nil {
+		return err
+	}
+	return f.ObjectStorage.CompleteUpload(key, uploadID, parts)
+}
+
+func (f *faulty) ListUploads(marker string) ([]*PendingPart, string, error) {
+	if err := f.inject("ListUploads"); err != nil {
+		return nil, "", err
+	}
+	return f.ObjectStorage.ListUploads(marker)
+}
+
+var _ ObjectStorage = &faulty{}
@@ -0,0 +1,86 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFaultInjection(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	m, _ := newMem("", "", "", "")
+	_ = m.Put("a", bytes.NewReader(make([]byte, 100)))
+	faults := func(seed int64) string {
+		s := WithFaultInjection(m, FaultConfig{Methods: []string{"Head"}, Probability: 0.5, Seed: seed, Err: ErrThrottled})
+		var pattern []byte
+		for i := 0; i < 100; i++ {
+			if err := s.Put("b", bytes.NewReader(nil)); err != nil {
+				t.Fatalf("put should not be faulty: %s", err)
+			}
+			if _, err := s.Head("a"); errors.Is(err, ErrThrottled) {
+				pattern = append(pattern, 'x')
+			} else if err != nil {
+				t.Fatalf("head: %s", err)
+			} else {
+				pattern = append(pattern, '.')
+			}
+		}
+		return string(pattern)
+	}
+	p := faults(1)
+	if n := bytes.Count([]byte(p), []byte("x")); n < 20 || n > 80 {
+		t.Fatalf("%d of 100 calls are faulty: %s", n, p)
+	}
+	if faults(1) != p {
+		t.Fatalf("the same seed should inject the same faults")
+	}
+	if faults(2) == p {
+		t.Fatalf("different seeds should inject different faults")
+	}
+
+	// throttled requests are retried
+	s := WithRetry(WithFaultInjection(m, FaultConfig{Probability: 0.5, Seed: 1, Err: ErrThrottled}), 10, nil)
+	for i := 0; i < 10; i++ {
+		if _, err := s.Head("a"); err != nil {
+			t.Fatalf("head should succeed after retries: %s", err)
+		}
+	}
+	s = WithRetry(WithFaultInjection(m, FaultConfig{Probability: 1, Err: ErrThrottled}), 2, nil)
+	if _, err := s.Head("a"); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("head should fail after retries: %v", err)
+	}
+
+	// truncated body
+	f := WithFaultInjection(m, FaultConfig{Methods: []string{"Get"}, Probability: 1, Truncate: true, TruncateAt: 10, Latency: time.Millisecond})
+	in, _ := f.Get("a", 0, -1)
+	if d, err := io.ReadAll(in); len(d) != 10 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("the body should be truncated: %d bytes %v", len(d), err)
+	}
+	// the rest is read by re-issued Get
+	if d, err := get(WithRetry(f, 20, nil), "a", 0, -1); err != nil || len(d) != 100 {
+		t.Fatalf("get with retry: %d bytes %v", len(d), err)
+	}
+	if d, err := get(WithRetry(f, 3, nil), "a", 0, -1); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("get should fail after retries: %d bytes %v", len(d), err)
+	}
+}
@@ -133,10 +133,31 @@ func (d *filestore) Get(key string, off, limit int64, getters ...AttrGetter) (io
 			Closer:        f,
 		}, nil
 	}
+	if off > 0 {
+		if _, err = f.Seek(off, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
 	return f, nil
 }
 
-func (d *filestore) Put(key string, in io.Reader, getters ...AttrGetter) (err error) {
+// tmpPath returns a hidden temporary path in the same directory as p, so it can be renamed to p atomically.
+func tmpPath(p string) string {
+	name := filepath.Base(p)
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return filepath.Join(filepath.Dir(p), "."+name+".tmp"+strconv.Itoa(rand.Int()))
+}
+
+func (d *filestore) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return d.put(key, in, TryCFR)
+}
+
+// put writes the data into a temporary file and renames it to the object, the data is copied by copy_file_range
+// if cfr is true and in is a file.
+func (d *filestore) put(key string, in io.Reader, cfr bool) (err error) {
 	p := d.path(key)
 
 	if strings.HasSuffix(key, dirSuffix) || key == "" && strings.HasSuffix(d.root, dirSuffix) {
@@ -147,11 +168,7 @@ func (d *filestore) Put(key string, in io.Reader, getters ...AttrGetter) (err er
 	if PutInplace {
 		tmp = p
 	} else {
-		name := filepath.Base(p)
-		if len(name) > 200 {
-			name = name[:200]
-		}
-		tmp = filepath.Join(filepath.Dir(p), "."+name+".tmp"+strconv.Itoa(rand.Int()))
+		tmp = tmpPath(p)
 		defer func() {
 			if err != nil {
 				_ = os.Remove(tmp)
@@ -169,7 +186,7 @@ func (d *filestore) Put(key string, in io.Reader, getters ...AttrGetter) (err er
 		return err
 	}
 
-	if TryCFR {
+	if cfr {
 		_, err = io.Copy(f, in)
 	} else {
 		buf := bufPool.Get().(*[]byte)
@@ -190,13 +207,31 @@ func (d *filestore) Put(key string, in io.Reader, getters ...AttrGetter) (err er
 	return err
 }
 
+// Copy copies the data by copy_file_range on Linux, which clones the blocks on the file systems supporting reflink
+// (e.g. XFS and Btrfs) rather than copying them through the user space.
 func (d *filestore) Copy(dst, src string) error {
 	r, err := d.Get(src, 0, -1)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
-	return d.Put(dst, r)
+	return d.put(dst, r, true)
+}
+
+// Move renames the file, the parent directories of dst are created if missing.
+func (d *filestore) Move(dst, src string) error {
+	p := d.path(dst)
+	err := os.Rename(d.path(src), p)
+	if err != nil && os.IsNotExist(err) {
+		if _, e := os.Lstat(d.path(src)); e != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(p), os.FileMode(0777)); err != nil {
+			return err
+		}
+		err = os.Rename(d.path(src), p)
+	}
+	return err
 }
 
 func (d *filestore) Delete(key string, getters ...AttrGetter) error {
@@ -343,5 +378,5 @@ func newDisk(root, accesskey, secretkey, token string) (ObjectStorage, error) {
 }
 
 func init() {
-	Register("file", newDisk)
+	RegisterRawKeys("file", newDisk)
 }
@@ -19,6 +19,7 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"testing"
@@ -51,6 +52,42 @@ func TestDisk2(t *testing.T) {
 	testFileSystem(t, s)
 }
 
+func TestDiskCopy(t *testing.T) {
+	root := t.TempDir() + "/"
+	s, _ := newDisk(root, "", "", "")
+	if err := s.Put("a", bytes.NewReader([]byte("v1"))); err != nil {
+		t.Fatalf("put a: %s", err)
+	}
+	if err := s.Copy("dir/b", "a"); err != nil {
+		t.Fatalf("copy a: %s", err)
+	}
+	fa, _ := os.Stat(root + "a")
+	fb, _ := os.Stat(root + "dir/b")
+	if os.SameFile(fa, fb) || fb.Size() != 2 {
+		t.Fatalf("b should be copied from a")
+	}
+
+	PutInplace = true
+	defer func() { PutInplace = false }()
+	if err := s.Put("a", bytes.NewReader([]byte("v2"))); err != nil {
+		t.Fatalf("put a: %s", err)
+	}
+	for key, expect := range map[string]string{"a": "v2", "dir/b": "v1"} {
+		r, err := s.Get(key, 0, -1)
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		data, _ := io.ReadAll(r)
+		_ = r.Close()
+		if string(data) != expect {
+			t.Fatalf("%s should be %s, got %s", key, expect, data)
+		}
+	}
+	if entries, _ := os.ReadDir(root + "dir"); len(entries) != 1 {
+		t.Fatalf("temporary files should be removed: %v", entries)
+	}
+}
+
 func TestSftp2(t *testing.T) { //skip mutate
 	if os.Getenv("SFTP_HOST") == "" {
 		t.SkipNow()
@@ -0,0 +1,576 @@
+//go:build !nogdrive
+// +build !nogdrive
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const (
+	gdriveFolderType = "application/vnd.google-apps.folder"
+	gdriveFields     = "id, name, size, modifiedTime, mimeType"
+	// the objects larger than a chunk are uploaded in resumable sessions, chunk by chunk
+	gdriveChunkSize = 8 << 20
+)
+
+// the endpoints of Drive API and OAuth2, which are replaced in tests
+var (
+	gdriveEndpoint = "https://www.googleapis.com/drive/v3/"
+	gdriveTokenURL = google.Endpoint.TokenURL
+)
+
+// gdrive stores the objects as files in the folder hierarchy of Google Drive, the keys are the paths of files
+// relative to the root folder. Drive addresses the files by IDs rather than names, so the IDs of the paths are
+// cached to save the lookups of every level.
+type gdrive struct {
+	DefaultObjectStorage
+	svc  *drive.Service
+	root string // path of the root folder in My Drive, without the trailing slash
+
+	mu     sync.Mutex
+	ids    map[string]string         // path (relative to My Drive) -> ID
+	mkdirs map[string]*gdriveMkdir   // the folders being created, by path
+	listed map[string]*gdriveListing // the folders where the last pages of List stopped, by ID
+}
+
+// gdriveMkdir is a folder being created, the other lookups of it wait for the result rather than creating
+// duplicated folders (Drive allows multiple folders with the same name).
+type gdriveMkdir struct {
+	wg  sync.WaitGroup
+	id  string
+	err error
+}
+
+// gdriveListTTL is how long the children of a folder listed by List are reused to list the following pages, so
+// paging through a large folder doesn't list all of it again for every page.
+const gdriveListTTL = time.Minute
+
+type gdriveListing struct {
+	entries []gdriveEntry
+	expire  time.Time
+}
+
+// gdriveEntry is a child of a folder with its key.
+type gdriveEntry struct {
+	key  string
+	file *drive.File
+}
+
+func (g *gdrive) String() string {
+	return fmt.Sprintf("gdrive://%s/", g.root)
+}
+
+// path returns the path of key relative to My Drive, the trailing slash of folders is removed.
+func (g *gdrive) path(key string) string {
+	return strings.TrimSuffix(g.root+"/"+key, "/")
+}
+
+// gdriveQuoter escapes the names in the queries of files.list.
+var gdriveQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// checkGDriveError translates the errors of Drive API.
+func checkGDriveError(err error) error {
+	var e *googleapi.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	if e.Code == http.StatusNotFound {
+		return os.ErrNotExist
+	}
+	if e.Code == http.StatusForbidden {
+		for _, item := range e.Errors {
+			if item.Reason == "userRateLimitExceeded" || item.Reason == "rateLimitExceeded" {
+				return &gdriveThrottled{e}
+			}
+		}
+	}
+	return err
+}
+
+// gdriveThrottled is the error of exceeding the rate limits, which is responded as 403 by Drive, but should be
+// retried like 429.
+type gdriveThrottled struct {
+	err *googleapi.Error
+}
+
+func (e *gdriveThrottled) Error() string { return e.err.Error() }
+
+func (e *gdriveThrottled) StatusCode() int { return http.StatusTooManyRequests }
+
+func (e *gdriveThrottled) Unwrap() error { return e.err }
+
+// find returns the file (or folder) named name in the folder parent, or nil if there is none. If there are
+// multiple ones with the same name (Drive allows that), the oldest one is used.
+func (g *gdrive) find(parent, name string, folder bool) (*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false", gdriveQuoter.Replace(parent), gdriveQuoter.Replace(name))
+	if folder {
+		q += " and mimeType = '" + gdriveFolderType + "'"
+	} else {
+		q += " and mimeType != '" + gdriveFolderType + "'"
+	}
+	list, err := g.svc.Files.List().Q(q).OrderBy("createdTime").PageSize(1).Fields("files(" + gdriveFields + ")").Context(ctx).Do()
+	if err != nil {
+		return nil, checkGDriveError(err)
+	}
+	if len(list.Files) == 0 {
+		return nil, nil
+	}
+	return list.Files[0], nil
+}
+
+func (g *gdrive) cached(p string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, ok := g.ids[p]
+	return id, ok
+}
+
+func (g *gdrive) cache(p, id string) {
+	g.mu.Lock()
+	g.ids[p] = id
+	g.mu.Unlock()
+}
+
+// forget removes the cached ID of p and the paths under it.
+func (g *gdrive) forget(p string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.ids, p)
+	for k := range g.ids {
+		if strings.HasPrefix(k, p+"/") {
+			delete(g.ids, k)
+		}
+	}
+}
+
+// lookup returns the ID of the path relative to My Drive, the missing folders are created if mkdir is true.
+func (g *gdrive) lookup(p string, folder, mkdir bool) (string, error) {
+	if p == "" {
+		return "root", nil
+	}
+	if id, ok := g.cached(p); ok {
+		return id, nil
+	}
+	var dir, name string
+	if i := strings.LastIndexByte(p, '/'); i >= 0 {
+		dir, name = p[:i], p[i+1:]
+	} else {
+		name = p
+	}
+	parent, err := g.lookup(dir, true, mkdir)
+	if err != nil {
+		return "", err
+	}
+	f, err := g.find(parent, name, folder)
+	if err != nil {
+		return "", err
+	}
+	if f == nil {
+		if !folder || !mkdir {
+			return "", os.ErrNotExist
+		}
+		return g.mkdir(p, parent, name)
+	}
+	g.cache(p, f.Id)
+	return f.Id, nil
+}
+
+// mkdir creates the folder p in parent, the concurrent creations of the same folder are serialized, and the
+// folder is looked up again before it's created, in case it's just created by another one.
+func (g *gdrive) mkdir(p, parent, name string) (string, error) {
+	g.mu.Lock()
+	if id, ok := g.ids[p]; ok {
+		g.mu.Unlock()
+		return id, nil
+	}
+	if c, ok := g.mkdirs[p]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.id, c.err
+	}
+	c := &gdriveMkdir{}
+	c.wg.Add(1)
+	g.mkdirs[p] = c
+	g.mu.Unlock()
+
+	f, err := g.find(parent, name, true)
+	if err == nil && f == nil {
+		f, err = g.svc.Files.Create(&drive.File{Name: name, MimeType: gdriveFolderType, Parents: []string{parent}}).Fields("id").Context(ctx).Do()
+		err = checkGDriveError(err)
+	}
+	if err == nil {
+		c.id = f.Id
+	}
+	c.err = err
+	g.mu.Lock()
+	if err == nil {
+		g.ids[p] = c.id
+	}
+	delete(g.mkdirs, p)
+	g.mu.Unlock()
+	c.wg.Done()
+	return c.id, c.err
+}
+
+// do calls fn with the ID of key, the cached ID is looked up again if it's gone (e.g. removed by other clients).
+func (g *gdrive) do(key string, fn func(id string) error) error {
+	p := g.path(key)
+	_, cached := g.cached(p)
+	id, err := g.lookup(p, strings.HasSuffix(key, "/") || key == "", false)
+	if err != nil {
+		return err
+	}
+	err = fn(id)
+	if cached && errors.Is(err, os.ErrNotExist) {
+		g.forget(p)
+		if id, err = g.lookup(p, strings.HasSuffix(key, "/") || key == "", false); err != nil {
+			return err
+		}
+		err = fn(id)
+	}
+	return err
+}
+
+func (g *gdrive) Create() error {
+	_, err := g.lookup(g.root, true, true)
+	return err
+}
+
+func (g *gdrive) toObject(key string, f *drive.File) Object {
+	mtime, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+	isDir := f.MimeType == gdriveFolderType
+	return &obj{key, f.Size, mtime, isDir, ""}
+}
+
+func (g *gdrive) Head(key string) (Object, error) {
+	var o Object
+	err := g.do(key, func(id string) error {
+		f, err := g.svc.Files.Get(id).Fields(gdriveFields).Context(ctx).Do()
+		if err != nil {
+			return checkGDriveError(err)
+		}
+		o = g.toObject(key, f)
+		return nil
+	})
+	return o, err
+}
+
+// Get reads the range of the file with a Range request.
+func (g *gdrive) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := g.do(key, func(id string) error {
+		call := g.svc.Files.Get(id).Context(ctx)
+		if off > 0 || limit >= 0 {
+			r := fmt.Sprintf("bytes=%d-", off)
+			if limit >= 0 {
+				if limit == 0 {
+					body = io.NopCloser(strings.NewReader(""))
+					return nil
+				}
+				r += fmt.Sprintf("%d", off+limit-1)
+			}
+			call.Header().Set("Range", r)
+		}
+		resp, err := call.Download()
+		if err != nil {
+			var e *googleapi.Error
+			if errors.As(err, &e) && e.Code == http.StatusRequestedRangeNotSatisfiable {
+				// the range starts at the end of file
+				body = io.NopCloser(strings.NewReader(""))
+				return nil
+			}
+			return checkGDriveError(err)
+		}
+		body = resp.Body
+		return nil
+	})
+	return body, err
+}
+
+// Put updates the content of the existing file, or creates a new one in the folder (created if missing), so
+// there is only one file for a key. The file is looked up without the cache, since the data can't be sent again
+// if the cached ID is gone.
+func (g *gdrive) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	p := g.path(key)
+	if strings.HasSuffix(key, "/") {
+		_, err := g.lookup(p, true, true)
+		return err
+	}
+	i := strings.LastIndexByte(p, '/')
+	parent, err := g.lookup(p[:i], true, true)
+	if err != nil {
+		return err
+	}
+	f, err := g.find(parent, p[i+1:], false)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		f, err = g.svc.Files.Update(f.Id, &drive.File{}).Media(in, googleapi.ChunkSize(gdriveChunkSize)).Fields("id").Context(ctx).Do()
+	} else {
+		f, err = g.svc.Files.Create(&drive.File{Name: p[i+1:], Parents: []string{parent}}).Media(in, googleapi.ChunkSize(gdriveChunkSize)).Fields("id").Context(ctx).Do()
+	}
+	if err != nil {
+		return checkGDriveError(err)
+	}
+	g.cache(p, f.Id)
+	return nil
+}
+
+// Delete removes the file permanently, rather than moving it to the trash. The folders are deleted only when
+// they are empty, since Drive deletes all the files in them.
+func (g *gdrive) Delete(key string, getters ...AttrGetter) error {
+	err := g.do(key, func(id string) error {
+		if strings.HasSuffix(key, "/") {
+			list, err := g.svc.Files.List().Q(fmt.Sprintf("'%s' in parents and trashed = false", id)).PageSize(1).Fields("files(id)").Context(ctx).Do()
+			if err != nil {
+				return checkGDriveError(err)
+			}
+			if len(list.Files) > 0 {
+				return fmt.Errorf("folder %s is not empty", key)
+			}
+		}
+		return checkGDriveError(g.svc.Files.Delete(id).Context(ctx).Do())
+	})
+	g.forget(g.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// children returns the children of the folder dir (with ID id) sorted by their keys, the keys of folders end
+// with "/". Drive doesn't sort the names in the order of bytes, so all the pages of files.list (followed by the
+// pageToken) are listed and sorted. The children kept by the previous List are reused if reuse is true.
+func (g *gdrive) children(dir, id string, reuse bool) ([]gdriveEntry, error) {
+	if reuse {
+		g.mu.Lock()
+		l := g.listed[id]
+		g.mu.Unlock()
+		if l != nil && time.Now().Before(l.expire) {
+			return l.entries, nil
+		}
+	}
+	var entries []gdriveEntry
+	call := g.svc.Files.List().Q(fmt.Sprintf("'%s' in parents and trashed = false", id)).PageSize(1000).
+		Fields(googleapi.Field("nextPageToken, files(" + gdriveFields + ")"))
+	err := call.Pages(ctx, func(page *drive.FileList) error {
+		for _, f := range page.Files {
+			key := dir + f.Name
+			if f.MimeType == gdriveFolderType {
+				key += "/"
+			} else if strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+				continue // the documents of Google can't be downloaded
+			}
+			g.cache(g.path(key), f.Id)
+			entries = append(entries, gdriveEntry{key, f})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, checkGDriveError(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// keep keeps the children of the folder id for the next page of List, the expired ones are dropped.
+func (g *gdrive) keep(id string, entries []gdriveEntry) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, l := range g.listed {
+		if now.After(l.expire) {
+			delete(g.listed, k)
+		}
+	}
+	g.listed[id] = &gdriveListing{entries, now.Add(gdriveListTTL)}
+}
+
+// walk calls fn with the objects under the folder dir (with ID id) with prefix after marker in order, by
+// listing the subfolders in depth first order, until fn returns false. The subfolders before marker are skipped.
+// If paging is true, the folders where it stops are kept for the next page, which starts in them, and the
+// children of the folders of marker are reused.
+func (g *gdrive) walk(dir, id, prefix, marker string, paging bool, fn func(Object) bool) (bool, error) {
+	entries, err := g.children(dir, id, paging && marker != "")
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		inMarker := marker != "" && strings.HasPrefix(marker, e.key)
+		if marker != "" && e.key <= marker && !inMarker || !strings.HasPrefix(e.key, prefix) && !strings.HasPrefix(prefix, e.key) {
+			continue
+		}
+		more := true
+		if strings.HasPrefix(e.key, prefix) && e.key > marker {
+			more = fn(g.toObject(e.key, e.file))
+		}
+		if more && e.file.MimeType == gdriveFolderType {
+			m := marker
+			if !inMarker {
+				m = "" // all of it is after marker
+			}
+			if more, err = g.walk(e.key, e.file.Id, prefix, m, paging, fn); err != nil {
+				return false, err
+			}
+		}
+		if !more {
+			if paging {
+				g.keep(id, entries)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// List lists the folder of prefix with the delimiter "/", or all the objects under it by walking the subfolders
+// otherwise. The children of the folder of marker are reused from the last List (for a short while), so the
+// following pages don't list all the children again.
+func (g *gdrive) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if delimiter != "/" && delimiter != "" {
+		return nil, notSupported
+	}
+	dir := prefix[:strings.LastIndexByte(prefix, '/')+1]
+	id, err := g.lookup(g.path(dir), true, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objs []Object
+	if dir != "" && dir == prefix && (marker == "" || delimiter == "" && marker < dir) {
+		objs = append(objs, &obj{dir, 0, time.Unix(0, 0), true, ""})
+	}
+	if limit > 0 && int64(len(objs)) >= limit {
+		return objs, nil
+	}
+	add := func(o Object) bool {
+		objs = append(objs, o)
+		return limit <= 0 || int64(len(objs)) < limit
+	}
+	if delimiter == "" {
+		_, err = g.walk(dir, id, prefix, marker, true, add)
+		return objs, err
+	}
+	entries, err := g.children(dir, id, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.key, prefix) || (marker != "" && e.key <= marker) {
+			continue
+		}
+		if !add(g.toObject(e.key, e.file)) {
+			break
+		}
+	}
+	return objs, nil
+}
+
+// ListAll walks all the subfolders under prefix, so every folder is listed once.
+func (g *gdrive) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	dir := prefix[:strings.LastIndexByte(prefix, '/')+1]
+	id, err := g.lookup(g.path(dir), true, false)
+	out := make(chan Object, maxResults)
+	if errors.Is(err, os.ErrNotExist) {
+		close(out)
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(out)
+		if dir != "" && dir == prefix && marker < dir {
+			out <- &obj{dir, 0, time.Unix(0, 0), true, ""}
+		}
+		_, err := g.walk(dir, id, prefix, marker, false, func(o Object) bool {
+			out <- o
+			return true
+		})
+		if err != nil {
+			logger.Errorf("list %s: %s", prefix, err)
+			out <- nil
+		}
+	}()
+	return out, nil
+}
+
+// newGDrive creates the storage in the folder of endpoint (e.g. gdrive://juicefs/myjfs), with the ID and secret
+// of OAuth client as the access key and secret key, and the refresh token (authorized for the scope of drive) as
+// the session token.
+func newGDrive(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = fmt.Sprintf("gdrive://%s", endpoint)
+	}
+	uri, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, errors.Errorf("Invalid endpoint: %v, error: %v", endpoint, err)
+	}
+	root := strings.Trim(uri.Host+uri.Path, "/")
+	if root == "" {
+		return nil, errors.Errorf("the folder of Google Drive is required: %s", endpoint)
+	}
+	if accessKey == "" {
+		accessKey = os.Getenv("GDRIVE_CLIENT_ID")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("GDRIVE_CLIENT_SECRET")
+	}
+	if token == "" {
+		token = os.Getenv("GDRIVE_REFRESH_TOKEN")
+	}
+	if accessKey == "" || secretKey == "" || token == "" {
+		return nil, errors.New("the ID and secret of OAuth client, and the refresh token are required for Google Drive")
+	}
+	conf := &oauth2.Config{
+		ClientID:     accessKey,
+		ClientSecret: secretKey,
+		Endpoint:     oauth2.Endpoint{AuthURL: google.Endpoint.AuthURL, TokenURL: gdriveTokenURL},
+		Scopes:       []string{drive.DriveScope},
+	}
+	ts := conf.TokenSource(ctx, &oauth2.Token{RefreshToken: token})
+	svc, err := drive.NewService(ctx, option.WithTokenSource(ts), option.WithEndpoint(gdriveEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	return &gdrive{svc: svc, root: root, ids: make(map[string]string), mkdirs: make(map[string]*gdriveMkdir), listed: make(map[string]*gdriveListing)}, nil
+}
+
+func init() {
+	Register("gdrive", newGDrive)
+}
@@ -333,5 +333,5 @@ func newGluster(endpoint, ak, sk, token string) (ObjectStorage, error) {
 }
 
 func init() {
-	Register("gluster", newGluster)
+	RegisterRawKeys("gluster", newGluster)
 }
@@ -20,9 +20,14 @@
 package object
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"sort"
@@ -35,6 +40,7 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
@@ -52,6 +58,10 @@ func (g *gs) String() string {
 	return fmt.Sprintf("gs://%s/", g.bucket)
 }
 
+func (g *gs) BucketName() string {
+	return g.bucket
+}
+
 func (g *gs) getClient() *storage.Client {
 	if len(g.clients) == 1 {
 		return g.clients[0]
@@ -107,13 +117,26 @@ func (g *gs) Head(key string) (Object, error) {
 		return nil, err
 	}
 
-	return &obj{
-		key,
-		attrs.Size,
-		attrs.Updated,
-		strings.HasSuffix(key, "/"),
-		attrs.StorageClass,
-	}, nil
+	return gsObject(key, attrs), nil
+}
+
+// gsObject returns the object with the ETag and MD5 of GCS, the composite objects have no MD5.
+func gsObject(key string, attrs *storage.ObjectAttrs) Object {
+	return &checksumObj{
+		obj{key, attrs.Size, attrs.Updated, strings.HasSuffix(key, "/"), attrs.StorageClass},
+		attrs.Etag,
+		hex.EncodeToString(attrs.MD5),
+		attrs.ContentType,
+	}
+}
+
+// checkCRC32CMismatch translates the error of GCS rejecting the data into ErrChecksumMismatch.
+func checkCRC32CMismatch(key string, err error) error {
+	var e *googleapi.Error
+	if errors.As(err, &e) && e.Code == http.StatusBadRequest && strings.Contains(e.Message, "CRC32C") {
+		return fmt.Errorf("%w: upload %s: %s", ErrChecksumMismatch, key, err)
+	}
+	return err
 }
 
 func (g *gs) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
@@ -127,9 +150,22 @@ func (g *gs) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCl
 	return reader, nil
 }
 
+// Put sends the CRC32C of the data if it's seekable so GCS rejects corrupted data, otherwise the CRC32C
+// calculated by GCS is compared after the upload, and the object is deleted if it mismatches.
 func (g *gs) Put(key string, data io.Reader, getters ...AttrGetter) error {
-	writer := g.getClient().Bucket(g.bucket).Object(key).NewWriter(ctx)
+	o := g.getClient().Bucket(g.bucket).Object(key)
+	writer := o.NewWriter(ctx)
 	writer.StorageClass = g.sc
+	if rs, ok := data.(io.ReadSeeker); ok {
+		if sum, err := strconv.ParseUint(generateChecksum(rs), 10, 32); err == nil {
+			writer.CRC32C = uint32(sum)
+			writer.SendCRC32C = true
+		}
+	}
+	hash := crc32.New(crc32c)
+	if !writer.SendCRC32C {
+		data = io.TeeReader(data, hash)
+	}
 
 	// If you upload small objects (< 16MiB), you should set ChunkSize
 	// to a value slightly larger than the objects' sizes to avoid memory bloat.
@@ -140,11 +176,19 @@ func (g *gs) Put(key string, data io.Reader, getters ...AttrGetter) error {
 	defer bufPool.Put(buf)
 	_, err := io.CopyBuffer(writer, data, *buf)
 	if err != nil {
+		writer.CloseWithError(err)
 		return err
 	}
 	attrs := applyGetters(getters...)
 	attrs.SetStorageClass(g.sc)
-	return writer.Close()
+	if err = writer.Close(); err != nil {
+		return checkCRC32CMismatch(key, err)
+	}
+	if !writer.SendCRC32C && writer.Attrs().CRC32C != hash.Sum32() {
+		_ = o.Delete(ctx)
+		return fmt.Errorf("%w: upload %s: crc32c %d != %d", ErrChecksumMismatch, key, writer.Attrs().CRC32C, hash.Sum32())
+	}
+	return nil
 }
 
 func (g *gs) Copy(dst, src string) error {
@@ -186,7 +230,7 @@ func (g *gs) List(prefix, marker, delimiter string, limit int64, followLink bool
 		if delimiter != "" && item.Prefix != "" {
 			objs[i] = &obj{item.Prefix, 0, time.Unix(0, 0), true, item.StorageClass}
 		} else {
-			objs[i] = &obj{item.Name, item.Size, item.Updated, strings.HasSuffix(item.Name, "/"), item.StorageClass}
+			objs[i] = gsObject(item.Name, item)
 		}
 	}
 	if delimiter != "" {
@@ -195,6 +239,132 @@ func (g *gs) List(prefix, marker, delimiter string, limit int64, followLink bool
 	return objs, nil
 }
 
+// gsMaxCompose is the max number of source objects composed in a request.
+const gsMaxCompose = 32
+
+// gsUploadsDir is the directory of the parts of multipart uploads, which are temporary objects.
+const gsUploadsDir = ".juicefs-uploads/"
+
+func (g *gs) Limits() Limits {
+	return Limits{
+		IsSupportMultipartUpload: true,
+		MinPartSize:              5 << 20,
+		MaxPartSize:              5 << 30,
+		MaxPartCount:             10000,
+		MaxObjectSize:            5 << 40,
+	}
+}
+
+func gsPartKey(uploadID string, num int) string {
+	return fmt.Sprintf("%s%s/%05d", gsUploadsDir, uploadID, num)
+}
+
+// CreateMultipartUpload starts an upload whose parts are put as temporary objects, which are composed into the
+// object by CompleteUpload, so the uploaded parts are persisted and not uploaded again after a failure.
+func (g *gs) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &MultipartUpload{UploadID: hex.EncodeToString(id[:]), MinPartSize: 5 << 20, MaxCount: 10000}, nil
+}
+
+// UploadPart puts the part as a temporary object with its CRC32C, so GCS rejects corrupted data.
+func (g *gs) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	pk := gsPartKey(uploadID, num)
+	writer := g.getClient().Bucket(g.bucket).Object(pk).NewWriter(ctx)
+	writer.CRC32C = crc32.Checksum(body, crc32c)
+	writer.SendCRC32C = true
+	writer.ChunkSize = 0 // the part is in memory already
+	if _, err := writer.Write(body); err != nil {
+		writer.CloseWithError(err)
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, checkCRC32CMismatch(pk, err)
+	}
+	return &Part{Num: num, Size: len(body), ETag: writer.Attrs().Etag}, nil
+}
+
+// compose composes srcs into dst, at most gsMaxCompose of them in a request, so more sources are composed into
+// the intermediate objects under tmp first, which are returned to be deleted.
+func (g *gs) compose(dst string, srcs []string, tmp string) ([]string, error) {
+	bucket := g.getClient().Bucket(g.bucket)
+	run := func(dst string, srcs []string) error {
+		handles := make([]*storage.ObjectHandle, len(srcs))
+		for i, src := range srcs {
+			handles[i] = bucket.Object(src)
+		}
+		composer := bucket.Object(dst).ComposerFrom(handles...)
+		composer.StorageClass = g.sc
+		_, err := composer.Run(ctx)
+		return err
+	}
+	var temps []string
+	for level := 0; len(srcs) > gsMaxCompose; level++ {
+		var next []string
+		for i := 0; i < len(srcs); i += gsMaxCompose {
+			end := i + gsMaxCompose
+			if end > len(srcs) {
+				end = len(srcs)
+			}
+			name := fmt.Sprintf("%sc%d-%05d", tmp, level, i/gsMaxCompose)
+			temps = append(temps, name)
+			if err := run(name, srcs[i:end]); err != nil {
+				return temps, err
+			}
+			next = append(next, name)
+		}
+		srcs = next
+	}
+	return temps, run(dst, srcs)
+}
+
+// CompleteUpload composes the parts in order into the object, and deletes all the temporary objects of the upload.
+func (g *gs) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	if len(parts) == 0 {
+		return g.Put(key, bytes.NewReader(nil))
+	}
+	srcs := make([]string, len(parts))
+	for i, p := range parts {
+		if i > 0 && p.Num <= parts[i-1].Num {
+			return fmt.Errorf("parts of %s should be in ascending order: %d after %d", key, p.Num, parts[i-1].Num)
+		}
+		srcs[i] = gsPartKey(uploadID, p.Num)
+	}
+	temps, err := g.compose(key, srcs, gsUploadsDir+uploadID+"/")
+	if err != nil {
+		var e *googleapi.Error
+		if errors.As(err, &e) && e.Code == http.StatusNotFound {
+			err = fmt.Errorf("%w: part of %s: %s", os.ErrNotExist, key, err)
+		}
+		for _, t := range temps {
+			_ = g.Delete(t)
+		}
+		return err
+	}
+	g.AbortUpload(key, uploadID) // the parts not completed are discarded too
+	return nil
+}
+
+// AbortUpload deletes the parts uploaded and the intermediate objects.
+func (g *gs) AbortUpload(key string, uploadID string) {
+	it := g.getClient().Bucket(g.bucket).Objects(ctx, &storage.Query{Prefix: gsUploadsDir + uploadID + "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			logger.Warnf("List parts of upload %s: %s", key, err)
+			return
+		}
+		if err = g.Delete(attrs.Name); err != nil {
+			logger.Warnf("Delete part %s of upload %s: %s", attrs.Name, key, err)
+		}
+	}
+}
+
 func (g *gs) SetStorageClass(sc string) error {
 	g.sc = sc
 	return nil
@@ -238,4 +408,11 @@ func newGS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 
 func init() {
 	Register("gs", newGS)
+	statusCodeFuncs = append(statusCodeFuncs, func(err error) int {
+		var e *googleapi.Error
+		if errors.As(err, &e) {
+			return e.Code
+		}
+		return 0
+	})
 }
@@ -0,0 +1,189 @@
+//go:build !nogs
+// +build !nogs
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// fakeGCS serves the objects of bucket "test" in JSON API of GCS, for the uploads, compose, deletion and listing.
+type fakeGCS struct {
+	sync.Mutex
+	objects  map[string][]byte
+	composes int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	reply := func(name string) {
+		data := f.objects[name]
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"bucket": "test", "name": name, "size": strconv.Itoa(len(data)),
+			"etag": fmt.Sprintf("etag-%x", md5.Sum(data)), "crc32c": base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crc32c)))})
+	}
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && path == "/upload/storage/v1/b/test/o":
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var meta struct{ Name, Crc32c string }
+		p, _ := mr.NextPart()
+		_ = json.NewDecoder(p).Decode(&meta)
+		p, _ = mr.NextPart()
+		data, _ := io.ReadAll(p)
+		if meta.Crc32c != "" && meta.Crc32c != base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crc32c))) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": {"code": 400, "message": "Provided CRC32C doesn't match calculated CRC32C."}}`))
+			return
+		}
+		f.objects[meta.Name] = data
+		reply(meta.Name)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/compose"):
+		dst, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/test/o/"), "/compose"))
+		var req struct{ SourceObjects []struct{ Name string } }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if len(req.SourceObjects) > gsMaxCompose {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, src := range req.SourceObjects {
+			d, ok := f.objects[src.Name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "Object not found"}}`))
+				return
+			}
+			data = append(data, d...)
+		}
+		f.composes++
+		f.objects[dst] = data
+		reply(dst)
+	case r.Method == http.MethodDelete:
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/test/o/"))
+		if _, ok := f.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && path == "/storage/v1/b/test/o":
+		var items []map[string]string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{"name": name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestGSMultipartUpload(t *testing.T) {
+	f := &fakeGCS{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	g := &gs{clients: []*storage.Client{client}, bucket: "test"}
+
+	up, err := g.CreateMultipartUpload("big")
+	if err != nil {
+		t.Fatalf("create upload: %s", err)
+	}
+	var parts []*Part
+	var expected []byte
+	for i := 1; i <= 70; i++ {
+		body := []byte(fmt.Sprintf("part %d;", i))
+		p, err := g.UploadPart("big", up.UploadID, i, body)
+		if err != nil {
+			t.Fatalf("upload part %d: %s", i, err)
+		}
+		if p.ETag == "" {
+			t.Fatalf("part %d should have the ETag", i)
+		}
+		expected = append(expected, body...)
+		parts = append(parts, p)
+	}
+	// the part persisted is kept for the retries of CompleteUpload
+	if _, ok := f.objects[gsPartKey(up.UploadID, 1)]; !ok {
+		t.Fatalf("part 1 should be persisted")
+	}
+	if err = g.CompleteUpload("big", up.UploadID, parts[:1:1]); err != nil {
+		t.Fatalf("complete upload with 1 part: %s", err)
+	}
+	if !bytes.Equal(f.objects["big"], []byte("part 1;")) {
+		t.Fatalf("only the parts completed should be composed: %q", f.objects["big"])
+	}
+	if err = g.CompleteUpload("big", up.UploadID, parts); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the parts are deleted after completed: %v", err)
+	}
+
+	up, _ = g.CreateMultipartUpload("big")
+	for _, p := range parts {
+		if _, err = g.UploadPart("big", up.UploadID, p.Num, []byte(fmt.Sprintf("part %d;", p.Num))); err != nil {
+			t.Fatalf("upload part %d: %s", p.Num, err)
+		}
+	}
+	f.composes = 0
+	if err = g.CompleteUpload("big", up.UploadID, parts); err != nil {
+		t.Fatalf("complete upload: %s", err)
+	}
+	if !bytes.Equal(f.objects["big"], expected) || f.composes != 4 {
+		t.Fatalf("70 parts should be composed by 3 intermediate objects: %q with %d composes", f.objects["big"], f.composes)
+	}
+	if len(f.objects) != 1 {
+		t.Fatalf("the temporary objects should be deleted: %d objects", len(f.objects))
+	}
+	if err = g.CompleteUpload("big", up.UploadID, []*Part{parts[1], parts[0]}); err == nil {
+		t.Fatalf("parts out of order should fail")
+	}
+
+	up, _ = g.CreateMultipartUpload("aborted")
+	_, _ = g.UploadPart("aborted", up.UploadID, 1, []byte("1"))
+	_, _ = g.UploadPart("aborted", up.UploadID, 2, []byte("2"))
+	g.AbortUpload("aborted", up.UploadID)
+	if len(f.objects) != 1 {
+		t.Fatalf("the parts should be deleted by abort: %d objects", len(f.objects))
+	}
+}
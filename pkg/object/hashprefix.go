@@ -0,0 +1,356 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxHashPrefixDigits is the max digits of hash prefixes, the listings are sent to all the 16^digits prefixes.
+const maxHashPrefixDigits = 3
+
+// hashListConcurrency is the number of hash prefixes listed concurrently by List.
+const hashListConcurrency = 16
+
+// HashPrefixKey returns the key of an object in the object storage wrapped by WithHashPrefix, which is the
+// first digits of the hex encoded sha256 of the key before it, e.g. "ca/a" for "a" with 2 digits.
+func HashPrefixKey(key string, digits int) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:(digits+1)/2])[:digits] + "/" + key
+}
+
+type hashPrefixed struct {
+	ObjectStorage
+	digits int
+}
+
+// WithHashPrefix returns an object storage that puts the objects under the prefixes of their hash (HashPrefixKey),
+// so the sequential keys are spread over 16^digits prefixes, which are partitioned by S3 and alike to scale, rather
+// than throttled as a hot prefix. The hash only depends on the key, so the objects are always addressed by the same
+// keys, and they are listed by their keys in order (by merging the listings of all the prefixes), the objects not
+// written by it are skipped.
+//
+// It should be enabled for a new bucket (or prefix), the existing objects are not visible until they are copied
+// to their HashPrefixKey. digits should be 1 to 3.
+func WithHashPrefix(s ObjectStorage, digits int) (ObjectStorage, error) {
+	if digits < 1 || digits > maxHashPrefixDigits {
+		return nil, fmt.Errorf("invalid digits of hash prefix: %d, should be 1 to %d", digits, maxHashPrefixDigits)
+	}
+	return &hashPrefixed{s, digits}, nil
+}
+
+func (h *hashPrefixed) WithContext(ctx context.Context) ObjectStorage {
+	return &hashPrefixed{WithContext(h.ObjectStorage, ctx), h.digits}
+}
+
+func (h *hashPrefixed) String() string {
+	return fmt.Sprintf("%s(hash%d)", h.ObjectStorage, h.digits)
+}
+
+func (h *hashPrefixed) key(key string) string {
+	return HashPrefixKey(key, h.digits)
+}
+
+// prefixes returns all the hash prefixes in order.
+func (h *hashPrefixed) prefixes() []string {
+	n := 1 << (4 * h.digits)
+	prefixes := make([]string, n)
+	for i := range prefixes {
+		prefixes[i] = fmt.Sprintf("%0*x/", h.digits, i)
+	}
+	return prefixes
+}
+
+// logical returns the object with the key before hashed, or false if it's not written by WithHashPrefix. The
+// directories (common prefixes) are shared by all the hash prefixes, so their hash is not checked.
+func (h *hashPrefixed) logical(o Object, dir bool) (Object, bool) {
+	key := o.Key()
+	if len(key) <= h.digits || key[h.digits] != '/' {
+		return nil, false
+	}
+	logical := key[h.digits+1:]
+	if !(dir && strings.HasSuffix(logical, dirSuffix)) && h.key(logical) != key {
+		return nil, false
+	}
+	return setKey(o, logical), true
+}
+
+func (h *hashPrefixed) Head(key string) (Object, error) {
+	o, err := h.ObjectStorage.Head(h.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return setKey(o, key), nil
+}
+
+func (h *hashPrefixed) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	return h.ObjectStorage.Get(h.key(key), off, limit, getters...)
+}
+
+func (h *hashPrefixed) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return h.ObjectStorage.Put(h.key(key), in, getters...)
+}
+
+func (h *hashPrefixed) Copy(dst, src string) error {
+	return h.ObjectStorage.Copy(h.key(dst), h.key(src))
+}
+
+func (h *hashPrefixed) CopyWithOptions(dst, src string, opts CopyOptions) error {
+	return CopyWithOptions(h.ObjectStorage, h.key(dst), h.key(src), opts)
+}
+
+func (h *hashPrefixed) Move(dst, src string) error {
+	return Move(h.ObjectStorage, h.key(dst), h.key(src))
+}
+
+func (h *hashPrefixed) Delete(key string, getters ...AttrGetter) error {
+	return h.ObjectStorage.Delete(h.key(key), getters...)
+}
+
+func (h *hashPrefixed) DeleteMulti(keys []string) ([]string, error) {
+	hkeys := make([]string, len(keys))
+	for i, key := range keys {
+		hkeys[i] = h.key(key)
+	}
+	failed, err := DeleteMulti(h.ObjectStorage, hkeys)
+	for i, key := range failed {
+		failed[i] = key[h.digits+1:]
+	}
+	return failed, err
+}
+
+func (h *hashPrefixed) PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error {
+	return PutIfNotExists(h.ObjectStorage, h.key(key), in, getters...)
+}
+
+func (h *hashPrefixed) Exists(key string) (bool, error) {
+	return Exists(h.ObjectStorage, h.key(key))
+}
+
+// List lists all the hash prefixes and merges them, so it costs 16^digits requests for a page.
+func (h *hashPrefixed) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	prefixes := h.prefixes()
+	pages := make([][]Object, len(prefixes))
+	errs := make([]error, len(prefixes))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, hashListConcurrency)
+	for i, hp := range prefixes {
+		var m string
+		if marker != "" {
+			m = hp + marker
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, hp, m string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			pages[i], errs[i] = h.ObjectStorage.List(hp+prefix, m, delimiter, limit, followLink)
+		}(i, hp, m)
+	}
+	wg.Wait()
+	var objs []Object
+	for i, page := range pages {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("list %s: %w", prefixes[i], errs[i])
+		}
+		for _, o := range page {
+			if o, ok := h.logical(o, delimiter != ""); ok {
+				objs = append(objs, o)
+			}
+		}
+	}
+	sort.SliceStable(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	merged := objs[:0]
+	for i, o := range objs {
+		if i > 0 && o.Key() == objs[i-1].Key() { // a directory found in multiple hash prefixes
+			continue
+		}
+		merged = append(merged, o)
+	}
+	if limit > 0 && int64(len(merged)) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
+
+// hashListPage is the number of objects listed from a hash prefix at a time by ListAll.
+const hashListPage = 100
+
+// hashListing is the listing of a hash prefix by pages, for ListAll to merge.
+type hashListing struct {
+	hp     string
+	marker string   // the last key listed
+	objs   []Object // the objects listed but not merged yet, with the keys before hashed
+	done   bool
+	ch     <-chan Object // the listing by ListAll if the object storage can't list by pages
+}
+
+// next lists the next page of the hash prefix, skipping the pages without any object written by WithHashPrefix.
+func (h *hashPrefixed) next(l *hashListing, prefix string, followLink bool) error {
+	for len(l.objs) == 0 && !l.done {
+		if l.ch != nil {
+			o, ok := <-l.ch
+			if ok && o == nil {
+				return fmt.Errorf("list %s failed", l.hp)
+			}
+			// the listing by ListAllWithDelimiter may start from the marker
+			if l.done = !ok; ok && o.Key() > l.marker {
+				if o, ok := h.logical(o, false); ok {
+					l.objs = append(l.objs, o)
+				}
+			}
+			continue
+		}
+		page, err := h.ObjectStorage.List(l.hp+prefix, l.marker, "", hashListPage, followLink)
+		if errors.Is(err, notSupported) {
+			if l.ch, err = ListAll(h.ObjectStorage, l.hp+prefix, l.marker, followLink); err != nil {
+				return fmt.Errorf("list %s: %w", l.hp, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("list %s: %w", l.hp, err)
+		}
+		l.done = len(page) < hashListPage
+		if len(page) > 0 {
+			l.marker = page[len(page)-1].Key()
+		}
+		for _, o := range page {
+			if o, ok := h.logical(o, false); ok {
+				l.objs = append(l.objs, o)
+			}
+		}
+	}
+	return nil
+}
+
+type hashListings []*hashListing
+
+func (s hashListings) Len() int            { return len(s) }
+func (s hashListings) Less(i, j int) bool  { return s[i].objs[0].Key() < s[j].objs[0].Key() }
+func (s hashListings) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *hashListings) Push(x interface{}) { *s = append(*s, x.(*hashListing)) }
+func (s *hashListings) Pop() interface{} {
+	old := *s
+	l := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return l
+}
+
+// ListAll merges the listings of all the hash prefixes in order. They are listed by pages, and the next page of a
+// hash prefix is listed only after the objects of the previous one are merged, so the number of concurrent
+// requests (hashListConcurrency for the first pages, then one) and the objects kept are bounded. The hash prefixes
+// are listed by ListAll if the object storage can't list them by pages.
+func (h *hashPrefixed) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	prefixes := h.prefixes()
+	listings := make([]*hashListing, len(prefixes))
+	errs := make([]error, len(prefixes))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, hashListConcurrency)
+	for i, hp := range prefixes {
+		listings[i] = &hashListing{hp: hp}
+		if marker != "" {
+			listings[i].marker = hp + marker
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = h.next(listings[i], prefix, followLink)
+		}(i)
+	}
+	wg.Wait()
+	heads := make(hashListings, 0, len(listings))
+	for i, l := range listings {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if len(l.objs) > 0 {
+			heads = append(heads, l)
+		}
+	}
+	heap.Init(&heads)
+
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		for heads.Len() > 0 {
+			l := heads[0]
+			out <- l.objs[0]
+			l.objs = l.objs[1:]
+			if err := h.next(l, prefix, followLink); err != nil {
+				logger.Errorf("List all of %s: %s", h, err)
+				out <- nil
+				return
+			}
+			if len(l.objs) > 0 {
+				heap.Fix(&heads, 0)
+			} else {
+				heap.Pop(&heads)
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (h *hashPrefixed) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return h.ObjectStorage.CreateMultipartUpload(h.key(key))
+}
+
+func (h *hashPrefixed) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	return h.ObjectStorage.UploadPart(h.key(key), uploadID, num, body)
+}
+
+func (h *hashPrefixed) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	return h.ObjectStorage.UploadPartCopy(h.key(key), uploadID, num, h.key(srcKey), off, size)
+}
+
+func (h *hashPrefixed) AbortUpload(key string, uploadID string) {
+	h.ObjectStorage.AbortUpload(h.key(key), uploadID)
+}
+
+func (h *hashPrefixed) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	return h.ObjectStorage.CompleteUpload(h.key(key), uploadID, parts)
+}
+
+func (h *hashPrefixed) ListUploads(marker string) ([]*PendingPart, string, error) {
+	parts, nextMarker, err := h.ObjectStorage.ListUploads(marker)
+	var ours []*PendingPart
+	for _, part := range parts {
+		if len(part.Key) > h.digits && h.key(part.Key[h.digits+1:]) == part.Key {
+			part.Key = part.Key[h.digits+1:]
+			ours = append(ours, part)
+		}
+	}
+	return ours, nextMarker, err
+}
+
+var _ ObjectStorage = &hashPrefixed{}
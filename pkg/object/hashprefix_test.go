@@ -0,0 +1,187 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashPrefix(t *testing.T) {
+	// the keys must never change, otherwise the existing objects are lost
+	if k := HashPrefixKey("a", 2); k != "ca/a" {
+		t.Fatalf("hash prefix key of a: %s", k)
+	}
+	if k := HashPrefixKey("chunks/0/1/1_0_4", 3); k != "7b8/chunks/0/1/1_0_4" {
+		t.Fatalf("hash prefix key: %s", k)
+	}
+	m, _ := newMem("", "", "", "")
+	if _, err := WithHashPrefix(m, 0); err == nil {
+		t.Fatalf("0 digits should fail")
+	}
+	if _, err := WithHashPrefix(m, maxHashPrefixDigits+1); err == nil {
+		t.Fatalf("too many digits should fail")
+	}
+	s, err := WithHashPrefix(m, 1)
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	testStorage(t, s)
+}
+
+func TestHashPrefixListing(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	s, _ := WithHashPrefix(m, 2)
+	var expected []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("d/k%03d", i)
+		if err := s.Put(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+		expected = append(expected, key)
+	}
+	_ = s.Put("e/sub/k", bytes.NewReader(nil))
+	_ = m.Put("ab/d/foreign", bytes.NewReader(nil)) // not written by the wrapper
+	_ = m.Put("plain", bytes.NewReader(nil))
+
+	raw, _ := m.List("", "", "", 1000, true)
+	prefixes := make(map[string]bool)
+	for _, o := range raw {
+		prefixes[o.Key()[:2]] = true
+	}
+	if len(prefixes) < 40 {
+		t.Fatalf("the keys should be spread over the hash prefixes: %d prefixes", len(prefixes))
+	}
+	if d, err := get(s, "d/k042", 0, -1); err != nil || d != "d/k042" {
+		t.Fatalf("get d/k042: %q %v", d, err)
+	}
+	if o, err := s.Head("d/k042"); err != nil || o.Key() != "d/k042" {
+		t.Fatalf("head should return the key before hashed: %+v %v", o, err)
+	}
+
+	objs, err := s.List("d/", "d/k010", "", 20, true)
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	if keys := listKeys(objs); keys != strings.Join(expected[11:31], ",") {
+		t.Fatalf("list after d/k010: %s", keys)
+	}
+	objs, err = s.List("", "", "/", 10, true)
+	if err != nil || listKeys(objs) != "d/,e/" {
+		t.Fatalf("list with delimiter: %s %v", listKeys(objs), err)
+	}
+
+	ch, err := ListAll(s, "d/", "d/k049", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var keys []string
+	for o := range ch {
+		if o == nil {
+			t.Fatalf("list all failed")
+		}
+		keys = append(keys, o.Key())
+	}
+	checkListed(t, keys, expected[50:])
+
+	if err := s.Copy("f/copy", "d/k000"); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+	if _, err := m.Head(HashPrefixKey("f/copy", 2)); err != nil {
+		t.Fatalf("the copy should be hashed: %s", err)
+	}
+	up, err := s.CreateMultipartUpload("g/large")
+	if err != nil {
+		t.Fatalf("create multipart upload: %s", err)
+	}
+	if parts, _, err := s.ListUploads(""); err != nil || len(parts) != 1 || parts[0].Key != "g/large" {
+		t.Fatalf("list uploads: %+v %v", parts, err)
+	}
+	part, _ := s.UploadPart("g/large", up.UploadID, 1, []byte("large"))
+	if err = s.CompleteUpload("g/large", up.UploadID, []*Part{part}); err != nil {
+		t.Fatalf("complete upload: %s", err)
+	}
+	if d, err := get(s, "g/large", 0, -1); err != nil || d != "large" {
+		t.Fatalf("get g/large: %q %v", d, err)
+	}
+}
+
+// concurrentLists counts the concurrent List calls.
+type concurrentLists struct {
+	ObjectStorage
+	running, max atomic.Int32
+}
+
+func (c *concurrentLists) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for m := c.max.Load(); n > m && !c.max.CompareAndSwap(m, n); m = c.max.Load() {
+	}
+	time.Sleep(time.Millisecond)
+	return c.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+}
+
+func TestHashPrefixListAll(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	c := &concurrentLists{ObjectStorage: m}
+	s, _ := WithHashPrefix(c, 2)
+	var expected []string
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		_ = s.Put(key, bytes.NewReader(nil))
+		expected = append(expected, key)
+	}
+	ch, err := ListAll(s, "", "k0999", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var keys []string
+	for o := range ch {
+		if o == nil {
+			t.Fatalf("list all failed")
+		}
+		keys = append(keys, o.Key())
+	}
+	checkListed(t, keys, expected[1000:])
+	if n := c.max.Load(); n > hashListConcurrency {
+		t.Fatalf("%d prefixes are listed concurrently, more than %d", n, hashListConcurrency)
+	}
+
+	// listed by ListAll if the object storage can't list by pages
+	d, _ := newDisk(t.TempDir()+"/", "", "", "")
+	s, _ = WithHashPrefix(d, 1)
+	for _, key := range expected[:50] {
+		_ = s.Put(key, bytes.NewReader(nil))
+	}
+	if ch, err = ListAll(s, "", "k0009", true); err != nil {
+		t.Fatalf("list all of disk: %s", err)
+	}
+	keys = keys[:0]
+	for o := range ch {
+		if o == nil {
+			t.Fatalf("list all of disk failed")
+		}
+		if !o.IsDir() {
+			keys = append(keys, o.Key())
+		}
+	}
+	checkListed(t, keys, expected[10:50])
+}
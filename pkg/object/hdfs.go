@@ -26,6 +26,7 @@ import (
 	"io"
 	"io/fs"
 	"math/rand"
+	"net/url"
 	"os"
 	"os/user"
 	"path"
@@ -47,6 +48,7 @@ type hdfsclient struct {
 	basePath       string
 	c              *hdfs.Client
 	dfsReplication int
+	dfsBlockSize   int64
 	umask          os.FileMode
 }
 
@@ -142,15 +144,15 @@ func (h *hdfsclient) Put(key string, in io.Reader, getters ...AttrGetter) (err e
 			}
 		}()
 	}
-	f, err := h.c.CreateFile(tmp, h.dfsReplication, 128<<20, 0666&^h.umask)
+	f, err := h.c.CreateFile(tmp, h.dfsReplication, h.dfsBlockSize, 0666&^h.umask)
 	if err != nil {
 		if pe, ok := err.(*os.PathError); ok && pe.Err == os.ErrNotExist {
 			_ = h.c.MkdirAll(path.Dir(p), 0777&^h.umask)
-			f, err = h.c.CreateFile(tmp, h.dfsReplication, 128<<20, 0666&^h.umask)
+			f, err = h.c.CreateFile(tmp, h.dfsReplication, h.dfsBlockSize, 0666&^h.umask)
 		}
 		if pe, ok := err.(*os.PathError); ok && errors.Is(pe.Err, os.ErrExist) {
 			_ = h.c.Remove(tmp)
-			f, err = h.c.CreateFile(tmp, h.dfsReplication, 128<<20, 0666&^h.umask)
+			f, err = h.c.CreateFile(tmp, h.dfsReplication, h.dfsBlockSize, 0666&^h.umask)
 		}
 		if err != nil {
 			return err
@@ -186,6 +188,18 @@ func (h *hdfsclient) Delete(key string, getters ...AttrGetter) error {
 	return err
 }
 
+// Move renames the file by the NameNode, which replaces dst atomically.
+func (h *hdfsclient) Move(dst, src string) error {
+	p := h.path(dst)
+	err := h.c.Rename(h.path(src), p)
+	if err != nil && os.IsNotExist(err) {
+		if e := h.c.MkdirAll(path.Dir(p), 0777&^h.umask); e == nil {
+			err = h.c.Rename(h.path(src), p)
+		}
+	}
+	return err
+}
+
 func (h *hdfsclient) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
 	if delimiter != "/" {
 		return nil, notSupported
@@ -273,11 +287,78 @@ func (h *hdfsclient) Chown(key string, owner, group string) error {
 	return h.c.Chown(h.path(key), owner, group)
 }
 
+// parseHDFSSize parses the size in bytes with an optional unit (k, m, g), like dfs.blocksize.
+func parseHDFSSize(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	var shift uint
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k':
+			shift = 10
+		case 'm':
+			shift = 20
+		case 'g':
+			shift = 30
+		}
+		if shift > 0 {
+			v = v[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return size << shift, nil
+}
+
+// hdfsFileOptions returns the replication and block size of the files, from the query of the address
+// (replication, block-size), or the hadoop configuration (dfs.replication, dfs.blocksize).
+func hdfsFileOptions(query url.Values, conf hadoopconf.HadoopConf) (int, int64, error) {
+	var replication = 3
+	if v, found := conf["dfs.replication"]; found {
+		if x, err := strconv.Atoi(v); err == nil {
+			replication = x
+		}
+	}
+	var blockSize int64 = 128 << 20
+	if v, found := conf["dfs.blocksize"]; found {
+		if x, err := parseHDFSSize(v); err == nil {
+			blockSize = x
+		}
+	}
+	if v := query.Get("replication"); v != "" {
+		x, err := strconv.Atoi(v)
+		if err != nil || x <= 0 {
+			return 0, 0, fmt.Errorf("invalid replication: %q", v)
+		}
+		replication = x
+	}
+	if v := query.Get("block-size"); v != "" {
+		x, err := parseHDFSSize(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid block-size: %s", err)
+		}
+		blockSize = x
+	}
+	return replication, blockSize, nil
+}
+
 func newHDFS(addr, username, sk, token string) (ObjectStorage, error) {
 	conf, err := hadoopconf.LoadFromEnvironment()
 	if err != nil {
 		return nil, fmt.Errorf("Problem loading configuration: %s", err)
 	}
+	var query url.Values
+	if i := strings.Index(addr, "?"); i >= 0 {
+		if query, err = url.ParseQuery(addr[i+1:]); err != nil {
+			return nil, fmt.Errorf("invalid query of %s: %s", addr, err)
+		}
+		addr = addr[:i]
+	}
+	replication, blockSize, err := hdfsFileOptions(query, conf)
+	if err != nil {
+		return nil, err
+	}
 
 	rpcAddr, basePath := parseHDFSAddr(addr, conf)
 	options := hdfs.ClientOptionsFromConf(conf)
@@ -316,12 +397,6 @@ func newHDFS(addr, username, sk, token string) (ObjectStorage, error) {
 		supergroup = os.Getenv("HADOOP_SUPER_GROUP")
 	}
 
-	var replication = 3
-	if v, found := conf["dfs.replication"]; found {
-		if x, err := strconv.Atoi(v); err == nil {
-			replication = x
-		}
-	}
 	var umask uint16 = 022
 	if v, found := conf["fs.permissions.umask-mode"]; found {
 		if x, err := strconv.ParseUint(v, 8, 16); err == nil {
@@ -334,6 +409,7 @@ func newHDFS(addr, username, sk, token string) (ObjectStorage, error) {
 		basePath:       basePath,
 		c:              c,
 		dfsReplication: replication,
+		dfsBlockSize:   blockSize,
 		umask:          os.FileMode(umask),
 	}, nil
 }
@@ -366,5 +442,5 @@ func parseHDFSAddr(addr string, conf hadoopconf.HadoopConf) (rpcAddresses []stri
 }
 
 func init() {
-	Register("hdfs", newHDFS)
+	RegisterRawKeys("hdfs", newHDFS)
 }
@@ -26,12 +26,14 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/IBM/ibm-cos-sdk-go/aws"
 	"github.com/IBM/ibm-cos-sdk-go/aws/awserr"
+	"github.com/IBM/ibm-cos-sdk-go/aws/credentials"
 	"github.com/IBM/ibm-cos-sdk-go/aws/credentials/ibmiam"
 	"github.com/IBM/ibm-cos-sdk-go/aws/request"
 	"github.com/IBM/ibm-cos-sdk-go/aws/session"
@@ -50,6 +52,10 @@ func (s *ibmcos) String() string {
 	return fmt.Sprintf("ibmcos://%s/", s.bucket)
 }
 
+func (s *ibmcos) BucketName() string {
+	return s.bucket
+}
+
 func (s *ibmcos) Create() error {
 	input := &s3.CreateBucketInput{Bucket: &s.bucket}
 	// https://cloud.ibm.com/docs/cloud-object-storage?topic=cloud-object-storage-classes&code=go
@@ -154,7 +160,7 @@ func (s *ibmcos) Head(key string) (Object, error) {
 		*r.ContentLength,
 		*r.LastModified,
 		strings.HasSuffix(key, "/"),
-		*r.StorageClass,
+		aws.StringValue(r.StorageClass), // omitted for the standard class
 	}, nil
 }
 
@@ -299,21 +305,46 @@ func (s *ibmcos) SetStorageClass(sc string) error {
 	return nil
 }
 
-func newIBMCOS(endpoint, apiKey, serviceInstanceID, token string) (ObjectStorage, error) {
+const ibmDefaultAuthEndpoint = "https://iam.cloud.ibm.com/identity/token"
+
+var ibmHMACAccessKey = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var ibmHMACSecretKey = regexp.MustCompile(`^[0-9a-f]{48}$`)
+
+// ibmCredentials returns the HMAC credentials if the keys look like the HMAC keys (32 and 48 hex characters),
+// otherwise the access key is taken as an IAM API key and the secret key as the service instance ID, the OAuth token
+// is exchanged from the token endpoint (IBM_AUTH_ENDPOINT) and refreshed before it expires.
+func ibmCredentials(accessKey, secretKey, token string) *credentials.Credentials {
+	if ibmHMACAccessKey.MatchString(accessKey) && ibmHMACSecretKey.MatchString(secretKey) {
+		logger.Debugf("Use HMAC credentials of IBM COS")
+		return credentials.NewStaticCredentials(accessKey, secretKey, token)
+	}
+	authEndpoint := os.Getenv("IBM_AUTH_ENDPOINT")
+	if authEndpoint == "" {
+		authEndpoint = ibmDefaultAuthEndpoint
+	}
+	logger.Debugf("Use IAM API key of IBM COS with token endpoint %s", authEndpoint)
+	return ibmiam.NewStaticCredentials(aws.NewConfig(), authEndpoint, accessKey, secretKey)
+}
+
+func newIBMCOS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("https://%s", endpoint)
 	}
-	uri, _ := url.ParseRequestURI(endpoint)
+	uri, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endpoint %s: %s", endpoint, err)
+	}
 	hostParts := strings.Split(uri.Host, ".")
+	if len(hostParts) < 3 {
+		return nil, fmt.Errorf("invalid endpoint of IBM COS: %s, should be like https://<bucket>.s3.<region>.cloud-object-storage.appdomain.cloud", endpoint)
+	}
 	bucket := hostParts[0]
 	region := hostParts[2]
-	authEndpoint := "https://iam.cloud.ibm.com/identity/token"
-	serviceEndpoint := "https://" + strings.SplitN(uri.Host, ".", 2)[1]
+	serviceEndpoint := uri.Scheme + "://" + strings.SplitN(uri.Host, ".", 2)[1]
 	conf := aws.NewConfig().
 		WithRegion(region).
 		WithEndpoint(serviceEndpoint).
-		WithCredentials(ibmiam.NewStaticCredentials(aws.NewConfig(),
-			authEndpoint, apiKey, serviceInstanceID)).
+		WithCredentials(ibmCredentials(accessKey, secretKey, token)).
 		WithS3ForcePathStyle(defaultPathStyle())
 	sess := session.Must(session.NewSession())
 	client := s3.New(sess, conf)
@@ -17,6 +17,8 @@
 package object
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"io"
 	"time"
 )
@@ -45,6 +47,81 @@ func (o *obj) IsDir() bool          { return o.isDir }
 func (o *obj) IsSymlink() bool      { return false }
 func (o *obj) StorageClass() string { return o.sc }
 
+// linkObj is an object which is a symbolic link to another object.
+type linkObj struct {
+	obj
+}
+
+func (o *linkObj) IsSymlink() bool { return true }
+
+// ObjectWithChecksum is an Object that carries the ETag and Content-MD5 returned by the object
+// storage, they are empty if the object storage doesn't provide them.
+type ObjectWithChecksum interface {
+	Object
+	ETag() string
+	// ContentMD5 returns the hex encoded MD5 of the whole content.
+	ContentMD5() string
+}
+
+type checksumObj struct {
+	obj
+	etag        string
+	md5         string
+	contentType string
+}
+
+func (o *checksumObj) ETag() string        { return o.etag }
+func (o *checksumObj) ContentMD5() string  { return o.md5 }
+func (o *checksumObj) ContentType() string { return o.contentType }
+
+// ObjectWithContentType is an Object with the Content-Type stored by the object storage.
+type ObjectWithContentType interface {
+	Object
+	// ContentType returns the Content-Type of the object, it's empty if the object storage doesn't return it.
+	ContentType() string
+}
+
+// ObjectWithExpiry is an Object which is deleted by the object storage at a time, see PutWithExpiry.
+type ObjectWithExpiry interface {
+	Object
+	// Expiry returns the time when the object expires, or the zero time if it never expires.
+	Expiry() time.Time
+}
+
+// ObjectWithServerChecksum is an Object with the checksum verified and stored by the object storage on upload.
+type ObjectWithServerChecksum interface {
+	Object
+	// ServerChecksum returns the algorithm (like CRC32C, SHA256 or MD5) and the base64 encoded checksum, they are
+	// empty if there is no checksum. The checksum of a multipart object may be the checksum of the checksums of
+	// parts, with a suffix "-<parts>".
+	ServerChecksum() (algorithm, value string)
+}
+
+func (o *checksumObj) ServerChecksum() (string, string) {
+	sum, err := hex.DecodeString(o.md5)
+	if err != nil || len(sum) == 0 {
+		return "", ""
+	}
+	return "MD5", base64.StdEncoding.EncodeToString(sum)
+}
+
+// ObjectTypeAppendable is the type of the objects that can be appended.
+const ObjectTypeAppendable = "Appendable"
+
+// ObjectWithType is an Object with the type reported by the object storage, like Normal, Appendable or Multipart.
+type ObjectWithType interface {
+	Object
+	// ObjectType returns the type of object, only the ones of ObjectTypeAppendable can be appended.
+	ObjectType() string
+}
+
+type typedObj struct {
+	obj
+	objType string
+}
+
+func (o *typedObj) ObjectType() string { return o.objType }
+
 type MultipartUpload struct {
 	MinPartSize int
 	MaxCount    int
@@ -52,9 +129,10 @@ type MultipartUpload struct {
 }
 
 type Part struct {
-	Num  int
-	Size int
-	ETag string
+	Num      int
+	Size     int
+	ETag     string
+	Checksum string // the checksum of the part, required by some object storages to complete the upload
 }
 
 type PendingPart struct {
@@ -69,6 +147,10 @@ type Limits struct {
 	MinPartSize              int
 	MaxPartSize              int64
 	MaxPartCount             int
+	MaxObjectSize            int64 // 0 means no known limit
+	MaxCopySize              int64 // the largest object copied by a single Copy, 0 means no known limit
+	PartSize                 int64 // the part size of uploads configured by part-size, 0 means choosing it by uploaders
+	UploadConcurrency        int   // the parts uploaded concurrently configured by upload-concurrency, 0 means default
 }
 
 // ObjectStorage is the interface for object storage.
@@ -89,7 +171,7 @@ type ObjectStorage interface {
 	// Delete a object.
 	Delete(key string, getters ...AttrGetter) error
 
-	// Head returns some information about the object or an error if not found.
+	// Head returns some information about the object, or ErrNotFound if not found.
 	Head(key string) (Object, error)
 	// List returns a list of objects.
 	List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error)
@@ -124,6 +206,35 @@ func Shutdown(o ObjectStorage) {
 	switch o := o.(type) {
 	case *encrypted:
 		fn(o.ObjectStorage)
+	case *blockEncrypted:
+		fn(o.ObjectStorage)
+	case *compressed:
+		fn(o.ObjectStorage)
+	case *readOnly:
+		fn(o.ObjectStorage)
+	case *retryStorage:
+		fn(o.ObjectStorage)
+	case *rateLimited:
+		fn(o.ObjectStorage)
+	case *withMetrics:
+		fn(o.ObjectStorage)
+	case *cached:
+		fn(o.ObjectStorage)
+	case *mirrored:
+		fn(o.ObjectStorage)
+		fn(o.secondary)
+	case *sortedListing:
+		fn(o.ObjectStorage)
+	case *normalized:
+		fn(o.ObjectStorage)
+	case *accounted:
+		fn(o.ObjectStorage)
+	case *faulty:
+		fn(o.ObjectStorage)
+	case *checkedListing:
+		fn(o.ObjectStorage)
+	case *hashPrefixed:
+		fn(o.ObjectStorage)
 	case *withPrefix:
 		fn(o.os)
 	case *sharded:
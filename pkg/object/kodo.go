@@ -0,0 +1,259 @@
+//go:build !noqiniu
+// +build !noqiniu
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qiniu/go-sdk/v7/auth"
+	"github.com/qiniu/go-sdk/v7/client"
+	"github.com/qiniu/go-sdk/v7/storage"
+)
+
+// the error code of Qiniu for the missing objects
+const kodoNotFound = 612
+
+// kodo is the native client of Qiniu Kodo, which talks to the Kodo API rather than the S3 gateway (see qiniu).
+type kodo struct {
+	DefaultObjectStorage
+	bucket   string
+	domain   string // the download domain bound to the bucket, like https://cdn.example.com
+	cred     *auth.Credentials
+	cfg      *storage.Config
+	cli      *client.Client
+	bm       *storage.BucketManager
+	uploader *storage.ResumeUploaderV2
+	markers  *listMarkers // the markers of Kodo where the pages of List stopped
+}
+
+func (k *kodo) String() string {
+	return fmt.Sprintf("kodo://%s/", k.bucket)
+}
+
+func kodoError(err error) error {
+	var e *client.ErrorInfo
+	if errors.As(err, &e) && (e.Code == kodoNotFound || e.Code == http.StatusNotFound) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// kodoTime converts the put time of Kodo (in 100 nanoseconds) into time.
+func kodoTime(t int64) time.Time {
+	return time.Unix(0, t*100)
+}
+
+func (k *kodo) Head(key string) (Object, error) {
+	r, err := k.bm.Stat(k.bucket, key)
+	if err != nil {
+		return nil, kodoError(err)
+	}
+	return &checksumObj{
+		obj{key, r.Fsize, kodoTime(r.PutTime), strings.HasSuffix(key, "/"), ""},
+		r.Hash,
+		"",
+		r.MimeType,
+	}, nil
+}
+
+// Get downloads the object through the private URL of the bound domain, which is signed by the credentials.
+func (k *kodo) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	deadline := time.Now().Add(time.Hour).Unix()
+	req, err := http.NewRequest(http.MethodGet, storage.MakePrivateURLv2(k.cred, k.domain, key, deadline), nil)
+	if err != nil {
+		return nil, err
+	}
+	if off > 0 || limit > 0 {
+		if limit > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+limit-1))
+		} else {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", off))
+		}
+	}
+	resp, err := k.cli.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		attrs := applyGetters(getters...)
+		attrs.SetRequestID(resp.Header.Get("X-Reqid"))
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, os.ErrNotExist
+	default:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("get %s: status code %d", key, resp.StatusCode)
+	}
+}
+
+// Put uploads the object with the resumable upload API (v2), which uploads large objects in parts concurrently.
+func (k *kodo) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	putPolicy := storage.PutPolicy{Scope: k.bucket + ":" + key}
+	upToken := putPolicy.UploadToken(k.cred)
+	attrs := applyGetters(getters...)
+	extra := &storage.RputV2Extra{MimeType: attrs.contentTypeOf(key, true)}
+	var ret storage.PutRet
+	var err error
+	if r, ok := in.(io.ReaderAt); ok {
+		var size int64
+		if _, size, err = findLen(in); err != nil {
+			return err
+		}
+		err = k.uploader.Put(ctx, &ret, upToken, key, r, size, extra)
+	} else {
+		err = k.uploader.PutWithoutSize(ctx, &ret, upToken, key, in, extra)
+	}
+	return err
+}
+
+func (k *kodo) Copy(dst, src string) error {
+	return kodoError(k.bm.Copy(k.bucket, src, k.bucket, dst, true))
+}
+
+func (k *kodo) Move(dst, src string) error {
+	return kodoError(k.bm.Move(k.bucket, src, k.bucket, dst, true))
+}
+
+func (k *kodo) Delete(key string, getters ...AttrGetter) error {
+	if err := kodoError(k.bm.Delete(k.bucket, key)); !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the objects after marker (a key). The markers of Kodo are opaque, so the marker of Kodo where the
+// previous page stopped is used if marker is the last key of it, otherwise the objects before marker are skipped
+// by following the markers of Kodo from the beginning.
+func (k *kodo) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+	next := k.markers.get(prefix, delimiter, marker)
+	skipping := marker != "" && next == ""
+	last := marker
+	var objs []Object
+	for {
+		// ask for the rest only, so the page stops at the marker of Kodo
+		size := limit - int64(len(objs))
+		if skipping && len(objs) == 0 {
+			size = 1000
+		}
+		entries, prefixes, token, hasNext, err := k.bm.ListFiles(k.bucket, prefix, delimiter, next, int(size))
+		if err != nil {
+			return nil, err
+		}
+		page := make([]Object, 0, len(entries)+len(prefixes))
+		for _, e := range entries {
+			page = append(page, &checksumObj{
+				obj{e.Key, e.Fsize, kodoTime(e.PutTime), strings.HasSuffix(e.Key, "/"), ""},
+				e.Hash,
+				"",
+				e.MimeType,
+			})
+		}
+		for _, p := range prefixes {
+			page = append(page, &obj{p, 0, time.Unix(0, 0), true, ""})
+		}
+		if len(prefixes) > 0 {
+			sort.Slice(page, func(i, j int) bool { return page[i].Key() < page[j].Key() })
+		}
+		for _, o := range page {
+			// a common prefix may be returned again in the next page
+			if o.Key() > last {
+				objs = append(objs, o)
+				last = o.Key()
+			}
+		}
+		if int64(len(objs)) >= limit {
+			if int64(len(objs)) == limit && hasNext {
+				k.markers.put(prefix, delimiter, last, token)
+			}
+			return objs[:limit], nil
+		}
+		if !hasNext {
+			return objs, nil
+		}
+		next = token
+	}
+}
+
+// newKodo creates the client of Qiniu Kodo, the bucket is like https://<bucket>.<region>?domain=<domain>, the region
+// (like z0 or cn-east-2) is queried from Kodo if it's omitted, the download domain bound to the bucket can also be set
+// by the environment variable QINIU_DOMAIN.
+func newKodo(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = fmt.Sprintf("https://%s", endpoint)
+	}
+	uri, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endpoint: %v, error: %v", endpoint, err)
+	}
+	hostParts := strings.SplitN(uri.Host, ".", 2)
+	bucket := hostParts[0]
+	domain := uri.Query().Get("domain")
+	if domain == "" {
+		domain = os.Getenv("QINIU_DOMAIN")
+	}
+	if domain == "" {
+		return nil, fmt.Errorf("the download domain bound to bucket %s is required, set it by domain=<domain> in the bucket URL or QINIU_DOMAIN", bucket)
+	}
+	if !strings.Contains(domain, "://") {
+		domain = uri.Scheme + "://" + domain
+	}
+
+	var region *storage.Region
+	if len(hostParts) > 1 {
+		r, ok := storage.GetRegionByID(storage.RegionID(hostParts[1]))
+		if !ok {
+			return nil, fmt.Errorf("unknown region of Kodo: %s", hostParts[1])
+		}
+		region = &r
+	} else if region, err = storage.GetRegion(accessKey, bucket); err != nil {
+		return nil, fmt.Errorf("get region of bucket %s: %s", bucket, err)
+	}
+	// the bucket manager only uses Zone (the alias of Region)
+	cfg := &storage.Config{Zone: region, UseHTTPS: uri.Scheme == "https"}
+	cred := auth.New(accessKey, secretKey)
+	cli := &client.Client{Client: httpClient}
+	return &kodo{
+		bucket:   bucket,
+		domain:   domain,
+		cred:     cred,
+		cfg:      cfg,
+		cli:      cli,
+		bm:       storage.NewBucketManagerEx(cred, cfg, cli),
+		uploader: storage.NewResumeUploaderV2Ex(cfg, cli),
+		markers:  newListMarkers(),
+	}, nil
+}
+
+func init() {
+	Register("kodo", newKodo)
+}
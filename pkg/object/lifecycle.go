@@ -0,0 +1,172 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LifecycleTransition moves the objects to a colder storage class some days after they are modified.
+type LifecycleTransition struct {
+	Days         int
+	StorageClass string
+}
+
+// LifecycleRule transitions the objects under Prefix to colder storage classes, and expires (deletes) them some
+// days after they are modified.
+type LifecycleRule struct {
+	ID             string
+	Prefix         string
+	Transitions    []LifecycleTransition // in the order of days
+	ExpirationDays int                   // 0 means the objects never expire
+}
+
+// SupportLifecycle is implemented by the object storages that can manage the lifecycle rules of the bucket.
+type SupportLifecycle interface {
+	// SetLifecycle replaces all the lifecycle rules of the bucket, an empty list removes them.
+	SetLifecycle(rules []LifecycleRule) error
+	// GetLifecycle returns the lifecycle rules of the bucket, or nil if there is none.
+	GetLifecycle() ([]LifecycleRule, error)
+}
+
+func lifecycleStorage(store ObjectStorage) SupportLifecycle {
+	for s := store; s != nil; s = unwrap(s) {
+		if l, ok := s.(SupportLifecycle); ok {
+			return l
+		}
+	}
+	return nil
+}
+
+// SetLifecycle replaces the lifecycle rules of the bucket (only the ones under the prefix if it's wrapped by
+// WithPrefix), which are applied by the object storage in background. The rules are validated before being sent, and ErrNotSupported is returned if the object storage can't do it.
+func SetLifecycle(store ObjectStorage, rules []LifecycleRule) error {
+	if s := lifecycleStorage(store); s != nil {
+		return s.SetLifecycle(rules)
+	}
+	return notSupported
+}
+
+// GetLifecycle returns the lifecycle rules of the bucket, or ErrNotSupported if the object storage can't do it.
+func GetLifecycle(store ObjectStorage) ([]LifecycleRule, error) {
+	if s := lifecycleStorage(store); s != nil {
+		return s.GetLifecycle()
+	}
+	return nil, notSupported
+}
+
+// normalizeLifecycle validates the rules and returns a copy of them, with the storage classes translated by classes,
+// which maps the lower case names to the ones of the object storage.
+func normalizeLifecycle(rules []LifecycleRule, classes map[string]string) ([]LifecycleRule, error) {
+	ids := make(map[string]bool)
+	normalized := make([]LifecycleRule, 0, len(rules))
+	for i, r := range rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("lifecycle rule %d: ID is required", i)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("lifecycle rule %s: duplicated ID", r.ID)
+		}
+		ids[r.ID] = true
+		if len(r.Transitions) == 0 && r.ExpirationDays == 0 {
+			return nil, fmt.Errorf("lifecycle rule %s: no transition or expiration", r.ID)
+		}
+		if r.ExpirationDays < 0 {
+			return nil, fmt.Errorf("lifecycle rule %s: invalid expiration days %d", r.ID, r.ExpirationDays)
+		}
+		var last int
+		used := make(map[string]bool)
+		r.Transitions = append([]LifecycleTransition(nil), r.Transitions...)
+		for j, t := range r.Transitions {
+			if t.Days <= last {
+				return nil, fmt.Errorf("lifecycle rule %s: days of transitions should be positive and increasing, got %d", r.ID, t.Days)
+			}
+			last = t.Days
+			sc, ok := classes[strings.ToLower(t.StorageClass)]
+			if !ok {
+				return nil, fmt.Errorf("lifecycle rule %s: unsupported storage class %q", r.ID, t.StorageClass)
+			}
+			if used[sc] {
+				return nil, fmt.Errorf("lifecycle rule %s: duplicated transition to %s", r.ID, sc)
+			}
+			used[sc] = true
+			r.Transitions[j].StorageClass = sc
+		}
+		if r.ExpirationDays > 0 && r.ExpirationDays <= last {
+			return nil, fmt.Errorf("lifecycle rule %s: expiration (%d days) should be after the transitions", r.ID, r.ExpirationDays)
+		}
+		normalized = append(normalized, r)
+	}
+	return normalized, nil
+}
+
+func sortTransitions(ts []LifecycleTransition) {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Days < ts[j].Days })
+}
+
+// SetLifecycle replaces the rules under the prefix, the rules of the bucket for other prefixes are kept.
+func (p *withPrefix) SetLifecycle(rules []LifecycleRule) error {
+	existing, err := GetLifecycle(p.os)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		ids[r.ID] = true
+	}
+	var merged []LifecycleRule
+	for _, r := range existing {
+		if strings.HasPrefix(r.Prefix, p.prefix) {
+			continue
+		}
+		if ids[r.ID] {
+			return fmt.Errorf("lifecycle rule %s: the ID is used by the rule of prefix %q", r.ID, r.Prefix)
+		}
+		merged = append(merged, r)
+	}
+	for _, r := range rules {
+		r.Prefix = p.prefix + r.Prefix
+		merged = append(merged, r)
+	}
+	return SetLifecycle(p.os, merged)
+}
+
+// GetLifecycle returns the rules under the prefix.
+func (p *withPrefix) GetLifecycle() ([]LifecycleRule, error) {
+	rules, err := GetLifecycle(p.os)
+	if err != nil {
+		return nil, err
+	}
+	var result []LifecycleRule
+	for _, r := range rules {
+		if strings.HasPrefix(r.Prefix, p.prefix) {
+			r.Prefix = r.Prefix[len(p.prefix):]
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (r *readOnly) SetLifecycle(rules []LifecycleRule) error {
+	return fmt.Errorf("%w: set lifecycle of %s", r.err, r.ObjectStorage)
+}
+
+func (r *readOnly) GetLifecycle() ([]LifecycleRule, error) {
+	return GetLifecycle(r.ObjectStorage)
+}
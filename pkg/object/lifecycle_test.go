@@ -0,0 +1,242 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// memLifecycle keeps the lifecycle rules in memory.
+type memLifecycle struct {
+	ObjectStorage
+	rules []LifecycleRule
+}
+
+func (m *memLifecycle) SetLifecycle(rules []LifecycleRule) error {
+	m.rules = rules
+	return nil
+}
+
+func (m *memLifecycle) GetLifecycle() ([]LifecycleRule, error) {
+	return m.rules, nil
+}
+
+var testLifecycle = []LifecycleRule{
+	{ID: "logs", Prefix: "logs/", Transitions: []LifecycleTransition{{30, "standard_ia"}, {90, "GLACIER"}}, ExpirationDays: 365},
+	{ID: "tmp", Prefix: "tmp/", ExpirationDays: 7},
+}
+
+func TestLifecycle(t *testing.T) {
+	classes := map[string]string{"cool": "Cool", "archive": "Archive"}
+	for _, rules := range [][]LifecycleRule{
+		{{Prefix: "a/", ExpirationDays: 1}},
+		{{ID: "a", ExpirationDays: 1}, {ID: "a", ExpirationDays: 2}},
+		{{ID: "a"}},
+		{{ID: "a", ExpirationDays: -1}},
+		{{ID: "a", Transitions: []LifecycleTransition{{0, "cool"}}}},
+		{{ID: "a", Transitions: []LifecycleTransition{{30, "archive"}, {10, "cool"}}}},
+		{{ID: "a", Transitions: []LifecycleTransition{{30, "hot"}}}},
+		{{ID: "a", Transitions: []LifecycleTransition{{10, "cool"}, {30, "Cool"}}}},
+		{{ID: "a", Transitions: []LifecycleTransition{{30, "cool"}}, ExpirationDays: 30}},
+	} {
+		if _, err := normalizeLifecycle(rules, classes); err == nil {
+			t.Fatalf("invalid rules should fail: %+v", rules)
+		}
+	}
+	rules := []LifecycleRule{{ID: "a", Transitions: []LifecycleTransition{{10, "cool"}, {30, "ARCHIVE"}}, ExpirationDays: 31}}
+	normalized, err := normalizeLifecycle(rules, classes)
+	if err != nil || normalized[0].Transitions[0].StorageClass != "Cool" || normalized[0].Transitions[1].StorageClass != "Archive" {
+		t.Fatalf("normalize: %+v, %v", normalized, err)
+	}
+	if rules[0].Transitions[0].StorageClass != "cool" {
+		t.Fatalf("the rules should not be changed: %+v", rules)
+	}
+
+	m, _ := newMem("", "", "", "")
+	if err = SetLifecycle(m, testLifecycle); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("set lifecycle of mem: %v", err)
+	}
+	l := &memLifecycle{ObjectStorage: m}
+	s := WithEncryption(WithPrefix(l, "p/"), []byte("key"))
+	if err = SetLifecycle(s, testLifecycle); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if l.rules[0].Prefix != "p/logs/" || l.rules[1].Prefix != "p/tmp/" {
+		t.Fatalf("rules should be prefixed: %+v", l.rules)
+	}
+	l.rules = append(l.rules, LifecycleRule{ID: "other", Prefix: "q/", ExpirationDays: 1})
+	if rules, err = GetLifecycle(s); err != nil || !reflect.DeepEqual(rules, testLifecycle) {
+		t.Fatalf("get lifecycle: %+v, %v", rules, err)
+	}
+	// the rules of other prefixes are kept
+	if err = SetLifecycle(s, testLifecycle[:1]); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if len(l.rules) != 2 || l.rules[0].ID != "other" || l.rules[1].Prefix != "p/logs/" {
+		t.Fatalf("only the rules under the prefix should be replaced: %+v", l.rules)
+	}
+	if err = SetLifecycle(s, []LifecycleRule{{ID: "other", ExpirationDays: 1}}); err == nil {
+		t.Fatalf("the ID used by other prefix should fail")
+	}
+	_ = SetLifecycle(s, testLifecycle)
+	if err = SetLifecycle(WithReadOnly(s), nil); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("set lifecycle of read-only storage: %v", err)
+	}
+	if rules, err = GetLifecycle(WithReadOnly(s)); err != nil || len(rules) != 2 {
+		t.Fatalf("get lifecycle of read-only storage: %+v, %v", rules, err)
+	}
+}
+
+func TestS3Lifecycle(t *testing.T) {
+	var conf string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.Query().Has("lifecycle") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			conf = string(body)
+		case http.MethodDelete:
+			conf = ""
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if conf == "" {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchLifecycleConfiguration</Code></Error>`))
+				return
+			}
+			_, _ = w.Write([]byte(conf))
+		}
+	}))
+	defer srv.Close()
+	s, _ := newS3(srv.URL+"/test", "ak", "sk", "")
+
+	if rules, err := GetLifecycle(s); err != nil || rules != nil {
+		t.Fatalf("get missing lifecycle: %+v, %v", rules, err)
+	}
+	if err := SetLifecycle(s, testLifecycle); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if !strings.Contains(conf, "<StorageClass>STANDARD_IA</StorageClass>") || !strings.Contains(conf, "<Filter><Prefix>tmp/</Prefix></Filter>") {
+		t.Fatalf("lifecycle configuration: %s", conf)
+	}
+	rules, err := GetLifecycle(s)
+	expected, _ := normalizeLifecycle(testLifecycle, s3TransitionClasses)
+	if err != nil || !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("get lifecycle: %+v, %v", rules, err)
+	}
+	if err = SetLifecycle(s, []LifecycleRule{{ID: "a", Transitions: []LifecycleTransition{{30, "Cool"}}}}); err == nil {
+		t.Fatalf("storage class of Azure should fail")
+	}
+	if err = SetLifecycle(s, nil); err != nil || conf != "" {
+		t.Fatalf("remove lifecycle: %v, %s", err, conf)
+	}
+}
+
+type staticToken string
+
+func (t staticToken) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t)}, nil
+}
+
+func TestAzureLifecycle(t *testing.T) {
+	other := `{"enabled":true,"name":"other","type":"Lifecycle","definition":{"filters":{"blobTypes":["blockBlob"],"prefixMatch":["other/"]},"actions":{"baseBlob":{"delete":{"daysAfterModificationGreaterThan":1}}}}}`
+	policy := `{"properties":{"policy":{"rules":[` + other + `]}}}`
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "management.test" || r.Header.Get("Authorization") != "Bearer token" ||
+			r.URL.Path != "/subscriptions/sub/resourceGroups/group/providers/Microsoft.Storage/storageAccounts/account/managementPolicies/default" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if policy == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(policy))
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			policy = string(body)
+		case http.MethodDelete:
+			policy = ""
+		}
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_RESOURCE_MANAGER_ENDPOINT", "http://management.test")
+	t.Setenv("AZURE_SUBSCRIPTION_ID", "sub")
+	t.Setenv("AZURE_RESOURCE_GROUP", "group")
+	s, err := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	if _, err = GetLifecycle(s); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("lifecycle with shared key should not be supported: %v", err)
+	}
+	s.(*wasb).tokenCred = staticToken("token")
+
+	rules := []LifecycleRule{
+		{ID: "logs", Prefix: "logs/", Transitions: []LifecycleTransition{{90, "archive"}, {30, "cool"}}, ExpirationDays: 365},
+	}
+	if err = SetLifecycle(s, rules); err == nil {
+		t.Fatalf("transitions out of order should fail")
+	}
+	if err = SetLifecycle(s, []LifecycleRule{{ID: "tmp-1", ExpirationDays: 7}}); err == nil {
+		t.Fatalf("invalid rule name should fail")
+	}
+	rules[0].Transitions[0], rules[0].Transitions[1] = rules[0].Transitions[1], rules[0].Transitions[0]
+	if err = SetLifecycle(s, rules); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if !strings.Contains(policy, `"prefixMatch":["test/logs/"]`) || !strings.Contains(policy, `"tierToArchive":{"daysAfterModificationGreaterThan":90}`) {
+		t.Fatalf("policy: %s", policy)
+	}
+	var p wasbPolicy
+	_ = json.Unmarshal([]byte(policy), &p)
+	if len(p.Properties.Policy.Rules) != 2 || string(p.Properties.Policy.Rules[1]) != other {
+		t.Fatalf("the rules of other containers should be kept: %s", policy)
+	}
+	got, err := GetLifecycle(s)
+	if err != nil || len(got) != 1 || got[0].ID != "logs" || got[0].Prefix != "logs/" || got[0].ExpirationDays != 365 ||
+		!reflect.DeepEqual(got[0].Transitions, []LifecycleTransition{{30, "Cool"}, {90, "Archive"}}) {
+		t.Fatalf("get lifecycle: %+v, %v", got, err)
+	}
+	if err = SetLifecycle(s, nil); err != nil || !strings.Contains(policy, `"other"`) || strings.Contains(policy, `"logs"`) {
+		t.Fatalf("remove lifecycle of the container: %v, %s", err, policy)
+	}
+	policy = `{"properties":{"policy":{"rules":[` + strings.Replace(other, "other/", "test/", 1) + `]}}}`
+	if err = SetLifecycle(s, nil); err != nil || policy != "" {
+		t.Fatalf("remove the last rule: %v, %s", err, policy)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrInconsistentListing is returned by the listings checked by WithCheckedListing for a duplicated or out of
+// order key.
+var ErrInconsistentListing = errors.New("inconsistent listing")
+
+type checkedListing struct {
+	ObjectStorage
+}
+
+// WithCheckedListing checks that List and ListAll return the keys in strictly increasing order (after the
+// marker), which catches the bugs of continuing the listing from a wrong position, like duplicated or missing
+// pages. The listing fails on the first duplicated or out of order key, with the page (the marker of List) or the
+// position of ListAll where it occurred. It costs a comparison per key, so it can be left on during migrations.
+func WithCheckedListing(s ObjectStorage) ObjectStorage {
+	return &checkedListing{s}
+}
+
+func (c *checkedListing) WithContext(ctx context.Context) ObjectStorage {
+	return &checkedListing{WithContext(c.ObjectStorage, ctx)}
+}
+
+func (c *checkedListing) String() string {
+	return fmt.Sprintf("%s(checked)", c.ObjectStorage)
+}
+
+// checkOrder returns the problem of key if it doesn't follow last, or "" if it does.
+func checkOrder(last, key string) string {
+	switch {
+	case key == last:
+		return "duplicated"
+	case key < last:
+		return "out of order"
+	}
+	return ""
+}
+
+func (c *checkedListing) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := c.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+	if err != nil {
+		return objs, err
+	}
+	last := marker
+	for i, o := range objs {
+		if i > 0 || marker != "" {
+			if p := checkOrder(last, o.Key()); p != "" {
+				err = fmt.Errorf("%w: key %q is %s after %q, the #%d of the page after marker %q", ErrInconsistentListing, o.Key(), p, last, i, marker)
+				logger.Errorf("List %s: %s", c.ObjectStorage, err)
+				return nil, err
+			}
+		}
+		last = o.Key()
+	}
+	return objs, nil
+}
+
+// ListAll checks the objects listed by the object storage, the callers list the pages with List (which are checked
+// too) if it's not supported.
+func (c *checkedListing) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	in, err := c.ObjectStorage.ListAll(prefix, marker, followLink)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		last, n := marker, 0
+		for o := range in {
+			if o == nil { // failed
+				out <- nil
+				return
+			}
+			if n > 0 || marker != "" {
+				if p := checkOrder(last, o.Key()); p != "" {
+					logger.Errorf("ListAll %s: %s: key %q is %s after %q, the #%d of the listing after marker %q",
+						c.ObjectStorage, ErrInconsistentListing, o.Key(), p, last, n, marker)
+					out <- nil
+					for range in { // let the listing finish
+					}
+					return
+				}
+			}
+			out <- o
+			last = o.Key()
+			n++
+		}
+	}()
+	return out, nil
+}
+
+var _ ObjectStorage = &checkedListing{}
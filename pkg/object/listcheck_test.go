@@ -0,0 +1,129 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stalePages returns the page of the previous List again, like the object storages keeping the position of
+// listing in the client rather than following the marker.
+type stalePages struct {
+	ObjectStorage
+	pageSize int64
+	last     []Object
+}
+
+func (s *stalePages) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if s.last != nil {
+		return s.last, nil
+	}
+	objs, err := s.ObjectStorage.List(prefix, marker, delimiter, s.pageSize, followLink)
+	s.last = objs
+	return objs, err
+}
+
+func (s *stalePages) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	return nil, notSupported
+}
+
+// streamed lists all the objects of the pages in one stream, done is closed after all of them are sent.
+type streamed struct {
+	ObjectStorage
+	done chan struct{}
+}
+
+func (s *streamed) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	objs, err := s.List(prefix, marker, "", 10, followLink)
+	if err != nil {
+		return nil, err
+	}
+	more, _ := s.List(prefix, objs[len(objs)-1].Key(), "", 10, followLink)
+	out := make(chan Object)
+	go func() {
+		defer close(s.done)
+		defer close(out)
+		for _, o := range append(objs, more...) {
+			out <- o
+		}
+	}()
+	return out, nil
+}
+
+func collect(t *testing.T, s ObjectStorage, marker string) ([]Object, bool) {
+	t.Helper()
+	ch, err := ListAll(s, "", marker, true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var objs []Object
+	for o := range ch {
+		if o == nil {
+			return objs, false
+		}
+		objs = append(objs, o)
+	}
+	return objs, true
+}
+
+func TestCheckedListing(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	var expected []Object
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		_ = m.Put(key, bytes.NewReader(nil))
+		expected = append(expected, &obj{key: key})
+	}
+	s := WithCheckedListing(m)
+	if s.String() != "mem:///(checked)" {
+		t.Fatalf("name: %s", s)
+	}
+	if objs, ok := collect(t, s, ""); !ok || listKeys(objs) != listKeys(expected) {
+		t.Fatalf("listed keys: %s", listKeys(objs))
+	}
+	if objs, ok := collect(t, s, "k010"); !ok || listKeys(objs) != listKeys(expected[11:]) {
+		t.Fatalf("listed keys after k010: %s", listKeys(objs))
+	}
+
+	// the second page is the same as the first one
+	stale := WithCheckedListing(&stalePages{ObjectStorage: m, pageSize: 10})
+	if objs, ok := collect(t, stale, ""); ok || len(objs) != 10 {
+		t.Fatalf("listing with duplicated page should fail after the first page: %d objects", len(objs))
+	}
+	if _, err := stale.List("", "k009", "", 10, true); !errors.Is(err, ErrInconsistentListing) {
+		t.Fatalf("list the page after k009: %v", err)
+	}
+	// the keys are swapped in every page
+	if _, err := WithCheckedListing(&unsortedPages{m, 10}).List("", "", "", 10, true); !errors.Is(err, ErrInconsistentListing) {
+		t.Fatalf("list unsorted page: %v", err)
+	}
+
+	// the duplicated keys in the stream of ListAll
+	st := &streamed{&stalePages{ObjectStorage: m, pageSize: 10}, make(chan struct{})}
+	if objs, ok := collect(t, WithCheckedListing(st), ""); ok || len(objs) != 10 {
+		t.Fatalf("streamed listing with duplicated page should fail after the first page: %d objects", len(objs))
+	}
+	select {
+	case <-st.done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the streamed listing is blocked after the failure")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"sync"
+	"time"
+)
+
+// listMarkerTTL is how long the markers where the pages of List stopped are kept.
+const listMarkerTTL = time.Minute
+
+// maxListMarkers is the max number of markers kept by List.
+const maxListMarkers = 1000
+
+type listMarker struct {
+	token  string
+	expire time.Time
+}
+
+// listMarkers keeps the opaque markers (like the continuation tokens of Azure) where the pages of List stopped,
+// by the prefix, the delimiter and the last key of the page, for the object storages which can't start listing
+// from a key, so the next page is listed from the marker of the server.
+type listMarkers struct {
+	sync.Mutex
+	tokens map[string]listMarker
+}
+
+func newListMarkers() *listMarkers {
+	return &listMarkers{tokens: make(map[string]listMarker)}
+}
+
+func (m *listMarkers) get(prefix, delimiter, marker string) string {
+	if m == nil || marker == "" {
+		return ""
+	}
+	m.Lock()
+	defer m.Unlock()
+	if t, ok := m.tokens[prefix+"\x00"+delimiter+"\x00"+marker]; ok && time.Now().Before(t.expire) {
+		return t.token
+	}
+	return ""
+}
+
+func (m *listMarkers) put(prefix, delimiter, last, token string) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	if len(m.tokens) >= maxListMarkers {
+		for k, t := range m.tokens {
+			if now.After(t.expire) {
+				delete(m.tokens, k)
+			}
+		}
+		if len(m.tokens) >= maxListMarkers {
+			return
+		}
+	}
+	m.tokens[prefix+"\x00"+delimiter+"\x00"+last] = listMarker{token, now.Add(listMarkerTTL)}
+}
@@ -18,6 +18,7 @@ package object
 
 import (
 	"bytes"
+	"crypto/md5"
 	"errors"
 	"fmt"
 	"io"
@@ -34,6 +35,13 @@ type mobj struct {
 	mode  os.FileMode
 	owner string
 	group string
+	meta  map[string]string
+}
+
+type mupload struct {
+	key     string
+	parts   map[int][]byte
+	created time.Time
 }
 
 type memStore struct {
@@ -41,12 +49,26 @@ type memStore struct {
 	DefaultObjectStorage
 	name    string
 	objects map[string]*mobj
+	uploads map[string]*mupload
+	nextID  int
 }
 
+const memMinPartSize = 1 << 20
+
 func (m *memStore) String() string {
 	return fmt.Sprintf("mem://%s/", m.name)
 }
 
+func (m *memStore) Limits() Limits {
+	return Limits{
+		IsSupportMultipartUpload: true,
+		IsSupportUploadPartCopy:  true,
+		MinPartSize:              memMinPartSize,
+		MaxPartSize:              5 << 30,
+		MaxPartCount:             10000,
+	}
+}
+
 func (m *memStore) Head(key string) (Object, error) {
 	m.Lock()
 	defer m.Unlock()
@@ -56,7 +78,7 @@ func (m *memStore) Head(key string) (Object, error) {
 	}
 	o, ok := m.objects[key]
 	if !ok {
-		return nil, os.ErrNotExist
+		return nil, ErrNotFound
 	}
 	f := &file{
 		obj{
@@ -83,7 +105,7 @@ func (m *memStore) Get(key string, off, limit int64, getters ...AttrGetter) (io.
 	}
 	d, ok := m.objects[key]
 	if !ok {
-		return nil, errors.New("not exists")
+		return nil, ErrNotFound
 	}
 	if off > int64(len(d.data)) {
 		off = int64(len(d.data))
@@ -110,16 +132,65 @@ func (m *memStore) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	if err != nil {
 		return err
 	}
-	m.objects[key] = &mobj{data: data, mtime: time.Now()}
+	m.objects[key] = &mobj{data: data, mtime: time.Now(), meta: applyGetters(getters...).metadata}
+	return nil
+}
+
+func (m *memStore) PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	if key == "" {
+		return errors.New("object key cannot be empty")
+	}
+	if _, ok := m.objects[key]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, key)
+	}
+	m.objects[key] = &mobj{data: data, mtime: time.Now(), meta: applyGetters(getters...).metadata}
 	return nil
 }
 
+// Copy copies the data and the metadata.
 func (m *memStore) Copy(dst, src string) error {
+	m.Lock()
+	o, ok := m.objects[src]
+	m.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
 	d, err := m.Get(src, 0, -1)
 	if err != nil {
 		return err
 	}
-	return m.Put(dst, d)
+	return m.Put(dst, d, withMetadata(o.meta))
+}
+
+func (m *memStore) SetMeta(key string, meta map[string]string) error {
+	m.Lock()
+	defer m.Unlock()
+	o, ok := m.objects[key]
+	if !ok {
+		return ErrNotFound
+	}
+	o.meta = meta
+	return nil
+}
+
+func (m *memStore) GetMeta(key string) (map[string]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	meta := make(map[string]string, len(o.meta))
+	for k, v := range o.meta {
+		meta[k] = v
+	}
+	return meta, nil
 }
 
 func (m *memStore) Delete(key string, getters ...AttrGetter) error {
@@ -183,15 +254,114 @@ func (m *memStore) List(prefix, marker, delimiter string, limit int64, followLin
 	sort.Slice(objs, func(i, j int) bool {
 		return objs[i].Key() < objs[j].Key()
 	})
-	if int64(len(objs)) > limit {
+	if limit > 0 && int64(len(objs)) > limit {
 		objs = objs[:limit]
 	}
 	return objs, nil
 }
 
+func (m *memStore) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	m.Lock()
+	defer m.Unlock()
+	if key == "" {
+		return nil, errors.New("object key cannot be empty")
+	}
+	m.nextID++
+	id := fmt.Sprintf("%d", m.nextID)
+	m.uploads[id] = &mupload{key: key, parts: make(map[int][]byte), created: time.Now()}
+	return &MultipartUpload{MinPartSize: memMinPartSize, MaxCount: 10000, UploadID: id}, nil
+}
+
+func (m *memStore) getUpload(key, uploadID string) (*mupload, error) {
+	u, ok := m.uploads[uploadID]
+	if !ok || u.key != key {
+		return nil, fmt.Errorf("no such upload %s for %s", uploadID, key)
+	}
+	return u, nil
+}
+
+func (m *memStore) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	m.Lock()
+	defer m.Unlock()
+	u, err := m.getUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if num < 1 || num > 10000 {
+		return nil, fmt.Errorf("invalid part number %d", num)
+	}
+	u.parts[num] = append([]byte{}, body...)
+	return &Part{Num: num, Size: len(body), ETag: fmt.Sprintf("%x", md5.Sum(body))}, nil
+}
+
+func (m *memStore) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	in, err := m.Get(srcKey, off, size)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	return m.UploadPart(key, uploadID, num, data)
+}
+
+func (m *memStore) AbortUpload(key string, uploadID string) {
+	m.Lock()
+	defer m.Unlock()
+	if _, err := m.getUpload(key, uploadID); err == nil {
+		delete(m.uploads, uploadID)
+	}
+}
+
+func (m *memStore) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	m.Lock()
+	defer m.Unlock()
+	u, err := m.getUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for i, p := range parts {
+		if i > 0 && p.Num <= parts[i-1].Num {
+			return fmt.Errorf("parts of %s are not in ascending order", key)
+		}
+		data, ok := u.parts[p.Num]
+		if !ok || p.ETag != "" && p.ETag != fmt.Sprintf("%x", md5.Sum(data)) {
+			return fmt.Errorf("part %d of %s is not found", p.Num, key)
+		}
+		if i < len(parts)-1 && len(data) < memMinPartSize {
+			return fmt.Errorf("part %d of %s is too small: %d < %d", p.Num, key, len(data), memMinPartSize)
+		}
+		buf.Write(data)
+	}
+	m.objects[key] = &mobj{data: buf.Bytes(), mtime: time.Now()}
+	delete(m.uploads, uploadID)
+	return nil
+}
+
+func (m *memStore) ListUploads(marker string) ([]*PendingPart, string, error) {
+	m.Lock()
+	defer m.Unlock()
+	var parts []*PendingPart
+	for id, u := range m.uploads {
+		if u.key > marker {
+			parts = append(parts, &PendingPart{Key: u.key, UploadID: id, Created: u.created})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].Key == parts[j].Key {
+			return parts[i].UploadID < parts[j].UploadID
+		}
+		return parts[i].Key < parts[j].Key
+	})
+	return parts, "", nil
+}
+
 func newMem(endpoint, accesskey, secretkey, token string) (ObjectStorage, error) {
 	store := &memStore{name: endpoint}
 	store.objects = make(map[string]*mobj)
+	store.uploads = make(map[string]*mupload)
 	return store, nil
 }
 
@@ -0,0 +1,230 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type storageMetrics struct {
+	requests  *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	dataBytes *prometheus.CounterVec
+}
+
+type withMetrics struct {
+	ObjectStorage
+	m *storageMetrics
+}
+
+// register registers the collector, or returns the one registered before.
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		logger.Warnf("register metrics of object storage: %s", err)
+	}
+	return c
+}
+
+// WithMetrics records the number of requests, errors, latency and data bytes of each method of the object storage
+// into the registry. It returns the object storage itself if the registry is nil.
+func WithMetrics(s ObjectStorage, reg prometheus.Registerer) ObjectStorage {
+	if reg == nil {
+		return s
+	}
+	m := &storageMetrics{
+		requests: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "object_storage_requests",
+			Help: "Number of requests to object storage.",
+		}, []string{"method"})),
+		errors: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "object_storage_request_errors",
+			Help: "Number of failed requests to object storage.",
+		}, []string{"method"})),
+		durations: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "object_storage_request_durations_histogram_seconds",
+			Help:    "Latency distributions of requests to object storage.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.5, 25),
+		}, []string{"method"})),
+		dataBytes: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "object_storage_data_bytes",
+			Help: "Bytes transferred from/to object storage.",
+		}, []string{"method"})),
+	}
+	return &withMetrics{s, m}
+}
+
+func (w *withMetrics) WithContext(ctx context.Context) ObjectStorage {
+	return &withMetrics{WithContext(w.ObjectStorage, ctx), w.m}
+}
+
+func (w *withMetrics) observe(method string, start time.Time, err error) {
+	w.m.requests.WithLabelValues(method).Inc()
+	w.m.durations.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	// the missing objects are expected by the lookups, but fail the others like copy
+	lookup := method == "get" || method == "head" || method == "delete"
+	if err != nil && !(lookup && errors.Is(err, ErrNotFound)) {
+		w.m.errors.WithLabelValues(method).Inc()
+	}
+}
+
+type countedReader struct {
+	io.Reader
+	bytes prometheus.Counter
+}
+
+func (r *countedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.bytes.Add(float64(n))
+	return n, err
+}
+
+type countedReadCloser struct {
+	countedReader
+	io.Closer
+}
+
+type countedReadSeeker struct {
+	countedReader
+	io.Seeker
+}
+
+func (w *withMetrics) Create() error {
+	start := time.Now()
+	err := w.ObjectStorage.Create()
+	w.observe("create", start, err)
+	return err
+}
+
+func (w *withMetrics) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	start := time.Now()
+	in, err := w.ObjectStorage.Get(key, off, limit, getters...)
+	w.observe("get", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &countedReadCloser{countedReader{in, w.m.dataBytes.WithLabelValues("get")}, in}, nil
+}
+
+func (w *withMetrics) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	counter := w.m.dataBytes.WithLabelValues("put")
+	if rs, ok := in.(io.ReadSeeker); ok {
+		in = &countedReadSeeker{countedReader{rs, counter}, rs}
+	} else {
+		in = &countedReader{in, counter}
+	}
+	start := time.Now()
+	err := w.ObjectStorage.Put(key, in, getters...)
+	w.observe("put", start, err)
+	return err
+}
+
+func (w *withMetrics) Copy(dst, src string) error {
+	start := time.Now()
+	err := w.ObjectStorage.Copy(dst, src)
+	w.observe("copy", start, err)
+	return err
+}
+
+func (w *withMetrics) Delete(key string, getters ...AttrGetter) error {
+	start := time.Now()
+	err := w.ObjectStorage.Delete(key, getters...)
+	w.observe("delete", start, err)
+	return err
+}
+
+func (w *withMetrics) Head(key string) (Object, error) {
+	start := time.Now()
+	o, err := w.ObjectStorage.Head(key)
+	w.observe("head", start, err)
+	return o, err
+}
+
+func (w *withMetrics) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	start := time.Now()
+	objs, err := w.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+	w.observe("list", start, err)
+	return objs, err
+}
+
+func (w *withMetrics) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	start := time.Now()
+	ch, err := w.ObjectStorage.ListAll(prefix, marker, followLink)
+	if !errors.Is(err, notSupported) { // fallback to List
+		w.observe("list_all", start, err)
+	}
+	return ch, err
+}
+
+func (w *withMetrics) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	start := time.Now()
+	up, err := w.ObjectStorage.CreateMultipartUpload(key)
+	if !errors.Is(err, notSupported) {
+		w.observe("create_multipart_upload", start, err)
+	}
+	return up, err
+}
+
+func (w *withMetrics) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	start := time.Now()
+	part, err := w.ObjectStorage.UploadPart(key, uploadID, num, body)
+	w.observe("upload_part", start, err)
+	if err == nil {
+		w.m.dataBytes.WithLabelValues("upload_part").Add(float64(len(body)))
+	}
+	return part, err
+}
+
+func (w *withMetrics) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	start := time.Now()
+	part, err := w.ObjectStorage.UploadPartCopy(key, uploadID, num, srcKey, off, size)
+	w.observe("upload_part_copy", start, err)
+	return part, err
+}
+
+func (w *withMetrics) AbortUpload(key string, uploadID string) {
+	start := time.Now()
+	w.ObjectStorage.AbortUpload(key, uploadID)
+	w.observe("abort_upload", start, nil)
+}
+
+func (w *withMetrics) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	start := time.Now()
+	err := w.ObjectStorage.CompleteUpload(key, uploadID, parts)
+	w.observe("complete_upload", start, err)
+	return err
+}
+
+func (w *withMetrics) ListUploads(marker string) ([]*PendingPart, string, error) {
+	start := time.Now()
+	parts, next, err := w.ObjectStorage.ListUploads(marker)
+	w.observe("list_uploads", start, err)
+	return parts, next, err
+}
+
+var _ ObjectStorage = &withMetrics{}
@@ -0,0 +1,65 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	if WithMetrics(m, nil) != m {
+		t.Fatalf("should not wrap the object storage without registry")
+	}
+	reg := prometheus.NewRegistry()
+	s := WithMetrics(m, reg)
+	testStorage(t, s)
+
+	// register again into the same registry
+	s = WithMetrics(m, reg)
+	mt := s.(*withMetrics).m
+	puts := testutil.ToFloat64(mt.requests.WithLabelValues("put"))
+	if err := s.Put("metrics", bytes.NewReader(make([]byte, 100))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if v := testutil.ToFloat64(mt.requests.WithLabelValues("put")); v != puts+1 {
+		t.Fatalf("expect %f puts, got %f", puts+1, v)
+	}
+	getBytes := testutil.ToFloat64(mt.dataBytes.WithLabelValues("get"))
+	in, err := s.Get("metrics", 0, -1)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	_, _ = io.Copy(io.Discard, in)
+	_ = in.Close()
+	if v := testutil.ToFloat64(mt.dataBytes.WithLabelValues("get")); v != getBytes+100 {
+		t.Fatalf("expect %f bytes read, got %f", getBytes+100, v)
+	}
+	errs := testutil.ToFloat64(mt.errors.WithLabelValues("copy"))
+	if err = s.Copy("metrics2", "missing"); err == nil {
+		t.Fatalf("copy of missing object should fail")
+	}
+	if v := testutil.ToFloat64(mt.errors.WithLabelValues("copy")); v != errs+1 {
+		t.Fatalf("expect %f errors of copy, got %f", errs+1, v)
+	}
+	_ = s.Delete("metrics")
+}
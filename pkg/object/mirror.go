@@ -0,0 +1,254 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MirrorStorage is the object storage returned by WithMirror.
+type MirrorStorage interface {
+	ObjectStorage
+	// Resync writes the objects that failed on the secondary again, from the primary.
+	// It returns the number of objects still pending.
+	Resync() (int, error)
+	// PreferSecondary switches the reads to the secondary (or back to the primary), it fails if some objects
+	// are not mirrored yet. The objects written before mirroring should be copied already (by sync).
+	PreferSecondary(prefer bool) error
+}
+
+// mirrorKeyLocks is the number of locks to serialize the writes of the same key.
+const mirrorKeyLocks = 1024
+
+type mirrorState struct {
+	sync.Mutex
+	preferSecondary bool
+	pending         map[string]struct{} // keys failed on the secondary
+	// the writes of a key (to both) and the resync of it are serialized, so the secondary is not overwritten
+	// by a stale version after a newer one is written (or failed)
+	keyLocks [mirrorKeyLocks]sync.Mutex
+}
+
+type mirrored struct {
+	ObjectStorage // primary
+	secondary     ObjectStorage
+	*mirrorState
+}
+
+// WithMirror writes the objects to both primary and secondary, and reads them from the primary, to migrate
+// the data without downtime. A write fails if it fails on the primary, the objects failed on the secondary
+// are logged and kept to be written again by Resync. Multipart uploads are done on the primary, and mirrored
+// once completed.
+func WithMirror(primary, secondary ObjectStorage) ObjectStorage {
+	return &mirrored{primary, secondary, &mirrorState{pending: make(map[string]struct{})}}
+}
+
+func (m *mirrored) WithContext(ctx context.Context) ObjectStorage {
+	return &mirrored{WithContext(m.ObjectStorage, ctx), WithContext(m.secondary, ctx), m.mirrorState}
+}
+
+func (m *mirrored) String() string {
+	return fmt.Sprintf("%s(mirror %s)", m.ObjectStorage, m.secondary)
+}
+
+// reader returns the object storage to read from.
+func (m *mirrored) reader() ObjectStorage {
+	m.Lock()
+	defer m.Unlock()
+	if m.preferSecondary {
+		return m.secondary
+	}
+	return m.ObjectStorage
+}
+
+// lockKey locks the key (shared with the keys of the same hash), and returns the function to unlock it.
+func (m *mirrored) lockKey(key string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	l := &m.keyLocks[h.Sum32()%mirrorKeyLocks]
+	l.Lock()
+	return l.Unlock
+}
+
+func (m *mirrored) secondaryDone(key string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	if err == nil {
+		delete(m.pending, key)
+		return
+	}
+	logger.Warnf("Mirror %s to %s: %s", key, m.secondary, err)
+	m.pending[key] = struct{}{}
+}
+
+// mirror copies the object from the primary to the secondary, or deletes it if it's not found. The data is
+// streamed rather than held in memory.
+func (m *mirrored) mirror(key string) error {
+	in, err := m.ObjectStorage.Get(key, 0, -1)
+	if errors.Is(err, ErrNotFound) {
+		return m.secondary.Delete(key)
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+	return m.secondary.Put(key, in)
+}
+
+// spool copies in into a temporary file, so it can be read again for the secondary without holding all of it
+// in memory.
+func spool(in io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "juicefs-mirror-*")
+	if err != nil {
+		return nil, err
+	}
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+	if _, err = io.CopyBuffer(onlyWriter{f}, in, *buf); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+func (m *mirrored) Create() error {
+	if err := m.ObjectStorage.Create(); err != nil {
+		return err
+	}
+	return m.secondary.Create()
+}
+
+func (m *mirrored) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	return m.reader().Get(key, off, limit, getters...)
+}
+
+func (m *mirrored) Head(key string) (Object, error) {
+	return m.reader().Head(key)
+}
+
+func (m *mirrored) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	return m.reader().List(prefix, marker, delimiter, limit, followLink)
+}
+
+func (m *mirrored) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	return m.reader().ListAll(prefix, marker, followLink)
+}
+
+// Put reads the data again for the secondary if it's seekable, or spools it into a temporary file otherwise.
+func (m *mirrored) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	rs, ok := in.(io.ReadSeeker)
+	if !ok {
+		f, err := spool(in)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}()
+		rs = f
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	defer m.lockKey(key)()
+	if err = m.ObjectStorage.Put(key, rs, getters...); err != nil {
+		return err
+	}
+	if _, err = rs.Seek(start, io.SeekStart); err == nil {
+		err = m.secondary.Put(key, rs)
+	}
+	m.secondaryDone(key, err)
+	return nil
+}
+
+func (m *mirrored) Copy(dst, src string) error {
+	defer m.lockKey(dst)()
+	if err := m.ObjectStorage.Copy(dst, src); err != nil {
+		return err
+	}
+	m.secondaryDone(dst, m.secondary.Copy(dst, src))
+	return nil
+}
+
+func (m *mirrored) Delete(key string, getters ...AttrGetter) error {
+	defer m.lockKey(key)()
+	if err := m.ObjectStorage.Delete(key, getters...); err != nil {
+		return err
+	}
+	m.secondaryDone(key, m.secondary.Delete(key))
+	return nil
+}
+
+func (m *mirrored) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	defer m.lockKey(key)()
+	if err := m.ObjectStorage.CompleteUpload(key, uploadID, parts); err != nil {
+		return err
+	}
+	m.secondaryDone(key, m.mirror(key))
+	return nil
+}
+
+// resync mirrors the key while holding the lock of it, so the version read from the primary is the latest one.
+func (m *mirrored) resync(key string) error {
+	defer m.lockKey(key)()
+	err := m.mirror(key)
+	m.secondaryDone(key, err)
+	return err
+}
+
+func (m *mirrored) Resync() (int, error) {
+	m.Lock()
+	keys := make([]string, 0, len(m.pending))
+	for k := range m.pending {
+		keys = append(keys, k)
+	}
+	m.Unlock()
+	sort.Strings(keys)
+	var lastErr error
+	for _, key := range keys {
+		if err := m.resync(key); err != nil {
+			lastErr = err
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	return len(m.pending), lastErr
+}
+
+func (m *mirrored) PreferSecondary(prefer bool) error {
+	m.Lock()
+	defer m.Unlock()
+	if prefer && len(m.pending) > 0 {
+		return fmt.Errorf("%d objects are not mirrored to %s yet", len(m.pending), m.secondary)
+	}
+	m.preferSecondary = prefer
+	return nil
+}
+
+var _ MirrorStorage = &mirrored{}
@@ -0,0 +1,158 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+var errBroken = errors.New("broken")
+
+// brokenWrites fails all the writes if broken is set.
+type brokenWrites struct {
+	ObjectStorage
+	broken bool
+}
+
+func (b *brokenWrites) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if b.broken {
+		return errBroken
+	}
+	return b.ObjectStorage.Put(key, in, getters...)
+}
+
+func (b *brokenWrites) Delete(key string, getters ...AttrGetter) error {
+	if b.broken {
+		return errBroken
+	}
+	return b.ObjectStorage.Delete(key, getters...)
+}
+
+func TestMirror(t *testing.T) {
+	p, _ := newMem("", "", "", "")
+	m2, _ := newMem("", "", "", "")
+	sec := &brokenWrites{ObjectStorage: m2}
+	s := WithMirror(p, sec).(MirrorStorage)
+
+	_ = s.Put("a", bytes.NewReader([]byte("a")))
+	_ = s.Put("b", bytes.NewReader([]byte("b")))
+	_ = s.Copy("c", "a")
+	_ = s.Delete("b")
+	for _, store := range []ObjectStorage{p, m2} {
+		if d, _ := get(store, "c", 0, -1); d != "a" {
+			t.Fatalf("c of %s: %q", store, d)
+		}
+		if _, err := store.Head("b"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("b of %s should be deleted: %v", store, err)
+		}
+	}
+
+	// the primary fails
+	bp := &brokenWrites{ObjectStorage: p, broken: true}
+	if err := WithMirror(bp, m2).Put("x", bytes.NewReader(nil)); err != errBroken {
+		t.Fatalf("put should fail with the primary: %v", err)
+	}
+	if _, err := m2.Head("x"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("x should not be written to secondary: %v", err)
+	}
+
+	// the secondary fails
+	sec.broken = true
+	if err := s.Put("d", bytes.NewReader([]byte("d"))); err != nil {
+		t.Fatalf("put should succeed with the primary: %s", err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Fatalf("delete should succeed with the primary: %s", err)
+	}
+	up, _ := s.CreateMultipartUpload("e")
+	part, _ := s.UploadPart("e", up.UploadID, 1, []byte("e"))
+	if err := s.CompleteUpload("e", up.UploadID, []*Part{part}); err != nil {
+		t.Fatalf("complete upload: %s", err)
+	}
+	if n, err := s.Resync(); n != 3 || err == nil {
+		t.Fatalf("resync with broken secondary: %d %v", n, err)
+	}
+	if err := s.PreferSecondary(true); err == nil {
+		t.Fatalf("secondary should not be preferred with pending objects")
+	}
+
+	sec.broken = false
+	if n, err := s.Resync(); n != 0 || err != nil {
+		t.Fatalf("resync: %d %v", n, err)
+	}
+	if err := s.PreferSecondary(true); err != nil {
+		t.Fatalf("prefer secondary: %s", err)
+	}
+	_ = p.Put("d", bytes.NewReader([]byte("primary")))
+	for k, v := range map[string]string{"d": "d", "e": "e"} {
+		if d, _ := get(s, k, 0, -1); d != v {
+			t.Fatalf("%s should be read from secondary: %q", k, d)
+		}
+	}
+	if _, err := s.Head("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a should be deleted from secondary: %v", err)
+	}
+	if objs, _ := s.List("", "", "", 10, true); listKeys(objs) != "c,d,e" {
+		t.Fatalf("list of secondary: %s", listKeys(objs))
+	}
+}
+
+func TestMirrorStream(t *testing.T) {
+	p, _ := newMem("", "", "", "")
+	m2, _ := newMem("", "", "", "")
+	sec := &brokenWrites{ObjectStorage: m2}
+	s := WithMirror(p, sec).(MirrorStorage)
+
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	// not seekable, so it's spooled for the secondary
+	if err := s.Put("large", io.MultiReader(bytes.NewReader(data[:5]), bytes.NewReader(data[5:]))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	for _, store := range []ObjectStorage{p, m2} {
+		if d, _ := get(store, "large", 0, -1); d != string(data) {
+			t.Fatalf("large of %s is corrupted: %d bytes", store, len(d))
+		}
+	}
+
+	// the versions written during resync are not lost
+	sec.broken = true
+	_ = s.Put("k", bytes.NewReader([]byte("v0")))
+	sec.broken = false
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 20; i++ {
+			_ = s.Put("k", bytes.NewReader([]byte(fmt.Sprintf("v%d", i))))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_, _ = s.Resync()
+		}
+	}()
+	wg.Wait()
+	if d, _ := get(m2, "k", 0, -1); d != "v20" {
+		t.Fatalf("the secondary should have the latest version: %q", d)
+	}
+}
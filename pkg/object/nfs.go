@@ -470,5 +470,5 @@ func newNFSStore(addr, username, pass, token string) (ObjectStorage, error) {
 }
 
 func init() {
-	Register("nfs", newNFSStore)
+	RegisterRawKeys("nfs", newNFSStore)
 }
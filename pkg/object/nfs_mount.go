@@ -0,0 +1,303 @@
+//go:build !nonfs && !windows
+// +build !nonfs,!windows
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// nfsLockFile is the file under the root locked by PutIfNotExists, a byte of it for each directory, so no file is
+// left in the directories of the objects. It's hidden from the listing.
+const nfsLockFile = ".juicefs.lock"
+
+// nfsStaleRetries is the number of retries of an operation failed with ESTALE.
+const nfsStaleRetries = 3
+
+// nfsMount is a directory of a mounted NFS export. Unlike file, the data is flushed to the NFS server before Put
+// returns, the operations failed with a stale file handle are retried, and PutIfNotExists is serialized by an
+// advisory lock, which is shared by all the clients of the server.
+type nfsMount struct {
+	*filestore
+	locks *nfsLocks
+}
+
+// nfsLocks are the bytes of the lock file locked by this process. POSIX locks are owned by the process, so the
+// goroutines locking the same byte are serialized here before taking the POSIX lock, and the lock file is kept
+// open, since closing any fd of it releases all the locks of the process.
+type nfsLocks struct {
+	sync.Mutex
+	f    *os.File
+	held map[int64]chan struct{} // closed when the byte is unlocked
+}
+
+func (n *nfsMount) String() string {
+	return "nfsmount://" + n.root
+}
+
+// retry retries fn if the file handles cached by the NFS client are stale, e.g. the directory is removed and
+// created again by another client, which are looked up again by path in the next try.
+func (n *nfsMount) retry(fn func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		if err = fn(); !errors.Is(err, syscall.ESTALE) || i >= nfsStaleRetries {
+			return err
+		}
+		logger.Debugf("Retry %s after stale file handle: %s", n, err)
+		time.Sleep(time.Millisecond * 100 * time.Duration(i+1))
+	}
+}
+
+func (n *nfsMount) Head(key string) (o Object, err error) {
+	err = n.retry(func() error {
+		o, err = n.filestore.Head(key)
+		return err
+	})
+	return
+}
+
+func (n *nfsMount) Get(key string, off, limit int64, getters ...AttrGetter) (r io.ReadCloser, err error) {
+	err = n.retry(func() error {
+		r, err = n.filestore.Get(key, off, limit, getters...)
+		return err
+	})
+	return
+}
+
+func (n *nfsMount) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return n.put(key, in, false, TryCFR)
+}
+
+// PutIfNotExists checks the existence and renames the data into place while holding the lock of the directory,
+// so it's atomic among the callers of PutIfNotExists, but not against Put.
+func (n *nfsMount) PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error {
+	return n.put(key, in, true, TryCFR)
+}
+
+// put writes the data into a temporary file and fsync it (COMMIT to the NFS server), then renames it to the
+// object, which is synchronous in NFS. The data is copied by copy_file_range if cfr is true and in is a file.
+func (n *nfsMount) put(key string, in io.Reader, exclusive, cfr bool) (err error) {
+	p := n.path(key)
+	if strings.HasSuffix(key, dirSuffix) || key == "" && strings.HasSuffix(n.root, dirSuffix) {
+		if exclusive {
+			return notSupported
+		}
+		return n.retry(func() error { return os.MkdirAll(p, os.FileMode(0777)) })
+	}
+	dir := filepath.Dir(p)
+	tmp := tmpPath(p)
+	var f *os.File
+	err = n.retry(func() (err error) {
+		f, err = os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+		if err != nil && os.IsNotExist(err) {
+			if err = os.MkdirAll(dir, os.FileMode(0777)); err == nil {
+				f, err = os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0666)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	if cfr {
+		_, err = io.Copy(f, in)
+	} else {
+		buf := bufPool.Get().(*[]byte)
+		defer bufPool.Put(buf)
+		_, err = io.CopyBuffer(onlyWriter{f}, in, *buf)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	if exclusive {
+		var unlock func()
+		if unlock, err = n.lock(strings.TrimPrefix(dir, n.root)); err != nil {
+			return err
+		}
+		defer unlock()
+		err = n.retry(func() error {
+			_, err := os.Lstat(p)
+			return err
+		})
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrExists, key)
+			return err
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err = n.retry(func() error { return os.Rename(tmp, p) }); err != nil {
+		return err
+	}
+	return n.retry(func() error { return syncDir(dir) })
+}
+
+// lock takes the POSIX lock of the byte for dir (by the hash of it) in the lock file, which is forwarded to the NFS
+// server (by NLM in NFSv3 or by NFSv4 itself), and returns the function to release it. The directories sharing a
+// byte are serialized too.
+func (n *nfsMount) lock(dir string) (func(), error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(dir))
+	off := int64(h.Sum32())
+	l := n.locks
+	l.Lock()
+	for {
+		ch, ok := l.held[off]
+		if !ok {
+			break
+		}
+		l.Unlock()
+		<-ch
+		l.Lock()
+	}
+	done := make(chan struct{})
+	l.held[off] = done
+	release := func() {
+		l.Lock()
+		delete(l.held, off)
+		close(done)
+		l.Unlock()
+	}
+	var err error
+	if l.f == nil {
+		err = n.retry(func() (err error) {
+			l.f, err = os.OpenFile(filepath.Join(n.root, nfsLockFile), os.O_CREATE|os.O_RDWR, 0666)
+			return err
+		})
+	}
+	f := l.f
+	l.Unlock()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	lk := unix.Flock_t{Type: unix.F_WRLCK, Whence: io.SeekStart, Start: off, Len: 1}
+	for {
+		if err = unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &lk); err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("lock %s: %w", dir, err)
+	}
+	return func() {
+		lk.Type = unix.F_UNLCK
+		_ = unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lk)
+		release()
+	}, nil
+}
+
+// syncDir flushes the entries of dir, it's ignored by the file systems which can't fsync directories.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if e := d.Close(); err == nil {
+		err = e
+	}
+	if errors.Is(err, syscall.EINVAL) {
+		err = nil
+	}
+	return err
+}
+
+// Copy copies the data by copy_file_range on Linux, which is done by the NFS server with NFS 4.2 (server side
+// copy) rather than through the client.
+func (n *nfsMount) Copy(dst, src string) error {
+	r, err := n.Get(src, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return n.put(dst, r, false, true)
+}
+
+func (n *nfsMount) Move(dst, src string) error {
+	return n.retry(func() error { return n.filestore.Move(dst, src) })
+}
+
+func (n *nfsMount) Delete(key string, getters ...AttrGetter) error {
+	return n.retry(func() error { return n.filestore.Delete(key, getters...) })
+}
+
+// List lists the directory sorted by name like file, without the lock file.
+func (n *nfsMount) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	var objs []Object
+	fetch := limit
+	if fetch > 0 {
+		fetch++ // one more for the lock file, so a full page of the root is not mistaken for the last one
+	}
+	err := n.retry(func() (err error) {
+		objs, err = n.filestore.List(prefix, marker, delimiter, fetch, followLink)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	listed := objs[:0]
+	for _, o := range objs {
+		if o.Key() != nfsLockFile {
+			listed = append(listed, o)
+		}
+	}
+	if limit > 0 && int64(len(listed)) > limit {
+		listed = listed[:limit]
+	}
+	return listed, nil
+}
+
+func newNFSMount(root, accesskey, secretkey, token string) (ObjectStorage, error) {
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("the mount point %q should be an absolute path", root)
+	}
+	if !strings.HasSuffix(root, dirSuffix) {
+		root += dirSuffix
+	}
+	return &nfsMount{&filestore{root: root}, &nfsLocks{held: make(map[int64]chan struct{})}}, nil
+}
+
+func init() {
+	RegisterRawKeys("nfsmount", newNFSMount)
+}
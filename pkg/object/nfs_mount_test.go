@@ -0,0 +1,140 @@
+//go:build !nonfs && !windows
+// +build !nonfs,!windows
+
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNFSMount(t *testing.T) {
+	if _, err := newNFSMount("relative/", "", "", ""); err == nil {
+		t.Fatalf("relative mount point should fail")
+	}
+	s, err := newNFSMount(t.TempDir(), "", "", "")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	testStorage(t, s)
+}
+
+func TestNFSMountPutIfNotExists(t *testing.T) {
+	s, _ := newNFSMount(t.TempDir(), "", "", "")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var created []int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := PutIfNotExists(s, "d/lock", bytes.NewReader([]byte(fmt.Sprint(i))))
+			if err == nil {
+				mu.Lock()
+				created = append(created, i)
+				mu.Unlock()
+			} else if !errors.Is(err, ErrExists) {
+				t.Errorf("put if not exists: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(created) != 1 {
+		t.Fatalf("exactly one should be created: %v", created)
+	}
+	if d, err := get(s, "d/lock", 0, -1); err != nil || d != fmt.Sprint(created[0]) {
+		t.Fatalf("the object should be put by the winner %d: %q %v", created[0], d, err)
+	}
+	if objs, err := s.List("", "", "/", 10, true); err != nil || listKeys(objs) != ",d/" {
+		t.Fatalf("the lock file should be hidden: %s %v", listKeys(objs), err)
+	}
+	if objs, err := s.List("", "", "/", 2, true); err != nil || listKeys(objs) != ",d/" {
+		t.Fatalf("the page with the lock file should be full: %s %v", listKeys(objs), err)
+	}
+	// the POSIX locks are owned by the process, the goroutines of it are serialized as well
+	n := s.(*nfsMount)
+	unlock, err := n.lock("d/")
+	if err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+	locked := make(chan func())
+	go func() {
+		u, _ := n.lock("d/")
+		locked <- u
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("the lock of d/ should be held by another goroutine")
+	case <-time.After(time.Millisecond * 100):
+	}
+	unlock()
+	select {
+	case u := <-locked:
+		u()
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the lock of d/ should be taken after released")
+	}
+
+	root := n.root
+	if entries, _ := os.ReadDir(root + "d"); len(entries) != 1 {
+		t.Fatalf("the temporary files should be removed: %v", entries)
+	}
+	// nothing is left in the directory to keep it from being removed
+	_ = s.Delete("d/lock")
+	if err := os.Remove(root + "d"); err != nil {
+		t.Fatalf("remove the empty directory: %s", err)
+	}
+}
+
+func TestNFSMountStaleRetry(t *testing.T) {
+	s, _ := newNFSMount(t.TempDir(), "", "", "")
+	n := s.(*nfsMount)
+	var calls int
+	err := n.retry(func() error {
+		if calls++; calls < 3 {
+			return &os.PathError{Op: "open", Path: "a", Err: syscall.ESTALE}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("stale file handle should be retried: %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = n.retry(func() error {
+		calls++
+		return syscall.ESTALE
+	})
+	if !errors.Is(err, syscall.ESTALE) || calls != nfsStaleRetries+1 {
+		t.Fatalf("retries should be limited: %v after %d calls", err, calls)
+	}
+	calls = 0
+	_ = n.retry(func() error {
+		calls++
+		return syscall.EIO
+	})
+	if calls != 1 {
+		t.Fatalf("other errors should not be retried: %d calls", calls)
+	}
+}
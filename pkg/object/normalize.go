@@ -0,0 +1,324 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// NormalizeKey returns the canonical form of key, so the same object is addressed by the same key in all the
+// object storages:
+//
+//   - the leading slashes are removed, and the duplicated slashes are merged into one ("/a//b" -> "a/b")
+//   - the "." segments are removed ("a/./b" -> "a/b")
+//   - a ".." segment removes the segment before it, or itself at the beginning ("a/../b" -> "b", "../a" -> "a")
+//   - a trailing slash is kept for the directories ("a//b/" -> "a/b/", "a/b/.." -> "a/"), unless nothing is left
+//     ("/" -> "")
+//
+// It's idempotent: a normalized key is normalized into itself.
+func NormalizeKey(key string) string {
+	if !needNormalize(key) {
+		return key
+	}
+	parts := strings.Split(key, "/")
+	segs := make([]string, 0, len(parts))
+	for _, p := range parts {
+		switch p {
+		case "", ".":
+		case "..":
+			if len(segs) > 0 {
+				segs = segs[:len(segs)-1]
+			}
+		default:
+			segs = append(segs, p)
+		}
+	}
+	if len(segs) == 0 {
+		return ""
+	}
+	normalized := strings.Join(segs, "/")
+	if last := parts[len(parts)-1]; last == "" || last == "." || last == ".." {
+		normalized += "/"
+	}
+	return normalized
+}
+
+// needNormalize returns false for the keys already in the canonical form, which are most of them.
+func needNormalize(key string) bool {
+	if key == "" {
+		return false
+	}
+	if key[0] == '/' || strings.Contains(key, "//") {
+		return true
+	}
+	for _, p := range strings.Split(key, "/") {
+		if p == "." || p == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePrefix normalizes the complete segments of a prefix (or marker) of listing, the last segment is kept
+// as it is, since it's a prefix of the names ("a//." -> "a/." to match "a/.hidden").
+func normalizePrefix(prefix string) string {
+	i := strings.LastIndexByte(prefix, '/')
+	if i < 0 {
+		return prefix
+	}
+	return NormalizeKey(prefix[:i+1]) + prefix[i+1:]
+}
+
+var rawKeyStorages = make(map[string]bool)
+
+// RegisterRawKeys registers an object storage like Register, but the keys are passed to it as they are, rather
+// than normalized by WithNormalizedKeys, for the object storages which resolve the keys as paths themselves (like
+// file systems) or need the keys not in the canonical form.
+func RegisterRawKeys(name string, register Creator) {
+	Register(name, register)
+	rawKeyStorages[name] = true
+}
+
+type normalized struct {
+	ObjectStorage
+}
+
+// WithNormalizedKeys returns an object storage that normalizes the keys by NormalizeKey before using them, so an
+// object put as "a//b" can be read back as "a//b" or "a/b". CreateStorage applies it to the object storages
+// except the ones registered by RegisterRawKeys. The objects whose keys are not normalized (written by other tools) can't
+// be addressed through it, so they are skipped by the listings, otherwise they would be read or deleted as other
+// objects.
+func WithNormalizedKeys(s ObjectStorage) ObjectStorage {
+	if _, ok := s.(*normalized); ok {
+		return s
+	}
+	return &normalized{s}
+}
+
+// canonical returns the objects whose keys are normalized.
+func canonical(objs []Object) []Object {
+	listed := objs[:0]
+	for _, o := range objs {
+		if k := o.Key(); NormalizeKey(k) == k {
+			listed = append(listed, o)
+		} else {
+			logger.Debugf("Skip object %q whose key is not normalized", k)
+		}
+	}
+	return listed
+}
+
+func (n *normalized) WithContext(ctx context.Context) ObjectStorage {
+	return &normalized{WithContext(n.ObjectStorage, ctx)}
+}
+
+func (n *normalized) Head(key string) (Object, error) {
+	return n.ObjectStorage.Head(NormalizeKey(key))
+}
+
+func (n *normalized) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	return n.ObjectStorage.Get(NormalizeKey(key), off, limit, getters...)
+}
+
+func (n *normalized) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return n.ObjectStorage.Put(NormalizeKey(key), in, getters...)
+}
+
+func (n *normalized) Copy(dst, src string) error {
+	return n.ObjectStorage.Copy(NormalizeKey(dst), NormalizeKey(src))
+}
+
+func (n *normalized) CopyWithOptions(dst, src string, opts CopyOptions) error {
+	return CopyWithOptions(n.ObjectStorage, NormalizeKey(dst), NormalizeKey(src), opts)
+}
+
+func (n *normalized) Move(dst, src string) error {
+	return Move(n.ObjectStorage, NormalizeKey(dst), NormalizeKey(src))
+}
+
+func (n *normalized) Append(key string, off int64, data io.Reader) (int64, error) {
+	return Append(n.ObjectStorage, NormalizeKey(key), off, data)
+}
+
+func (n *normalized) Delete(key string, getters ...AttrGetter) error {
+	return n.ObjectStorage.Delete(NormalizeKey(key), getters...)
+}
+
+func (n *normalized) DeleteMulti(keys []string) ([]string, error) {
+	nkeys := make([]string, len(keys))
+	origin := make(map[string]string, len(keys))
+	for i, key := range keys {
+		nkeys[i] = NormalizeKey(key)
+		origin[nkeys[i]] = key
+	}
+	failed, err := DeleteMulti(n.ObjectStorage, nkeys)
+	for i, key := range failed {
+		if k, ok := origin[key]; ok {
+			failed[i] = k
+		}
+	}
+	return failed, err
+}
+
+func (n *normalized) PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error {
+	return PutIfNotExists(n.ObjectStorage, NormalizeKey(key), in, getters...)
+}
+
+func (n *normalized) PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	return PutWithExpiry(n.ObjectStorage, NormalizeKey(key), in, expiry, getters...)
+}
+
+func (n *normalized) Exists(key string) (bool, error) {
+	return Exists(n.ObjectStorage, NormalizeKey(key))
+}
+
+func (n *normalized) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := n.ObjectStorage.List(normalizePrefix(prefix), normalizePrefix(marker), delimiter, limit, followLink)
+	return canonical(objs), err
+}
+
+func (n *normalized) ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	objs, prefixes, err := ListWithDelimiter(n.ObjectStorage, normalizePrefix(prefix), delimiter, normalizePrefix(marker), limit)
+	return canonical(objs), prefixes, err
+}
+
+func (n *normalized) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	in, err := n.ObjectStorage.ListAll(normalizePrefix(prefix), normalizePrefix(marker), followLink)
+	if err != nil || in == nil {
+		return in, err
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		for o := range in {
+			if o != nil && NormalizeKey(o.Key()) != o.Key() {
+				logger.Debugf("Skip object %q whose key is not normalized", o.Key())
+				continue
+			}
+			out <- o
+		}
+	}()
+	return out, nil
+}
+
+func (n *normalized) ListAllResumable(prefix string, token ResumeToken, followLink bool) (<-chan ListedObject, error) {
+	in, err := ListAllResumable(n.ObjectStorage, normalizePrefix(prefix), token, followLink)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan ListedObject, maxResults)
+	go func() {
+		defer close(out)
+		for o := range in {
+			if o.Object != nil && NormalizeKey(o.Key()) != o.Key() {
+				logger.Debugf("Skip object %q whose key is not normalized", o.Key())
+				continue
+			}
+			out <- o
+		}
+	}()
+	return out, nil
+}
+
+func (n *normalized) Symlink(oldName, newName string) error {
+	return Symlink(n.ObjectStorage, oldName, NormalizeKey(newName))
+}
+
+func (n *normalized) Readlink(name string) (string, error) {
+	return Readlink(n.ObjectStorage, NormalizeKey(name))
+}
+
+func (n *normalized) Usage() (int64, int64, error) {
+	if s, ok := n.ObjectStorage.(SupportUsage); ok {
+		return s.Usage()
+	}
+	return 0, 0, notSupported
+}
+
+func (n *normalized) SetStorageClass(sc string) error {
+	if o, ok := n.ObjectStorage.(SupportStorageClass); ok {
+		return o.SetStorageClass(sc)
+	}
+	return notSupported
+}
+
+func (n *normalized) SetMeta(key string, meta map[string]string) error {
+	if w, ok := n.ObjectStorage.(SupportMetadata); ok {
+		return w.SetMeta(NormalizeKey(key), meta)
+	}
+	return notSupported
+}
+
+func (n *normalized) GetMeta(key string) (map[string]string, error) {
+	if w, ok := n.ObjectStorage.(SupportMetadata); ok {
+		return w.GetMeta(NormalizeKey(key))
+	}
+	return nil, notSupported
+}
+
+func (n *normalized) SetTags(key string, tags map[string]string) error {
+	if w, ok := n.ObjectStorage.(SupportTags); ok {
+		return w.SetTags(NormalizeKey(key), tags)
+	}
+	return notSupported
+}
+
+func (n *normalized) GetTags(key string) (map[string]string, error) {
+	if w, ok := n.ObjectStorage.(SupportTags); ok {
+		return w.GetTags(NormalizeKey(key))
+	}
+	return nil, notSupported
+}
+
+func (n *normalized) SignGet(key string, expire time.Duration) (string, error) {
+	if w, ok := n.ObjectStorage.(SupportPresign); ok {
+		return w.SignGet(NormalizeKey(key), expire)
+	}
+	return "", notSupported
+}
+
+func (n *normalized) SignPut(key string, expire time.Duration) (string, error) {
+	if w, ok := n.ObjectStorage.(SupportPresign); ok {
+		return w.SignPut(NormalizeKey(key), expire)
+	}
+	return "", notSupported
+}
+
+func (n *normalized) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return n.ObjectStorage.CreateMultipartUpload(NormalizeKey(key))
+}
+
+func (n *normalized) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	return n.ObjectStorage.UploadPart(NormalizeKey(key), uploadID, num, body)
+}
+
+func (n *normalized) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	return n.ObjectStorage.UploadPartCopy(NormalizeKey(key), uploadID, num, NormalizeKey(srcKey), off, size)
+}
+
+func (n *normalized) AbortUpload(key string, uploadID string) {
+	n.ObjectStorage.AbortUpload(NormalizeKey(key), uploadID)
+}
+
+func (n *normalized) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	return n.ObjectStorage.CompleteUpload(NormalizeKey(key), uploadID, parts)
+}
+
+var _ ObjectStorage = &normalized{}
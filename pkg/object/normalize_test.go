@@ -0,0 +1,168 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		"":                 "",
+		"a":                "a",
+		"a/b/":             "a/b/",
+		"chunks/0/0/1_0_4": "chunks/0/0/1_0_4",
+		"/a":               "a",
+		"//a//b":           "a/b",
+		"a//b/":            "a/b/",
+		"a/./b":            "a/b",
+		"./a":              "a",
+		"a/../b":           "b",
+		"../a":             "a",
+		"a/b/..":           "a/",
+		"a/b/.":            "a/b/",
+		"a/..":             "",
+		"/":                "",
+		"..":               "",
+		"a/.b/..c":         "a/.b/..c",
+		"a/...":            "a/...",
+	} {
+		if n := NormalizeKey(key); n != expected {
+			t.Fatalf("normalize %q: %q, expected %q", key, n, expected)
+		}
+	}
+	for prefix, expected := range map[string]string{
+		"":      "",
+		"a":     "a",
+		".h":    ".h",
+		"a//.h": "a/.h",
+		"/a/":   "a/",
+		"a/./b": "a/b",
+	} {
+		if n := normalizePrefix(prefix); n != expected {
+			t.Fatalf("normalize prefix %q: %q, expected %q", prefix, n, expected)
+		}
+	}
+}
+
+func FuzzNormalizeKey(f *testing.F) {
+	for _, key := range []string{"", "a", "a/b/", "/a//b", "a/./b/../c", "../..//.", "a/.b/..c/"} {
+		f.Add(key)
+	}
+	f.Fuzz(func(t *testing.T, key string) {
+		n := NormalizeKey(key)
+		if NormalizeKey(n) != n {
+			t.Fatalf("normalize %q: %q is not stable", key, n)
+		}
+		if strings.HasPrefix(n, "/") || strings.Contains(n, "//") {
+			t.Fatalf("normalize %q: %q has leading or duplicated slashes", key, n)
+		}
+		for _, p := range strings.Split(strings.TrimSuffix(n, "/"), "/") {
+			if p == "." || p == ".." {
+				t.Fatalf("normalize %q: %q has segment %q", key, n, p)
+			}
+		}
+		if n != "" && strings.HasSuffix(key, "/") != strings.HasSuffix(n, "/") && !strings.HasSuffix(key, ".") {
+			t.Fatalf("normalize %q: %q changes the trailing slash", key, n)
+		}
+		if p := normalizePrefix(key); normalizePrefix(p) != p {
+			t.Fatalf("normalize prefix %q: %q is not stable", key, p)
+		}
+	})
+}
+
+func TestNormalizedStorage(t *testing.T) {
+	s, _ := CreateStorage("mem", "", "", "", "")
+	if _, ok := s.(*normalized); !ok {
+		t.Fatalf("keys of %s should be normalized", s)
+	}
+	if err := s.Put("a//b", bytes.NewReader([]byte("b"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	for _, key := range []string{"a//b", "/a/b", "a/./b", "a/c/../b"} {
+		if d, err := get(s, key, 0, -1); err != nil || d != "b" {
+			t.Fatalf("get %s: %q %v", key, d, err)
+		}
+	}
+	objs, err := s.List("a//", "", "", 10, true)
+	if err != nil || listKeys(objs) != "a/b" {
+		t.Fatalf("list: %s %v", listKeys(objs), err)
+	}
+	if err = Move(s, "/c", "a//b"); err != nil {
+		t.Fatalf("move: %s", err)
+	}
+	if ok, err := Exists(s, "c"); !ok || err != nil {
+		t.Fatalf("c should exist: %v %v", ok, err)
+	}
+	if err = s.Delete("//c"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if ok, _ := Exists(s, "c"); ok {
+		t.Fatalf("c should be deleted")
+	}
+
+	// the objects written as other keys can't be addressed, so they are not listed
+	inner := s.(*normalized).ObjectStorage
+	_ = inner.Put("d//e", bytes.NewReader([]byte("raw")))
+	_ = s.Put("d/e", bytes.NewReader([]byte("e")))
+	if objs, err = s.List("d/", "", "", 10, true); err != nil || listKeys(objs) != "d/e" {
+		t.Fatalf("list should skip the keys not normalized: %s %v", listKeys(objs), err)
+	}
+	ch, _ := ListAll(s, "d", "", true)
+	var keys []string
+	for o := range ch {
+		keys = append(keys, o.Key())
+	}
+	checkListed(t, keys, []string{"d/e"})
+	if files, _, err := ListWithDelimiter(s, "d/", "/", "", 10); err != nil || listKeys(files) != "d/e" {
+		t.Fatalf("list with delimiter should skip the keys not normalized: %s %v", listKeys(files), err)
+	}
+	if err = s.Delete("d/e"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if d, err := get(inner, "d//e", 0, -1); err != nil || d != "raw" {
+		t.Fatalf("the raw object should be kept: %q %v", d, err)
+	}
+
+	RegisterRawKeys("rawmem", newMem)
+	defer func() {
+		delete(storages, "rawmem")
+		delete(rawKeyStorages, "rawmem")
+	}()
+	raw, _ := CreateStorage("rawmem", "", "", "", "")
+	_ = raw.Put("a//b", bytes.NewReader([]byte("b")))
+	if _, err = raw.Head("a/b"); err == nil {
+		t.Fatalf("keys of rawmem should not be normalized")
+	}
+	if objs, _ = raw.List("", "", "", 10, true); listKeys(objs) != "a//b" {
+		t.Fatalf("raw keys should be listed: %s", listKeys(objs))
+	}
+}
+
+func TestNormalizedCapabilities(t *testing.T) {
+	f, _ := newDisk(t.TempDir()+"/", "", "", "")
+	s := WithNormalizedKeys(f)
+	_ = s.Put("a/b", bytes.NewReader([]byte("b")))
+	if err := Symlink(s, "b", "a//link"); err != nil {
+		t.Fatalf("symlink should be forwarded: %s", err)
+	}
+	if target, err := Readlink(s, "/a/link"); err != nil || target != "b" {
+		t.Fatalf("readlink: %q %v", target, err)
+	}
+}
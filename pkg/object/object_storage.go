@@ -17,10 +17,15 @@
 package object
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -44,6 +49,616 @@ type SupportSymlink interface {
 	Readlink(name string) (string, error)
 }
 
+// Symlink creates newName as a symbolic link to oldName, or returns ErrNotSupported if the object storage
+// doesn't support symbolic links natively.
+func Symlink(store ObjectStorage, oldName, newName string) error {
+	if s, ok := store.(SupportSymlink); ok {
+		return s.Symlink(oldName, newName)
+	}
+	return notSupported
+}
+
+// Readlink returns the target of the symbolic link without following it, or ErrNotSupported if the object
+// storage doesn't support symbolic links natively.
+func Readlink(store ObjectStorage, name string) (string, error) {
+	if s, ok := store.(SupportSymlink); ok {
+		return s.Readlink(name)
+	}
+	return "", notSupported
+}
+
+type SupportMetadata interface {
+	// SetMeta replaces the user defined metadata of an object
+	SetMeta(key string, meta map[string]string) error
+	// GetMeta returns the user defined metadata of an object
+	GetMeta(key string) (map[string]string, error)
+}
+
+// SupportTags is implemented by the object storages that support object tags, which are indexed
+// by the service and can be used by lifecycle rules, different from user defined metadata.
+type SupportTags interface {
+	// SetTags replaces all the tags of an object
+	SetTags(key string, tags map[string]string) error
+	// GetTags returns the tags of an object
+	GetTags(key string) (map[string]string, error)
+}
+
+// SupportPresign is implemented by the object storages that can sign URLs, so the clients can
+// download or upload an object directly without proxying the data.
+type SupportPresign interface {
+	// SignGet returns a URL to download the object, which is valid until expire
+	SignGet(key string, expire time.Duration) (string, error)
+	// SignPut returns a URL to upload the object by HTTP PUT, which is valid until expire
+	SignPut(key string, expire time.Duration) (string, error)
+}
+
+// SupportDeleteMulti is implemented by the object storages that can delete multiple objects in one request.
+type SupportDeleteMulti interface {
+	// DeleteMulti deletes the objects and returns the keys that failed to be deleted
+	DeleteMulti(keys []string) ([]string, error)
+}
+
+// DeleteMulti deletes the objects in batch if supported, or one by one otherwise. It returns the keys
+// that failed to be deleted together with the last error, so the caller can retry them.
+func DeleteMulti(store ObjectStorage, keys []string) ([]string, error) {
+	if s, ok := store.(SupportDeleteMulti); ok {
+		return s.DeleteMulti(keys)
+	}
+	return deleteEach(store, keys)
+}
+
+// MkdirMarker creates a directory marker, an empty object whose key is the dir with a trailing slash, which is
+// listed as a directory (IsDir) by all the object storages and shown as a folder by the consoles. The marker is
+// written through the wrappers that transform the data (like encryption or compression), so it's always empty.
+func MkdirMarker(store ObjectStorage, dir string) error {
+	if dir == "" || dir == dirSuffix {
+		return fmt.Errorf("invalid directory %q", dir)
+	}
+	key := strings.TrimSuffix(dir, dirSuffix) + dirSuffix
+	switch s := store.(type) {
+	case *withPrefix:
+		return MkdirMarker(s.os, s.prefix+key)
+	case *encrypted:
+		return MkdirMarker(s.ObjectStorage, key)
+	case *blockEncrypted:
+		return MkdirMarker(s.ObjectStorage, key)
+	case *compressed:
+		return MkdirMarker(s.ObjectStorage, key)
+	}
+	return store.Put(key, bytes.NewReader(nil))
+}
+
+// DeleteDir deletes all the objects under the dir (with a trailing slash) in batches. The directory marker is
+// deleted last if removeMarker is true and all the others are deleted, so the directory is still shown while
+// deleting, or kept as an empty directory otherwise.
+func DeleteDir(store ObjectStorage, dir string, removeMarker bool) error {
+	marker := strings.TrimSuffix(dir, dirSuffix) + dirSuffix
+	if err := removeAll(store, marker, 1, marker); err != nil {
+		return err
+	}
+	if removeMarker {
+		if err := store.Delete(marker); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveAllError is returned by RemoveAll when some objects can't be deleted.
+type RemoveAllError struct {
+	Prefix string
+	Failed []string // the keys failed to be deleted
+	Errs   []error  // the errors of the failed batches and listing
+}
+
+func (e *RemoveAllError) Error() string {
+	errs := e.Errs
+	if len(errs) > 3 {
+		errs = errs[:3]
+	}
+	msg := fmt.Sprintf("remove %d objects under %q failed", len(e.Failed), e.Prefix)
+	if len(e.Failed) > 0 {
+		msg += fmt.Sprintf(" (like %s)", e.Failed[0])
+	}
+	return fmt.Sprintf("%s: %s", msg, errors.Join(errs...))
+}
+
+func (e *RemoveAllError) Unwrap() []error {
+	return e.Errs
+}
+
+// RemoveAll deletes all the objects under prefix with concurrent threads, in batches of 1000 by DeleteMulti. It keeps
+// going after failures, which are returned together as a *RemoveAllError at the end. The directories (including
+// the markers) are deleted after the others, deeper ones first, so they can be removed from file systems.
+func RemoveAll(store ObjectStorage, prefix string, concurrency int) error {
+	return removeAll(store, prefix, concurrency, "")
+}
+
+// removeAll deletes all the objects under prefix except the key to keep (if not empty).
+func removeAll(store ObjectStorage, prefix string, concurrency int, keep string) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	objs, err := ListAll(store, prefix, "", false)
+	if err != nil {
+		return err
+	}
+	result := &RemoveAllError{Prefix: prefix}
+	var mu sync.Mutex
+	fail := func(keys []string, err error) {
+		mu.Lock()
+		result.Failed = append(result.Failed, keys...)
+		result.Errs = append(result.Errs, err)
+		mu.Unlock()
+	}
+	batches := make(chan []string, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for keys := range batches {
+				if failed, err := DeleteMulti(store, keys); err != nil {
+					fail(failed, err)
+				}
+			}
+		}()
+	}
+	var keys, dirs []string
+	for o := range objs {
+		if o == nil {
+			fail(nil, fmt.Errorf("list %s failed", prefix))
+			break
+		}
+		if o.Key() == keep {
+			continue
+		}
+		if o.IsDir() {
+			dirs = append(dirs, o.Key())
+			continue
+		}
+		if keys = append(keys, o.Key()); len(keys) == 1000 {
+			batches <- keys
+			keys = nil
+		}
+	}
+	if len(keys) > 0 {
+		batches <- keys
+	}
+	close(batches)
+	wg.Wait()
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if err := store.Delete(dir); err != nil && !errors.Is(err, ErrNotFound) {
+			fail([]string{dir}, err)
+		}
+	}
+	if len(result.Errs) > 0 {
+		return result
+	}
+	return nil
+}
+
+func deleteEach(store ObjectStorage, keys []string) ([]string, error) {
+	var failed []string
+	var err error
+	for _, key := range keys {
+		if e := store.Delete(key); e != nil {
+			failed = append(failed, key)
+			err = e
+		}
+	}
+	return failed, err
+}
+
+// SupportPutIfNotExists is implemented by the object storages that can put an object atomically
+// only if it does not exist (If-None-Match: *).
+type SupportPutIfNotExists interface {
+	// PutIfNotExists uploads the object, or returns ErrExists if it exists already
+	PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error
+}
+
+// PutIfNotExists uploads the object only if it does not exist, otherwise ErrExists is returned.
+// For the object storages that can't do it atomically, it falls back to Head then Put, which is racy:
+// two clients may both see the object missing and overwrite each other, so it should not be used for
+// locking on them.
+func PutIfNotExists(store ObjectStorage, key string, in io.Reader, getters ...AttrGetter) error {
+	if s, ok := store.(SupportPutIfNotExists); ok {
+		return s.PutIfNotExists(key, in, getters...)
+	}
+	if _, err := store.Head(key); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, key)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return store.Put(key, in, getters...)
+}
+
+// ErrAppendOffset is returned by Append when the offset doesn't match the size of object.
+var ErrAppendOffset = errors.New("append offset doesn't match the size of object")
+
+// SupportAppend is implemented by the object storages that can append data to an object.
+type SupportAppend interface {
+	// Append writes data at off of the object, which must be the size of the object (0 creates a new one),
+	// and returns the offset of next append. ErrAppendOffset is returned if off doesn't match.
+	Append(key string, off int64, data io.Reader) (int64, error)
+}
+
+// Append appends data to the object at off and returns the offset of next append. It returns ErrNotSupported
+// if the object storage (or any wrapper of it) can't append, since appending can't be emulated atomically.
+// Only the objects created by Append are appendable (see ObjectWithType), others can't be appended even if the
+// object storage supports it, and the appendable objects can't be uploaded by multipart on most object storages.
+func Append(store ObjectStorage, key string, off int64, data io.Reader) (int64, error) {
+	if s, ok := store.(SupportAppend); ok {
+		return s.Append(key, off, data)
+	}
+	return off, notSupported
+}
+
+// SupportMove is implemented by the object storages that can rename an object atomically.
+type SupportMove interface {
+	// Move renames src to dst atomically, dst is replaced if it exists.
+	Move(dst, src string) error
+}
+
+// Move renames the object src to dst. It's atomic on the object storages with SupportMove (like abfs, HDFS and
+// local disk), otherwise it's emulated by CopyLarge then Delete, which is NOT atomic: both of them are visible in
+// between, and an existing dst is overwritten before src is deleted. If src can't be deleted, dst is deleted to
+// roll back, so src is left as it was, but the previous dst (if any) is lost.
+func Move(store ObjectStorage, dst, src string) error {
+	if s, ok := store.(SupportMove); ok {
+		return s.Move(dst, src)
+	}
+	if err := CopyLarge(store, dst, src); err != nil {
+		return err
+	}
+	if err := store.Delete(src); err != nil {
+		if e := store.Delete(dst); e != nil {
+			logger.Warnf("Roll back the copy %s of %s: %s", dst, src, e)
+		}
+		return fmt.Errorf("delete %s after copied to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// MetadataDirective tells whether the metadata of a copy is copied from the source or replaced.
+type MetadataDirective string
+
+const (
+	// MetadataCopy copies the user defined metadata, tags and content type of the source.
+	MetadataCopy MetadataDirective = "COPY"
+	// MetadataReplace replaces the user defined metadata, tags and content type with the ones in CopyOptions.
+	MetadataReplace MetadataDirective = "REPLACE"
+)
+
+// CopyOptions controls the metadata of the copy made by CopyWithOptions.
+type CopyOptions struct {
+	MetadataDirective MetadataDirective // MetadataCopy if it's empty
+	// the following are only used by MetadataReplace
+	Metadata    map[string]string
+	Tags        map[string]string
+	ContentType string // the content type of the source is kept if it's empty
+}
+
+// SupportCopyWithOptions is implemented by the object storages that can control the metadata of copies.
+type SupportCopyWithOptions interface {
+	CopyWithOptions(dst, src string, opts CopyOptions) error
+}
+
+// CopyWithOptions copies src to dst within the object storage, keeping or replacing the metadata as opts. The object
+// storages without SupportCopyWithOptions can only keep what their Copy keeps, so MetadataReplace is not supported.
+func CopyWithOptions(store ObjectStorage, dst, src string, opts CopyOptions) error {
+	if s, ok := store.(SupportCopyWithOptions); ok {
+		return s.CopyWithOptions(dst, src, opts)
+	}
+	switch opts.MetadataDirective {
+	case "", MetadataCopy:
+		return store.Copy(dst, src)
+	case MetadataReplace:
+		return notSupported
+	default:
+		return fmt.Errorf("invalid metadata directive: %s", opts.MetadataDirective)
+	}
+}
+
+// SupportExpiry is implemented by the object storages that can expire (delete) an object at a given time.
+type SupportExpiry interface {
+	PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error
+}
+
+// PutWithExpiry puts an object which is deleted by the object storage after expiry, e.g. for ephemeral caches. The
+// zero time means it never expires (same as Put), otherwise it should be in the future. ErrNotSupported is returned
+// if the object storage can't expire objects. The expiry of an object is reported by Head (ObjectWithExpiry).
+func PutWithExpiry(store ObjectStorage, key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	if expiry.IsZero() {
+		return store.Put(key, in, getters...)
+	}
+	if !expiry.After(time.Now()) {
+		return fmt.Errorf("expiry %s of %s is not in the future", expiry.Format(time.RFC3339), key)
+	}
+	if s, ok := store.(SupportExpiry); ok {
+		return s.PutWithExpiry(key, in, expiry, getters...)
+	}
+	return notSupported
+}
+
+// SupportExpirySetup is implemented by the object storages that expire the objects put with expiry by the rules of
+// the bucket, which are set up once rather than by PutWithExpiry.
+type SupportExpirySetup interface {
+	SetupExpiry() error
+}
+
+// SetupExpiry sets up the bucket to expire the objects put by PutWithExpiry, it should be called once (e.g. after
+// the bucket is created) with the permission to change the configuration of the bucket. It does nothing for the
+// object storages that expire the objects by themselves.
+func SetupExpiry(store ObjectStorage) error {
+	for s := store; s != nil; s = unwrap(s) {
+		if e, ok := s.(SupportExpirySetup); ok {
+			return e.SetupExpiry()
+		}
+	}
+	return nil
+}
+
+// SupportExists is implemented by the object storages that can check the existence of an object
+// cheaper than Head.
+type SupportExists interface {
+	// Exists returns whether the object exists, errors other than not found (like auth failures) are returned.
+	Exists(key string) (bool, error)
+}
+
+// Exists returns whether the object exists, it's built on Head for the object storages without SupportExists,
+// so ErrNotFound is translated into (false, nil) and the other errors are returned.
+func Exists(store ObjectStorage, key string) (bool, error) {
+	if s, ok := store.(SupportExists); ok {
+		return s.Exists(key)
+	}
+	_, err := store.Head(key)
+	if err == nil {
+		return true, nil
+	} else if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// SupportCheck is implemented by the object storages that can verify the access to the bucket cheaper or more
+// precisely than listing it.
+type SupportCheck interface {
+	// Check verifies the credentials and the connectivity without side effects.
+	Check() error
+}
+
+// Check verifies the credentials and the connectivity of the object storage without side effects, e.g. as a
+// readiness probe. It uses SupportCheck of the object storage (or the one it wraps), or lists one object otherwise
+// (Head of a missing key if listing is not supported). The failures are classified as ErrPermission,
+// ErrBucketNotFound or ErrUnreachable if possible, other errors are returned as they are.
+func Check(store ObjectStorage) error {
+	for s := store; s != nil; s = unwrap(s) {
+		if c, ok := s.(SupportCheck); ok {
+			return checkError(c.Check())
+		}
+	}
+	_, err := store.List("", "", "", 1, false)
+	if errors.Is(err, notSupported) {
+		if _, err = store.Head("juicefs-check"); errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	}
+	return checkError(err)
+}
+
+// checkError classifies the error of Check by the HTTP status code or the type of it.
+func checkError(err error) error {
+	if err == nil || errors.Is(err, ErrPermission) || errors.Is(err, ErrBucketNotFound) || errors.Is(err, ErrUnreachable) {
+		return err
+	}
+	switch httpStatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrPermission, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, err)
+	}
+	// the errors of aws-sdk-go carry the original error without Unwrap
+	for e := err; e != nil; {
+		var ne net.Error
+		if errors.As(e, &ne) {
+			return fmt.Errorf("%w: %s", ErrUnreachable, err)
+		}
+		o, ok := e.(interface{ OrigErr() error })
+		if !ok {
+			break
+		}
+		e = o.OrigErr()
+	}
+	return err
+}
+
+// SupportListWithDelimiter is implemented by the object storages that can list one level of the keys.
+type SupportListWithDelimiter interface {
+	// ListWithDelimiter returns the objects directly under prefix and the common prefixes (ending with the delimiter)
+	// of the deeper ones, at most limit of them in total, after the marker (an object key or common prefix).
+	ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error)
+}
+
+// ListWithDelimiter lists one level of the keys under prefix, it uses List with the delimiter if the object storage
+// supports it (like the hierarchy listing of Azure and CommonPrefixes of S3), or derives the common prefixes from
+// the flat listing otherwise.
+func ListWithDelimiter(store ObjectStorage, prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	if s, ok := store.(SupportListWithDelimiter); ok {
+		return s.ListWithDelimiter(prefix, delimiter, marker, limit)
+	}
+	return listCommonPrefixes(store, prefix, delimiter, marker, limit)
+}
+
+// listCommonPrefixes implements ListWithDelimiter with List of the object storage.
+func listCommonPrefixes(store ObjectStorage, prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	if delimiter == "" {
+		objs, err := store.List(prefix, marker, "", limit, false)
+		return objs, nil, err
+	}
+	objs, err := store.List(prefix, marker, delimiter, limit, false)
+	if err == nil {
+		files, prefixes := splitCommonPrefixes(prefix, delimiter, marker, objs)
+		return files, prefixes, nil
+	}
+	if !errors.Is(err, notSupported) {
+		return nil, nil, err
+	}
+	return listWithDelimiter(store, prefix, delimiter, marker, limit)
+}
+
+// splitCommonPrefixes separates the common prefixes from the objects returned by List with delimiter.
+func splitCommonPrefixes(prefix, delimiter, marker string, objs []Object) ([]Object, []string) {
+	var files []Object
+	var prefixes []string
+	for _, o := range objs {
+		if rest := strings.TrimPrefix(o.Key(), prefix); strings.Contains(rest, delimiter) {
+			// a common prefix equal to the marker is returned again by some object storages
+			if o.Key() > marker {
+				prefixes = append(prefixes, o.Key())
+			}
+		} else {
+			files = append(files, o)
+		}
+	}
+	return files, prefixes
+}
+
+// listWithDelimiter rolls up the keys of flat listing into common prefixes, skipping the rest of a common prefix
+// by listing after it again.
+func listWithDelimiter(store ObjectStorage, prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	var files []Object
+	var prefixes []string
+	const skip = "\xff" // larger than any byte of UTF-8
+	if marker != "" && strings.HasSuffix(marker, delimiter) {
+		marker += skip // the marker is a common prefix returned before
+	}
+	for int64(len(files)+len(prefixes)) < limit {
+		objs, err := store.List(prefix, marker, "", limit, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(objs) == 0 {
+			break
+		}
+		for _, o := range objs {
+			rest := o.Key()[len(prefix):]
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				cp := prefix + rest[:i+len(delimiter)]
+				prefixes = append(prefixes, cp)
+				marker = cp + skip
+				break
+			}
+			files = append(files, o)
+			marker = o.Key()
+			if int64(len(files)+len(prefixes)) >= limit {
+				break
+			}
+		}
+	}
+	return files, prefixes, nil
+}
+
+// SupportBucketName is implemented by the object storages backed by a bucket (or container).
+type SupportBucketName interface {
+	// BucketName returns the name of the bucket, without endpoint or prefix
+	BucketName() string
+}
+
+// BucketName returns the name of the bucket that the object storage points at, through the wrappers
+// like prefix or encryption. It returns "" if the object storage is not backed by a single bucket.
+func BucketName(store ObjectStorage) string {
+	if s, ok := store.(SupportBucketName); ok {
+		return s.BucketName()
+	}
+	if inner := unwrap(store); inner != nil {
+		return BucketName(inner)
+	}
+	return ""
+}
+
+// Notification is a rule of the bucket to send the events of the matched objects to a target.
+type Notification struct {
+	ID     string
+	Target string   // ARN of the queue, topic or function
+	Events []string // like s3:ObjectCreated:*
+	Prefix string
+	Suffix string
+}
+
+// SupportNotification is implemented by the object storages that can report the notification rules of the bucket.
+type SupportNotification interface {
+	// GetNotifications returns the notification rules configured on the bucket
+	GetNotifications() ([]*Notification, error)
+}
+
+// GetNotifications returns the notification rules of the bucket behind the wrappers, to check whether the events
+// are wired. It returns ErrNotSupported if the object storage has no notification.
+func GetNotifications(store ObjectStorage) ([]*Notification, error) {
+	if s, ok := store.(SupportNotification); ok {
+		return s.GetNotifications()
+	}
+	if inner := unwrap(store); inner != nil {
+		return GetNotifications(inner)
+	}
+	return nil, notSupported
+}
+
+// unwrap returns the object storage wrapped by store, or nil if store doesn't wrap a single object storage.
+func unwrap(store ObjectStorage) ObjectStorage {
+	switch s := store.(type) {
+	case *withPrefix:
+		return s.os
+	case *encrypted:
+		return s.ObjectStorage
+	case *blockEncrypted:
+		return s.ObjectStorage
+	case *compressed:
+		return s.ObjectStorage
+	case *readOnly:
+		return s.ObjectStorage
+	case *retryStorage:
+		return s.ObjectStorage
+	case *rateLimited:
+		return s.ObjectStorage
+	case *withMetrics:
+		return s.ObjectStorage
+	case *cached:
+		return s.ObjectStorage
+	case *mirrored:
+		return s.ObjectStorage
+	case *sortedListing:
+		return s.ObjectStorage
+	case *normalized:
+		return s.ObjectStorage
+	case *accounted:
+		return s.ObjectStorage
+	case *faulty:
+		return s.ObjectStorage
+	case *checkedListing:
+		return s.ObjectStorage
+	case *hashPrefixed:
+		return s.ObjectStorage
+	}
+	return nil
+}
+
+// SupportContext is implemented by the object storages whose requests can be bound to a context.
+type SupportContext interface {
+	// WithContext returns a copy of the object storage that uses ctx for all the requests
+	WithContext(ctx context.Context) ObjectStorage
+}
+
+// WithContext binds the requests of the object storage to ctx, so they are canceled together with ctx.
+// The object storage is returned unchanged if it doesn't support context.
+func WithContext(store ObjectStorage, ctx context.Context) ObjectStorage {
+	if s, ok := store.(SupportContext); ok {
+		return s.WithContext(ctx)
+	}
+	return store
+}
+
 type File interface {
 	Object
 	Owner() string
@@ -105,6 +720,35 @@ type FileSystem interface {
 
 var notSupported = utils.ENOTSUP
 
+// ErrNotSupported is returned when the object storage doesn't support the operation.
+var ErrNotSupported = notSupported
+
+// ErrNotFound is returned when the object does not exist, it's the same as os.ErrNotExist
+// so the checks of os.IsNotExist keep working, errors.Is should be used for wrapped errors.
+var ErrNotFound = os.ErrNotExist
+
+// ErrPermission is returned when the access is denied by the permissions of the object (or directory),
+// it's the same as os.ErrPermission.
+var ErrPermission = os.ErrPermission
+
+// ErrExists is returned by PutIfNotExists when the object exists already, it's the same as os.ErrExist
+var ErrExists = os.ErrExist
+
+// ErrBucketNotFound is returned by Check when the bucket (or container) does not exist.
+var ErrBucketNotFound = errors.New("bucket does not exist")
+
+// ErrUnreachable is returned by Check when the object storage can't be reached because of network errors.
+var ErrUnreachable = errors.New("object storage is unreachable")
+
+// ErrArchived is returned when reading an object in an archive storage class, it should be restored first.
+var ErrArchived = errors.New("object is archived")
+
+// ErrChecksumMismatch is returned when the object storage rejects an upload because of corrupted data.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrObjectLocked is returned when an object can't be modified or deleted because of a retention policy or legal hold.
+var ErrObjectLocked = errors.New("object is locked")
+
 type DefaultObjectStorage struct{}
 
 func (s DefaultObjectStorage) Create() error {
@@ -165,7 +809,11 @@ func CreateStorage(name, endpoint, accessKey, secretKey, token string) (ObjectSt
 	f, ok := storages[name]
 	if ok {
 		logger.Debugf("Creating %s storage at endpoint %s", name, endpoint)
-		return f(endpoint, accessKey, secretKey, token)
+		store, err := f(endpoint, accessKey, secretKey, token)
+		if err != nil || rawKeyStorages[name] {
+			return store, err
+		}
+		return WithNormalizedKeys(store), nil
 	}
 	return nil, fmt.Errorf("invalid storage: %s", name)
 }
@@ -19,26 +19,56 @@ package object
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/colinmarc/hdfs/v2/hadoopconf"
 	"github.com/juicedata/juicefs/pkg/utils"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	blob2 "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/ncw/swift/v2"
+	"github.com/ncw/swift/v2/swifttest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qiniu/go-sdk/v7/storage"
+	xwebdav "golang.org/x/net/webdav"
+	"google.golang.org/api/drive/v3"
+	"gopkg.in/kothar/go-backblaze.v0"
 
 	"github.com/volcengine/ve-tos-golang-sdk/v2/tos/enum"
 
@@ -165,6 +195,10 @@ func testStorage(t *testing.T, s ObjectStorage) {
 	if d, e := get(s, "test", 2, 2); e != nil || d != "ll" {
 		t.Fatalf("expect ll, but got %v, error: %s", d, e)
 	}
+	// get to the end
+	if d, e := get(s, "test", 2, 0); e != nil || d != "llo" {
+		t.Fatalf("expect llo, but got %v, error: %s", d, e)
+	}
 	// get the end out of range
 	if d, e := get(s, "test", 4, 2); e != nil || d != "o" {
 		t.Logf("out-of-range get: 'o', but got %v, error: %s", len(d), e)
@@ -370,6 +404,12 @@ func testStorage(t *testing.T, s ObjectStorage) {
 			}
 		}
 	}
+	if failed, err := DeleteMulti(s, append(sortedKeys[:10:10], "hashKeyMissing")); err != nil || len(failed) > 0 {
+		t.Fatalf("delete multi: failed %v: %v", failed, err)
+	}
+	if _, err := s.Head(sortedKeys[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("%s should be deleted: %v", sortedKeys[0], err)
+	}
 
 	f, _ := os.CreateTemp("", "test")
 	f.Write([]byte("this is a file"))
@@ -548,6 +588,35 @@ func testStorage(t *testing.T, s ObjectStorage) {
 func TestMem(t *testing.T) {
 	m, _ := newMem("", "", "", "")
 	testStorage(t, m)
+
+	for _, k := range []string{"c", "a/2", "b", "a/1"} {
+		_ = m.Put(k, bytes.NewReader(nil))
+	}
+	if objs, err := m.List("", "a/1", "", 2, true); err != nil || listKeys(objs) != "a/2,b" {
+		t.Fatalf("list after a/1: %s %v", listKeys(objs), err)
+	}
+	if objs, err := m.List("", "", "/", 10, true); err != nil || listKeys(objs) != "a/,b,c" {
+		t.Fatalf("list with delimiter: %s %v", listKeys(objs), err)
+	}
+	up, _ := m.CreateMultipartUpload("mp")
+	if ups, _, err := m.ListUploads(""); err != nil || len(ups) != 1 || ups[0].UploadID != up.UploadID {
+		t.Fatalf("list uploads: %+v %v", ups, err)
+	}
+	m.AbortUpload("mp", up.UploadID)
+	if _, err := m.UploadPart("mp", up.UploadID, 1, []byte("a")); err == nil {
+		t.Fatalf("upload part of aborted upload should fail")
+	}
+	if ups, _, _ := m.ListUploads(""); len(ups) != 0 {
+		t.Fatalf("aborted upload should be removed: %+v", ups)
+	}
+}
+
+func listKeys(objs []Object) string {
+	keys := make([]string, len(objs))
+	for i, o := range objs {
+		keys[i] = o.Key()
+	}
+	return strings.Join(keys, ",")
 }
 
 func TestDisk(t *testing.T) {
@@ -573,6 +642,711 @@ func TestQingStor(t *testing.T) { //skip mutate
 	testStorage(t, s2)
 }
 
+func TestS3RequesterPays(t *testing.T) {
+	var mu sync.Mutex
+	payers := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		payers[r.Method] = r.Header.Get("X-Amz-Request-Payer")
+		mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`<ListBucketResult><Name>test</Name></ListBucketResult>`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	for _, pays := range []bool{false, true} {
+		s, err := newS3(fmt.Sprintf("%s/test?requester-pays=%v", srv.URL, pays), "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("create s3: %s", err)
+		}
+		_, _ = s.Head("key")
+		_, _ = s.List("", "", "", 10, true)
+		_ = s.Delete("key")
+		expect := ""
+		if pays {
+			expect = "requester"
+		}
+		for _, m := range []string{http.MethodHead, http.MethodGet, http.MethodDelete} {
+			if payers[m] != expect {
+				t.Fatalf("request payer of %s should be %q, got %q", m, expect, payers[m])
+			}
+		}
+	}
+}
+
+func TestS3SSE(t *testing.T) {
+	var mu sync.Mutex
+	sse := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		op := r.Method
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			op = "COPY"
+		}
+		mu.Lock()
+		sse[op] = r.Header.Get("X-Amz-Server-Side-Encryption") + "," + r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id")
+		mu.Unlock()
+		switch op {
+		case http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("X-Amz-Server-Side-Encryption", "aws:kms")
+			w.Header().Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", "key-1")
+		case http.MethodPost:
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+		case "COPY":
+			_, _ = w.Write([]byte(`<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`))
+		}
+	}))
+	defer srv.Close()
+	if _, err := newS3(srv.URL+"/test?sse=AES256&sse-kms-key-id=key-1", "ak", "sk", ""); err == nil {
+		t.Fatalf("kms key id should only be used with aws:kms")
+	}
+	if _, err := newS3(srv.URL+"/test?sse=aes", "ak", "sk", ""); err == nil {
+		t.Fatalf("invalid sse should fail")
+	}
+	s, err := newS3(srv.URL+"/test?sse=aws:kms&sse-kms-key-id=key-1", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	s.(*s3client).disableChecksum = true
+	if err = s.Put("a", bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if err = s.Copy("b", "a"); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+	if _, err = s.CreateMultipartUpload("c"); err != nil {
+		t.Fatalf("create multipart upload: %s", err)
+	}
+	for _, op := range []string{http.MethodPut, "COPY", http.MethodPost} {
+		if sse[op] != "aws:kms,key-1" {
+			t.Fatalf("sse of %s: %s", op, sse[op])
+		}
+	}
+	o, err := WithPrefix(s, "p/").Head("a")
+	if err != nil {
+		t.Fatalf("head: %s", err)
+	}
+	if so, ok := o.(ObjectWithSSE); !ok || so.ServerSideEncryption() != "aws:kms" || so.SSEKMSKeyID() != "key-1" {
+		t.Fatalf("head should return the sse: %+v", o)
+	}
+}
+
+func TestS3ContentType(t *testing.T) {
+	var mu sync.Mutex
+	types := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		switch r.Method {
+		case http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("Content-Type", "text/html")
+		case http.MethodPost:
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+		}
+		mu.Lock()
+		types[r.URL.Path] = r.Header.Get("Content-Type")
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	s.(*s3client).disableChecksum = true
+	_ = s.Put("a.html", bytes.NewReader([]byte("a")))
+	_ = s.Put("b.html", bytes.NewReader([]byte("b")), WithContentType("text/plain"))
+	_ = s.Put("chunks/0/0/1_0_4", bytes.NewReader([]byte("c")))
+	_, _ = s.CreateMultipartUpload("d.txt")
+	for key, ct := range map[string]string{"a.html": "text/html; charset=utf-8", "b.html": "text/plain", "chunks/0/0/1_0_4": "application/octet-stream", "d.txt": "text/plain; charset=utf-8"} {
+		if types["/test/"+key] != ct {
+			t.Fatalf("content type of %s: %q, expected %q", key, types["/test/"+key], ct)
+		}
+	}
+	o, err := WithPrefix(s, "p/").Head("a.html")
+	if co, ok := o.(ObjectWithContentType); err != nil || !ok || co.ContentType() != "text/html" {
+		t.Fatalf("head should return the content type: %+v, %v", o, err)
+	}
+
+	s, _ = newS3(srv.URL+"/test?disable-content-type=true", "ak", "sk", "")
+	s.(*s3client).disableChecksum = true
+	_ = s.Put("e.html", bytes.NewReader([]byte("e")))
+	_ = s.Put("f.html", bytes.NewReader([]byte("f")), WithContentType("text/plain"))
+	_, _ = s.CreateMultipartUpload("g.txt")
+	if types["/test/e.html"] != "" || types["/test/f.html"] != "text/plain" || types["/test/g.txt"] != "" {
+		t.Fatalf("content type should not be inferred: %+v", types)
+	}
+}
+
+func TestS3CopyWithOptions(t *testing.T) {
+	var copies []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("X-Amz-Meta-"+checksumAlgr, "1234")
+			w.Header().Set("X-Amz-Meta-Owner", "a")
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			copies = append(copies, r.Header.Clone())
+			_, _ = w.Write([]byte(`<CopyObjectResult><ETag>"1"</ETag></CopyObjectResult>`))
+		}
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	if err = s.Copy("b", "a"); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+	if err = CopyWithOptions(WithPrefix(s, "p/"), "b", "a", CopyOptions{
+		MetadataDirective: MetadataReplace,
+		Metadata:          map[string]string{"owner": "b"},
+		Tags:              map[string]string{"env": "test"},
+	}); err != nil {
+		t.Fatalf("copy with replace: %s", err)
+	}
+	if err = CopyWithOptions(s, "b", "a", CopyOptions{MetadataDirective: "MOVE"}); err == nil {
+		t.Fatalf("invalid directive should fail")
+	}
+	if len(copies) != 2 {
+		t.Fatalf("copies: %d", len(copies))
+	}
+	if h := copies[0]; h.Get("X-Amz-Metadata-Directive") != "COPY" || h.Get("X-Amz-Tagging-Directive") != "COPY" || h.Get("X-Amz-Copy-Source") != "test/a" {
+		t.Fatalf("copy should keep the metadata: %+v", h)
+	}
+	h := copies[1]
+	if h.Get("X-Amz-Metadata-Directive") != "REPLACE" || h.Get("X-Amz-Tagging-Directive") != "REPLACE" || h.Get("X-Amz-Copy-Source") != "test/p/a" {
+		t.Fatalf("copy should replace the metadata: %+v", h)
+	}
+	if h.Get("X-Amz-Meta-Owner") != "b" || h.Get("X-Amz-Meta-"+checksumAlgr) != "1234" || h.Get("X-Amz-Tagging") != "env=test" || h.Get("Content-Type") != "text/html" {
+		t.Fatalf("replaced metadata: %+v", h)
+	}
+}
+
+func TestS3PutWithExpiry(t *testing.T) {
+	var mu sync.Mutex
+	var lifecycle []byte
+	var lifecycleGets, lifecyclePuts int
+	tags := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Query().Has("lifecycle") && r.Method == http.MethodGet:
+			lifecycleGets++
+			if lifecycle == nil {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchLifecycleConfiguration</Code></Error>`))
+				return
+			}
+			_, _ = w.Write(lifecycle)
+		case r.URL.Query().Has("lifecycle") && r.Method == http.MethodPut:
+			lifecyclePuts++
+			lifecycle = body
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("X-Amz-Expiration", `expiry-date="Fri, 23 Dec 2033 00:00:00 GMT", rule-id="juicefs-expire-2d"`)
+		case r.Method == http.MethodPut:
+			tags[r.URL.Path] = r.Header.Get("X-Amz-Tagging")
+		}
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	s.(*s3client).disableChecksum = true
+	if err = PutWithExpiry(s, "a", bytes.NewReader([]byte("a")), time.Now().Add(-time.Minute)); err == nil {
+		t.Fatalf("expiry in the past should fail")
+	}
+	if err = PutWithExpiry(s, "a", bytes.NewReader([]byte("a")), time.Now().Add(3651*24*time.Hour)); err == nil {
+		t.Fatalf("expiry too far should fail")
+	}
+	lifecycle = []byte(`<LifecycleConfiguration><Rule><ID>logs</ID><Filter><Prefix>logs/</Prefix></Filter><Status>Enabled</Status>
+<Expiration><Days>3</Days></Expiration></Rule></LifecycleConfiguration>`)
+	if err = SetupExpiry(WithPrefix(s, "p/")); err != nil {
+		t.Fatalf("setup expiry: %s", err)
+	}
+	if !bytes.Contains(lifecycle, []byte("<ID>logs</ID>")) || bytes.Count(lifecycle, []byte("<Rule>")) != len(s3ExpiryDays)+1 {
+		t.Fatalf("the rules of expiry should be added: %s", lifecycle)
+	}
+	lifecycleGets, lifecyclePuts = 0, 0
+	for _, key := range []string{"a", "b"} {
+		if err = PutWithExpiry(s, key, bytes.NewReader([]byte("a")), time.Now().Add(36*time.Hour)); err != nil {
+			t.Fatalf("put with expiry: %s", err)
+		}
+	}
+	if err = PutWithExpiry(s, "c", bytes.NewReader([]byte("c")), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("put with expiry: %s", err)
+	}
+	if err = PutWithExpiry(s, "d", bytes.NewReader([]byte("d")), time.Now().Add(8*24*time.Hour)); err != nil {
+		t.Fatalf("put with expiry: %s", err)
+	}
+	if lifecycleGets != 0 || lifecyclePuts != 0 {
+		t.Fatalf("the lifecycle should not be changed by put: %d gets, %d puts", lifecycleGets, lifecyclePuts)
+	}
+	if tags["/test/d"] != s3ExpiryTag+"=10" {
+		t.Fatalf("the days should be rounded up to the rules: %s", tags["/test/d"])
+	}
+	if err = SetLifecycle(s, testLifecycle); err != nil {
+		t.Fatalf("set lifecycle: %s", err)
+	}
+	if bytes.Count(lifecycle, []byte("<Rule>")) != len(s3ExpiryDays)+len(testLifecycle) || !bytes.Contains(lifecycle, []byte("<ID>juicefs-expire-10d</ID>")) {
+		t.Fatalf("the rules of expiry should be kept by SetLifecycle: %s", lifecycle)
+	}
+	if tags["/test/a"] != s3ExpiryTag+"=2" || tags["/test/b"] != s3ExpiryTag+"=2" || tags["/test/c"] != s3ExpiryTag+"=1" {
+		t.Fatalf("tags of objects: %+v", tags)
+	}
+	if rules, err := s.(SupportLifecycle).GetLifecycle(); err != nil || len(rules) != len(testLifecycle) {
+		t.Fatalf("the rules by tags should be ignored: %+v %v", rules, err)
+	}
+	o, err := s.Head("a")
+	if eo, ok := o.(ObjectWithExpiry); err != nil || !ok || !eo.Expiry().Equal(time.Date(2033, 12, 23, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("head should return the expiry: %+v %v", o, err)
+	}
+}
+
+func TestS3Archived(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("X-Amz-Storage-Class", "DEEP_ARCHIVE")
+		case !strings.HasPrefix(r.URL.Path, "/test/"):
+			_, _ = w.Write([]byte(`<ListBucketResult><Name>test</Name>
+<Contents><Key>a</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified><StorageClass>STANDARD_IA</StorageClass></Contents>
+<Contents><Key>b</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified><StorageClass>DEEP_ARCHIVE</StorageClass></Contents>
+</ListBucketResult>`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Error><Code>InvalidObjectState</Code><Message>The operation is not valid for the object's storage class</Message></Error>`))
+		}
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	objs, err := s.List("", "", "", 10, true)
+	if err != nil || len(objs) != 2 {
+		t.Fatalf("list: %v, %v", objs, err)
+	}
+	if objs[0].StorageClass() != "STANDARD_IA" || objs[1].StorageClass() != "DEEP_ARCHIVE" {
+		t.Fatalf("storage class: %s %s", objs[0].StorageClass(), objs[1].StorageClass())
+	}
+	if o, err := s.Head("b"); err != nil || o.StorageClass() != "DEEP_ARCHIVE" {
+		t.Fatalf("head: %v, %v", o, err)
+	}
+	_, err = s.Get("b", 0, -1)
+	if !errors.Is(err, ErrArchived) || !strings.Contains(err.Error(), "DEEP_ARCHIVE") {
+		t.Fatalf("get of archived object should fail with ErrArchived: %v", err)
+	}
+}
+
+func TestS3Restore(t *testing.T) {
+	var restores []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("X-Amz-Storage-Class", "GLACIER")
+			w.Header().Set("X-Amz-Restore", `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+		case r.Method == http.MethodPost && r.URL.Query().Has("restore"):
+			restores = append(restores, string(body))
+			if len(restores) > 1 {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`<Error><Code>RestoreAlreadyInProgress</Code></Error>`))
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	c := s.(*s3client)
+	if err = c.Restore("a", 1, "glacier"); err == nil {
+		t.Fatalf("invalid tier should fail")
+	}
+	for i := 0; i < 2; i++ {
+		if err = c.Restore("a", 3, "bulk"); err != nil {
+			t.Fatalf("restore: %s", err)
+		}
+	}
+	if len(restores) != 2 || !strings.Contains(restores[0], "<Days>3</Days>") || !strings.Contains(restores[0], "<Tier>Bulk</Tier>") {
+		t.Fatalf("restore requests: %v", restores)
+	}
+	o, err := WithPrefix(s, "p/").Head("a")
+	if err != nil {
+		t.Fatalf("head: %s", err)
+	}
+	ro, ok := o.(ObjectWithRestoreStatus)
+	if !ok {
+		t.Fatalf("head should return the restore status: %+v", o)
+	}
+	if ongoing, expiry := ro.RestoreStatus(); ongoing || !expiry.Equal(time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("restore status: %v %s", ongoing, expiry)
+	}
+}
+
+func TestS3Notifications(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["notification"]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`<NotificationConfiguration>
+<QueueConfiguration><Id>1</Id><Queue>arn:minio:sqs::primary:webhook</Queue><Event>s3:ObjectCreated:*</Event><Event>s3:ObjectRemoved:*</Event>
+<Filter><S3Key><FilterRule><Name>prefix</Name><Value>chunks/</Value></FilterRule><FilterRule><Name>suffix</Name><Value>.jpg</Value></FilterRule></S3Key></Filter></QueueConfiguration>
+<TopicConfiguration><Id>2</Id><Topic>arn:aws:sns:us-east-1:123:topic</Topic><Event>s3:ObjectCreated:Put</Event></TopicConfiguration>
+</NotificationConfiguration>`))
+	}))
+	defer srv.Close()
+	s, err := newMinio(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create minio: %s", err)
+	}
+	ns, err := GetNotifications(WithPrefix(s, "p/"))
+	if err != nil || len(ns) != 2 {
+		t.Fatalf("get notifications: %+v %v", ns, err)
+	}
+	if n := ns[0]; n.ID != "1" || n.Target != "arn:minio:sqs::primary:webhook" || strings.Join(n.Events, ",") != "s3:ObjectCreated:*,s3:ObjectRemoved:*" || n.Prefix != "chunks/" || n.Suffix != ".jpg" {
+		t.Fatalf("queue notification: %+v", n)
+	}
+	if n := ns[1]; n.ID != "2" || n.Target != "arn:aws:sns:us-east-1:123:topic" || n.Prefix != "" {
+		t.Fatalf("topic notification: %+v", n)
+	}
+	m, _ := newMem("", "", "", "")
+	if _, err = GetNotifications(m); err != ErrNotSupported {
+		t.Fatalf("mem should not support notifications: %v", err)
+	}
+}
+
+func TestS3ChecksumAlgorithm(t *testing.T) {
+	var mu sync.Mutex
+	var headers []http.Header
+	var completed string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("X-Amz-Checksum-Crc32c", "yZRlqg==-2")
+			w.Header().Set("Last-Modified", "Fri, 21 Dec 2012 00:00:00 GMT")
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			headers = append(headers, r.Header.Clone())
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPost:
+			completed = string(body)
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"e"</ETag></CompleteMultipartUploadResult>`))
+		case r.Method == http.MethodPut:
+			sum := crc32.Checksum(body, crc32c)
+			expected := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, sum))
+			if r.Header.Get("X-Amz-Checksum-Crc32c") != expected {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			headers = append(headers, r.Header.Clone())
+			w.Header().Set("ETag", `"e"`)
+		}
+	}))
+	defer srv.Close()
+
+	// compatible object storages don't verify checksums by default
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	if s.(*s3client).checksumAlgo != "" {
+		t.Fatalf("checksum should be disabled by default: %s", s.(*s3client).checksumAlgo)
+	}
+	if s, _ = newS3("https://test.s3.us-west-2.amazonaws.com", "ak", "sk", ""); s.(*s3client).checksumAlgo != s3.ChecksumAlgorithmCrc32c {
+		t.Fatalf("checksum of AWS should be CRC32C by default: %s", s.(*s3client).checksumAlgo)
+	}
+	if _, err = newS3(srv.URL+"/test?checksum-algorithm=md5", "ak", "sk", ""); err == nil {
+		t.Fatalf("unsupported checksum algorithm should fail")
+	}
+	if _, err = newWasb("https://test.blob.core.windows.net?checksum-algorithm=crc32c", "ak", "a2V5", ""); err == nil {
+		t.Fatalf("unsupported checksum algorithm of Azure should fail")
+	}
+
+	s, err = newS3(srv.URL+"/test?checksum-algorithm=crc32c", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	if err = s.Put("key", bytes.NewReader([]byte("hello"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	up, err := s.CreateMultipartUpload("large")
+	if err != nil {
+		t.Fatalf("create multipart upload: %s", err)
+	}
+	part, err := s.UploadPart("large", up.UploadID, 1, []byte("world"))
+	if err != nil {
+		t.Fatalf("upload part: %s", err)
+	}
+	if err = s.CompleteUpload("large", up.UploadID, []*Part{part}); err != nil {
+		t.Fatalf("complete upload: %s", err)
+	}
+	if len(headers) != 3 {
+		t.Fatalf("requests with checksum: %d", len(headers))
+	}
+	for _, h := range headers {
+		if h.Get("X-Amz-Checksum-Algorithm") != "CRC32C" && h.Get("X-Amz-Sdk-Checksum-Algorithm") != "CRC32C" {
+			t.Fatalf("checksum algorithm is not set: %v", h)
+		}
+	}
+	if !strings.Contains(completed, "<ChecksumCRC32C>"+part.Checksum+"</ChecksumCRC32C>") {
+		t.Fatalf("checksum of parts should be sent on completion: %s", completed)
+	}
+
+	o, err := s.Head("large")
+	if err != nil {
+		t.Fatalf("head: %s", err)
+	}
+	if algo, sum := o.(ObjectWithServerChecksum).ServerChecksum(); algo != "CRC32C" || sum != "yZRlqg==-2" {
+		t.Fatalf("server checksum: %s %s", algo, sum)
+	}
+	co := &checksumObj{md5: "5d41402abc4b2a76b9719d911017c592"}
+	if algo, sum := co.ServerChecksum(); algo != "MD5" || sum != "XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Fatalf("md5 checksum: %s %s", algo, sum)
+	}
+}
+
+func TestS3PathStyle(t *testing.T) {
+	for _, c := range []struct {
+		endpoint, url string
+	}{
+		// MinIO-style endpoints use path-style by default
+		{"http://127.0.0.1:9000/test", "http://127.0.0.1:9000/test/key"},
+		{"http://test.minio.local:9000", "http://minio.local:9000/test/key"},
+		{"http://127.0.0.1:9000/test?force-path-style=false", "http://test.127.0.0.1:9000/key"},
+		{"http://test.minio.local:9000?force-path-style=false", "http://test.minio.local:9000/key"},
+		// AWS endpoints use virtual-hosted-style by default
+		{"https://test.s3.us-west-2.amazonaws.com", "https://test.s3.us-west-2.amazonaws.com/key"},
+		{"https://test.s3.us-west-2.amazonaws.com?force-path-style=true", "https://s3.us-west-2.amazonaws.com/test/key"},
+		{"https://s3.us-west-2.amazonaws.com/test?force-path-style=true", "https://s3.us-west-2.amazonaws.com/test/key"},
+	} {
+		s, err := newS3(c.endpoint, "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("create s3 %s: %s", c.endpoint, err)
+		}
+		req, _ := s.(*s3client).s3.HeadObjectRequest(&s3.HeadObjectInput{Bucket: aws.String("test"), Key: aws.String("key")})
+		if err = req.Build(); err != nil {
+			t.Fatalf("build request: %s", err)
+		}
+		if u := req.HTTPRequest.URL.String(); u != c.url {
+			t.Fatalf("url of %s should be %s, got %s", c.endpoint, c.url, u)
+		}
+	}
+	if _, err := newS3("http://127.0.0.1:9000/test?force-path-style=yes", "ak", "sk", ""); err == nil {
+		t.Fatalf("invalid force-path-style should fail")
+	}
+}
+
+func TestS3ContentMD5(t *testing.T) {
+	var mu sync.Mutex
+	md5s := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			md5s[r.URL.Path] = r.Header.Get("Content-Md5")
+			mu.Unlock()
+			w.Header().Set("ETag", `"etag"`)
+		}
+	}))
+	defer srv.Close()
+	sum := md5.Sum([]byte("data"))
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	for query, sent := range map[string]bool{"": true, "?disable-content-md5=true": false, "?require-content-md5=true": true} {
+		s, err := newS3(srv.URL+"/test"+query, "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("create s3 with %q: %s", query, err)
+		}
+		s.(*s3client).disableChecksum = true
+		if query != "" {
+			// the header is set or removed no matter what the SDK does
+			s.(*s3client).s3.Config.S3DisableContentMD5Validation = aws.Bool(sent)
+		}
+		if err = s.Put("a", struct{ io.Reader }{bytes.NewReader([]byte("data"))}); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+		if _, err = s.UploadPart("b", "id", 1, []byte("data")); err != nil {
+			t.Fatalf("upload part: %s", err)
+		}
+		for _, key := range []string{"/test/a", "/test/b"} {
+			if v := md5s[key]; sent && v != expected || !sent && v != "" {
+				t.Fatalf("Content-MD5 of %s with %q: %q", key, query, v)
+			}
+		}
+	}
+	if _, err := newS3(srv.URL+"/test?disable-content-md5=true&require-content-md5=true", "ak", "sk", ""); err == nil {
+		t.Fatalf("conflicting options should fail")
+	}
+}
+
+func TestS3Express(t *testing.T) {
+	for bucket, az := range map[string]string{
+		"mybucket--usw2-az1--x-s3":      "usw2-az1",
+		"my-bucket--use1-az4--x-s3":     "use1-az4",
+		"data--usw2-lax1-az1--x-s3":     "usw2-lax1-az1",
+		"mybucket":                      "",
+		"mybucket--usw2-az1":            "",
+		"mybucket--x-s3":                "",
+		"--usw2-az1--x-s3":              "",
+		"mybucket--usw2-az1--x-s3-copy": "",
+	} {
+		if id, ok := parseS3ExpressBucket(bucket); id != az || ok != (az != "") {
+			t.Fatalf("availability zone of %s should be %q, got %q", bucket, az, id)
+		}
+	}
+	if r := parseRegion("s3express-usw2-az1.us-west-2.amazonaws.com"); r != "us-west-2" {
+		t.Fatalf("region of zonal endpoint: %s", r)
+	}
+	t.Setenv("AWS_REGION", "us-west-2")
+	for _, c := range []struct {
+		endpoint, url string
+		express       bool
+	}{
+		{"https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com", "https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com/key", true},
+		{"https://s3express-usw2-az1.us-west-2.amazonaws.com/mybucket--usw2-az1--x-s3", "https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com/key", true},
+		{"https://mybucket--usw2-az1--x-s3.s3.us-west-2.amazonaws.com", "https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com/key", true},
+		{"mybucket--usw2-az1--x-s3", "https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com/key", true},
+		{"https://mybucket.s3.us-west-2.amazonaws.com", "https://mybucket.s3.us-west-2.amazonaws.com/key", false},
+		{"http://127.0.0.1:9000/mybucket--usw2-az1--x-s3", "http://127.0.0.1:9000/mybucket--usw2-az1--x-s3/key", false},
+	} {
+		s, err := newS3(c.endpoint, "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("create s3 %s: %s", c.endpoint, err)
+		}
+		cli := s.(*s3client)
+		if (cli.expressSession != nil) != c.express {
+			t.Fatalf("%s should be a directory bucket: %v", c.endpoint, c.express)
+		}
+		req, _ := cli.s3.HeadObjectRequest(&s3.HeadObjectInput{Bucket: &cli.bucket, Key: aws.String("key")})
+		if err = req.Build(); err != nil {
+			t.Fatalf("build request: %s", err)
+		}
+		if u := req.HTTPRequest.URL.String(); u != c.url {
+			t.Fatalf("url of %s should be %s, got %s", c.endpoint, c.url, u)
+		}
+	}
+	if _, err := newS3("https://mybucket--usw2-az1--x-s3.s3express-usw2-az1.us-west-2.amazonaws.com?anonymous=true", "", "", ""); err == nil {
+		t.Fatalf("directory bucket can't be accessed anonymously")
+	}
+
+	var mu sync.Mutex
+	var sessions int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		if r.URL.Query().Has("session") {
+			sessions++
+			if !strings.Contains(auth, "Credential=ak/") || !strings.Contains(auth, "/s3express/aws4_request") || r.Header.Get("X-Amz-Create-Session-Mode") != "ReadWrite" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `<CreateSessionResult><Credentials><SessionToken>token</SessionToken><SecretAccessKey>ssk</SecretAccessKey><AccessKeyId>sak</AccessKeyId><Expiration>%s</Expiration></Credentials></CreateSessionResult>`,
+				time.Now().Add(5*time.Minute).UTC().Format(time.RFC3339))
+			return
+		}
+		if !strings.Contains(auth, "Credential=sak/") || !strings.Contains(auth, "/s3express/aws4_request") ||
+			r.Header.Get("X-Amz-S3session-Token") != "token" || r.Header.Get("X-Amz-Security-Token") != "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			if r.URL.Query().Get("prefix") != "a/" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// the keys are out of order in two pages
+			if r.URL.Query().Get("continuation-token") == "" {
+				_, _ = w.Write([]byte(`<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>` +
+					`<Contents><Key>a/c</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>` +
+					`<Contents><Key>a/bb</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>` +
+					`</ListBucketResult>`))
+				return
+			}
+			var prefixes string
+			if r.URL.Query().Get("delimiter") == "/" {
+				prefixes = `<CommonPrefixes><Prefix>a/d/</Prefix></CommonPrefixes>`
+			}
+			_, _ = w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated>` +
+				`<Contents><Key>a/b</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>` +
+				`<Contents><Key>a/a</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>` +
+				prefixes + `</ListBucketResult>`))
+		}
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test--usw2-az1--x-s3", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	cli := s.(*s3client)
+	cli.disableChecksum = true
+	enableS3Express(cli)
+	for _, key := range []string{"a/b", "a/c"} {
+		if err = s.Put(key, bytes.NewReader([]byte("a"))); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+	}
+	objs, err := s.List("a/b", "", "", 10, true)
+	if err != nil || listKeys(objs) != "a/b,a/bb" {
+		t.Fatalf("list: %s %v", listKeys(objs), err)
+	}
+	if objs, err = s.List("a/", "", "", 2, true); err != nil || listKeys(objs) != "a/a,a/b" {
+		t.Fatalf("the first keys should be listed from all the pages: %s %v", listKeys(objs), err)
+	}
+	if objs, err = s.List("a/", "a/b", "", 10, true); err != nil || listKeys(objs) != "a/bb,a/c" {
+		t.Fatalf("list after a marker: %s %v", listKeys(objs), err)
+	}
+	if objs, err = s.List("a/", "a/bb", "/", 10, true); err != nil || listKeys(objs) != "a/c,a/d/" {
+		t.Fatalf("list with delimiter: %s %v", listKeys(objs), err)
+	}
+	if objs, err = s.List("a/", "a/a", "/", 2, true); err != nil || listKeys(objs) != "a/b,a/bb" {
+		t.Fatalf("list with delimiter and limit: %s %v", listKeys(objs), err)
+	}
+	ch, err := s.ListAll("a/", "a/b", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var keys []Object
+	for o := range ch {
+		keys = append(keys, o)
+	}
+	if listKeys(keys) != "a/bb,a/c" {
+		t.Fatalf("list all after a marker: %s", listKeys(keys))
+	}
+	if sessions != 1 {
+		t.Fatalf("the session should be reused: %d sessions", sessions)
+	}
+}
+
 func TestS3(t *testing.T) { //skip mutate
 	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
 		t.SkipNow()
@@ -627,6 +1401,127 @@ func TestOSS(t *testing.T) { //skip mutate
 	testStorage(t, s)
 }
 
+func TestOSSSymlink(t *testing.T) {
+	links := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/test/")
+		_, isLink := r.URL.Query()["symlink"]
+		switch {
+		case r.Method == http.MethodPut && isLink:
+			links[key] = r.Header.Get("X-Oss-Symlink-Target")
+		case r.Method == http.MethodGet && isLink:
+			target, ok := links[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+				return
+			}
+			w.Header().Set("X-Oss-Symlink-Target", target)
+		case r.Method == http.MethodGet && key == "":
+			_, _ = w.Write([]byte(`<ListBucketResult><Name>test</Name>
+<Contents><Key>a</Key><Size>3</Size><Type>Normal</Type></Contents>
+<Contents><Key>b</Key><Size>0</Size><Type>Symlink</Type></Contents>
+</ListBucketResult>`))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+	client, err := oss.New(srv.URL, "ak", "sk")
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	bucket, _ := client.Bucket("test")
+	s := &ossClient{client: client, bucket: bucket}
+
+	if err = Symlink(s, "a", "b"); err != nil {
+		t.Fatalf("symlink: %s", err)
+	}
+	if target, err := Readlink(s, "b"); err != nil || target != "a" {
+		t.Fatalf("readlink: %q, %v", target, err)
+	}
+	if _, err = Readlink(s, "c"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("readlink of missing link should be not found: %v", err)
+	}
+	objs, err := s.List("", "", "", 10, false)
+	if err != nil || len(objs) != 2 {
+		t.Fatalf("list: %v, %v", objs, err)
+	}
+	if objs[0].IsSymlink() || !objs[1].IsSymlink() {
+		t.Fatalf("only b should be symlink")
+	}
+	if o := WithPrefix(s, "").(*withPrefix).updateKey(objs[1]); !o.IsSymlink() {
+		t.Fatalf("symlink should be kept with prefix")
+	}
+
+	m, _ := newMem("", "", "", "")
+	if err = Symlink(m, "a", "b"); err != ErrNotSupported {
+		t.Fatalf("mem should not support symlink: %v", err)
+	}
+}
+
+func TestOSSAppend(t *testing.T) {
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/test/")
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("append"):
+			pos, _ := strconv.Atoi(q.Get("position"))
+			if pos != len(objects[key]) {
+				w.Header().Set("X-Oss-Next-Append-Position", strconv.Itoa(len(objects[key])))
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`<Error><Code>PositionNotEqualToLength</Code></Error>`))
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = append(objects[key], data...)
+			w.Header().Set("X-Oss-Next-Append-Position", strconv.Itoa(len(objects[key])))
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", "Fri, 21 Dec 2012 00:00:00 GMT")
+			w.Header().Set("Content-Length", strconv.Itoa(len(objects[key])))
+			w.Header().Set("X-Oss-Object-Type", "Appendable")
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+	client, err := oss.New(srv.URL, "ak", "sk")
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	bucket, _ := client.Bucket("test")
+	s := WithPrefix(&ossClient{client: client, bucket: bucket}, "p/")
+
+	if next, err := Append(s, "log", 0, strings.NewReader("hello")); err != nil || next != 5 {
+		t.Fatalf("append: %d %v", next, err)
+	}
+	if next, err := Append(s, "log", 5, strings.NewReader(" world")); err != nil || next != 11 {
+		t.Fatalf("append: %d %v", next, err)
+	}
+	if next, err := Append(s, "log", 5, strings.NewReader("!")); !errors.Is(err, ErrAppendOffset) || next != 5 || !strings.Contains(err.Error(), "size is 11") {
+		t.Fatalf("append at wrong offset: %d %v", next, err)
+	}
+	if string(objects["p/log"]) != "hello world" {
+		t.Fatalf("appended object: %q", objects["p/log"])
+	}
+	o, err := s.Head("log")
+	if err != nil {
+		t.Fatalf("head: %s", err)
+	}
+	if to, ok := o.(ObjectWithType); !ok || to.ObjectType() != ObjectTypeAppendable || o.Key() != "log" || o.Size() != 11 {
+		t.Fatalf("head of appendable object: %+v", o)
+	}
+
+	if _, err = Append(WithReadOnly(s), "log", 11, strings.NewReader("!")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("append to read-only storage: %v", err)
+	}
+	m, _ := newMem("", "", "", "")
+	if _, err = Append(m, "log", 0, strings.NewReader("!")); err != ErrNotSupported {
+		t.Fatalf("mem should not support append: %v", err)
+	}
+}
+
 func TestUFile(t *testing.T) { //skip mutate
 	if os.Getenv("UCLOUD_PUBLIC_KEY") == "" {
 		t.SkipNow()
@@ -644,45 +1539,1915 @@ func TestGS(t *testing.T) { //skip mutate
 	testStorage(t, gs)
 }
 
-func TestQiniu(t *testing.T) { //skip mutate
-	if os.Getenv("QINIU_ACCESS_KEY") == "" {
-		t.SkipNow()
-	}
-	qiniu, _ := newQiniu(os.Getenv("QINIU_ENDPOINT"),
-		os.Getenv("QINIU_ACCESS_KEY"), os.Getenv("QINIU_SECRET_KEY"), "")
-	testStorage(t, qiniu)
-	//qiniu, _ = newQiniu("https://test.cn-north-1-s3.qiniu.com",
-	//	os.Getenv("QINIU_ACCESS_KEY"), os.Getenv("QINIU_SECRET_KEY"))
-	//testStorage(t, qiniu)
+// fakeGDrive serves the files of Drive API v3 used by gdrive, and the token endpoint of OAuth2.
+type fakeGDrive struct {
+	sync.Mutex
+	files     map[string]*drive.File
+	data      map[string][]byte
+	sessions  map[string]*drive.File
+	resumable int  // the number of resumable uploads
+	throttle  bool // respond the errors of exceeding the rate limits
+	refreshed int
+	lists     int // the number of files.list
 }
 
-func TestKS3(t *testing.T) { //skip mutate
-	if os.Getenv("KS3_ACCESS_KEY") == "" {
-		t.SkipNow()
+var fakeGDriveNameRegexp = regexp.MustCompile(`name = '((?:[^'\\]|\\.)*)'`)
+
+func (f *fakeGDrive) list(q string) []*drive.File {
+	parent := q[1:strings.Index(q, "' in parents")]
+	var name *string
+	if m := fakeGDriveNameRegexp.FindStringSubmatch(q); m != nil {
+		n := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(m[1])
+		name = &n
 	}
-	ks3, _ := newKS3(os.Getenv("KS3_ENDPOINT"),
-		os.Getenv("KS3_ACCESS_KEY"), os.Getenv("KS3_SECRET_KEY"), "")
-	testStorage(t, ks3)
+	var files []*drive.File
+	for _, file := range f.files {
+		if len(file.Parents) == 0 || file.Parents[0] != parent || name != nil && file.Name != *name {
+			continue
+		}
+		isFolder := file.MimeType == gdriveFolderType
+		if strings.Contains(q, "mimeType = ") && !isFolder || strings.Contains(q, "mimeType != ") && isFolder {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedTime < files[j].CreatedTime })
+	return files
 }
 
-func TestCOS(t *testing.T) { //skip mutate
-	if os.Getenv("COS_SECRETID") == "" {
-		t.SkipNow()
+func (f *fakeGDrive) save(file *drive.File, data []byte) *drive.File {
+	if file.Id == "" {
+		file.Id = fmt.Sprintf("id%d", len(f.files))
+		file.CreatedTime = fmt.Sprintf("%09d", len(f.files))
+		f.files[file.Id] = file
 	}
-	cos, _ := newCOS(
+	if file.MimeType == "" {
+		file.MimeType = "application/octet-stream"
+	}
+	file.Size = int64(len(data))
+	file.ModifiedTime = time.Now().UTC().Format(time.RFC3339)
+	f.data[file.Id] = data
+	return file
+}
+
+func (f *fakeGDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	reply := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+	if r.URL.Path == "/token" {
+		f.refreshed++
+		w.Header().Set("Content-Type", "application/json")
+		reply(map[string]interface{}{"access_token": "token", "token_type": "Bearer", "expires_in": 3600})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.throttle {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "Rate limit exceeded", "errors": [{"reason": "userRateLimitExceeded"}]}}`))
+		return
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "File not found"}}`))
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/drive/v3")
+	q := r.URL.Query()
+	switch {
+	case strings.HasPrefix(path, "/session/"): // the chunks of resumable uploads
+		file := f.sessions[path]
+		body, _ := io.ReadAll(r.Body)
+		var start, end int64
+		var total string
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%s", &start, &end, &total); err != nil || start != int64(len(f.data[path])) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := append(f.data[path], body...)
+		if total != "*" {
+			f.resumable++
+			delete(f.sessions, path)
+			delete(f.data, path)
+			reply(f.save(file, data))
+			return
+		}
+		f.data[path] = data
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+		w.Header().Set("X-Http-Status-Code-Override", "308") // asked by X-GUploader-No-308
+	case strings.HasPrefix(path, "/upload/drive/v3/files"):
+		file := &drive.File{}
+		if id := strings.TrimPrefix(path, "/upload/drive/v3/files/"); id != path {
+			if file = f.files[id]; file == nil {
+				notFound()
+				return
+			}
+		}
+		if q.Get("uploadType") == "resumable" {
+			if file.Id == "" {
+				_ = json.NewDecoder(r.Body).Decode(file)
+			}
+			session := fmt.Sprintf("/session/%d", len(f.sessions)+f.resumable)
+			f.sessions[session] = file
+			w.Header().Set("Location", "http://"+r.Host+session)
+			return
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		meta, _ := mr.NextPart()
+		if file.Id == "" {
+			_ = json.NewDecoder(meta).Decode(file)
+		}
+		media, _ := mr.NextPart()
+		data, _ := io.ReadAll(media)
+		reply(f.save(file, data))
+	case path == "/files" && r.Method == http.MethodGet:
+		f.lists++
+		files := f.list(q.Get("q"))
+		var start int
+		if t := q.Get("pageToken"); t != "" {
+			start, _ = strconv.Atoi(t)
+		}
+		size, _ := strconv.Atoi(q.Get("pageSize"))
+		if size == 0 || size > 2 {
+			size = 2 // small pages to follow the pageToken
+		}
+		ret := drive.FileList{Files: files[start:]}
+		if len(ret.Files) > size {
+			ret.Files = ret.Files[:size]
+			ret.NextPageToken = strconv.Itoa(start + size)
+		}
+		reply(ret)
+	case path == "/files" && r.Method == http.MethodPost:
+		var file drive.File
+		_ = json.NewDecoder(r.Body).Decode(&file)
+		reply(f.save(&file, nil))
+	default:
+		id := strings.TrimPrefix(path, "/files/")
+		file := f.files[id]
+		if file == nil {
+			notFound()
+			return
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.files, id)
+			w.WriteHeader(http.StatusNoContent)
+		case q.Get("alt") == "media":
+			data := f.data[id]
+			var start, end int64 = 0, int64(len(data)) - 1
+			if rg := r.Header.Get("Range"); rg != "" {
+				if _, err := fmt.Sscanf(rg, "bytes=%d-%d", &start, &end); err != nil {
+					_, _ = fmt.Sscanf(rg, "bytes=%d-", &start)
+				}
+				if start >= int64(len(data)) {
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				if end >= int64(len(data)) {
+					end = int64(len(data)) - 1
+				}
+				w.WriteHeader(http.StatusPartialContent)
+			}
+			_, _ = w.Write(data[start : end+1])
+		default:
+			reply(file)
+		}
+	}
+}
+
+func TestGDrive(t *testing.T) {
+	f := &fakeGDrive{files: make(map[string]*drive.File), data: make(map[string][]byte), sessions: make(map[string]*drive.File)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	defer func(e, u string) { gdriveEndpoint, gdriveTokenURL = e, u }(gdriveEndpoint, gdriveTokenURL)
+	gdriveEndpoint, gdriveTokenURL = srv.URL+"/drive/v3/", srv.URL+"/token"
+	f.files["root"] = &drive.File{Id: "root", MimeType: gdriveFolderType}
+
+	if _, err := newGDrive("gdrive://", "id", "secret", "refresh"); err == nil {
+		t.Fatalf("the folder should be required")
+	}
+	if _, err := newGDrive("gdrive://juicefs/test", "id", "secret", ""); err == nil {
+		t.Fatalf("the refresh token should be required")
+	}
+	s, err := newGDrive("gdrive://juicefs/test", "id", "secret", "refresh")
+	if err != nil {
+		t.Fatalf("create gdrive: %s", err)
+	}
+	if err = s.Create(); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	if f.refreshed != 1 {
+		t.Fatalf("the access token should be refreshed once: %d", f.refreshed)
+	}
+	if _, err = s.Head("a/b/c"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("head of missing file: %v", err)
+	}
+	keys := []string{"a/b/c", "a/b/it's", "a/B", "a/c", "a/b/d/e", "z"}
+	for _, key := range keys {
+		if err = s.Put(key, bytes.NewReader([]byte("hello "+key))); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+	}
+	if err = s.Put("a/e/", bytes.NewReader(nil)); err != nil {
+		t.Fatalf("put folder: %s", err)
+	}
+	if o, err := s.Head("a/b/it's"); err != nil || o.Size() != int64(len("hello a/b/it's")) || o.IsDir() {
+		t.Fatalf("head: %+v %v", o, err)
+	}
+	if o, err := s.Head("a/e/"); err != nil || !o.IsDir() {
+		t.Fatalf("head folder: %+v %v", o, err)
+	}
+	if d, err := get(s, "a/b/c", 0, -1); err != nil || d != "hello a/b/c" {
+		t.Fatalf("get: %q %v", d, err)
+	}
+	if d, err := get(s, "a/b/c", 6, 3); err != nil || d != "a/b" {
+		t.Fatalf("get range: %q %v", d, err)
+	}
+	if d, err := get(s, "a/b/c", 11, -1); err != nil || d != "" {
+		t.Fatalf("get at the end: %q %v", d, err)
+	}
+
+	// overwritten in place
+	n := len(f.files)
+	if err = s.Put("a/b/c", bytes.NewReader([]byte("world"))); err != nil {
+		t.Fatalf("overwrite: %s", err)
+	}
+	if d, err := get(s, "a/b/c", 0, -1); err != nil || d != "world" || len(f.files) != n {
+		t.Fatalf("get overwritten: %q %v, %d files (%d before)", d, err, len(f.files), n)
+	}
+
+	objs, err := s.List("a/", "", "/", 100, true)
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	if got := listKeys(objs); got != "a/,a/B,a/b/,a/c,a/e/" {
+		t.Fatalf("list a/: %s", got)
+	}
+	if objs, err = s.List("a/", "a/B", "/", 2, true); err != nil || listKeys(objs) != "a/b/,a/c" {
+		t.Fatalf("list after a/B: %s %v", listKeys(objs), err)
+	}
+	flat := "a/,a/B,a/b/,a/b/c,a/b/d/,a/b/d/e,a/b/it's,a/c,a/e/,z"
+	var paged []Object
+	for marker := ""; ; {
+		objs, err := s.List("", marker, "", 3, true)
+		if err != nil {
+			t.Fatalf("list without delimiter: %s", err)
+		}
+		paged = append(paged, objs...)
+		if len(objs) < 3 {
+			break
+		}
+		marker = objs[len(objs)-1].Key()
+	}
+	if got := listKeys(paged); got != flat {
+		t.Fatalf("list without delimiter: %s", got)
+	}
+	if objs, err = s.List("a/b", "a/b/c", "", 100, true); err != nil || listKeys(objs) != "a/b/d/,a/b/d/e,a/b/it's" {
+		t.Fatalf("list a/b after a/b/c: %s %v", listKeys(objs), err)
+	}
+	ch, err := ListAll(s, "", "", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var all []Object
+	for o := range ch {
+		all = append(all, o)
+	}
+	if got := listKeys(all); got != flat {
+		t.Fatalf("list all: %s", got)
+	}
+
+	// the pages of a large folder are listed from the children kept by the previous page
+	for i := 0; i < 20; i++ {
+		_ = s.Put(fmt.Sprintf("many/%02d", i), bytes.NewReader(nil))
+	}
+	lists := f.lists
+	var many []Object
+	for marker := "many/"; ; {
+		objs, err := s.List("many/", marker, "", 5, true)
+		if err != nil {
+			t.Fatalf("list many: %s", err)
+		}
+		many = append(many, objs...)
+		if len(objs) < 5 {
+			break
+		}
+		marker = objs[len(objs)-1].Key()
+	}
+	// 10 pages of files.list (2 files per page) for the first page of List, the others are reused
+	if len(many) != 20 || f.lists-lists != 10 {
+		t.Fatalf("list many: %d objects with %d pages of files.list", len(many), f.lists-lists)
+	}
+
+	// the folders are created once by the concurrent puts
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Put(fmt.Sprintf("new/sub/%d", i), bytes.NewReader(nil)); err != nil {
+				t.Errorf("put: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	var folders int
+	for _, file := range f.files {
+		if file.Name == "new" || file.Name == "sub" {
+			folders++
+		}
+	}
+	if objs, err = s.List("new/sub/", "", "/", 100, true); folders != 2 || err != nil || len(objs) != 11 {
+		t.Fatalf("%d folders created, %d objects listed: %v", folders, len(objs), err)
+	}
+
+	// the stale ID of a file removed by another client
+	s2, _ := newGDrive("gdrive://juicefs/test", "id", "secret", "refresh")
+	if err = s2.Delete("a/c"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if err = s2.Put("a/c", bytes.NewReader([]byte("again"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if d, err := get(s, "a/c", 0, -1); err != nil || d != "again" {
+		t.Fatalf("get the recreated file: %q %v", d, err)
+	}
+
+	if err = s.Delete("a/b/d/"); err == nil {
+		t.Fatalf("non-empty folder should not be deleted")
+	}
+	for _, key := range []string{"a/b/d/e", "a/b/d/", "a/b/d/"} {
+		if err = s.Delete(key); err != nil {
+			t.Fatalf("delete %s: %s", key, err)
+		}
+	}
+	if _, err = s.Head("a/b/d/e"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("head of deleted file: %v", err)
+	}
+
+	// the large objects are uploaded in resumable sessions
+	data := make([]byte, gdriveChunkSize*2+100)
+	_, _ = rand.Read(data)
+	for i := 0; i < 2; i++ {
+		if err = s.Put("big", bytes.NewReader(data)); err != nil {
+			t.Fatalf("put big object: %s", err)
+		}
+	}
+	if d, err := get(s, "big", 0, -1); err != nil || d != string(data) || f.resumable != 2 {
+		t.Fatalf("get big object: %d bytes %v, %d resumable uploads", len(d), err, f.resumable)
+	}
+
+	f.throttle = true
+	if _, err = s.Head("z"); !DefaultShouldRetry(err) || httpStatusCode(err) != http.StatusTooManyRequests {
+		t.Fatalf("rate limit exceeded should be retried: %v", err)
+	}
+}
+
+func TestQiniu(t *testing.T) { //skip mutate
+	if os.Getenv("QINIU_ACCESS_KEY") == "" {
+		t.SkipNow()
+	}
+	qiniu, _ := newQiniu(os.Getenv("QINIU_ENDPOINT"),
+		os.Getenv("QINIU_ACCESS_KEY"), os.Getenv("QINIU_SECRET_KEY"), "")
+	testStorage(t, qiniu)
+	//qiniu, _ = newQiniu("https://test.cn-north-1-s3.qiniu.com",
+	//	os.Getenv("QINIU_ACCESS_KEY"), os.Getenv("QINIU_SECRET_KEY"))
+	//testStorage(t, qiniu)
+}
+
+// fakeKodo serves the APIs of Qiniu Kodo used by kodo for bucket "test", and counts the requests to list in lists.
+func fakeKodo(lists *int) *httptest.Server {
+	var mu sync.Mutex
+	objs := make(map[string]string)
+	markers := make(map[string]string)
+	types := make(map[string]string)
+	parts := make(map[string]map[int]string)
+	entryKey := func(e string) string {
+		b, _ := base64.URLEncoding.DecodeString(e)
+		return strings.TrimPrefix(string(b), "test:")
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch path[0] {
+		case "stat", "delete":
+			key := entryKey(path[1])
+			data, ok := objs[key]
+			if !ok {
+				w.WriteHeader(612)
+				_, _ = w.Write([]byte(`{"error":"no such file or directory"}`))
+			} else if path[0] == "delete" {
+				delete(objs, key)
+			} else {
+				_ = json.NewEncoder(w).Encode(storage.FileInfo{Fsize: int64(len(data)), PutTime: time.Now().UnixNano() / 100, MimeType: types[key]})
+			}
+		case "list":
+			q := r.URL.Query()
+			*lists++
+			after, ok := markers[q.Get("marker")]
+			if !ok && q.Get("marker") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid marker"}`))
+				return
+			}
+			limit, _ := strconv.Atoi(q.Get("limit"))
+			var keys []string
+			for k := range objs {
+				if strings.HasPrefix(k, q.Get("prefix")) && k > after {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			var ret storage.ListFilesRet
+			if len(keys) > limit {
+				keys = keys[:limit]
+				// the markers are opaque to the clients
+				ret.Marker = fmt.Sprintf("m%d", len(markers))
+				markers[ret.Marker] = keys[limit-1]
+			}
+			for _, k := range keys {
+				ret.Items = append(ret.Items, storage.ListItem{Key: k, Fsize: int64(len(objs[k])), MimeType: types[k]})
+			}
+			_ = json.NewEncoder(w).Encode(ret)
+		case "buckets": // the resumable upload: /buckets/test/objects/<key>/uploads[/<id>[/<part>]]
+			key := entryKey(path[3])
+			switch len(path) {
+			case 5:
+				parts[key] = make(map[int]string)
+				_, _ = w.Write([]byte(`{"uploadId":"1"}`))
+			case 7:
+				n, _ := strconv.Atoi(path[6])
+				data, _ := io.ReadAll(r.Body)
+				parts[key][n] = string(data)
+				_, _ = fmt.Fprintf(w, `{"etag":"%d"}`, n)
+			case 6:
+				var complete struct {
+					Parts    []storage.UploadPartInfo `json:"parts"`
+					MimeType string                   `json:"mimeType"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&complete)
+				var data string
+				for _, p := range complete.Parts {
+					data += parts[key][int(p.PartNumber)]
+				}
+				objs[key], types[key] = data, complete.MimeType
+				_, _ = fmt.Fprintf(w, `{"key":%q}`, key)
+			}
+		default: // download through the bound domain
+			if r.URL.Query().Get("token") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			data, ok := objs[strings.TrimPrefix(r.URL.Path, "/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, "", time.Now(), strings.NewReader(data))
+		}
+	}))
+}
+
+func TestKodo(t *testing.T) {
+	var lists int
+	srv := fakeKodo(&lists)
+	defer srv.Close()
+	t.Setenv("QINIU_DOMAIN", "")
+	if _, err := newKodo("http://test.z0", "ak", "sk", ""); err == nil {
+		t.Fatalf("the download domain should be required")
+	}
+	if _, err := newKodo("http://test.mars?domain="+srv.URL, "ak", "sk", ""); err == nil {
+		t.Fatalf("unknown region should fail")
+	}
+	s, err := newKodo("http://test.z0?domain="+srv.URL, "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create kodo: %s", err)
+	}
+	if s.String() != "kodo://test/" {
+		t.Fatalf("description: %s", s)
+	}
+	s.(*kodo).cfg.Zone = &storage.Region{RsHost: srv.URL, RsfHost: srv.URL, SrcUpHosts: []string{srv.URL}}
+
+	if err = s.Put("a/b.txt", bytes.NewReader([]byte("hello world"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if err = s.Put("a/c", io.LimitReader(strings.NewReader("stream"), 6)); err != nil {
+		t.Fatalf("put a stream: %s", err)
+	}
+	_ = s.Put("d", bytes.NewReader(nil), WithContentType("text/html"))
+	if d, err := get(s, "a/b.txt", 6, 3); err != nil || d != "wor" {
+		t.Fatalf("get range: %q, %v", d, err)
+	}
+	if d, err := get(s, "a/b.txt", 6, -1); err != nil || d != "world" {
+		t.Fatalf("get to the end: %q, %v", d, err)
+	}
+	if _, err = s.Get("missing", 0, -1); !os.IsNotExist(err) {
+		t.Fatalf("get missing object: %v", err)
+	}
+	o, err := s.Head("a/b.txt")
+	if co, ok := o.(ObjectWithContentType); err != nil || o.Size() != 11 || !ok || co.ContentType() != "text/plain; charset=utf-8" {
+		t.Fatalf("head: %+v, %v", o, err)
+	}
+	if _, err = s.Head("missing"); !os.IsNotExist(err) {
+		t.Fatalf("head missing object: %v", err)
+	}
+	if objs, err := s.List("", "a/b.txt", "", 1, false); err != nil || listKeys(objs) != "a/c" {
+		t.Fatalf("list after a marker: %+v, %v", objs, err)
+	}
+	// the next page is listed from the marker of Kodo where the previous one stopped
+	objs, err := s.List("", "", "", 1, false)
+	if err != nil || listKeys(objs) != "a/b.txt" {
+		t.Fatalf("list the first page: %+v, %v", objs, err)
+	}
+	lists = 0
+	if objs, err = s.List("", "a/b.txt", "", 1, false); err != nil || listKeys(objs) != "a/c" || lists != 1 {
+		t.Fatalf("list the next page: %+v, %v after %d requests", objs, err, lists)
+	}
+	if err = s.Delete("a/b.txt"); err != nil {
+		t.Fatalf("delete: %s", err)
+	}
+	if err = s.Delete("a/b.txt"); err != nil {
+		t.Fatalf("delete missing object: %s", err)
+	}
+	if objs, err := listAll(s, "", "", 10, true); err != nil || len(objs) != 2 || objs[1].Key() != "d" {
+		t.Fatalf("list all: %+v, %v", objs, err)
+	}
+}
+
+func TestKS3(t *testing.T) { //skip mutate
+	if os.Getenv("KS3_ACCESS_KEY") == "" {
+		t.SkipNow()
+	}
+	ks3, _ := newKS3(os.Getenv("KS3_ENDPOINT"),
+		os.Getenv("KS3_ACCESS_KEY"), os.Getenv("KS3_SECRET_KEY"), "")
+	testStorage(t, ks3)
+}
+
+func TestCOS(t *testing.T) { //skip mutate
+	if os.Getenv("COS_SECRETID") == "" {
+		t.SkipNow()
+	}
+	cos, _ := newCOS(
 		os.Getenv("COS_ENDPOINT"),
 		os.Getenv("COS_SECRETID"), os.Getenv("COS_SECRETKEY"), "")
 	testStorage(t, cos)
 }
 
-func TestAzure(t *testing.T) { //skip mutate
-	if os.Getenv("AZURE_STORAGE_ACCOUNT") == "" {
-		t.SkipNow()
+func TestCOSBucketName(t *testing.T) {
+	for _, c := range []struct {
+		bucket, name string
+		match        bool
+	}{
+		{"data-1250000000", "data-1250000000", true},
+		{"data-1250000000", "data", true},
+		{"my-data-1250000000", "my-data", true},
+		{"my-data-1250000000", "my", false},
+		{"my-data", "my", false},
+		{"data-1250000000", "data-125", false},
+		{"-1250000000", "", false},
+	} {
+		if cosBucketMatches(c.bucket, c.name) != c.match {
+			t.Fatalf("match %s with %s should be %v", c.bucket, c.name, c.match)
+		}
+	}
+	s, err := newCOS("https://my-data-1250000000.cos.ap-guangzhou.myqcloud.com", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create cos: %s", err)
+	}
+	if name := s.(SupportBucketName).BucketName(); name != "my-data-1250000000" {
+		t.Fatalf("bucket name: %s", name)
+	}
+	if s.String() != "cos://my-data-1250000000/" {
+		t.Fatalf("description: %s", s)
+	}
+}
+
+func TestAzure(t *testing.T) { //skip mutate
+	if os.Getenv("AZURE_STORAGE_ACCOUNT") == "" {
+		t.SkipNow()
+	}
+	//https://containersName.core.windows.net
+	abs, _ := newWasb(os.Getenv("AZURE_ENDPOINT"),
+		os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), "")
+	testStorage(t, abs)
+}
+
+// fakeDFS serves the paths of the Data Lake filesystem API, the directories are listed in pages of 3 paths.
+func fakeDFS(paths map[string]bool, lists *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/test"), "/")
+		mtime := "Mon, 02 Jan 2006 15:04:05 GMT"
+		if r.Method == http.MethodHead {
+			isDir, ok := paths[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if isDir {
+				w.Header().Set("x-ms-resource-type", "directory")
+			}
+			w.Header().Set("Last-Modified", mtime)
+			return
+		}
+		*lists++
+		dir := r.URL.Query().Get("directory")
+		if isDir, ok := paths[dir]; dir != "" && (!ok || !isDir) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var children []dfsPath
+		for p, isDir := range paths {
+			if parent := p[:strings.LastIndexByte(p, '/')+1]; parent == dir+"/" || dir == "" && parent == "" {
+				children = append(children, dfsPath{Name: p, IsDirectory: fmt.Sprint(isDir), ContentLength: "1", LastModified: mtime})
+			}
+		}
+		sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+		start, _ := strconv.Atoi(r.URL.Query().Get("continuation"))
+		end := start + 3
+		if end >= len(children) {
+			end = len(children)
+		} else {
+			w.Header().Set("x-ms-continuation", strconv.Itoa(end))
+		}
+		_ = json.NewEncoder(w).Encode(map[string][]dfsPath{"paths": children[start:end]})
+	}
+}
+
+func TestAbfsList(t *testing.T) {
+	paths := map[string]bool{"a": true, "a/b": true, "c": true, "a-b": false, "a/x": false, "a/b/y": false, "a/b/z": false, "a0": false, "c/d": false, "e": false}
+	var lists int
+	srv := httptest.NewServer(fakeDFS(paths, &lists))
+	defer srv.Close()
+	s := &abfs{wasb: &wasb{cName: "test", hc: srv.Client(), ctx: ctx}, endpoint: srv.URL, accountName: "account", accountKey: []byte("key")}
+
+	all := []string{"a-b", "a/", "a/b/", "a/b/y", "a/b/z", "a/x", "a0", "c/", "c/d", "e"}
+	objs, err := s.List("", "", "", 100, true)
+	if err != nil || listKeys(objs) != strings.Join(all, ",") {
+		t.Fatalf("list all: %s %v", listKeys(objs), err)
+	}
+	var keys []string
+	for marker := ""; ; {
+		objs, err := s.List("", marker, "", 3, true)
+		if err != nil {
+			t.Fatalf("list after %s: %s", marker, err)
+		}
+		for _, o := range objs {
+			keys = append(keys, o.Key())
+		}
+		if len(objs) < 3 {
+			break
+		}
+		marker = objs[len(objs)-1].Key()
+	}
+	if strings.Join(keys, ",") != strings.Join(all, ",") {
+		t.Fatalf("list in pages: %s", keys)
+	}
+	if objs, err = s.List("a/", "", "", 0, true); err != nil || listKeys(objs) != "a/,a/b/,a/b/y,a/b/z,a/x" {
+		t.Fatalf("list a/: %s %v", listKeys(objs), err)
+	}
+	if objs, err = s.List("a", "", "/", 10, true); err != nil || listKeys(objs) != "a-b,a/,a0" {
+		t.Fatalf("list with delimiter: %s %v", listKeys(objs), err)
+	}
+	if _, err = s.List("", "", "-", 10, true); !errors.Is(err, notSupported) {
+		t.Fatalf("other delimiters should not be supported: %v", err)
+	}
+
+	lists = 0
+	ch, err := ListAll(s, "", "a/b/y", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	keys = keys[:0]
+	for o := range ch {
+		if o == nil {
+			t.Fatalf("list all failed")
+		}
+		keys = append(keys, o.Key())
+	}
+	checkListed(t, keys, all[4:])
+	if lists != 5 { // the root (in 2 pages), a, a/b and c
+		t.Fatalf("every directory after marker should be listed once: %d", lists)
+	}
+}
+
+func TestAzureStorageClass(t *testing.T) {
+	b := &wasb{}
+	if err := b.SetStorageClass("cool"); err != nil || b.sc != string(blob2.AccessTierCool) {
+		t.Fatalf("set storage class cool: %v, got %q", err, b.sc)
+	}
+	if err := b.SetStorageClass("Frozen"); err == nil {
+		t.Fatalf("set invalid storage class should fail")
+	}
+	if b.sc != string(blob2.AccessTierCool) {
+		t.Fatalf("invalid storage class should not change the current one: %q", b.sc)
+	}
+}
+
+func TestAzureMetaKey(t *testing.T) {
+	for k, expected := range map[string]string{"source": "source", "retention-policy": "retention_policy", "_x.y": "_x_y"} {
+		if nk, err := normalizeMetaKey(k); err != nil || nk != expected {
+			t.Fatalf("normalize %q: expect %q, got %q (%v)", k, expected, nk, err)
+		}
+	}
+	for _, k := range []string{"", "1abc", "a b", "中文"} {
+		if _, err := normalizeMetaKey(k); err == nil {
+			t.Fatalf("normalize %q should fail", k)
+		}
+	}
+}
+
+func TestAzureUploadPart(t *testing.T) {
+	var committed string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			sum := md5.Sum(body)
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		case "blocklist":
+			committed = string(body)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	s, err := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	up, _ := s.CreateMultipartUpload("k")
+	var parts []*Part
+	for i, data := range []string{"hello", "world"} {
+		p, err := s.UploadPart("k", up.UploadID, i+1, []byte(data))
+		if err != nil || p.ETag != fmt.Sprintf("%x", md5.Sum([]byte(data))) {
+			t.Fatalf("the ETag of part %d should be the MD5 of it: %+v %v", i+1, p, err)
+		}
+		parts = append(parts, p)
+	}
+	if err = s.CompleteUpload("k", up.UploadID, []*Part{parts[1], parts[0]}); err != nil {
+		t.Fatalf("complete: %s", err)
+	}
+	if !strings.Contains(committed, blockID(up.UploadID, 1)+"</Latest><Latest>"+blockID(up.UploadID, 2)) {
+		t.Fatalf("the blocks should be committed by their numbers: %s", committed)
+	}
+}
+
+func TestAzureSASWithAccountKey(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "sv=1&sig=1")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	if _, err := newWasb("http://test.core.windows.net", "account", "a2V5", ""); err == nil || !strings.Contains(err.Error(), "the SAS token takes precedence, unset the account key") {
+		t.Fatalf("SAS token with account key should be rejected: %v", err)
+	}
+}
+
+func TestAzureDeleteLocked(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := strings.TrimPrefix(r.URL.Path, "/test/")
+		w.Header().Set("x-ms-error-code", code)
+		if code == string(bloberror.BlobNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	s, err := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	for _, code := range []bloberror.Code{bloberror.BlobImmutableDueToPolicy, blobImmutableDueToLegalHold} {
+		if err = s.Delete(string(code)); !errors.Is(err, ErrObjectLocked) {
+			t.Fatalf("delete with %s: %v", code, err)
+		}
+	}
+	if err = s.Delete(string(bloberror.BlobNotFound)); err != nil {
+		t.Fatalf("delete missing blob: %s", err)
+	}
+}
+
+func TestAzureImmutability(t *testing.T) { //skip mutate
+	// the container must have version-level immutability enabled
+	if os.Getenv("AZURE_IMMUTABLE_CONTAINER") == "" {
+		t.SkipNow()
+	}
+	s, err := newWasb(os.Getenv("AZURE_IMMUTABLE_CONTAINER"), os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	b := s.(*wasb)
+	key := fmt.Sprintf("locked-%d", time.Now().UnixNano())
+	if err = b.Put(key, bytes.NewReader([]byte("worm"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	until := time.Now().Add(time.Minute)
+	if err = b.SetImmutabilityPolicy(key, until, false); err != nil {
+		t.Fatalf("set immutability policy: %s", err)
+	}
+	if err = b.Delete(key); !errors.Is(err, ErrObjectLocked) {
+		t.Fatalf("delete of a locked blob should fail with ErrObjectLocked: %v", err)
+	}
+	time.Sleep(time.Until(until) + 5*time.Second)
+	if err = b.Delete(key); err != nil {
+		t.Fatalf("delete after retention expired: %s", err)
+	}
+
+	if err = b.Put(key, bytes.NewReader([]byte("hold"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if err = b.SetLegalHold(key, true); err != nil {
+		t.Fatalf("set legal hold: %s", err)
+	}
+	if err = b.Delete(key); !errors.Is(err, ErrObjectLocked) {
+		t.Fatalf("delete of a blob under legal hold should fail with ErrObjectLocked: %v", err)
+	}
+	if err = b.SetLegalHold(key, false); err != nil {
+		t.Fatalf("clear legal hold: %s", err)
+	}
+	if err = b.Delete(key); err != nil {
+		t.Fatalf("delete after legal hold cleared: %s", err)
+	}
+}
+
+func TestAzureTags(t *testing.T) { //skip mutate
+	if os.Getenv("AZURE_STORAGE_ACCOUNT") == "" {
+		t.SkipNow()
+	}
+	s, _ := newWasb(os.Getenv("AZURE_ENDPOINT"),
+		os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), "")
+	b := s.(*wasb)
+	key := "tagged"
+	if err := b.Put(key, bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	defer b.Delete(key)
+	value := fmt.Sprintf("v%d", time.Now().UnixNano())
+	tags := map[string]string{"juicefs_test": value, "env": "test"}
+	if err := b.SetTags(key, tags); err != nil {
+		t.Fatalf("set tags: %s", err)
+	}
+	if got, err := b.GetTags(key); err != nil || !reflect.DeepEqual(got, tags) {
+		t.Fatalf("get tags: %+v %v", got, err)
+	}
+	// the tag index is updated asynchronously
+	where := fmt.Sprintf(`@container='%s' AND "juicefs_test"='%s'`, b.cName, value)
+	for i := 0; ; i++ {
+		resp, err := b.azblobCli.ServiceClient().FilterBlobs(ctx, where, nil)
+		if err != nil {
+			t.Fatalf("filter blobs: %s", err)
+		}
+		if len(resp.Blobs) == 1 && *resp.Blobs[0].Name == key {
+			break
+		}
+		if i == 30 {
+			t.Fatalf("blob %s is not found by tags: %+v", key, resp.Blobs)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func TestAzureValidateTags(t *testing.T) {
+	cases := []struct {
+		tags  map[string]string
+		valid bool
+	}{
+		{map[string]string{"project": "juicefs", "path": "a/b.c=1:2 3+4_5-6"}, true},
+		{map[string]string{"empty": ""}, true},
+		{map[string]string{"": "v"}, false},
+		{map[string]string{"k": "v!"}, false},
+		{map[string]string{"key#": "v"}, false},
+		{map[string]string{strings.Repeat("k", 129): "v"}, false},
+		{map[string]string{"k": strings.Repeat("v", 257)}, false},
+	}
+	for _, c := range cases {
+		if err := validateTags(c.tags); (err == nil) != c.valid {
+			t.Fatalf("validate %+v: %v", c.tags, err)
+		}
+	}
+	tags := make(map[string]string)
+	for i := 0; i <= wasbMaxTags; i++ {
+		tags[fmt.Sprintf("k%d", i)] = "v"
+	}
+	if err := validateTags(tags); err == nil {
+		t.Fatalf("more than %d tags should be rejected", wasbMaxTags)
+	}
+}
+
+func TestAzureNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/forbidden") {
+			w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx}
+
+	if _, err = s.Head("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("head of missing blob should return ErrNotFound: %v", err)
+	}
+	if _, err = s.Get("missing", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get of missing blob should return ErrNotFound: %v", err)
+	}
+	if err = s.Delete("missing"); err != nil {
+		t.Fatalf("delete of missing blob should succeed: %v", err)
+	}
+	if _, err = s.Head("forbidden"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("head of forbidden blob should not return ErrNotFound: %v", err)
+	}
+}
+
+func TestAzureRetry(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("x-ms-error-code", "ServerBusy")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, maxRetries: 2}
+	if _, err = s.Head("busy"); err == nil {
+		t.Fatalf("head should fail")
+	}
+	// the SDK doesn't retry, otherwise the attempts multiply
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expect 3 requests, got %d", n)
+	}
+}
+
+func TestBucketName(t *testing.T) {
+	b := &wasb{cName: "container"}
+	s := WithPrefix(WithMetrics(WithRateLimit(b, 10, 0), prometheus.NewRegistry()), "prefix/")
+	if name := BucketName(s); name != "container" {
+		t.Fatalf("bucket name of %s should be container, but got %q", s, name)
+	}
+	m, _ := newMem("", "", "", "")
+	if name := BucketName(WithPrefix(m, "p/")); name != "" {
+		t.Fatalf("mem should not have bucket name, but got %q", name)
+	}
+	if name := BucketName(&minio{s3client{bucket: "bucket"}}); name != "bucket" {
+		t.Fatalf("bucket name of minio should be bucket, but got %q", name)
+	}
+}
+
+func TestPrefixSharedBucket(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	v1, v2 := WithPrefix(m, "vol1/"), WithPrefix(m, "vol2/")
+	for _, k := range []string{"a", "b", "c"} {
+		_ = v1.Put(k, bytes.NewReader([]byte(k)))
+		_ = v2.Put(k, bytes.NewReader([]byte("2"+k)))
+	}
+	_ = m.Put("v", bytes.NewReader(nil))
+	if d, _ := get(v2, "b", 0, -1); d != "2b" {
+		t.Fatalf("get b of vol2: %q", d)
+	}
+	if o, err := v1.Head("b"); err != nil || o.Key() != "b" {
+		t.Fatalf("head b of vol1: %+v %v", o, err)
+	}
+	objs, err := v1.List("", "", "", 2, true)
+	if err != nil || listKeys(objs) != "a,b" {
+		t.Fatalf("list of vol1: %s %v", listKeys(objs), err)
+	}
+	objs, err = v1.List("", objs[len(objs)-1].Key(), "", 2, true)
+	if err != nil || listKeys(objs) != "c" {
+		t.Fatalf("list of vol1 from marker: %s %v", listKeys(objs), err)
+	}
+
+	up1, _ := v1.CreateMultipartUpload("x")
+	_, _ = v2.CreateMultipartUpload("y")
+	_, _ = m.CreateMultipartUpload("z")
+	ups, _, err := v1.ListUploads("")
+	if err != nil || len(ups) != 1 || ups[0].Key != "x" || ups[0].UploadID != up1.UploadID {
+		t.Fatalf("uploads of vol1: %+v %v", ups, err)
+	}
+}
+
+func TestAzurePutIfNotExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.Header.Get("If-None-Match") != "*" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/new") {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, disableChecksum: true}
+
+	if err = PutIfNotExists(s, "new", bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatalf("put new blob: %s", err)
+	}
+	if err = PutIfNotExists(WithPrefix(s, "p/"), "lock", bytes.NewReader([]byte("a"))); !errors.Is(err, ErrExists) {
+		t.Fatalf("put existing blob should return ErrExists: %v", err)
+	}
+}
+
+func TestAzureContentType(t *testing.T) {
+	var mu sync.Mutex
+	types := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "0")
+			w.Header().Set("Content-Type", "text/html")
+			return
+		}
+		mu.Lock()
+		types[r.URL.Path] = r.Header.Get("x-ms-blob-content-type")
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, disableChecksum: true}
+	_ = s.Put("a.html", bytes.NewReader([]byte("a")))
+	_ = s.Put("b.html", bytes.NewReader([]byte("b")), WithContentType("text/plain"))
+	_ = s.Put("c", io.LimitReader(strings.NewReader("c"), 1)) // uploaded as a stream
+	_ = s.CompleteUpload("d.txt", "1", nil)
+	for key, ct := range map[string]string{"a.html": "text/html; charset=utf-8", "b.html": "text/plain", "c": "application/octet-stream", "d.txt": "text/plain; charset=utf-8"} {
+		if types["/test/"+key] != ct {
+			t.Fatalf("content type of %s: %q, expected %q", key, types["/test/"+key], ct)
+		}
+	}
+	o, err := s.Head("a.html")
+	if co, ok := o.(ObjectWithContentType); err != nil || !ok || co.ContentType() != "text/html" {
+		t.Fatalf("head should return the content type: %+v, %v", o, err)
+	}
+
+	s.disableContentType = true
+	_ = s.Put("e.html", bytes.NewReader([]byte("e")))
+	_ = s.Put("f.html", bytes.NewReader([]byte("f")), WithContentType("text/plain"))
+	_ = s.CompleteUpload("g.txt", "1", nil)
+	if types["/test/e.html"] != "" || types["/test/f.html"] != "text/plain" || types["/test/g.txt"] != "" {
+		t.Fatalf("content type should not be inferred: %+v", types)
+	}
+}
+
+func TestAzureCopyWithOptions(t *testing.T) {
+	var mu sync.Mutex
+	var reqs []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("x-ms-meta-owner", "a")
+			w.Header().Set("x-ms-tag-count", "1")
+		case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "tags":
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><Tags><TagSet><Tag><Key>env</Key><Value>prod</Value></Tag></TagSet></Tags>`))
+		default:
+			mu.Lock()
+			reqs = append(reqs, r)
+			mu.Unlock()
+			if r.Header.Get("x-ms-copy-source") != "" {
+				w.Header().Set("x-ms-copy-status", "success")
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, sasToken: "sig=1", maxRetries: 1}
+	if err = s.Copy("b", "a"); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+	if len(reqs) != 1 || reqs[0].Header.Get("x-ms-tags") != "env=prod" || reqs[0].Header.Get("x-ms-meta-owner") != "" {
+		t.Fatalf("copy should keep the metadata and the tags: %+v", reqs)
+	}
+
+	reqs = nil
+	err = CopyWithOptions(s, "c", "a", CopyOptions{
+		MetadataDirective: MetadataReplace,
+		Metadata:          map[string]string{"owner-id": "b"},
+		Tags:              map[string]string{"env": "test"},
+		ContentType:       "text/plain",
+	})
+	if err != nil {
+		t.Fatalf("copy with replace: %s", err)
+	}
+	if len(reqs) != 2 || reqs[0].Header.Get("x-ms-meta-owner_id") != "b" || reqs[0].Header.Get("x-ms-tags") != "env=test" {
+		t.Fatalf("copy should replace the metadata and the tags: %+v", reqs)
+	}
+	if h := reqs[1].Header; reqs[1].URL.Query().Get("comp") != "properties" || h.Get("x-ms-blob-content-type") != "text/plain" || h.Get("x-ms-blob-content-encoding") != "gzip" {
+		t.Fatalf("content type should be replaced: %+v", reqs[1])
+	}
+
+	reqs = nil
+	if err = CopyWithOptions(s, "d", "a", CopyOptions{MetadataDirective: MetadataReplace}); err != nil {
+		t.Fatalf("copy with replace: %s", err)
+	}
+	if len(reqs) != 2 || reqs[1].URL.Query().Get("comp") != "metadata" || reqs[1].Header.Get("x-ms-meta-owner") != "" {
+		t.Fatalf("the copied metadata should be cleared: %+v", reqs)
+	}
+	if err = CopyWithOptions(s, "e", "a", CopyOptions{MetadataDirective: MetadataReplace, Metadata: map[string]string{"1a": "b"}}); err == nil {
+		t.Fatalf("invalid metadata key should fail")
+	}
+}
+
+func TestAzureConcurrentCreate(t *testing.T) {
+	var mu sync.Mutex
+	var deleting, notReady, created = 3, 2, 0
+	var denied bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		fail := func(status int, code bloberror.Code) {
+			w.Header().Set("x-ms-error-code", string(code))
+			w.WriteHeader(status)
+		}
+		switch {
+		case denied:
+			fail(http.StatusForbidden, bloberror.AuthorizationFailure)
+		case r.Method == http.MethodPut && deleting > 0: // the container was just deleted
+			deleting--
+			fail(http.StatusConflict, bloberror.ContainerBeingDeleted)
+		case r.Method == http.MethodPut && created > 0:
+			fail(http.StatusConflict, bloberror.ContainerAlreadyExists)
+		case r.Method == http.MethodPut:
+			created++
+			w.WriteHeader(http.StatusCreated)
+		case created == 0 || notReady > 0:
+			notReady--
+			fail(http.StatusNotFound, bloberror.ContainerNotFound)
+		default:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		}
+	}))
+	defer srv.Close()
+	defer func(wait, poll time.Duration) { wasbContainerWait, wasbContainerPoll = wait, poll }(wasbContainerWait, wasbContainerPoll)
+	wasbContainerWait, wasbContainerPoll = time.Second, time.Millisecond*10
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, maxRetries: 1}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Create()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("create %d: %s", i, err)
+		}
+	}
+	if created != 1 || deleting != 0 {
+		t.Fatalf("the container should be created once after deleted: created %d, deleting %d", created, deleting)
+	}
+
+	deleting = 1 << 20
+	if err := s.Create(); err == nil || !strings.Contains(err.Error(), "not usable") {
+		t.Fatalf("create should time out when the container is always being deleted: %v", err)
+	}
+	denied = true
+	start := time.Now()
+	if err := s.Create(); !bloberror.HasCode(err, bloberror.AuthorizationFailure) || time.Since(start) > wasbContainerWait/2 {
+		t.Fatalf("other errors should fail immediately: %v", err)
+	}
+}
+
+func TestAzurePutWithExpiry(t *testing.T) {
+	var mu sync.Mutex
+	expiries := make(map[string]string)
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead:
+			w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("x-ms-expiry-time", "Fri, 23 Dec 2033 00:00:00 GMT")
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Query().Get("comp") == "expiry":
+			if strings.HasSuffix(r.URL.Path, "/flat") {
+				w.Header().Set("x-ms-error-code", "HierarchicalNamespaceNotEnabled")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			expiries[r.URL.Path] = r.Header.Get("x-ms-expiry-option") + " " + r.Header.Get("x-ms-expiry-time")
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	s := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, maxRetries: 1}
+	expiry := time.Date(2033, 12, 23, 0, 0, 0, 0, time.UTC)
+	if err = PutWithExpiry(WithPrefix(s, "p/"), "a", bytes.NewReader([]byte("a")), expiry); err != nil {
+		t.Fatalf("put with expiry: %s", err)
+	}
+	if e := expiries["/test/p/a"]; e != "Absolute Fri, 23 Dec 2033 00:00:00 GMT" {
+		t.Fatalf("expiry of blob: %q", e)
+	}
+	if err = PutWithExpiry(s, "flat", bytes.NewReader([]byte("a")), expiry); err == nil {
+		t.Fatalf("put with expiry should fail without hierarchical namespace")
+	}
+	if len(deleted) != 1 || deleted[0] != "/test/flat" {
+		t.Fatalf("the blob without expiry should be deleted: %v", deleted)
+	}
+	o, err := WithPrefix(s, "p/").Head("a")
+	if eo, ok := o.(ObjectWithExpiry); err != nil || !ok || !eo.Expiry().Equal(expiry) || o.Key() != "a" {
+		t.Fatalf("head should return the expiry: %+v %v", o, err)
+	}
+	if _, ok := o.(ObjectWithContentType); !ok {
+		t.Fatalf("head should return the content type")
+	}
+}
+
+func TestAzureExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/denied"):
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+			w.WriteHeader(http.StatusForbidden)
+		case strings.HasPrefix(r.URL.Path, "/nocontainer/"):
+			w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/missing"):
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	newContainer := func(name string) ObjectStorage {
+		return &wasb{container: client.ServiceClient().NewContainerClient(name), azblobCli: client, cName: name, ctx: ctx, maxRetries: 1}
+	}
+	s := newContainer("test")
+	if ok, err := Exists(WithPrefix(s, "p/"), "key"); !ok || err != nil {
+		t.Fatalf("key should exist: %v %s", ok, err)
+	}
+	if ok, err := Exists(s, "missing"); ok || err != nil {
+		t.Fatalf("missing key should not exist: %v %s", ok, err)
+	}
+	if _, err := Exists(s, "denied"); err == nil {
+		t.Fatalf("auth failure should be returned")
+	}
+	if _, err := Exists(newContainer("nocontainer"), "key"); err == nil {
+		t.Fatalf("missing container should be returned")
+	}
+}
+
+func TestCopyWithOptions(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	_ = m.Put("p/a", bytes.NewReader([]byte("a")))
+	s := WithPrefix(m, "p/")
+	if err := CopyWithOptions(s, "b", "a", CopyOptions{}); err != nil {
+		t.Fatalf("copy: %s", err)
+	}
+	if d, err := get(m, "p/b", 0, -1); err != nil || d != "a" {
+		t.Fatalf("copied: %q %s", d, err)
+	}
+	if err := CopyWithOptions(s, "c", "a", CopyOptions{MetadataDirective: MetadataReplace}); err != notSupported {
+		t.Fatalf("replace should not be supported: %v", err)
+	}
+	if err := CopyWithOptions(s, "c", "a", CopyOptions{MetadataDirective: "MOVE"}); err == nil {
+		t.Fatalf("invalid directive should fail")
+	}
+}
+
+func TestPutWithExpiry(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	if err := PutWithExpiry(m, "a", bytes.NewReader([]byte("a")), time.Now().Add(time.Hour)); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expiry should not be supported: %v", err)
+	}
+	if err := PutWithExpiry(m, "a", bytes.NewReader([]byte("a")), time.Time{}); err != nil {
+		t.Fatalf("put without expiry: %s", err)
+	}
+	if err := PutWithExpiry(WithReadOnly(m), "a", bytes.NewReader([]byte("a")), time.Now().Add(time.Hour)); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("put to read-only storage: %v", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	_ = m.Put("p/a", bytes.NewReader(nil))
+	if ok, err := Exists(WithPrefix(m, "p/"), "a"); !ok || err != nil {
+		t.Fatalf("a should exist: %v %s", ok, err)
+	}
+	if ok, err := Exists(m, "a"); ok || err != nil {
+		t.Fatalf("a should not exist: %v %s", ok, err)
+	}
+}
+
+func TestPutIfNotExists(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	// hide PutIfNotExists of mem to test the fallback
+	for _, s := range []ObjectStorage{m, struct{ ObjectStorage }{m}} {
+		_ = m.Delete("lock")
+		if err := PutIfNotExists(s, "lock", bytes.NewReader([]byte("1"))); err != nil {
+			t.Fatalf("put lock: %s", err)
+		}
+		if err := PutIfNotExists(s, "lock", bytes.NewReader([]byte("2"))); !errors.Is(err, ErrExists) {
+			t.Fatalf("put existing lock should return ErrExists: %v", err)
+		}
+		in, _ := m.Get("lock", 0, -1)
+		if d, _ := io.ReadAll(in); string(d) != "1" {
+			t.Fatalf("lock should not be overwritten: %s", d)
+		}
+	}
+}
+
+// flatList doesn't support delimiter in List.
+type flatList struct{ ObjectStorage }
+
+func (f flatList) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if delimiter != "" {
+		return nil, notSupported
+	}
+	return f.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+}
+
+func TestListWithDelimiter(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	for _, k := range []string{"p/a", "p/b/1", "p/b/2", "p/c", "p/d/e/1", "p/f"} {
+		_ = m.Put(k, bytes.NewReader(nil))
+	}
+	for _, s := range []ObjectStorage{m, flatList{m}} {
+		objs, prefixes, err := ListWithDelimiter(s, "p/", "/", "", 10)
+		if err != nil || listKeys(objs) != "p/a,p/c,p/f" || strings.Join(prefixes, ",") != "p/b/,p/d/" {
+			t.Fatalf("list of %T: %s %v %v", s, listKeys(objs), prefixes, err)
+		}
+		objs, prefixes, err = ListWithDelimiter(s, "p/", "/", "p/a", 2)
+		if err != nil || listKeys(objs) != "p/c" || strings.Join(prefixes, ",") != "p/b/" {
+			t.Fatalf("list of %T after p/a: %s %v %v", s, listKeys(objs), prefixes, err)
+		}
+		objs, prefixes, err = ListWithDelimiter(s, "p/", "/", "p/b/", 10)
+		if err != nil || listKeys(objs) != "p/c,p/f" || strings.Join(prefixes, ",") != "p/d/" {
+			t.Fatalf("list of %T after p/b/: %s %v %v", s, listKeys(objs), prefixes, err)
+		}
+		objs, prefixes, err = ListWithDelimiter(WithPrefix(s, "p/"), "", "/", "", 10)
+		if err != nil || listKeys(objs) != "a,c,f" || strings.Join(prefixes, ",") != "b/,d/" {
+			t.Fatalf("list of %T with prefix: %s %v %v", s, listKeys(objs), prefixes, err)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("delimiter") != "/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`<ListBucketResult><Name>test</Name>
+<Contents><Key>p/a</Key><Size>1</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>
+<CommonPrefixes><Prefix>p/b/</Prefix></CommonPrefixes>
+</ListBucketResult>`))
+	}))
+	defer srv.Close()
+	s, err := newS3(srv.URL+"/test", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	objs, prefixes, err := ListWithDelimiter(s, "p/", "/", "", 10)
+	if err != nil || listKeys(objs) != "p/a" || strings.Join(prefixes, ",") != "p/b/" {
+		t.Fatalf("list of s3: %s %v %v", listKeys(objs), prefixes, err)
+	}
+	if objs, err := s.List("p/", "", "/", 10, false); err != nil || listKeys(objs) != "p/a,p/b/" {
+		t.Fatalf("list of s3 with delimiter: %s %v", listKeys(objs), err)
+	}
+}
+
+// failedDeletes fails to delete the keys in failed.
+type failedDeletes struct {
+	ObjectStorage
+	failed map[string]bool
+}
+
+func (f *failedDeletes) Delete(key string, getters ...AttrGetter) error {
+	if f.failed[key] {
+		return errBroken
+	}
+	return f.ObjectStorage.Delete(key, getters...)
+}
+
+func TestDirMarker(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	c, err := WithCompression(WithPrefix(m, "p/"), "lz4")
+	if err != nil {
+		t.Fatalf("with compression: %s", err)
+	}
+	s := WithEncryption(c, []byte("key"))
+	if err := MkdirMarker(s, "a"); err != nil {
+		t.Fatalf("mkdir marker: %s", err)
+	}
+	if err := MkdirMarker(s, "a/b/"); err != nil {
+		t.Fatalf("mkdir marker: %s", err)
+	}
+	if err := MkdirMarker(s, ""); err == nil {
+		t.Fatalf("marker of root should fail")
+	}
+	for _, key := range []string{"p/a/", "p/a/b/"} {
+		if o, err := m.Head(key); err != nil || o.Size() != 0 || !o.IsDir() {
+			t.Fatalf("marker %s should be empty: %+v %v", key, o, err)
+		}
+	}
+	_ = s.Put("a/1", bytes.NewReader([]byte("1")))
+	objs, err := s.List("a/", "", "", 10, false)
+	if err != nil || listKeys(objs) != "a/,a/1,a/b/" || !objs[0].IsDir() || objs[1].IsDir() || !objs[2].IsDir() {
+		t.Fatalf("list: %s %v", listKeys(objs), err)
+	}
+
+	// the marker is kept if others failed to be deleted
+	f := &failedDeletes{m, map[string]bool{"p/a/1": true}}
+	if err = DeleteDir(WithPrefix(f, "p/"), "a", true); !errors.Is(err, errBroken) {
+		t.Fatalf("delete dir should fail: %v", err)
+	}
+	if objs, _ = m.List("p/", "", "", 10, false); listKeys(objs) != "p/a/,p/a/1" {
+		t.Fatalf("marker should be kept: %s", listKeys(objs))
+	}
+	f.failed = nil
+	if err = DeleteDir(WithPrefix(f, "p/"), "a/", false); err != nil {
+		t.Fatalf("delete dir: %s", err)
+	}
+	if objs, _ = m.List("p/", "", "", 10, false); listKeys(objs) != "p/a/" {
+		t.Fatalf("only marker should be kept: %s", listKeys(objs))
+	}
+	if err = DeleteDir(s, "a", true); err != nil {
+		t.Fatalf("delete dir with marker: %s", err)
+	}
+	if objs, _ = m.List("p/", "", "", 10, false); len(objs) != 0 {
+		t.Fatalf("marker should be deleted: %s", listKeys(objs))
+	}
+}
+
+func TestAzureContext(t *testing.T) {
+	stuck := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stuck
+	}))
+	defer srv.Close()
+	defer close(stuck)
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	var s ObjectStorage = &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx, maxRetries: 3}
+	c, cancel := context.WithCancel(context.Background())
+	s = WithContext(WithPrefix(s, "prefix/"), c)
+	done := make(chan error)
+	go func() {
+		done <- s.Put("stuck", bytes.NewReader([]byte("data")))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("put should be canceled, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("put is not canceled")
+	}
+}
+
+// fakeAzureList serves the flat listing of n blobs (k0000000, k0000001, ...) of Azure, the continuation
+// token is the index of the next blob, and counts the requests.
+func fakeAzureList(n int, calls *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		q := r.URL.Query()
+		start, _ := strconv.Atoi(q.Get("marker"))
+		size, _ := strconv.Atoi(q.Get("maxresults"))
+		var buf bytes.Buffer
+		buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		end := start + size
+		if end > n {
+			end = n
+		}
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&buf, `<Blob><Name>k%07d</Name><Properties><Last-Modified>Mon, 02 Jan 2006 15:04:05 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`, i)
+		}
+		buf.WriteString(`</Blobs><NextMarker>`)
+		if end < n {
+			buf.WriteString(strconv.Itoa(end))
+		}
+		buf.WriteString(`</NextMarker></EnumerationResults>`)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(buf.Bytes())
+	}
+}
+
+func TestAzureListSnapshots(t *testing.T) {
+	// the snapshots are listed by Azure before their base blob, oldest first
+	blobs := [][2]string{{"a", "t1"}, {"a", "t2"}, {"a", ""}, {"a-b", ""}, {"a.txt", "t1"}, {"a.txt", ""}, {"b", ""}}
+	var snapshots []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("comp") != "list" {
+			snapshots = append(snapshots, q.Get("snapshot"))
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			_, _ = w.Write([]byte("s"))
+			return
+		}
+		start, _ := strconv.Atoi(q.Get("marker"))
+		end := start + 2
+		if end > len(blobs) {
+			end = len(blobs)
+		}
+		var buf bytes.Buffer
+		buf.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+		for _, b := range blobs[start:end] {
+			fmt.Fprintf(&buf, `<Blob><Name>%s</Name><Snapshot>%s</Snapshot><Properties><Last-Modified>Mon, 02 Jan 2006 15:04:05 GMT</Last-Modified><Content-Length>1</Content-Length></Properties></Blob>`, b[0], b[1])
+		}
+		buf.WriteString(`</Blobs><NextMarker>`)
+		if end < len(blobs) {
+			buf.WriteString(strconv.Itoa(end))
+		}
+		buf.WriteString(`</NextMarker></EnumerationResults>`)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(buf.Bytes())
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	s, err := newWasb("http://test.core.windows.net?list-snapshots=true&list-page-size=2", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	objs, err := listAll(s, "", "", 100, true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	expected := "a,a\x00snapshot=t1,a\x00snapshot=t2,a-b,a.txt,a.txt\x00snapshot=t1,b"
+	if keys := listKeys(objs); keys != expected {
+		t.Fatalf("the snapshots should be sorted after the base blob: %q", keys)
+	}
+	if objs, err = s.List("", "a\x00snapshot=t2", "", 2, true); err != nil || listKeys(objs) != "a-b,a.txt" {
+		t.Fatalf("list after a snapshot: %q %v", listKeys(objs), err)
+	}
+	for _, key := range []string{"a\x00snapshot=t1", "a?snapshot=t2"} {
+		if _, err = get(s, key, 0, -1); err != nil {
+			t.Fatalf("get %q: %s", key, err)
+		}
+	}
+	if strings.Join(snapshots, ",") != "t1,t2" {
+		t.Fatalf("the snapshots read: %v", snapshots)
+	}
+}
+
+func TestAzureListTimeout(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+	var calls int
+	var stalls atomic.Int32
+	list := fakeAzureList(10, &calls)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stalls.Add(-1) >= 0 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		list(w, r)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	if _, err := newWasb("http://test.core.windows.net?list-timeout=abc", "account", "a2V5", ""); err == nil {
+		t.Fatalf("invalid list-timeout should fail")
+	}
+	s, err := newWasb("http://test.core.windows.net?list-timeout=100ms", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	// the stalled page is retried
+	stalls.Store(1)
+	start := time.Now()
+	objs, err := s.List("", "", "", 10, true)
+	if err != nil || len(objs) != 10 {
+		t.Fatalf("list with a stalled page: %d objects, %v", len(objs), err)
+	}
+	if used := time.Since(start); used > 2*time.Second {
+		t.Fatalf("the stalled page should time out: %s", used)
+	}
+	stalls.Store(4)
+	if _, err = s.List("", "", "", 10, true); !errors.Is(err, errListTimeout) {
+		t.Fatalf("list should time out after retries: %v", err)
+	}
+	stalls.Store(0)
+
+	// no retry if the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = s.(SupportContext).WithContext(ctx).List("", "", "", 10, true); errors.Is(err, errListTimeout) || err == nil {
+		t.Fatalf("list with canceled context: %v", err)
+	}
+}
+
+func TestAzureListPageSize(t *testing.T) {
+	var calls int
+	proxy := httptest.NewServer(fakeAzureList(20000, &calls))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	for _, c := range []struct {
+		query string
+		calls int
+	}{
+		{"", 1992},
+		{"?list-page-size=5000", 4},
+	} {
+		s, err := newWasb("http://test.core.windows.net"+c.query, "account", "a2V5", "")
+		if err != nil {
+			t.Fatalf("create wasb: %s", err)
+		}
+		calls = 0
+		objs, err := s.List("", "k0019900", "", 10, true)
+		if err != nil {
+			t.Fatalf("list: %s", err)
+		}
+		if len(objs) != 10 || objs[0].Key() != "k0019901" || objs[9].Key() != "k0019910" {
+			t.Fatalf("list after k0019900: %s", listKeys(objs))
+		}
+		if calls != c.calls {
+			t.Fatalf("requests to list with %q: %d, expect %d", c.query, calls, c.calls)
+		}
+	}
+
+	s, _ := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	objs, err := s.List("", "k0019900", "", 10, true)
+	if err != nil || len(objs) != 10 {
+		t.Fatalf("list after k0019900: %s %v", listKeys(objs), err)
+	}
+	calls = 0
+	// the next page is listed from the continuation token of Azure
+	if objs, err = s.List("", objs[9].Key(), "", 10, true); err != nil || objs[0].Key() != "k0019911" || objs[9].Key() != "k0019920" {
+		t.Fatalf("list after k0019910: %s %v", listKeys(objs), err)
+	}
+	if calls != 1 {
+		t.Fatalf("the next page should be listed in one request: %d", calls)
+	}
+	if objs, err = s.List("", "", "", 0, true); err != nil || len(objs) != maxResults {
+		t.Fatalf("list without limit: %d objects, %v", len(objs), err)
+	}
+	for _, v := range []string{"0", "5001", "abc"} {
+		if _, err := newWasb("http://test.core.windows.net?list-page-size="+v, "account", "a2V5", ""); err == nil {
+			t.Fatalf("invalid list-page-size %s should fail", v)
+		}
+	}
+}
+
+// BenchmarkAzureListPageSize lists 100 blobs at the end of a million-object prefix, reports the requests to Azure.
+func BenchmarkAzureListPageSize(b *testing.B) {
+	var calls int
+	proxy := httptest.NewServer(fakeAzureList(1000000, &calls))
+	defer proxy.Close()
+	b.Setenv("HTTP_PROXY", proxy.URL)
+	b.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	b.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	b.Setenv("AZURE_CLIENT_ID", "")
+	for name, query := range map[string]string{"default": "", "5000": "?list-page-size=5000"} {
+		b.Run(name, func(b *testing.B) {
+			s, err := newWasb("http://test.core.windows.net"+query, "account", "a2V5", "")
+			if err != nil {
+				b.Fatalf("create wasb: %s", err)
+			}
+			calls = 0
+			for i := 0; i < b.N; i++ {
+				if _, err = s.List("", "k0999800", "", 100, true); err != nil {
+					b.Fatalf("list: %s", err)
+				}
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "requests/op")
+		})
+	}
+}
+
+func TestAzureCredentialFile(t *testing.T) {
+	var calls int
+	var account string
+	list := fakeAzureList(1, &calls)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account = strings.SplitN(strings.TrimPrefix(r.Header.Get("Authorization"), "SharedKey "), ":", 2)[0]
+		list(w, r)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	file := filepath.Join(t.TempDir(), "credential")
+	content := "# mounted from a secret\nDefaultEndpointsProtocol=http;\nAccountName=fileaccount\nAccountKey=a2V5\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("write credential file: %s", err)
+	}
+	t.Setenv("AZURE_STORAGE_CREDENTIAL_FILE", file)
+	for _, c := range []struct {
+		name       string
+		connString string
+		accountKey string
+		expected   string
+	}{
+		{"credential file", "", "", "fileaccount"},
+		{"connection string over credential file", "DefaultEndpointsProtocol=http;AccountName=envaccount;AccountKey=a2V5", "", "envaccount"},
+		{"arguments over connection string", "DefaultEndpointsProtocol=http;AccountName=envaccount;AccountKey=a2V5", "a2V5", "account"},
+	} {
+		t.Setenv("AZURE_STORAGE_CONNECTION_STRING", c.connString)
+		s, err := newWasb("http://test.blob.core.windows.net", "account", c.accountKey, "")
+		if err != nil {
+			t.Fatalf("%s: create wasb: %s", c.name, err)
+		}
+		account = ""
+		if _, err = s.List("", "", "", 1, true); err != nil {
+			t.Fatalf("%s: list: %s", c.name, err)
+		}
+		if account != c.expected {
+			t.Fatalf("%s: signed by account %q, expect %q", c.name, account, c.expected)
+		}
+	}
+
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	for _, content := range []string{"", "# no connection string\n"} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatalf("write credential file: %s", err)
+		}
+		if _, err := newWasb("http://test.blob.core.windows.net", "account", "", ""); err == nil {
+			t.Fatalf("empty credential file should fail")
+		}
+	}
+	t.Setenv("AZURE_STORAGE_CREDENTIAL_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := newWasb("http://test.blob.core.windows.net", "account", "", ""); err == nil {
+		t.Fatalf("missing credential file should fail")
+	}
+}
+
+func TestAzureProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.Host
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_STORAGE_TIMEOUT", "10s")
+
+	s, err := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	if hc := s.(*wasb).hc; hc.Timeout != 10*time.Second {
+		t.Fatalf("expect timeout 10s, got %s", hc.Timeout)
+	}
+	if _, err = s.Head("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("head through proxy: %v", err)
+	}
+	if requested != "account.blob.core.windows.net" {
+		t.Fatalf("request should go through the proxy, got %q", requested)
+	}
+}
+
+func TestAnonymous(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	t.Setenv("HTTP_PROXY", srv.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	s3s, err := newS3(srv.URL+"/test?anonymous=true", "", "", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	wasbs, err := newWasb("http://test.core.windows.net?anonymous=true", "account", "", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	for _, s := range []ObjectStorage{s3s, wasbs} {
+		requests = nil
+		if _, err = s.Head("missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("head of %s: %v", s, err)
+		}
+		if err = s.Put("key", bytes.NewReader(nil)); !errors.Is(err, ErrAnonymousReadOnly) || !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("put to %s should fail: %v", s, err)
+		}
+		if err = s.Delete("key"); !errors.Is(err, ErrAnonymousReadOnly) || !strings.Contains(err.Error(), "anonymous access is read-only") {
+			t.Fatalf("delete from %s should fail: %v", s, err)
+		}
+		if len(requests) != 1 || requests[0] != "HEAD " {
+			t.Fatalf("requests of %s should be sent without credentials: %q", s, requests)
+		}
+	}
+
+	if _, err = newS3(srv.URL+"/test?anonymous=true", "ak", "sk", ""); err == nil {
+		t.Fatalf("anonymous s3 with credentials should fail")
+	}
+	if _, err = newWasb("http://test.core.windows.net?anonymous=true", "account", "a2V5", ""); err == nil {
+		t.Fatalf("anonymous wasb with account key should fail")
+	}
+}
+
+func TestAzureSign(t *testing.T) {
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	s, err := newWasb("https://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	var ps SupportPresign = s.(*wasb)
+	u, err := ps.SignGet("a/b", time.Hour)
+	if err != nil {
+		t.Fatalf("sign get: %s", err)
+	}
+	if !strings.HasPrefix(u, "https://account.blob.core.windows.net/test/a") || !strings.Contains(u, "sp=r&") || !strings.Contains(u, "sig=") {
+		t.Fatalf("invalid signed URL: %s", u)
+	}
+	if u, err = ps.SignPut("a/b", time.Hour); err != nil || !strings.Contains(u, "sp=cw&") {
+		t.Fatalf("sign put: %s %v", u, err)
+	}
+
+	s, err = newWasb("https://test.core.windows.net?sv=2021-06-08&sp=r&sig=abc", "account", "", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	if _, err = s.(SupportPresign).SignGet("a/b", time.Hour); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("sign with SAS token should not be supported: %v", err)
 	}
-	//https://containersName.core.windows.net
-	abs, _ := newWasb(os.Getenv("AZURE_ENDPOINT"),
-		os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), "")
-	testStorage(t, abs)
 }
 
 func TestJSS(t *testing.T) { //skip mutate
@@ -705,6 +3470,109 @@ func TestB2(t *testing.T) { //skip mutate
 	testStorage(t, b)
 }
 
+func TestB2LargeFile(t *testing.T) {
+	var mu sync.Mutex
+	token, expired := "token-1", false
+	var uploaded, finished []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		reply := func(v interface{}) { _ = json.NewEncoder(w).Encode(v) }
+		if r.URL.Path == "/upload" {
+			sum := sha1.Sum(body)
+			if r.Header.Get("Authorization") != "upload-token" || r.Header.Get("X-Bz-Content-Sha1") != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			uploaded = append(uploaded, r.Header.Get("X-Bz-Part-Number"))
+			reply(map[string]interface{}{"contentSha1": hex.EncodeToString(sum[:]), "contentLength": len(body)})
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/b2api/v2/")
+		if name == "b2_authorize_account" {
+			if user, pass, _ := r.BasicAuth(); user != "id" || pass != "key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if expired {
+				token = "token-2"
+			}
+			reply(map[string]string{"authorizationToken": token, "apiUrl": srv.URL})
+			return
+		}
+		// the token expires before finishing the large file
+		if r.Header.Get("Authorization") != token || name == "b2_finish_large_file" && !expired {
+			expired = true
+			w.WriteHeader(http.StatusUnauthorized)
+			reply(map[string]interface{}{"status": 401, "code": "expired_auth_token", "message": "expired"})
+			return
+		}
+		var req map[string]interface{}
+		_ = json.Unmarshal(body, &req)
+		switch name {
+		case "b2_start_large_file":
+			if req["bucketId"] != "bucket-id" || req["fileName"] != "big" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reply(map[string]string{"fileId": "file-1"})
+		case "b2_get_upload_part_url":
+			reply(map[string]string{"uploadUrl": srv.URL + "/upload", "authorizationToken": "upload-token"})
+		case "b2_finish_large_file":
+			for _, s := range req["partSha1Array"].([]interface{}) {
+				finished = append(finished, s.(string))
+			}
+			reply(map[string]string{"fileId": "file-1"})
+		case "b2_list_unfinished_large_files":
+			reply(map[string]interface{}{"files": []map[string]interface{}{{"fileId": "file-2", "fileName": "big2", "uploadTimestamp": 1000}}, "nextFileId": "file-3"})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			reply(map[string]interface{}{"status": 400, "code": "bad_request", "message": name})
+		}
+	}))
+	defer srv.Close()
+
+	b := &b2client{bucket: &backblaze.Bucket{BucketInfo: &backblaze.BucketInfo{ID: "bucket-id", Name: "test"}},
+		api: &b2API{host: srv.URL, keyID: "id", applicationKey: "key"}}
+	up, err := b.CreateMultipartUpload("big")
+	if err != nil || up.UploadID != "file-1" {
+		t.Fatalf("start large file: %+v %v", up, err)
+	}
+	var ps []*Part
+	var sums []string
+	for i, data := range []string{"part1", "part2"} {
+		p, err := b.UploadPart("big", up.UploadID, i+1, []byte(data))
+		if err != nil {
+			t.Fatalf("upload part %d: %s", i+1, err)
+		}
+		sum := sha1.Sum([]byte(data))
+		sums = append(sums, hex.EncodeToString(sum[:]))
+		if p.ETag != sums[i] {
+			t.Fatalf("etag of part %d should be sha1: %s", i+1, p.ETag)
+		}
+		ps = append(ps, p)
+	}
+	if strings.Join(uploaded, ",") != "1,2" {
+		t.Fatalf("uploaded parts: %v", uploaded)
+	}
+	if err = b.CompleteUpload("big", up.UploadID, ps); err != nil {
+		t.Fatalf("finish large file: %s", err)
+	}
+	if token != "token-2" || !reflect.DeepEqual(finished, sums) {
+		t.Fatalf("finish large file with token %s, parts %v", token, finished)
+	}
+	pending, next, err := b.ListUploads("")
+	if err != nil || len(pending) != 1 || pending[0].UploadID != "file-2" || pending[0].Key != "big2" || next != "file-3" {
+		t.Fatalf("list unfinished large files: %+v %s %v", pending, next, err)
+	}
+	var e *b2Error
+	if _, err = b.api.authorize(); err != nil || !errors.As(b.api.call("b2_unknown", nil, nil), &e) || e.Code != "bad_request" {
+		t.Fatalf("unknown api should return b2Error: %v", err)
+	}
+}
+
 func TestSpace(t *testing.T) { //skip mutate
 	if os.Getenv("SPACE_ACCESS_KEY") == "" {
 		t.SkipNow()
@@ -778,6 +3646,25 @@ func TestHDFS(t *testing.T) { //skip mutate
 	checkAddr("hdfs://ns/user/juicefs", []string{"hadoop01:8020", "hadoop02:8020"}, "/user/juicefs/")
 	checkAddr("ns/user/juicefs/", []string{"hadoop01:8020", "hadoop02:8020"}, "/user/juicefs/")
 
+	conf["dfs.replication"] = "2"
+	conf["dfs.blocksize"] = "256m"
+	checkOptions := func(query string, replication int, blockSize int64) {
+		q, _ := url.ParseQuery(query)
+		r, bs, err := hdfsFileOptions(q, conf)
+		if err != nil || r != replication || bs != blockSize {
+			t.Fatalf("options of %q: %d %d %v", query, r, bs, err)
+		}
+	}
+	checkOptions("", 2, 256<<20)
+	checkOptions("replication=1&block-size=64M", 1, 64<<20)
+	checkOptions("block-size=1048576", 2, 1<<20)
+	for _, query := range []string{"replication=0", "block-size=1x", "block-size=-1"} {
+		q, _ := url.ParseQuery(query)
+		if _, _, err := hdfsFileOptions(q, conf); err == nil {
+			t.Fatalf("invalid options %q should fail", query)
+		}
+	}
+
 	if os.Getenv("HDFS_ADDR") == "" {
 		t.SkipNow()
 	}
@@ -840,6 +3727,36 @@ func TestRedis(t *testing.T) {
 		t.Fatal(err)
 	}
 	testStorage(t, s)
+
+	// large objects are stored in chunks
+	data := make([]byte, redisChunkSize*2+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err = s.Put("large", bytes.NewReader(data)); err != nil {
+		t.Fatalf("put large object: %s", err)
+	}
+	defer s.Delete("large")
+	defer s.Delete("large2")
+	if o, err := s.Head("large"); err != nil || o.Size() != int64(len(data)) {
+		t.Fatalf("head large object: %+v %v", o, err)
+	}
+	if d, err := get(s, "large", redisChunkSize-10, 20); err != nil || d != string(data[redisChunkSize-10:redisChunkSize+10]) {
+		t.Fatalf("get across chunks: %d bytes, %v", len(d), err)
+	}
+	if err = s.Copy("large2", "large"); err != nil {
+		t.Fatalf("copy large object: %s", err)
+	}
+	if d, err := get(s, "large2", 0, -1); err != nil || d != string(data) {
+		t.Fatalf("get copied large object: %d bytes, %v", len(d), err)
+	}
+	objs, err := listAll(s, "large", "", 10, true)
+	if err != nil || len(objs) != 2 || objs[0].Size() != int64(len(data)) {
+		t.Fatalf("list large objects: %+v %v", objs, err)
+	}
+	if _, err = s.Get("missing", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get missing object: %v", err)
+	}
 }
 
 func TestSwift(t *testing.T) { //skip mutate
@@ -853,6 +3770,62 @@ func TestSwift(t *testing.T) { //skip mutate
 	testStorage(t, s)
 }
 
+func TestSwiftLargeObject(t *testing.T) {
+	srv, err := swifttest.NewSwiftServer("localhost")
+	if err != nil {
+		t.Fatalf("start swift server: %s", err)
+	}
+	defer srv.Close()
+	conn := &swift.Connection{UserName: swifttest.TEST_ACCOUNT, ApiKey: swifttest.TEST_ACCOUNT, AuthUrl: srv.AuthURL}
+	if err = conn.Authenticate(ctx); err != nil {
+		t.Fatalf("auth: %s", err)
+	}
+	s := &swiftOSS{conn: conn, storageUrl: conn.StorageUrl, container: "test"}
+	if err = s.Create(); err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	up, err := s.CreateMultipartUpload("big")
+	if err != nil {
+		t.Fatalf("create multipart upload: %s", err)
+	}
+	var parts []*Part
+	for i, data := range []string{"hello ", "world"} {
+		p, err := s.UploadPart("big", up.UploadID, i+1, []byte(data))
+		if err != nil {
+			t.Fatalf("upload part %d: %s", i+1, err)
+		}
+		parts = append(parts, p)
+	}
+	if err = s.CompleteUpload("big", up.UploadID, parts); err != nil {
+		t.Fatalf("complete upload: %s", err)
+	}
+	if d, err := get(s, "big", 0, -1); err != nil || d != "hello world" {
+		t.Fatalf("get large object: %q, %v", d, err)
+	}
+	if d, err := get(s, "big", 3, 5); err != nil || d != "lo wo" {
+		t.Fatalf("get range of large object: %q, %v", d, err)
+	}
+	if objs, err := s.List("", "", "", 100, true); err != nil || len(objs) != 1 || objs[0].Key() != "big" {
+		t.Fatalf("segments should not be listed: %+v, %v", objs, err)
+	}
+	if err = s.Delete("big"); err != nil {
+		t.Fatalf("delete large object: %s", err)
+	}
+	if err = s.Delete("big"); err != nil {
+		t.Fatalf("delete non-existent object: %s", err)
+	}
+
+	up, _ = s.CreateMultipartUpload("aborted")
+	if _, err = s.UploadPart("aborted", up.UploadID, 1, []byte("data")); err != nil {
+		t.Fatalf("upload part: %s", err)
+	}
+	s.AbortUpload("aborted", up.UploadID)
+	names, err := conn.ObjectNamesAll(ctx, "test_segments", &swift.ObjectsOpts{Prefix: "aborted/"})
+	if err != nil || len(names) != 0 {
+		t.Fatalf("segments should be removed after abort: %v, %v", names, err)
+	}
+}
+
 func TestWebDAV(t *testing.T) { //skip mutate
 	if os.Getenv("WEBDAV_TEST_BUCKET") == "" {
 		t.SkipNow()
@@ -861,12 +3834,67 @@ func TestWebDAV(t *testing.T) { //skip mutate
 	testStorage(t, s)
 }
 
+func TestWebDAVLocal(t *testing.T) {
+	dav := &xwebdav.Handler{FileSystem: xwebdav.NewMemFS(), LockSystem: xwebdav.NewMemLS()}
+	noRange := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if noRange {
+			r.Header.Del("Range")
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	s, err := newWebDAV(srv.URL+"/a/b/", "user", "pass", "")
+	if err != nil {
+		t.Fatalf("create webdav: %s", err)
+	}
+	if err = s.Create(); err != nil {
+		t.Fatalf("create collection: %s", err)
+	}
+	if _, err = s.Head("missing"); !os.IsNotExist(err) {
+		t.Fatalf("head missing object should return not exist: %v", err)
+	}
+	if _, err = s.Get("missing", 1, -1); !os.IsNotExist(err) {
+		t.Fatalf("get missing object should return not exist: %v", err)
+	}
+	if err = s.Put("k", bytes.NewReader([]byte("hello world"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	for _, noRange = range []bool{false, true} {
+		for _, c := range []struct {
+			off, limit int64
+			expect     string
+		}{{0, -1, "hello world"}, {6, -1, "world"}, {6, 3, "wor"}, {11, -1, ""}} {
+			r, err := s.Get("k", c.off, c.limit)
+			if err != nil {
+				t.Fatalf("get %d-%d: %s", c.off, c.limit, err)
+			}
+			data, _ := io.ReadAll(r)
+			_ = r.Close()
+			if string(data) != c.expect {
+				t.Fatalf("get %d-%d (without range %v): expect %q, got %q", c.off, c.limit, noRange, c.expect, data)
+			}
+		}
+	}
+	noRange = false
+	objs, err := s.List("", "", "/", 10, true)
+	if err != nil || len(objs) != 1 || objs[0].Key() != "k" || objs[0].Size() != 11 {
+		t.Fatalf("list: %v %+v", err, objs)
+	}
+}
+
 func TestEncrypted(t *testing.T) {
 	s, _ := CreateStorage("mem", "", "", "", "")
 	privkey, _ := rsa.GenerateKey(rand.Reader, 2048)
 	kc := NewRSAEncryptor(privkey)
 	dc, _ := NewDataEncryptor(kc, AES256GCM_RSA)
-	es := NewEncrypted(s, dc)
+	// the parts can't be encrypted separately
+	es := NewEncrypted(withoutMultipart{s}, dc)
 	testStorage(t, es)
 }
 
@@ -904,7 +3932,17 @@ func TestMarsharl(t *testing.T) {
 
 func TestSharding(t *testing.T) {
 	s, _ := NewSharded("mem", "%d", "", "", "", 10)
-	testStorage(t, s)
+	// the source of UploadPartCopy may be in another shard
+	testStorage(t, withoutMultipart{s})
+}
+
+// withoutMultipart hides the multipart upload of the object storage.
+type withoutMultipart struct {
+	ObjectStorage
+}
+
+func (w withoutMultipart) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return nil, notSupported
 }
 
 func TestSQLite(t *testing.T) {
@@ -913,6 +3951,20 @@ func TestSQLite(t *testing.T) {
 		t.Fatalf("create: %s", err)
 	}
 	testStorage(t, s)
+
+	_ = s.Put("range", bytes.NewReader([]byte("0123456789")))
+	defer s.Delete("range")
+	for _, c := range []struct {
+		off, limit int64
+		expected   string
+	}{{0, -1, "0123456789"}, {3, 4, "3456"}, {8, 10, "89"}, {5, -1, "56789"}, {10, 1, ""}} {
+		if d, err := get(s, "range", c.off, c.limit); err != nil || d != c.expected {
+			t.Fatalf("get %d,%d: %q %v", c.off, c.limit, d, err)
+		}
+	}
+	if s.Limits().MaxObjectSize != 1e9 {
+		t.Fatalf("max object size of sqlite: %d", s.Limits().MaxObjectSize)
+	}
 }
 
 func TestPG(t *testing.T) { //skip mutate
@@ -1001,6 +4053,72 @@ func TestIBMCOS(t *testing.T) { //skip mutate
 	testStorage(t, s)
 }
 
+func TestIBMCOSCredentials(t *testing.T) {
+	var mu sync.Mutex
+	grants := make(map[string]int)
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/identity/token" {
+			_ = r.ParseForm()
+			grant := r.Form.Get("grant_type")
+			grants[grant]++
+			// the first token expires in 2 seconds, so it's refreshed before being used
+			token, expiration := "initial", time.Now().Add(2*time.Second)
+			if grant == "refresh_token" {
+				token, expiration = "refreshed", time.Now().Add(time.Hour)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  token,
+				"refresh_token": "refresh-" + token,
+				"token_type":    "Bearer",
+				"expires_in":    int64(time.Until(expiration).Seconds()),
+				"expiration":    expiration.Unix(),
+			})
+			return
+		}
+		auths = append(auths, r.Header.Get("Authorization"))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer srv.Close()
+	t.Setenv("IBM_AUTH_ENDPOINT", srv.URL+"/identity/token")
+	newCOS := func(accessKey, secretKey string) ObjectStorage {
+		s, err := newIBMCOS("https://test.s3.us-south.cloud-object-storage.appdomain.cloud", accessKey, secretKey, "")
+		if err != nil {
+			t.Fatalf("create ibmcos: %s", err)
+		}
+		s.(*ibmcos).s3.Endpoint = srv.URL
+		s.(*ibmcos).s3.Config.S3ForcePathStyle = aws.Bool(true)
+		return s
+	}
+	if _, err := newIBMCOS("https://localhost", "key", "id", ""); err == nil {
+		t.Fatalf("invalid endpoint should fail")
+	}
+
+	s := newCOS("api-key", "instance-id")
+	for i := 0; i < 3; i++ {
+		if _, err := s.Head("a"); err != nil {
+			t.Fatalf("head with the IAM token: %s", err)
+		}
+	}
+	if grants["urn:ibm:params:oauth:grant-type:apikey"] != 1 || grants["refresh_token"] != 1 {
+		t.Fatalf("the token should be exchanged once and refreshed once: %+v", grants)
+	}
+	if auths[0] != "Bearer refreshed" || auths[1] != "Bearer refreshed" || auths[2] != "Bearer refreshed" {
+		t.Fatalf("authorization with the IAM token: %q", auths)
+	}
+
+	s = newCOS(strings.Repeat("a1", 16), strings.Repeat("b2", 24))
+	if _, err := s.Head("a"); err != nil {
+		t.Fatalf("head with HMAC keys: %s", err)
+	}
+	if !strings.HasPrefix(auths[3], "AWS4-HMAC-SHA256 Credential="+strings.Repeat("a1", 16)) || len(grants) != 2 {
+		t.Fatalf("authorization with HMAC keys: %q, %+v", auths[3], grants)
+	}
+}
+
 func TestTOS(t *testing.T) { //skip mutate
 	if os.Getenv("TOS_ENDPOINT") == "" {
 		t.SkipNow()
@@ -1050,3 +4168,260 @@ func TestMain(m *testing.M) {
 	}
 	m.Run()
 }
+
+func TestMove(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	_ = m.Put("p/a", bytes.NewReader([]byte("a")))
+	_ = m.Put("p/b", bytes.NewReader([]byte("b")))
+	s := WithPrefix(m, "p/")
+	if err := Move(s, "c", "a"); err != nil {
+		t.Fatalf("move: %s", err)
+	}
+	if objs, _ := m.List("p/", "", "", 10, false); listKeys(objs) != "p/b,p/c" {
+		t.Fatalf("keys after move: %s", listKeys(objs))
+	}
+
+	// the copy is deleted if the source can't be deleted
+	f := &failedDeletes{m, map[string]bool{"p/b": true}}
+	if err := Move(WithPrefix(f, "p/"), "d", "b"); !errors.Is(err, errBroken) {
+		t.Fatalf("move should fail: %v", err)
+	}
+	if objs, _ := m.List("p/", "", "", 10, false); listKeys(objs) != "p/b,p/c" {
+		t.Fatalf("keys after failed move: %s", listKeys(objs))
+	}
+	if err := Move(WithReadOnly(s), "d", "c"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("move in read-only storage: %v", err)
+	}
+
+	d, _ := newDisk(t.TempDir()+"/", "", "", "")
+	_ = d.Put("a", bytes.NewReader([]byte("a")))
+	if err := Move(d, "x/y/a", "a"); err != nil {
+		t.Fatalf("move in disk: %s", err)
+	}
+	if data, _ := get(d, "x/y/a", 0, -1); data != "a" {
+		t.Fatalf("content of moved file is not expected")
+	}
+	if _, err := d.Head("a"); !os.IsNotExist(err) {
+		t.Fatalf("source should be moved: %v", err)
+	}
+	if err := Move(d, "b", "a"); !os.IsNotExist(err) {
+		t.Fatalf("move missing file: %v", err)
+	}
+}
+
+func TestS3CACert(t *testing.T) {
+	var hosts []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Length", "1")
+		w.Header().Set("Last-Modified", "Fri, 21 Dec 2012 00:00:00 GMT")
+	}))
+	defer srv.Close()
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	_ = os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+
+	s, err := newS3(srv.URL+"/test?ca-cert="+caCert, "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3 with ca-cert: %s", err)
+	}
+	if _, err = s.Head("key"); err != nil {
+		t.Fatalf("head with ca-cert: %s", err)
+	}
+	if _, err = newS3(srv.URL+"/test?ca-cert="+caCert+".missing", "ak", "sk", ""); err == nil || !strings.Contains(err.Error(), "ca-cert") {
+		t.Fatalf("missing ca-cert should fail: %v", err)
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	_ = os.WriteFile(invalid, []byte("not a certificate"), 0644)
+	if _, err = newS3(srv.URL+"/test?ca-cert="+invalid, "ak", "sk", ""); err == nil || !strings.Contains(err.Error(), "no PEM certificate") {
+		t.Fatalf("invalid ca-cert should fail: %v", err)
+	}
+
+	// AWS endpoints are resolved by the custom resolver, without detecting the region
+	defer func() { S3EndpointResolver = nil }()
+	var regions []string
+	S3EndpointResolver = endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		regions = append(regions, region)
+		return endpoints.ResolvedEndpoint{URL: srv.URL, SigningRegion: region}, nil
+	})
+	t.Setenv("AWS_REGION", "us-west-2")
+	for _, ep := range []string{"https://s3.eu-west-1.amazonaws.com/test", "https://test"} {
+		if s, err = newS3(ep+"?force-path-style=true&ca-cert="+caCert, "ak", "sk", ""); err != nil {
+			t.Fatalf("create s3 %s: %s", ep, err)
+		}
+		if _, err = s.Head("key"); err != nil {
+			t.Fatalf("head %s: %s", ep, err)
+		}
+	}
+	if strings.Join(regions, ",") != "eu-west-1,us-west-2" {
+		t.Fatalf("regions: %s", regions)
+	}
+	if hosts[len(hosts)-1] != strings.TrimPrefix(srv.URL, "https://") {
+		t.Fatalf("host: %s", hosts)
+	}
+}
+
+// failedLists fails to list with err, and listing is not supported if err is nil.
+type failedLists struct {
+	ObjectStorage
+	err error
+}
+
+func (f *failedLists) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if f.err == nil {
+		return nil, notSupported
+	}
+	return nil, f.err
+}
+
+func TestCheck(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	if err := Check(WithPrefix(m, "p/")); err != nil {
+		t.Fatalf("check mem: %s", err)
+	}
+	if err := Check(&failedLists{ObjectStorage: m}); err != nil {
+		t.Fatalf("check by head: %s", err)
+	}
+	netErr := &url.Error{Op: "Get", URL: "http://bucket", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	for _, c := range []struct {
+		err      error
+		expected error
+	}{
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), http.StatusForbidden, ""), ErrPermission},
+		{awserr.NewRequestFailure(awserr.New("NoSuchBucket", "missing", nil), http.StatusNotFound, ""), ErrBucketNotFound},
+		{awserr.New("RequestError", "send request failed", netErr), ErrUnreachable},
+		{netErr, ErrUnreachable},
+		{errBroken, errBroken},
+	} {
+		if err := Check(&failedLists{m, c.err}); !errors.Is(err, c.expected) {
+			t.Fatalf("check with %q: %v, expect %s", c.err, err, c.expected)
+		}
+	}
+
+	var status int
+	var code string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("restype") != "container" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		w.Header().Set("x-ms-error-code", code)
+		w.WriteHeader(status)
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_STORAGE_MAX_RETRIES", "0")
+	s, err := newWasb("http://test.core.windows.net", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	for _, c := range []struct {
+		status   int
+		code     string
+		expected error
+	}{
+		{http.StatusOK, "", nil},
+		{http.StatusNotFound, "ContainerNotFound", ErrBucketNotFound},
+		{http.StatusForbidden, "AuthenticationFailed", ErrPermission},
+	} {
+		status, code = c.status, c.code
+		if err = Check(WithPrefix(s, "p/")); !errors.Is(err, c.expected) {
+			t.Fatalf("check wasb with %d %s: %v, expect %v", c.status, c.code, err, c.expected)
+		}
+	}
+}
+
+func TestS3EndpointVariants(t *testing.T) {
+	for _, c := range []struct {
+		endpoint, expected string
+	}{
+		{"https://test.s3.us-east-1.amazonaws.com?use-fips=true", "https://s3-fips.us-east-1.amazonaws.com"},
+		{"https://s3.us-west-2.amazonaws.com/test?use-fips=true", "https://s3-fips.us-west-2.amazonaws.com"},
+		{"https://test.s3.eu-west-1.amazonaws.com?use-dualstack=true", "https://s3.dualstack.eu-west-1.amazonaws.com"},
+		{"https://test.s3.us-east-1.amazonaws.com?use-fips=true&use-dualstack=true", "https://s3-fips.dualstack.us-east-1.amazonaws.com"},
+		{"https://test.s3.us-east-1.amazonaws.com?use-fips=false", "https://s3.amazonaws.com"},
+	} {
+		s, err := newS3(c.endpoint, "ak", "sk", "")
+		if err != nil {
+			t.Fatalf("create s3 %s: %s", c.endpoint, err)
+		}
+		if ep := s.(*s3client).s3.Endpoint; ep != c.expected {
+			t.Fatalf("endpoint of %s: %s, expect %s", c.endpoint, ep, c.expected)
+		}
+	}
+	for _, ep := range []string{
+		"https://test.s3.eu-west-1.amazonaws.com?use-fips=true",
+		"https://test.s3.us-east-1.amazonaws.com?use-fips=yes",
+		"http://127.0.0.1:9000/test?use-dualstack=true",
+	} {
+		if _, err := newS3(ep, "ak", "sk", ""); err == nil {
+			t.Fatalf("create s3 %s should fail", ep)
+		}
+	}
+}
+
+// batchDeletes counts the batches of DeleteMulti and the max of them in flight.
+type batchDeletes struct {
+	ObjectStorage
+	sync.Mutex
+	batches, inflight, maxInflight int
+}
+
+func (b *batchDeletes) DeleteMulti(keys []string) ([]string, error) {
+	b.Lock()
+	b.batches++
+	if b.inflight++; b.inflight > b.maxInflight {
+		b.maxInflight = b.inflight
+	}
+	b.Unlock()
+	time.Sleep(time.Millisecond * 10)
+	failed, err := deleteEach(b.ObjectStorage, keys)
+	b.Lock()
+	b.inflight--
+	b.Unlock()
+	return failed, err
+}
+
+func TestRemoveAll(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	for i := 0; i < 5500; i++ {
+		_ = m.Put(fmt.Sprintf("a/%04d", i), bytes.NewReader(nil))
+	}
+	_ = m.Put("b/1", bytes.NewReader(nil))
+	f := &failedDeletes{m, map[string]bool{"a/0001": true, "a/3000": true}}
+	b := &batchDeletes{ObjectStorage: f}
+	err := RemoveAll(b, "a/", 2)
+	var re *RemoveAllError
+	if !errors.As(err, &re) || !errors.Is(err, errBroken) {
+		t.Fatalf("remove all should fail: %v", err)
+	}
+	sort.Strings(re.Failed)
+	if strings.Join(re.Failed, ",") != "a/0001,a/3000" || len(re.Errs) != 2 {
+		t.Fatalf("failed keys: %s, errors: %v", re.Failed, re.Errs)
+	}
+	if b.batches != 6 || b.maxInflight != 2 {
+		t.Fatalf("deleted in %d batches with %d in flight", b.batches, b.maxInflight)
+	}
+	if objs, _ := m.List("", "", "", 10, false); listKeys(objs) != "a/0001,a/3000,b/1" {
+		t.Fatalf("keys after remove all: %s", listKeys(objs))
+	}
+	f.failed = nil
+	if err = RemoveAll(WithPrefix(f, "a/"), "", 0); err != nil {
+		t.Fatalf("remove all: %s", err)
+	}
+	if objs, _ := m.List("", "", "", 10, false); listKeys(objs) != "b/1" {
+		t.Fatalf("keys after remove all: %s", listKeys(objs))
+	}
+
+	d, _ := newDisk(t.TempDir()+"/", "", "", "")
+	for _, k := range []string{"x/1", "x/y/2", "x/y/z/3", "x0"} {
+		_ = d.Put(k, bytes.NewReader(nil))
+	}
+	if err = RemoveAll(d, "x/", 4); err != nil {
+		t.Fatalf("remove all in disk: %s", err)
+	}
+	if objs, _ := listAll(d, "", "", 100, false); listKeys(objs) != ",x0" {
+		t.Fatalf("keys after remove all in disk: %s", listKeys(objs))
+	}
+}
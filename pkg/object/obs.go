@@ -47,12 +47,17 @@ type obsClient struct {
 	checkEtag bool
 	sc        string
 	c         *obs.ObsClient
+	creds     *credentialRefresher // renews the security token, nil for the static credentials
 }
 
 func (s *obsClient) String() string {
 	return fmt.Sprintf("obs://%s/", s.bucket)
 }
 
+func (s *obsClient) BucketName() string {
+	return s.bucket
+}
+
 func (s *obsClient) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
@@ -362,6 +367,27 @@ func autoOBSEndpoint(bucketName, accessKey, secretKey, token string) (string, er
 	return "", fmt.Errorf("bucket %q does not exist", bucketName)
 }
 
+// SetCredentialProvider switches the client to the temporary credentials from the provider, which are
+// renewed in background before the security token expires.
+func (s *obsClient) SetCredentialProvider(p CredentialProvider) error {
+	r, err := newCredentialRefresher(p, func(c *Credentials) {
+		s.c.Refresh(c.AccessKey, c.SecretKey, c.Token)
+	})
+	if err != nil {
+		return err
+	}
+	s.creds.stop()
+	s.creds = r
+	c, _ := r.current()
+	s.c.Refresh(c.AccessKey, c.SecretKey, c.Token)
+	return nil
+}
+
+// Shutdown stops renewing the security token.
+func (s *obsClient) Shutdown() {
+	s.creds.stop()
+}
+
 func newOBS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("https://%s", endpoint)
@@ -379,6 +405,12 @@ func newOBS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 	if accessKey == "" {
 		accessKey = os.Getenv("HWCLOUD_ACCESS_KEY")
 		secretKey = os.Getenv("HWCLOUD_SECRET_KEY")
+		if token == "" {
+			token = os.Getenv("HWCLOUD_SECURITY_TOKEN")
+		}
+	}
+	if token == "" {
+		token = uri.Query().Get("security-token")
 	}
 
 	var region string
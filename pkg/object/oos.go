@@ -63,6 +63,11 @@ func (s *oos) List(prefix, marker, delimiter string, limit int64, followLink boo
 	return objs, err
 }
 
+// ListWithDelimiter overrides the one of s3client to go through List.
+func (s *oos) ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	return listCommonPrefixes(s, prefix, delimiter, marker, limit)
+}
+
 func newOOS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("https://%s", endpoint)
@@ -21,6 +21,7 @@ package object
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -36,18 +37,26 @@ import (
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
-const ossDefaultRegionID = "cn-hangzhou"
+const (
+	ossDefaultRegionID = "cn-hangzhou"
+	ossSymlinkType     = "Symlink"
+)
 
 type ossClient struct {
 	client *oss.Client
 	bucket *oss.Bucket
 	sc     string
+	creds  *credentialRefresher // renews the STS credentials, nil for the static ones
 }
 
 func (o *ossClient) String() string {
 	return fmt.Sprintf("oss://%s/", o.bucket.BucketName)
 }
 
+func (o *ossClient) BucketName() string {
+	return o.bucket.BucketName
+}
+
 func (o *ossClient) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
@@ -75,9 +84,9 @@ func (o *ossClient) checkError(err error) error {
 		return nil
 	}
 	msg := err.Error()
-	if strings.Contains(msg, "InvalidAccessKeyId") || strings.Contains(msg, "SecurityTokenExpired") {
-		logger.Warnf("refresh security token: %s", err)
-		go o.refreshToken()
+	if o.creds != nil && (strings.Contains(msg, "InvalidAccessKeyId") || strings.Contains(msg, "SecurityTokenExpired")) {
+		logger.Warnf("Renew the security token: %s", err)
+		o.creds.renew()
 	}
 	return err
 }
@@ -104,13 +113,21 @@ func (o *ossClient) Head(key string) (Object, error) {
 	contentLength := r.Get("Content-Length")
 	mtime, _ := time.Parse(time.RFC1123, lastModified)
 	size, _ := strconv.ParseInt(contentLength, 10, 64)
-	return &obj{
+	o2 := obj{
 		key,
 		size,
 		mtime,
 		strings.HasSuffix(key, "/"),
 		r.Get(oss.HTTPHeaderOssStorageClass),
-	}, nil
+	}
+	switch t := r.Get("X-Oss-Object-Type"); t {
+	case ossSymlinkType:
+		return &linkObj{o2}, nil
+	case "":
+		return &o2, nil
+	default:
+		return &typedObj{o2, t}, nil
+	}
 }
 
 func (o *ossClient) Get(key string, off, limit int64, getters ...AttrGetter) (resp io.ReadCloser, err error) {
@@ -159,6 +176,16 @@ func (o *ossClient) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	return o.checkError(err)
 }
 
+// Append appends data by AppendObject, OSS checks the offset against the size of object.
+func (o *ossClient) Append(key string, off int64, data io.Reader) (int64, error) {
+	var h http.Header
+	next, err := o.bucket.AppendObject(key, data, off, oss.GetResponseHeader(&h))
+	if e, ok := err.(oss.ServiceError); ok && e.Code == "PositionNotEqualToLength" {
+		return off, fmt.Errorf("%w: append %s at %d, but the size is %s", ErrAppendOffset, key, off, h.Get(oss.HTTPHeaderOssNextAppendPosition))
+	}
+	return next, o.checkError(err)
+}
+
 func (o *ossClient) Copy(dst, src string) error {
 	var option []oss.Option
 	if o.sc != "" {
@@ -190,6 +217,9 @@ func (o *ossClient) List(prefix, marker, delimiter string, limit int64, followLi
 	for i := 0; i < n; i++ {
 		o := result.Objects[i]
 		objs[i] = &obj{o.Key, o.Size, o.LastModified, strings.HasSuffix(o.Key, "/"), o.StorageClass}
+		if o.Type == ossSymlinkType {
+			objs[i] = &linkObj{obj{o.Key, o.Size, o.LastModified, false, o.StorageClass}}
+		}
 	}
 	if delimiter != "" {
 		for _, o := range result.CommonPrefixes {
@@ -200,6 +230,23 @@ func (o *ossClient) List(prefix, marker, delimiter string, limit int64, followLi
 	return objs, nil
 }
 
+// Symlink creates newName as a symlink object pointing to the object oldName, which is a key in the same bucket.
+// Get, Head and Copy of the symlink are served with the target object by OSS, use Readlink to get the target.
+func (o *ossClient) Symlink(oldName, newName string) error {
+	return o.checkError(o.bucket.PutSymlink(newName, oldName))
+}
+
+func (o *ossClient) Readlink(name string) (string, error) {
+	r, err := o.bucket.GetSymlink(name)
+	if o.checkError(err) != nil {
+		if e, ok := err.(oss.ServiceError); ok && e.StatusCode == http.StatusNotFound {
+			err = os.ErrNotExist
+		}
+		return "", err
+	}
+	return r.Get(oss.HTTPHeaderOssSymlinkTarget), nil
+}
+
 func (o *ossClient) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
 	return nil, notSupported
 }
@@ -285,8 +332,21 @@ type stsCred struct {
 	Code            string
 }
 
+var (
+	// ossMetadataURL is the metadata service of ECS to get the STS credentials of RAM roles
+	ossMetadataURL = "http://100.100.100.200/latest/meta-data/Ram/security-credentials/"
+	// emrMetaServiceURL is the MetaService of EMR: https://help.aliyun.com/document_detail/43966.html
+	emrMetaServiceURL = "http://127.0.0.1:10011/"
+	// the metadata services are local, so they should respond quickly
+	ossMetadataTimeout = 3 * time.Second
+	// the MetaService of EMR doesn't tell when the STS credentials expire, so they are renewed periodically
+	emrCredTTL = time.Hour
+)
+
 func fetch(url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), ossMetadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -294,67 +354,125 @@ func fetch(url string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return io.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	d, err := io.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("GET %s: %s %s", url, resp.Status, d)
+	}
+	return d, err
+}
+
+// ossRAMRole provides the STS credentials of the RAM role attached to the ECS instance from the metadata service,
+// or the ones of the EMR cluster from its MetaService if it's not available.
+type ossRAMRole struct {
+	role string // the name of the RAM role, which is got from the metadata service if empty
 }
 
-func fetchStsToken() (*stsCred, error) {
-	if cred, err := fetchStsCred(); err == nil {
+func (p *ossRAMRole) Retrieve() (*Credentials, error) {
+	cred, err := p.fromECS()
+	if err == nil {
 		return cred, nil
 	}
+	if c, e := fetchEMRCred(); e == nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("fetch STS credentials from the metadata service %s (is a RAM role attached to the ECS instance?): %s", ossMetadataURL, err)
+}
 
-	// EMR MetaService: https://help.aliyun.com/document_detail/43966.html
-	url := "http://127.0.0.1:10011/"
-	token, err := fetch(url + "role-security-token")
-	if err != nil {
-		return nil, err
+func (p *ossRAMRole) fromECS() (*Credentials, error) {
+	role := p.role
+	if role == "" {
+		d, err := fetch(ossMetadataURL)
+		if err != nil {
+			return nil, err
+		}
+		if role = strings.TrimSpace(string(d)); role == "" {
+			return nil, fmt.Errorf("no RAM role is attached")
+		}
 	}
-	accessKey, err := fetch(url + "role-access-key-id")
+	d, err := fetch(ossMetadataURL + role)
 	if err != nil {
 		return nil, err
 	}
-	secretKey, err := fetch(url + "role-access-key-secret")
+	var cred stsCred
+	if err = json.Unmarshal(d, &cred); err != nil {
+		return nil, fmt.Errorf("invalid STS credentials of RAM role %s: %s", role, err)
+	}
+	if cred.Code != "" && cred.Code != "Success" {
+		return nil, fmt.Errorf("fetch STS credentials of RAM role %s: %s", role, cred.Code)
+	}
+	expire, err := time.Parse("2006-01-02T15:04:05Z", cred.Expiration)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid expiration of STS credentials: %s, %s", cred.Expiration, err)
 	}
-	return &stsCred{
-		SecurityToken:   string(token),
-		AccessKeyId:     string(accessKey),
-		AccessKeySecret: string(secretKey),
-		Expiration:      time.Now().Add(time.Hour * 24 * 100).Format("2006-01-02T15:04:05Z"),
+	return &Credentials{
+		AccessKey:  cred.AccessKeyId,
+		SecretKey:  cred.AccessKeySecret,
+		Token:      cred.SecurityToken,
+		Expiration: expire,
 	}, nil
 }
 
-func fetchStsCred() (*stsCred, error) {
-	url := "http://100.100.100.200/latest/meta-data/Ram/security-credentials/"
-	role, err := fetch(url)
+func fetchEMRCred() (*Credentials, error) {
+	token, err := fetch(emrMetaServiceURL + "role-security-token")
 	if err != nil {
 		return nil, err
 	}
-	d, err := fetch(url + string(role))
+	accessKey, err := fetch(emrMetaServiceURL + "role-access-key-id")
 	if err != nil {
 		return nil, err
 	}
-	var cred stsCred
-	err = json.Unmarshal(d, &cred)
-	return &cred, err
-}
-
-func (o *ossClient) refreshToken() time.Time {
-	cred, err := fetchStsToken()
+	secretKey, err := fetch(emrMetaServiceURL + "role-access-key-secret")
 	if err != nil {
-		logger.Errorf("refresh token: %s", err)
-		return time.Now().Add(time.Second)
+		return nil, err
 	}
-	o.client.Config.AccessKeyID = cred.AccessKeyId
-	o.client.Config.AccessKeySecret = cred.AccessKeySecret
-	o.client.Config.SecurityToken = cred.SecurityToken
-	logger.Debugf("Refreshed STS, will be expired at %s", cred.Expiration)
-	expire, err := time.Parse("2006-01-02T15:04:05Z", cred.Expiration)
+	return &Credentials{
+		AccessKey:  string(accessKey),
+		SecretKey:  string(secretKey),
+		Token:      string(token),
+		Expiration: time.Now().Add(emrCredTTL),
+	}, nil
+}
+
+// ossCredentials adapts a credentialRefresher to the credentials provider of OSS SDK, which is asked for the
+// credentials for every request.
+type ossCredentials struct {
+	r *credentialRefresher
+}
+
+func (c *ossCredentials) GetCredentials() oss.Credentials { return c }
+
+func (c *ossCredentials) GetAccessKeyID() string {
+	cred, _ := c.r.current()
+	return cred.AccessKey
+}
+
+func (c *ossCredentials) GetAccessKeySecret() string {
+	cred, _ := c.r.current()
+	return cred.SecretKey
+}
+
+func (c *ossCredentials) GetSecurityToken() string {
+	cred, _ := c.r.current()
+	return cred.Token
+}
+
+// SetCredentialProvider switches the client to the temporary credentials from the provider, which are
+// renewed in background before the security token expires.
+func (o *ossClient) SetCredentialProvider(p CredentialProvider) error {
+	r, err := newCredentialRefresher(p, nil)
 	if err != nil {
-		logger.Errorf("invalid expiration: %s, %s", cred.Expiration, err)
-		return time.Now().Add(time.Minute)
+		return err
 	}
-	return expire
+	o.creds.stop()
+	o.client.Config.CredentialsProvider = &ossCredentials{r}
+	o.creds = r
+	return nil
+}
+
+// Shutdown stops renewing the STS credentials.
+func (o *ossClient) Shutdown() {
+	o.creds.stop()
 }
 
 func autoOSSEndpoint(bucketName, accessKey, secretKey, securityToken string) (string, error) {
@@ -413,7 +531,7 @@ func newOSS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		domain = uri.Scheme + "://" + hostParts[1]
 	}
 
-	var refresh bool
+	var refresher *credentialRefresher
 	if accessKey == "" {
 		// try environment variable
 		accessKey = os.Getenv("ALICLOUD_ACCESS_KEY_ID")
@@ -421,29 +539,28 @@ func newOSS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		token = os.Getenv("SECURITY_TOKEN")
 
 		if accessKey == "" {
-			var err error
-			var cred *stsCred
+			// the STS credentials of the RAM role, which are renewed before they expire
+			role := &ossRAMRole{role: os.Getenv("ALICLOUD_ECS_ROLE_NAME")}
 			maxRetry := 4
 			for i := 0; i < maxRetry; i++ {
 				time.Sleep(time.Second * time.Duration(i))
-				if cred, err = fetchStsToken(); err != nil {
+				if refresher, err = newCredentialRefresher(role, nil); err != nil {
 					logger.Warnf("Fetch STS Token try %d: %s", i+1, err)
 				} else {
-					accessKey = cred.AccessKeyId
-					secretKey = cred.AccessKeySecret
-					token = cred.SecurityToken
-					refresh = true
 					break
 				}
 			}
 			if err != nil {
 				return nil, fmt.Errorf("No credential provided for OSS: %s", err)
 			}
+			cred, _ := refresher.current()
+			accessKey, secretKey, token = cred.AccessKey, cred.SecretKey, cred.Token
 		}
 	}
 
 	if domain == "" {
 		if domain, err = autoOSSEndpoint(bucketName, accessKey, secretKey, token); err != nil {
+			refresher.stop()
 			return nil, fmt.Errorf("Unable to get endpoint of bucket %s: %s", bucketName, err)
 		}
 		logger.Debugf("Use endpoint %q", domain)
@@ -451,6 +568,7 @@ func newOSS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 
 	client, err := oss.New(domain, accessKey, secretKey, oss.SecurityToken(token), oss.HTTPClient(httpClient))
 	if err != nil {
+		refresher.stop()
 		return nil, fmt.Errorf("Cannot create OSS client with endpoint %s: %s", endpoint, err)
 	}
 
@@ -465,19 +583,14 @@ func newOSS(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 
 	bucket, err := client.Bucket(bucketName)
 	if err != nil {
+		refresher.stop()
 		return nil, fmt.Errorf("Cannot create bucket %s: %s", bucketName, err)
 	}
 
-	o := &ossClient{client: client, bucket: bucket}
-	if token != "" && refresh {
-		go func() {
-			for {
-				next := o.refreshToken()
-				time.Sleep(time.Until(next) / 2)
-			}
-		}()
+	if refresher != nil {
+		client.Config.CredentialsProvider = &ossCredentials{refresher}
 	}
-	return o, nil
+	return &ossClient{client: client, bucket: bucket, creds: refresher}, nil
 }
 
 func init() {
@@ -17,9 +17,11 @@
 package object
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -54,6 +56,76 @@ func (s *withPrefix) Readlink(name string) (string, error) {
 	return "", notSupported
 }
 
+func (s *withPrefix) SetMeta(key string, meta map[string]string) error {
+	if w, ok := s.os.(SupportMetadata); ok {
+		return w.SetMeta(s.prefix+key, meta)
+	}
+	return notSupported
+}
+
+func (s *withPrefix) GetMeta(key string) (map[string]string, error) {
+	if w, ok := s.os.(SupportMetadata); ok {
+		return w.GetMeta(s.prefix + key)
+	}
+	return nil, notSupported
+}
+
+func (s *withPrefix) SetTags(key string, tags map[string]string) error {
+	if w, ok := s.os.(SupportTags); ok {
+		return w.SetTags(s.prefix+key, tags)
+	}
+	return notSupported
+}
+
+func (s *withPrefix) GetTags(key string) (map[string]string, error) {
+	if w, ok := s.os.(SupportTags); ok {
+		return w.GetTags(s.prefix + key)
+	}
+	return nil, notSupported
+}
+
+func (s *withPrefix) SignGet(key string, expire time.Duration) (string, error) {
+	if w, ok := s.os.(SupportPresign); ok {
+		return w.SignGet(s.prefix+key, expire)
+	}
+	return "", notSupported
+}
+
+func (s *withPrefix) SignPut(key string, expire time.Duration) (string, error) {
+	if w, ok := s.os.(SupportPresign); ok {
+		return w.SignPut(s.prefix+key, expire)
+	}
+	return "", notSupported
+}
+
+func (p *withPrefix) DeleteMulti(keys []string) ([]string, error) {
+	pkeys := make([]string, len(keys))
+	for i, key := range keys {
+		pkeys[i] = p.prefix + key
+	}
+	failed, err := DeleteMulti(p.os, pkeys)
+	for i, key := range failed {
+		failed[i] = key[len(p.prefix):]
+	}
+	return failed, err
+}
+
+func (p *withPrefix) PutIfNotExists(key string, in io.Reader, getters ...AttrGetter) error {
+	return PutIfNotExists(p.os, p.prefix+key, in, getters...)
+}
+
+func (p *withPrefix) PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	return PutWithExpiry(p.os, p.prefix+key, in, expiry, getters...)
+}
+
+func (p *withPrefix) Exists(key string) (bool, error) {
+	return Exists(p.os, p.prefix+key)
+}
+
+func (p *withPrefix) WithContext(ctx context.Context) ObjectStorage {
+	return &withPrefix{WithContext(p.os, ctx), p.prefix}
+}
+
 func (p *withPrefix) String() string {
 	return fmt.Sprintf("%s%s", p.os, p.prefix)
 }
@@ -85,10 +157,24 @@ func (p *withPrefix) updateKey(o Object) Object {
 	if len(key) < len(p.prefix) {
 		return o
 	}
-	key = key[len(p.prefix):]
+	return setKey(o, key[len(p.prefix):])
+}
+
+// setKey returns the object with key as its key, the known objects are updated in place.
+func setKey(o Object, key string) Object {
 	switch po := o.(type) {
 	case *obj:
 		po.key = key
+	case *checksumObj:
+		po.key = key
+	case *wasbObj:
+		po.key = key
+	case *s3Obj:
+		po.key = key
+	case *typedObj:
+		po.key = key
+	case *linkObj:
+		po.key = key
 	case *file:
 		po.key = key
 	case File:
@@ -114,6 +200,14 @@ func (p *withPrefix) Get(key string, off, limit int64, getters ...AttrGetter) (i
 	return p.os.Get(p.prefix+key, off, limit, getters...)
 }
 
+func (p *withPrefix) Append(key string, off int64, data io.Reader) (int64, error) {
+	return Append(p.os, p.prefix+key, off, data)
+}
+
+func (p *withPrefix) Move(dst, src string) error {
+	return Move(p.os, p.prefix+dst, p.prefix+src)
+}
+
 func (p *withPrefix) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	return p.os.Put(p.prefix+key, in, getters...)
 }
@@ -122,6 +216,10 @@ func (p *withPrefix) Copy(dst, src string) error {
 	return p.os.Copy(dst, src)
 }
 
+func (p *withPrefix) CopyWithOptions(dst, src string, opts CopyOptions) error {
+	return CopyWithOptions(p.os, p.prefix+dst, p.prefix+src, opts)
+}
+
 func (p *withPrefix) Delete(key string, getters ...AttrGetter) error {
 	return p.os.Delete(p.prefix+key, getters...)
 }
@@ -158,6 +256,24 @@ func (p *withPrefix) ListAll(prefix, marker string, followLink bool) (<-chan Obj
 	return r2, nil
 }
 
+func (p *withPrefix) ListAllResumable(prefix string, token ResumeToken, followLink bool) (<-chan ListedObject, error) {
+	r, err := ListAllResumable(p.os, p.prefix+prefix, token, followLink)
+	if err != nil {
+		return r, err
+	}
+	r2 := make(chan ListedObject, 10240)
+	go func() {
+		for o := range r {
+			if o.Object != nil && o.Key() != "" {
+				o.Object = p.updateKey(o.Object)
+			}
+			r2 <- o
+		}
+		close(r2)
+	}()
+	return r2, nil
+}
+
 func (p *withPrefix) Chmod(path string, mode os.FileMode) error {
 	if fs, ok := p.os.(FileSystem); ok {
 		return fs.Chmod(p.prefix+path, mode)
@@ -201,10 +317,15 @@ func (p *withPrefix) CompleteUpload(key string, uploadID string, parts []*Part)
 
 func (p *withPrefix) ListUploads(marker string) ([]*PendingPart, string, error) {
 	parts, nextMarker, err := p.os.ListUploads(marker)
+	// the uploads of other prefixes sharing the bucket are skipped, the marker is opaque and returned as is
+	var ours []*PendingPart
 	for _, part := range parts {
-		part.Key = part.Key[len(p.prefix):]
+		if strings.HasPrefix(part.Key, p.prefix) {
+			part.Key = part.Key[len(p.prefix):]
+			ours = append(ours, part)
+		}
 	}
-	return parts, nextMarker, err
+	return ours, nextMarker, err
 }
 
 var _ ObjectStorage = &withPrefix{}
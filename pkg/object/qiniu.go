@@ -182,6 +182,11 @@ func (q *qiniu) List(prefix, marker, delimiter string, limit int64, followLink b
 	return objs, nil
 }
 
+// ListWithDelimiter overrides the one of s3client to go through List.
+func (q *qiniu) ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	return listCommonPrefixes(q, prefix, delimiter, marker, limit)
+}
+
 func newQiniu(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("https://%s", endpoint)
@@ -217,7 +222,6 @@ func newQiniu(endpoint, accessKey, secretKey, token string) (ObjectStorage, erro
 		return nil, fmt.Errorf("aws session: %s", err)
 	}
 	ses.Handlers.Build.PushFront(disableSha256Func)
-	s3client := s3client{bucket: bucket, s3: s3.New(ses), ses: ses}
 
 	cfg := storage.Config{
 		UseHTTPS: uri.Scheme == "https",
@@ -240,7 +244,7 @@ func newQiniu(endpoint, accessKey, secretKey, token string) (ObjectStorage, erro
 	cfg.Zone = zone
 	cred := auth.New(accessKey, secretKey)
 	bucketManager := storage.NewBucketManager(cred, &cfg)
-	return &qiniu{s3client, bucketManager, cred, &cfg, ""}, nil
+	return &qiniu{s3client{bucket: bucket, s3: s3.New(ses), ses: ses}, bucketManager, cred, &cfg, ""}, nil
 }
 
 func init() {
@@ -0,0 +1,194 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+type rateLimited struct {
+	ObjectStorage
+	ctx   context.Context
+	ops   *ratelimit.Bucket
+	bytes *ratelimit.Bucket
+}
+
+// WithRateLimit limits the number of requests per second and the throughput of Get/Put (and UploadPart)
+// of the object storage, zero or negative value means no limit.
+func WithRateLimit(s ObjectStorage, opsPerSec int, bytesPerSec int64) ObjectStorage {
+	if opsPerSec <= 0 && bytesPerSec <= 0 {
+		return s
+	}
+	r := &rateLimited{ObjectStorage: s, ctx: ctx}
+	if opsPerSec > 0 {
+		r.ops = ratelimit.NewBucketWithRate(float64(opsPerSec), int64(opsPerSec))
+	}
+	if bytesPerSec > 0 {
+		r.bytes = ratelimit.NewBucketWithRate(float64(bytesPerSec), bytesPerSec)
+	}
+	return r
+}
+
+// wait blocks until n tokens are available in the bucket or the context is canceled.
+func wait(ctx context.Context, b *ratelimit.Bucket, n int64) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	d := b.Take(n)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// WithContext returns a copy sharing the same limits, the waiting is also canceled together with ctx.
+func (r *rateLimited) WithContext(ctx context.Context) ObjectStorage {
+	return &rateLimited{WithContext(r.ObjectStorage, ctx), ctx, r.ops, r.bytes}
+}
+
+func (r *rateLimited) String() string {
+	return fmt.Sprintf("%s(ratelimited)", r.ObjectStorage)
+}
+
+type throttledReader struct {
+	io.Reader
+	r *rateLimited
+}
+
+func (l *throttledReader) Read(p []byte) (int, error) {
+	n, err := l.Reader.Read(p)
+	if e := wait(l.r.ctx, l.r.bytes, int64(n)); e != nil && err == nil {
+		err = e
+	}
+	return n, err
+}
+
+type throttledReadCloser struct {
+	throttledReader
+	io.Closer
+}
+
+type throttledReadSeeker struct {
+	throttledReader
+	io.Seeker
+}
+
+func (r *rateLimited) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	in, err := r.ObjectStorage.Get(key, off, limit, getters...)
+	if err != nil || r.bytes == nil {
+		return in, err
+	}
+	return &throttledReadCloser{throttledReader{in, r}, in}, nil
+}
+
+func (r *rateLimited) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return err
+	}
+	if r.bytes != nil {
+		if rs, ok := in.(io.ReadSeeker); ok {
+			// keep it seekable so the object storage can calculate checksum or retry
+			in = &throttledReadSeeker{throttledReader{rs, r}, rs}
+		} else {
+			in = &throttledReader{in, r}
+		}
+	}
+	return r.ObjectStorage.Put(key, in, getters...)
+}
+
+func (r *rateLimited) Copy(dst, src string) error {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return err
+	}
+	return r.ObjectStorage.Copy(dst, src)
+}
+
+func (r *rateLimited) Delete(key string, getters ...AttrGetter) error {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return err
+	}
+	return r.ObjectStorage.Delete(key, getters...)
+}
+
+func (r *rateLimited) Head(key string) (Object, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	return r.ObjectStorage.Head(key)
+}
+
+func (r *rateLimited) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	return r.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+}
+
+func (r *rateLimited) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	return r.ObjectStorage.CreateMultipartUpload(key)
+}
+
+func (r *rateLimited) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	if err := wait(r.ctx, r.bytes, int64(len(body))); err != nil {
+		return nil, err
+	}
+	return r.ObjectStorage.UploadPart(key, uploadID, num, body)
+}
+
+func (r *rateLimited) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, err
+	}
+	return r.ObjectStorage.UploadPartCopy(key, uploadID, num, srcKey, off, size)
+}
+
+func (r *rateLimited) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return err
+	}
+	return r.ObjectStorage.CompleteUpload(key, uploadID, parts)
+}
+
+func (r *rateLimited) ListUploads(marker string) ([]*PendingPart, string, error) {
+	if err := wait(r.ctx, r.ops, 1); err != nil {
+		return nil, "", err
+	}
+	return r.ObjectStorage.ListUploads(marker)
+}
+
+var _ ObjectStorage = &rateLimited{}
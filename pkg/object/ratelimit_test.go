@@ -0,0 +1,67 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	testStorage(t, WithRateLimit(m, 10000, 1<<30))
+
+	s := WithRateLimit(m, 50, 1<<20)
+	start := time.Now()
+	// the first 50 requests are allowed by the burst, the other 25 take 0.5 second
+	for i := 0; i < 75; i++ {
+		_, _ = s.Head("missing")
+	}
+	if used := time.Since(start); used < 400*time.Millisecond {
+		t.Fatalf("75 requests with 50 ops/s should take at least 0.5 second, but got %s", used)
+	}
+
+	data := make([]byte, 3<<19)
+	start = time.Now()
+	if err := s.Put("data", bytes.NewReader(data)); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	in, err := s.Get("data", 0, -1)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if n, err := io.Copy(io.Discard, in); err != nil || n != int64(len(data)) {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	_ = in.Close()
+	// 1 MiB burst, then 2 MiB at 1 MiB/s
+	if used := time.Since(start); used < 1500*time.Millisecond {
+		t.Fatalf("3 MiB with 1 MiB/s should take at least 2 seconds, but got %s", used)
+	}
+
+	s = WithRateLimit(m, 1, 0)
+	_, _ = s.Head("missing") // use up the burst
+	c, cancel := context.WithCancel(context.Background())
+	s.(*rateLimited).ctx = c
+	cancel()
+	if _, err = s.Head("missing"); err != context.Canceled {
+		t.Fatalf("wait should be canceled, but got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrReadOnly is returned by the methods that modify a read-only object storage.
+var ErrReadOnly = errors.New("object storage is read-only")
+
+// ErrAnonymousReadOnly is returned by the methods that modify an object storage accessed anonymously,
+// it's also an ErrReadOnly.
+var ErrAnonymousReadOnly error = anonymousReadOnly{}
+
+type anonymousReadOnly struct{}
+
+func (anonymousReadOnly) Error() string        { return "anonymous access is read-only" }
+func (anonymousReadOnly) Is(target error) bool { return target == ErrReadOnly }
+
+type readOnly struct {
+	ObjectStorage
+	err error
+}
+
+// WithReadOnly returns an object storage that can only be read, all the methods that modify it
+// fail with ErrReadOnly without sending any request.
+func WithReadOnly(s ObjectStorage) ObjectStorage {
+	return &readOnly{s, ErrReadOnly}
+}
+
+// withAnonymous returns a read-only object storage for the client without credentials.
+func withAnonymous(s ObjectStorage) ObjectStorage {
+	return &readOnly{s, ErrAnonymousReadOnly}
+}
+
+func (r *readOnly) WithContext(ctx context.Context) ObjectStorage {
+	return &readOnly{WithContext(r.ObjectStorage, ctx), r.err}
+}
+
+func (r *readOnly) String() string {
+	return fmt.Sprintf("%s(readonly)", r.ObjectStorage)
+}
+
+func (r *readOnly) Create() error {
+	return fmt.Errorf("%w: create %s", r.err, r.ObjectStorage)
+}
+
+func (r *readOnly) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return fmt.Errorf("%w: put %s", r.err, key)
+}
+
+func (r *readOnly) PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	return fmt.Errorf("%w: put %s", r.err, key)
+}
+
+func (r *readOnly) Append(key string, off int64, data io.Reader) (int64, error) {
+	return off, fmt.Errorf("%w: append %s", r.err, key)
+}
+
+func (r *readOnly) Copy(dst, src string) error {
+	return fmt.Errorf("%w: copy %s to %s", r.err, src, dst)
+}
+
+func (r *readOnly) CopyWithOptions(dst, src string, opts CopyOptions) error {
+	return fmt.Errorf("%w: copy %s to %s", r.err, src, dst)
+}
+
+func (r *readOnly) Move(dst, src string) error {
+	return fmt.Errorf("%w: move %s to %s", r.err, src, dst)
+}
+
+func (r *readOnly) Delete(key string, getters ...AttrGetter) error {
+	return fmt.Errorf("%w: delete %s", r.err, key)
+}
+
+func (r *readOnly) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return nil, fmt.Errorf("%w: create multipart upload %s", r.err, key)
+}
+
+func (r *readOnly) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	return nil, fmt.Errorf("%w: upload part %d of %s", r.err, num, key)
+}
+
+func (r *readOnly) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
+	return nil, fmt.Errorf("%w: upload part %d of %s", r.err, num, key)
+}
+
+func (r *readOnly) AbortUpload(key string, uploadID string) {
+	logger.Warnf("Abort upload %s of %s: %s", uploadID, key, r.err)
+}
+
+func (r *readOnly) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	return fmt.Errorf("%w: complete upload %s", r.err, key)
+}
+
+var _ ObjectStorage = &readOnly{}
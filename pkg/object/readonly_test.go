@@ -0,0 +1,80 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	_ = m.Put("a", bytes.NewReader([]byte("data")))
+	up, _ := m.CreateMultipartUpload("mp")
+	s := WithReadOnly(m)
+
+	_, err := s.CreateMultipartUpload("b")
+	_, err2 := s.UploadPart("mp", up.UploadID, 1, []byte("x"))
+	_, err3 := s.UploadPartCopy("mp", up.UploadID, 2, "a", 0, 4)
+	_, err4 := DeleteMulti(s, []string{"a"})
+	for i, err := range []error{
+		s.Create(),
+		s.Put("b", bytes.NewReader([]byte("b"))),
+		s.Copy("b", "a"),
+		s.Delete("a"),
+		err, err2, err3,
+		s.CompleteUpload("mp", up.UploadID, []*Part{{Num: 1}}),
+		err4,
+		PutIfNotExists(s, "b", bytes.NewReader(nil)),
+	} {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Fatalf("mutating method %d should fail with ErrReadOnly: %v", i, err)
+		}
+	}
+	s.AbortUpload("mp", up.UploadID)
+	if ups, _, _ := m.ListUploads(""); len(ups) != 1 {
+		t.Fatalf("upload should not be aborted: %+v", ups)
+	}
+	if _, err := m.Head("b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("b should not be created: %v", err)
+	}
+
+	if o, err := s.Head("a"); err != nil || o.Size() != 4 {
+		t.Fatalf("head: %+v %v", o, err)
+	}
+	in, err := s.Get("a", 1, 2)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if d, _ := io.ReadAll(in); string(d) != "at" {
+		t.Fatalf("get: %s", d)
+	}
+	if objs, err := s.List("", "", "", 10, true); err != nil || len(objs) != 1 {
+		t.Fatalf("list: %+v %v", objs, err)
+	}
+	if ups, _, err := s.ListUploads(""); err != nil || len(ups) != 1 {
+		t.Fatalf("list uploads: %+v %v", ups, err)
+	}
+	if _, err := s.ListAll("", "", true); err != notSupported {
+		t.Fatalf("list all should be passed through: %v", err)
+	}
+	if s.Limits() != m.Limits() {
+		t.Fatalf("limits should be passed through")
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,7 +36,11 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-// redisStore stores data chunks into Redis.
+// redisChunkSize is the max size of a Redis value, the larger objects are split into chunks (at most 512 MiB).
+const redisChunkSize = 32 << 20
+
+// redisStore stores data chunks into Redis. The objects are stored as strings, or hashes of the size and
+// chunks (field "0", "1", ...) if they are larger than redisChunkSize.
 type redisStore struct {
 	DefaultObjectStorage
 	rdb redis.UniversalClient
@@ -50,9 +55,19 @@ func (r *redisStore) Create() error {
 	return nil
 }
 
+func isWrongType(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
 func (r *redisStore) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
 	data, err := r.rdb.Get(ctx, key).Bytes()
-	if err != nil {
+	if isWrongType(err) {
+		data, err = r.getChunks(key, off, limit)
+		off = 0
+	}
+	if err == redis.Nil {
+		return nil, os.ErrNotExist
+	} else if err != nil {
 		return nil, err
 	}
 	if off > int64(len(data)) {
@@ -65,12 +80,76 @@ func (r *redisStore) Get(key string, off, limit int64, getters ...AttrGetter) (i
 	return io.NopCloser(bytes.NewBuffer(data)), nil
 }
 
+// getChunks reads the chunks of the range from a large object, the data before off in the first chunk is dropped.
+func (r *redisStore) getChunks(key string, off, limit int64) ([]byte, error) {
+	size, err := r.rdb.HGet(ctx, key, "size").Int64()
+	if err != nil {
+		return nil, err
+	}
+	end := size
+	if limit > 0 && off+limit < end {
+		end = off + limit
+	}
+	if off >= end {
+		return nil, nil
+	}
+	first := off / redisChunkSize
+	var fields []string
+	for i := first; i*redisChunkSize < end; i++ {
+		fields = append(fields, strconv.FormatInt(i, 10))
+	}
+	values, err := r.rdb.HMGet(ctx, key, fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, int64(len(fields))*redisChunkSize)
+	for i, v := range values {
+		chunk, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("chunk %s of %s is missing", fields[i], key)
+		}
+		buf = append(buf, chunk...)
+	}
+	off -= first * redisChunkSize
+	end -= first * redisChunkSize
+	if end > int64(len(buf)) {
+		return nil, fmt.Errorf("chunks of %s are shorter than %d bytes", key, size)
+	}
+	return buf[off:end], nil
+}
+
 func (r *redisStore) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	data, err := io.ReadAll(in)
 	if err != nil {
 		return err
 	}
-	return r.rdb.Set(ctx, key, data, 0).Err()
+	if len(data) <= redisChunkSize {
+		return r.rdb.Set(ctx, key, data, 0).Err()
+	}
+	values := []interface{}{"size", len(data)}
+	for i := 0; i*redisChunkSize < len(data); i++ {
+		end := (i + 1) * redisChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		values = append(values, strconv.Itoa(i), data[i*redisChunkSize:end])
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, values...)
+		return nil
+	})
+	return err
+}
+
+// Copy reads the object and writes it again, since the keys may be in different slots of Redis Cluster.
+func (r *redisStore) Copy(dst, src string) error {
+	in, err := r.Get(src, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return r.Put(dst, in)
 }
 
 func (r *redisStore) Delete(key string, getters ...AttrGetter) error {
@@ -132,7 +211,7 @@ func (t *redisStore) ListAll(prefix, marker string, followLink bool) (<-chan Obj
 				p.StrLen(ctx, key)
 			}
 			cmds, err := p.Exec(ctx)
-			if err != nil {
+			if err != nil && !isWrongType(err) {
 				objs <- nil
 				return
 			}
@@ -141,6 +220,12 @@ func (t *redisStore) ListAll(prefix, marker string, followLink bool) (<-chan Obj
 			for idx, cmd := range cmds {
 				if intCmd, ok := cmd.(*redis.IntCmd); ok {
 					size, err := intCmd.Result()
+					if isWrongType(err) {
+						size, err = t.rdb.HGet(ctx, keyList[start:end][idx], "size").Int64()
+						if err == redis.Nil {
+							continue
+						}
+					}
 					if err != nil {
 						objs <- nil
 						return
@@ -165,13 +250,25 @@ func (t *redisStore) ListAll(prefix, marker string, followLink bool) (<-chan Obj
 }
 
 func (t *redisStore) Head(key string) (Object, error) {
-	data, err := t.rdb.Get(context.TODO(), key).Bytes()
+	typ, err := t.rdb.Type(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	switch typ {
+	case "none":
+		return nil, os.ErrNotExist
+	case "hash":
+		size, err = t.rdb.HGet(ctx, key, "size").Int64()
+	default:
+		size, err = t.rdb.StrLen(ctx, key).Result()
+	}
 	if err == redis.Nil {
 		return nil, os.ErrNotExist
 	}
 	return &obj{
 		key,
-		int64(len(data)),
+		size,
 		time.Now(),
 		strings.HasSuffix(key, "/"),
 		"",
@@ -16,6 +16,8 @@
 
 package object
 
+import "github.com/juicedata/juicefs/pkg/utils"
+
 const DefaultStorageClass = "STANDARD"
 
 type SupportStorageClass interface {
@@ -26,6 +28,9 @@ type SupportStorageClass interface {
 type ResponseAttrs struct {
 	storageClass *string
 	requestID    *string
+	contentType  string            // the Content-Type of the object to put
+	tagging      string            // the tags of the object to put, URL encoded
+	metadata     map[string]string // the user defined metadata of the object to put
 	// other interested attrs can be added here
 }
 
@@ -57,6 +62,37 @@ func WithStorageClass(sc *string) AttrGetter {
 	}
 }
 
+// WithContentType sets the Content-Type of the object to put, which overrides the one inferred from the key.
+func WithContentType(ct string) AttrGetter {
+	return func(attrs *ResponseAttrs) {
+		attrs.contentType = ct
+	}
+}
+
+// withTagging sets the tags (URL encoded) of the object to put, which is only used internally by S3.
+func withTagging(tagging string) AttrGetter {
+	return func(attrs *ResponseAttrs) {
+		attrs.tagging = tagging
+	}
+}
+
+// withMetadata sets the user defined metadata of the object to put, which is only used internally by the object
+// storages supporting metadata (SupportMetadata).
+func withMetadata(meta map[string]string) AttrGetter {
+	return func(attrs *ResponseAttrs) {
+		attrs.metadata = meta
+	}
+}
+
+// contentTypeOf returns the Content-Type given by WithContentType, or the one inferred from the extension of key if
+// infer is true, an empty string leaves it to the object storage.
+func (r *ResponseAttrs) contentTypeOf(key string, infer bool) string {
+	if r.contentType != "" || !infer {
+		return r.contentType
+	}
+	return utils.GuessMimeType(key)
+}
+
 func applyGetters(getters ...AttrGetter) ResponseAttrs {
 	var attrs ResponseAttrs
 	for _, getter := range getters {
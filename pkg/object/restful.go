@@ -19,6 +19,7 @@ package object
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"math/rand"
@@ -83,6 +84,26 @@ func GetHttpClient() *http.Client {
 	return httpClient
 }
 
+// loadCACert reads the certificates of a private certificate authority from the PEM file caCert.
+func loadCACert(caCert string) ([]byte, error) {
+	data, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("load ca-cert: %s", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load ca-cert: no PEM certificate found in %s", caCert)
+	}
+	return data, nil
+}
+
+// copyHTTPClient returns a copy of the default HTTP client with its own transport, which can be changed without
+// affecting the others.
+func copyHTTPClient() *http.Client {
+	client := *httpClient
+	client.Transport = httpClient.Transport.(*http.Transport).Clone()
+	return &client
+}
+
 func cleanup(response *http.Response) {
 	if response != nil && response.Body != nil {
 		_, _ = io.Copy(io.Discard, response.Body)
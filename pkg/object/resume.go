@@ -0,0 +1,102 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ResumeToken is an opaque position of ListAllResumable, which can be persisted as a string and passed back to
+// continue the listing after a restart. An empty token starts from the beginning.
+type ResumeToken string
+
+// resumeState is the content of ResumeToken.
+type resumeState struct {
+	Prefix string `json:"prefix"`
+	Marker string `json:"marker"`         // the key of the last object listed
+	Page   string `json:"page,omitempty"` // the continuation token of the object storage for the page after Marker
+}
+
+func (s *resumeState) token() ResumeToken {
+	d, _ := json.Marshal(s)
+	return ResumeToken(base64.RawURLEncoding.EncodeToString(d))
+}
+
+// parse returns the state of token, which must be returned by the listing of prefix.
+func (t ResumeToken) parse(prefix string) (*resumeState, error) {
+	if t == "" {
+		return &resumeState{Prefix: prefix}, nil
+	}
+	d, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err != nil {
+		return nil, fmt.Errorf("invalid resume token: %s", err)
+	}
+	var s resumeState
+	if err = json.Unmarshal(d, &s); err != nil {
+		return nil, fmt.Errorf("invalid resume token: %s", err)
+	}
+	if s.Prefix != prefix {
+		return nil, fmt.Errorf("the resume token is for prefix %q rather than %q", s.Prefix, prefix)
+	}
+	return &s, nil
+}
+
+// ListedObject is an object returned by ListAllResumable, with the token to resume the listing after it.
+type ListedObject struct {
+	Object
+	Token ResumeToken
+}
+
+// SupportResumableListing is implemented by the object storages that can resume ListAll from the position of
+// their own (e.g. the continuation token of Azure), rather than the key of the last object.
+type SupportResumableListing interface {
+	// ListAllResumable lists all the objects after token like ListAll, a ListedObject with nil Object is sent if
+	// the listing fails.
+	ListAllResumable(prefix string, token ResumeToken, followLink bool) (<-chan ListedObject, error)
+}
+
+// ListAllResumable lists all the objects with prefix after the position of token (from the beginning if it's
+// empty), every object is returned with the token to continue the listing after it, which survives the restarts
+// of the process. The object storages that can't resume from their own positions continue after the key of the
+// last object.
+func ListAllResumable(store ObjectStorage, prefix string, token ResumeToken, followLink bool) (<-chan ListedObject, error) {
+	if s, ok := store.(SupportResumableListing); ok {
+		return s.ListAllResumable(prefix, token, followLink)
+	}
+	state, err := token.parse(prefix)
+	if err != nil {
+		return nil, err
+	}
+	in, err := ListAll(store, prefix, state.Marker, followLink)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan ListedObject, maxResults)
+	go func() {
+		defer close(out)
+		for o := range in {
+			if o == nil {
+				out <- ListedObject{}
+				return
+			}
+			out <- ListedObject{o, (&resumeState{Prefix: prefix, Marker: o.Key()}).token()}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,143 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+// listInTwoRuns stops the listing after stop objects, and resumes it from the serialized token as a new process.
+func listInTwoRuns(t *testing.T, s ObjectStorage, prefix string, stop int) []string {
+	t.Helper()
+	ch, err := ListAllResumable(s, prefix, "", true)
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	var keys []string
+	var saved string
+	for o := range ch {
+		if o.Object == nil {
+			t.Fatalf("list failed")
+		}
+		keys = append(keys, o.Key())
+		if saved = string(o.Token); len(keys) == stop {
+			break
+		}
+	}
+	ch, err = ListAllResumable(s, prefix, ResumeToken(saved), true)
+	if err != nil {
+		t.Fatalf("resume from %q: %s", saved, err)
+	}
+	for o := range ch {
+		if o.Object == nil {
+			t.Fatalf("resumed listing failed")
+		}
+		keys = append(keys, o.Key())
+	}
+	return keys
+}
+
+func checkListed(t *testing.T, keys []string, expected []string) {
+	t.Helper()
+	if len(keys) != len(expected) {
+		t.Fatalf("listed %d objects, expect %d: %v", len(keys), len(expected), keys)
+	}
+	for i, k := range keys {
+		if k != expected[i] {
+			t.Fatalf("the %dth object is %q, expect %q", i, k, expected[i])
+		}
+	}
+}
+
+func TestResumableListing(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	var expected []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("d/k%03d", i)
+		if err := m.Put(key, bytes.NewReader(nil)); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+		expected = append(expected, key)
+	}
+	_ = m.Put("e/k", bytes.NewReader(nil))
+	checkListed(t, listInTwoRuns(t, m, "d/", 12), expected)
+	for i := range expected {
+		expected[i] = expected[i][2:]
+	}
+	checkListed(t, listInTwoRuns(t, WithPrefix(m, "d/"), "", 17), expected)
+
+	if _, err := ListAllResumable(m, "d/", "invalid!", true); err == nil {
+		t.Fatalf("invalid token should fail")
+	}
+	ch, _ := ListAllResumable(m, "d/", "", true)
+	o := <-ch
+	if _, err := ListAllResumable(m, "e/", o.Token, true); err == nil {
+		t.Fatalf("token of another prefix should fail")
+	}
+}
+
+func TestAzureResumableListing(t *testing.T) {
+	var calls int
+	proxy := httptest.NewServer(fakeAzureList(50, &calls))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("AZURE_STORAGE_CONNECTION_STRING", "")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	s, err := newWasb("http://test.core.windows.net?list-page-size=7", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	var expected []string
+	for i := 0; i < 50; i++ {
+		expected = append(expected, fmt.Sprintf("k%07d", i))
+	}
+	for _, stop := range []int{1, 7, 23, 49} {
+		checkListed(t, listInTwoRuns(t, s, "", stop), expected)
+	}
+
+	// the resumed listing starts from the page of the last object
+	ch, err := ListAllResumable(s, "", "", true)
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+	var token ResumeToken
+	for o := range ch {
+		if token = o.Token; o.Key() == "k0000040" {
+			break
+		}
+	}
+	for range ch {
+	}
+	calls = 0
+	ch, err = ListAllResumable(s, "", token, true)
+	if err != nil {
+		t.Fatalf("resume: %s", err)
+	}
+	var keys []string
+	for o := range ch {
+		keys = append(keys, o.Key())
+	}
+	checkListed(t, keys, expected[41:])
+	if calls != 3 {
+		t.Fatalf("requests to resume from the 6th of 8 pages: %d, expect 3", calls)
+	}
+}
@@ -0,0 +1,411 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	retryBaseDelay = 200 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// retryCountFromEnv returns the retry count set by the environment variable name, or def if it's not set.
+func retryCountFromEnv(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		logger.Warnf("Invalid %s: %q, use %d", name, v, def)
+	}
+	return def
+}
+
+// backoff returns the delay before the n-th retry (starting from 0): exponential with jitter.
+func backoff(n int) time.Duration {
+	d := retryBaseDelay << uint(n)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d, or returns the error of ctx if it's done before that.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retryable,
+// has been retried for maxRetries times, or ctx is done while waiting for the next try.
+func withRetry(ctx context.Context, maxRetries int, retryable func(error) bool, fn func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		if err = fn(); err == nil || i >= maxRetries || !retryable(err) {
+			return err
+		}
+		logger.Debugf("Retry after error: %s", err)
+		if e := sleep(ctx, backoff(i)); e != nil {
+			return fmt.Errorf("%w (last error: %s)", e, err)
+		}
+	}
+}
+
+// isTransientNetError returns true for network errors that are likely to be gone after a retry.
+func isTransientNetError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
+
+// statusCodeFuncs extract the HTTP status code from the errors of the SDKs, the backends add theirs in init().
+var statusCodeFuncs []func(error) int
+
+// httpStatusCode returns the HTTP status code carried by the error, or 0 if unknown.
+func httpStatusCode(err error) int {
+	var sc interface{ StatusCode() int } // aws-sdk-go and others
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+	for _, f := range statusCodeFuncs {
+		if code := f(err); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// DefaultShouldRetry returns true for timeouts, connection resets, and HTTP 408, 429 or 5xx responses.
+func DefaultShouldRetry(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, notSupported) {
+		return false
+	}
+	switch code := httpStatusCode(err); {
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500 && code != http.StatusNotImplemented:
+		return true
+	case code != 0:
+		return false
+	}
+	return isTransientNetError(err)
+}
+
+// isNotFound returns true if the object is not found, by ErrNotFound or a 404 response.
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || httpStatusCode(err) == http.StatusNotFound
+}
+
+type retryStorage struct {
+	ObjectStorage
+	ctx         context.Context
+	maxRetries  int
+	shouldRetry func(error) bool
+	recent      *recentWrites // nil if read-after-write is not retried
+}
+
+// WithRetry retries the failed requests with exponential backoff, up to maxRetries times (3 if it's not positive),
+// if shouldRetry (DefaultShouldRetry if nil) returns true for the error. Put is retried only if the reader is
+// seekable (a warning is logged otherwise), a Get is re-issued from where it stopped when reading the body fails.
+func WithRetry(s ObjectStorage, maxRetries int, shouldRetry func(error) bool) ObjectStorage {
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	if shouldRetry == nil {
+		shouldRetry = DefaultShouldRetry
+	}
+	return &retryStorage{s, context.Background(), maxRetries, shouldRetry, nil}
+}
+
+// ReadAfterWrite configures the retries of reading the objects which may not be visible right after written.
+type ReadAfterWrite struct {
+	Attempts int           // how many times to retry after the object is not found
+	Delay    time.Duration // the delay before each retry
+	Window   time.Duration // how long an object may be invisible after it's written
+}
+
+// recentWrites remembers when the objects are written in the window.
+type recentWrites struct {
+	sync.Mutex
+	ReadAfterWrite
+	written map[string]time.Time
+	pruned  time.Time
+}
+
+func (w *recentWrites) add(key string) {
+	w.Lock()
+	defer w.Unlock()
+	now := time.Now()
+	if now.Sub(w.pruned) > w.Window {
+		for k, t := range w.written {
+			if now.Sub(t) > w.Window {
+				delete(w.written, k)
+			}
+		}
+		w.pruned = now
+	}
+	w.written[key] = now
+}
+
+func (w *recentWrites) remove(key string) {
+	w.Lock()
+	delete(w.written, key)
+	w.Unlock()
+}
+
+func (w *recentWrites) isRecent(key string) bool {
+	w.Lock()
+	defer w.Unlock()
+	t, ok := w.written[key]
+	return ok && time.Since(t) < w.Window
+}
+
+// WithReadAfterWriteRetry is WithRetry that also retries the Get and Head failed with not found in the window
+// after the object is written through it (by Put, Copy or CompleteUpload), for the object storages whose new
+// objects may not be visible immediately. The objects not written recently (or deleted after written) are not
+// retried, so the missing ones are still reported without delay.
+func WithReadAfterWriteRetry(s ObjectStorage, maxRetries int, shouldRetry func(error) bool, raw ReadAfterWrite) ObjectStorage {
+	r := WithRetry(s, maxRetries, shouldRetry).(*retryStorage)
+	if raw.Attempts > 0 && raw.Window > 0 {
+		r.recent = &recentWrites{ReadAfterWrite: raw, written: make(map[string]time.Time)}
+	}
+	return r
+}
+
+func (r *retryStorage) retry(fn func() error) error {
+	return withRetry(r.ctx, r.maxRetries, r.shouldRetry, fn)
+}
+
+func (r *retryStorage) WithContext(ctx context.Context) ObjectStorage {
+	return &retryStorage{WithContext(r.ObjectStorage, ctx), ctx, r.maxRetries, r.shouldRetry, r.recent}
+}
+
+func (r *retryStorage) String() string {
+	return fmt.Sprintf("%s(retry)", r.ObjectStorage)
+}
+
+// retryNotFound retries fn which failed with err, while it's not found in the window after key is written.
+func (r *retryStorage) retryNotFound(key string, err error, fn func() error) error {
+	for i := 0; r.recent != nil && i < r.recent.Attempts && isNotFound(err) && r.recent.isRecent(key); i++ {
+		logger.Debugf("%s is not visible after written, retry in %s", key, r.recent.Delay)
+		if e := sleep(r.ctx, r.recent.Delay); e != nil {
+			return err
+		}
+		err = r.retry(fn)
+	}
+	return err
+}
+
+// written remembers key is written if err is nil.
+func (r *retryStorage) written(key string, err error) error {
+	if err == nil && r.recent != nil {
+		r.recent.add(key)
+	}
+	return err
+}
+
+func (r *retryStorage) Create() error {
+	return r.retry(r.ObjectStorage.Create)
+}
+
+func (r *retryStorage) Head(key string) (o Object, err error) {
+	head := func() error {
+		o, err = r.ObjectStorage.Head(key)
+		return err
+	}
+	err = r.retryNotFound(key, r.retry(head), head)
+	return
+}
+
+// retryReader re-issues the Get from the current offset if reading the body fails.
+type retryReader struct {
+	r     *retryStorage
+	in    io.ReadCloser
+	key   string
+	off   int64
+	limit int64
+	read  int64
+	fails int
+	attrs []AttrGetter
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := rr.in.Read(p)
+		rr.read += int64(n)
+		if err == nil || err == io.EOF || rr.fails >= rr.r.maxRetries || !rr.r.shouldRetry(err) {
+			return n, err
+		}
+		if rr.limit > 0 && rr.read >= rr.limit {
+			// all of it is read, a Get with no bytes left would read to the end
+			return n, io.EOF
+		}
+		logger.Debugf("Re-issue get %s from %d after error: %s", rr.key, rr.off+rr.read, err)
+		if e := sleep(rr.r.ctx, backoff(rr.fails)); e != nil {
+			return n, err
+		}
+		rr.fails++
+		_ = rr.in.Close()
+		limit := rr.limit
+		if limit > 0 {
+			limit -= rr.read
+		} else if rr.off+rr.read > 0 {
+			limit = 0
+		}
+		in, e := rr.r.ObjectStorage.Get(rr.key, rr.off+rr.read, limit, rr.attrs...)
+		if e != nil {
+			rr.in = io.NopCloser(errReader{e})
+			return n, e
+		}
+		rr.in = in
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (rr *retryReader) Close() error {
+	return rr.in.Close()
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read(p []byte) (int, error) { return 0, e.err }
+
+func (r *retryStorage) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	var in io.ReadCloser
+	get := func() (err error) {
+		in, err = r.ObjectStorage.Get(key, off, limit, getters...)
+		return
+	}
+	err := r.retryNotFound(key, r.retry(get), get)
+	if err != nil {
+		return nil, err
+	}
+	return &retryReader{r: r, in: in, key: key, off: off, limit: limit, attrs: getters}, nil
+}
+
+func (r *retryStorage) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	rs, ok := in.(io.ReadSeeker)
+	var start int64
+	var err error
+	if ok {
+		start, err = rs.Seek(0, io.SeekCurrent)
+	}
+	if !ok || err != nil {
+		// the data can't be read again
+		err = r.ObjectStorage.Put(key, in, getters...)
+		if err != nil && r.shouldRetry(err) {
+			logger.Warnf("Put %s is not retried since the reader is not seekable: %s", key, err)
+		}
+		return r.written(key, err)
+	}
+	return r.written(key, r.retry(func() error {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		return r.ObjectStorage.Put(key, rs, getters...)
+	}))
+}
+
+func (r *retryStorage) Copy(dst, src string) error {
+	return r.written(dst, r.retry(func() error { return r.ObjectStorage.Copy(dst, src) }))
+}
+
+func (r *retryStorage) Delete(key string, getters ...AttrGetter) error {
+	if r.recent != nil {
+		// it's missing for real after deleted
+		r.recent.remove(key)
+	}
+	return r.retry(func() error { return r.ObjectStorage.Delete(key, getters...) })
+}
+
+func (r *retryStorage) List(prefix, marker, delimiter string, limit int64, followLink bool) (objs []Object, err error) {
+	err = r.retry(func() error {
+		objs, err = r.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+		return err
+	})
+	return
+}
+
+func (r *retryStorage) ListAll(prefix, marker string, followLink bool) (ch <-chan Object, err error) {
+	err = r.retry(func() error {
+		ch, err = r.ObjectStorage.ListAll(prefix, marker, followLink)
+		return err
+	})
+	return
+}
+
+func (r *retryStorage) CreateMultipartUpload(key string) (up *MultipartUpload, err error) {
+	err = r.retry(func() error {
+		up, err = r.ObjectStorage.CreateMultipartUpload(key)
+		return err
+	})
+	return
+}
+
+func (r *retryStorage) UploadPart(key string, uploadID string, num int, body []byte) (part *Part, err error) {
+	err = r.retry(func() error {
+		part, err = r.ObjectStorage.UploadPart(key, uploadID, num, body)
+		return err
+	})
+	return
+}
+
+func (r *retryStorage) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (part *Part, err error) {
+	err = r.retry(func() error {
+		part, err = r.ObjectStorage.UploadPartCopy(key, uploadID, num, srcKey, off, size)
+		return err
+	})
+	return
+}
+
+func (r *retryStorage) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	return r.written(key, r.retry(func() error { return r.ObjectStorage.CompleteUpload(key, uploadID, parts) }))
+}
+
+func (r *retryStorage) ListUploads(marker string) (parts []*PendingPart, next string, err error) {
+	err = r.retry(func() error {
+		parts, next, err = r.ObjectStorage.ListUploads(marker)
+		return err
+	})
+	return
+}
+
+var _ ObjectStorage = &retryStorage{}
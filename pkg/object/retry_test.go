@@ -0,0 +1,318 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	var calls int
+	err := withRetry(context.Background(), 3, isTransientNetError, func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expect success after 3 calls, got %d calls: %v", calls, err)
+	}
+
+	calls = 0
+	err = withRetry(context.Background(), 2, isTransientNetError, func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || calls != 3 {
+		t.Fatalf("expect to give up after 2 retries, got %d calls: %v", calls, err)
+	}
+
+	calls = 0
+	_ = withRetry(context.Background(), 3, isTransientNetError, func() error {
+		calls++
+		return errors.New("permanent")
+	})
+	if calls != 1 {
+		t.Fatalf("permanent error should not be retried, got %d calls", calls)
+	}
+
+	// waiting for the next try is interrupted by the context
+	retryBaseDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = withRetry(ctx, 3, isTransientNetError, func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("expect to stop after the context is canceled, got %d calls: %v", calls, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := backoff(i); d <= 0 || d > retryMaxDelay {
+			t.Fatalf("invalid backoff %s for retry %d", d, i)
+		}
+	}
+}
+
+func TestRetryCountFromEnv(t *testing.T) {
+	t.Setenv("TEST_MAX_RETRIES", "5")
+	if n := retryCountFromEnv("TEST_MAX_RETRIES", 3); n != 5 {
+		t.Fatalf("expect 5, got %d", n)
+	}
+	t.Setenv("TEST_MAX_RETRIES", "abc")
+	if n := retryCountFromEnv("TEST_MAX_RETRIES", 3); n != 3 {
+		t.Fatalf("expect default 3, got %d", n)
+	}
+}
+
+type httpError int
+
+func (e httpError) Error() string   { return fmt.Sprintf("http status %d", int(e)) }
+func (e httpError) StatusCode() int { return int(e) }
+
+// flakyStorage fails the first fails calls of each method, and the body of Get after reading half of it.
+type flakyStorage struct {
+	ObjectStorage
+	fails  int
+	calls  map[string]int
+	broken bool
+}
+
+func (f *flakyStorage) fail(method string) error {
+	f.calls[method]++
+	if f.calls[method] <= f.fails {
+		return httpError(503)
+	}
+	return nil
+}
+
+func (f *flakyStorage) Head(key string) (Object, error) {
+	if err := f.fail("head"); err != nil {
+		return nil, err
+	}
+	return f.ObjectStorage.Head(key)
+}
+
+type brokenReader struct {
+	io.ReadCloser
+	left int
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.left == 0 {
+		return 0, fmt.Errorf("read body: %w", io.ErrUnexpectedEOF)
+	}
+	if len(p) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= n
+	return n, err
+}
+
+func (f *flakyStorage) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if err := f.fail("get"); err != nil {
+		return nil, err
+	}
+	in, err := f.ObjectStorage.Get(key, off, limit, getters...)
+	if err == nil && f.broken {
+		f.broken = false
+		return &brokenReader{in, 3}, nil
+	}
+	return in, err
+}
+
+func (f *flakyStorage) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	if err := f.fail("put"); err != nil {
+		_, _ = io.CopyN(io.Discard, in, 2) // consume some data
+		return err
+	}
+	return f.ObjectStorage.Put(key, in, getters...)
+}
+
+func (f *flakyStorage) Delete(key string, getters ...AttrGetter) error {
+	f.calls["delete"]++
+	return httpError(403)
+}
+
+func TestWithRetryStorage(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	m, _ := newMem("", "", "", "")
+	f := &flakyStorage{ObjectStorage: m, fails: 2, calls: make(map[string]int)}
+	s := WithRetry(f, 0, nil)
+
+	if err := s.Put("k", bytes.NewReader([]byte("0123456789"))); err != nil || f.calls["put"] != 3 {
+		t.Fatalf("put should succeed after 3 calls: %d calls, %v", f.calls["put"], err)
+	}
+	f.calls["put"] = 0
+	if err := s.Put("k2", io.MultiReader(bytes.NewReader([]byte("data")))); err == nil || f.calls["put"] != 1 {
+		t.Fatalf("put with unseekable reader should not be retried: %d calls, %v", f.calls["put"], err)
+	}
+	if o, err := s.Head("k"); err != nil || o.Size() != 10 {
+		t.Fatalf("head: %+v %v", o, err)
+	}
+	f.broken = true
+	in, err := s.Get("k", 2, 6)
+	if err != nil {
+		t.Fatalf("get: %s", err)
+	}
+	if d, err := io.ReadAll(in); err != nil || string(d) != "234567" {
+		t.Fatalf("get should be re-issued after reading the body fails: %q %v", d, err)
+	}
+	f.broken = true
+	gets := f.calls["get"]
+	in, _ = s.Get("k", 2, 3)
+	if d, err := io.ReadAll(in); err != nil || string(d) != "234" || f.calls["get"] != gets+1 {
+		t.Fatalf("get should stop once all of it is read: %q %v after %d calls", d, err, f.calls["get"]-gets)
+	}
+	f.broken = true
+	in, _ = s.Get("k", 0, -1)
+	if d, err := io.ReadAll(in); err != nil || string(d) != "0123456789" {
+		t.Fatalf("get the whole object: %q %v", d, err)
+	}
+
+	if err := s.Delete("k"); err == nil || f.calls["delete"] != 1 {
+		t.Fatalf("403 should not be retried: %d calls, %v", f.calls["delete"], err)
+	}
+	f.fails, f.calls["head"] = 10, 0
+	if _, err := s.Head("k"); httpStatusCode(err) != 503 || f.calls["head"] != 4 {
+		t.Fatalf("head should give up after 3 retries: %d calls, %v", f.calls["head"], err)
+	}
+}
+
+func TestDefaultShouldRetry(t *testing.T) {
+	cases := map[error]bool{
+		httpError(429):          true,
+		httpError(500):          true,
+		httpError(501):          false,
+		httpError(404):          false,
+		io.ErrUnexpectedEOF:     true,
+		ErrNotFound:             false,
+		errors.New("permanent"): false,
+		fmt.Errorf("wrapped: %w", httpError(503)): true,
+	}
+	for err, expected := range cases {
+		if DefaultShouldRetry(err) != expected {
+			t.Fatalf("DefaultShouldRetry(%v) should be %v", err, expected)
+		}
+	}
+}
+
+// delayedStorage makes the objects visible to Get and Head only after delay since they are put.
+type delayedStorage struct {
+	ObjectStorage
+	sync.Mutex
+	delay time.Duration
+	put   map[string]time.Time
+}
+
+func (d *delayedStorage) visible(key string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return time.Since(d.put[key]) >= d.delay
+}
+
+func (d *delayedStorage) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	d.Lock()
+	d.put[key] = time.Now()
+	d.Unlock()
+	return d.ObjectStorage.Put(key, in, getters...)
+}
+
+func (d *delayedStorage) Head(key string) (Object, error) {
+	if !d.visible(key) {
+		return nil, ErrNotFound
+	}
+	return d.ObjectStorage.Head(key)
+}
+
+func (d *delayedStorage) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if !d.visible(key) {
+		return nil, httpError(404)
+	}
+	return d.ObjectStorage.Get(key, off, limit, getters...)
+}
+
+func TestReadAfterWriteRetry(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	d := &delayedStorage{ObjectStorage: m, delay: 50 * time.Millisecond, put: make(map[string]time.Time)}
+	if err := WithRetry(d, 0, nil).Put("a", bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if _, err := WithRetry(d, 0, nil).Get("a", 0, -1); !isNotFound(err) {
+		t.Fatalf("a should not be visible yet: %v", err)
+	}
+
+	raw := ReadAfterWrite{Attempts: 20, Delay: 10 * time.Millisecond, Window: 200 * time.Millisecond}
+	s := WithReadAfterWriteRetry(d, 0, nil, raw)
+	for _, key := range []string{"b", "c"} {
+		if err := s.Put(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatalf("put: %s", err)
+		}
+	}
+	if v, err := get(s, "b", 0, -1); err != nil || v != "b" {
+		t.Fatalf("get after put: %q %v", v, err)
+	}
+	if o, err := s.Head("c"); err != nil || o.Size() != 1 {
+		t.Fatalf("head after put: %v %v", o, err)
+	}
+
+	// the objects not written recently are missing for real
+	start := time.Now()
+	if _, err := s.Get("missing", 0, -1); !isNotFound(err) || time.Since(start) >= raw.Delay {
+		t.Fatalf("missing object should not be retried: %v in %s", err, time.Since(start))
+	}
+	if err := s.Put("deleted", bytes.NewReader([]byte("d"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	_ = s.Delete("deleted")
+	start = time.Now()
+	if _, err := s.Head("deleted"); !isNotFound(err) || time.Since(start) >= raw.Delay {
+		t.Fatalf("deleted object should not be retried: %v in %s", err, time.Since(start))
+	}
+	if err := s.Put("gone", bytes.NewReader([]byte("g"))); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	_ = m.Delete("gone")
+	time.Sleep(raw.Window)
+	start = time.Now()
+	if _, err := s.Head("gone"); !isNotFound(err) || time.Since(start) >= raw.Delay {
+		t.Fatalf("object should not be retried after the window: %v in %s", err, time.Since(start))
+	}
+	// the retries stop at the end of window
+	_ = s.Put("gone", bytes.NewReader([]byte("g")))
+	_ = m.Delete("gone")
+	start = time.Now()
+	if _, err := s.Head("gone"); !isNotFound(err) || time.Since(start) > raw.Window+5*raw.Delay {
+		t.Fatalf("object should be not found after the window: %v in %s", err, time.Since(start))
+	}
+}
@@ -21,13 +21,17 @@ package object
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,15 +40,21 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/juicedata/juicefs/pkg/utils"
 )
 
 const awsDefaultRegion = "us-east-1"
 const s3RequestIDKey = "X-Amz-Request-Id"
 
+// S3EndpointResolver resolves the endpoints of AWS S3 instead of the builtin ones of the SDK if set, e.g. to use
+// private endpoints in an isolated network. The region of a bucket is not detected through the Internet then, it's
+// taken from the endpoint or AWS_REGION (us-east-1 by default).
+var S3EndpointResolver endpoints.Resolver
+
 var disableSha256Func = func(r *request.Request) {
 	if op := r.Operation.Name; r.ClientInfo.ServiceID != "S3" || !(op == "PutObject" || op == "UploadPart") {
 		return
@@ -55,18 +65,250 @@ var disableSha256Func = func(r *request.Request) {
 	r.HTTPRequest.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
 }
 
+// contentMD5Func returns the handler to send the Content-MD5 of PutObject and UploadPart if send is true, or
+// remove it otherwise.
+func contentMD5Func(send bool) func(r *request.Request) {
+	return func(r *request.Request) {
+		if op := r.Operation.Name; r.ClientInfo.ServiceID != "S3" || !(op == "PutObject" || op == "UploadPart") {
+			return
+		}
+		if !send {
+			r.HTTPRequest.Header.Del("Content-Md5")
+			return
+		}
+		if len(r.HTTPRequest.Header.Get("Content-Md5")) != 0 {
+			return
+		}
+		if !aws.IsReaderSeekable(r.Body) {
+			r.Error = awserr.New("ContentMD5", "unable to compute Content-MD5 for unseekable body", nil)
+			return
+		}
+		h := md5.New()
+		if _, err := aws.CopySeekableBody(h, r.Body); err != nil {
+			r.Error = awserr.New("ContentMD5", "failed to compute body MD5", err)
+			return
+		}
+		r.HTTPRequest.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+}
+
+// requesterPaysFunc makes the requester pay for the requests and data transfer of requester-pays buckets,
+// otherwise they are rejected with 403.
+var requesterPaysFunc = func(r *request.Request) {
+	if r.ClientInfo.ServiceID == "S3" {
+		r.HTTPRequest.Header.Set("X-Amz-Request-Payer", "requester")
+	}
+}
+
 type s3client struct {
 	bucket          string
 	sc              string
 	s3              *s3.S3
 	ses             *session.Session
 	disableChecksum bool
+	// don't infer the Content-Type from the key, the objects are put as binary/octet-stream by default
+	disableContentType bool
+	sse                string // server-side encryption: AES256 or aws:kms
+	kmsKeyID           string
+	checksumAlgo       string // the checksum verified by S3 on upload: CRC32, CRC32C, SHA1, SHA256 or empty
+
+	partSize          int64
+	uploadConcurrency int
+	putThreshold      int64 // the data of unknown length larger than it is uploaded in parts by Put
+
+	expressSession *credentials.Credentials // the session credentials of the directory buckets of S3 Express One Zone
+	creds          *credentialRefresher     // renews the credentials from the CredentialProvider, nil for the static ones
+	usageMetrics   bool                     // report the usage from the storage metrics in CloudWatch
+}
+
+// ObjectWithSSE is an Object with the server-side encryption returned by S3.
+type ObjectWithSSE interface {
+	Object
+	// ServerSideEncryption returns AES256, aws:kms, or empty if it's not encrypted
+	ServerSideEncryption() string
+	// SSEKMSKeyID returns the ID of KMS key used by aws:kms
+	SSEKMSKeyID() string
+}
+
+// ObjectWithRestoreStatus is an Object with the status of the restore of archived objects returned by S3.
+type ObjectWithRestoreStatus interface {
+	Object
+	// RestoreStatus returns whether a restore is in progress, and when the restored copy expires,
+	// the expiry is zero if the object is not restored.
+	RestoreStatus() (ongoing bool, expiry time.Time)
+}
+
+// s3Obj is the object returned by Head of S3.
+type s3Obj struct {
+	obj
+	sse         string
+	kmsKeyID    string
+	restore     string // the x-amz-restore header
+	csAlgo      string // the algorithm of checksum
+	checksum    string
+	contentType string
+	expiry      time.Time
+}
+
+func (o *s3Obj) ServerSideEncryption() string { return o.sse }
+func (o *s3Obj) SSEKMSKeyID() string          { return o.kmsKeyID }
+func (o *s3Obj) ContentType() string          { return o.contentType }
+func (o *s3Obj) Expiry() time.Time            { return o.expiry }
+func (o *s3Obj) ServerChecksum() (string, string) {
+	return o.csAlgo, o.checksum
+}
+
+// RestoreStatus parses the restore header, like `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`.
+func (o *s3Obj) RestoreStatus() (bool, time.Time) {
+	var ongoing bool
+	var expiry time.Time
+	for _, m := range s3RestoreRegexp.FindAllStringSubmatch(o.restore, -1) {
+		switch m[1] {
+		case "ongoing-request":
+			ongoing = m[2] == "true"
+		case "expiry-date":
+			expiry, _ = time.Parse(http.TimeFormat, m[2])
+		}
+	}
+	return ongoing, expiry
+}
+
+var s3RestoreRegexp = regexp.MustCompile(`([a-z-]+)="([^"]*)"`)
+
+// parseS3Expiration parses the expiration header, like `expiry-date="Fri, 23 Dec 2012 00:00:00 GMT", rule-id="r1"`.
+func parseS3Expiration(expiration string) time.Time {
+	for _, m := range s3RestoreRegexp.FindAllStringSubmatch(expiration, -1) {
+		if m[1] == "expiry-date" {
+			t, _ := time.Parse(http.TimeFormat, m[2])
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseS3ChecksumAlgorithm parses the checksum-algorithm in the query of endpoint, it's CRC32C on AWS by default,
+// and disabled for the compatible object storages which may not support it.
+func parseS3ChecksumAlgorithm(query url.Values, isAWS bool) (string, error) {
+	v := query.Get("checksum-algorithm")
+	if v == "" && isAWS {
+		return s3.ChecksumAlgorithmCrc32c, nil
+	}
+	if v == "" || strings.EqualFold(v, "none") {
+		return "", nil
+	}
+	for _, algo := range s3.ChecksumAlgorithm_Values() {
+		if strings.EqualFold(v, algo) {
+			return algo, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported checksum algorithm %q, should be one of %s or none", v, strings.Join(s3.ChecksumAlgorithm_Values(), ", "))
+}
+
+// s3Checksum returns the base64 encoded checksum of the data in the algorithm.
+func s3Checksum(algo string, in io.Reader) (string, error) {
+	h := checksumHash(algo)
+	if h == nil {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	if _, err := io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// s3ChecksumFields returns the fields of requests (ChecksumCRC32, ChecksumCRC32C, ChecksumSHA1 and ChecksumSHA256)
+// with only the one of algorithm set.
+func s3ChecksumFields(algo, value string) (crc32, crc32c, sha1, sha256 *string) {
+	switch algo {
+	case s3.ChecksumAlgorithmCrc32:
+		crc32 = &value
+	case s3.ChecksumAlgorithmCrc32c:
+		crc32c = &value
+	case s3.ChecksumAlgorithmSha1:
+		sha1 = &value
+	case s3.ChecksumAlgorithmSha256:
+		sha256 = &value
+	}
+	return
+}
+
+// s3ChecksumOf returns the algorithm and value of the checksum in the fields of response.
+func s3ChecksumOf(crc32, crc32c, sha1, sha256 *string) (string, string) {
+	for i, v := range []*string{crc32, crc32c, sha1, sha256} {
+		if v != nil && *v != "" {
+			return []string{s3.ChecksumAlgorithmCrc32, s3.ChecksumAlgorithmCrc32c, s3.ChecksumAlgorithmSha1, s3.ChecksumAlgorithmSha256}[i], *v
+		}
+	}
+	return "", ""
+}
+
+// parseSSE returns the server-side encryption and KMS key ID in the query of endpoint.
+func parseSSE(query url.Values) (string, string, error) {
+	sse, keyID := query.Get("sse"), query.Get("sse-kms-key-id")
+	switch sse {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return "", "", fmt.Errorf("invalid server-side encryption %q, should be %s or %s", sse, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if keyID != "" && sse != s3.ServerSideEncryptionAwsKms {
+		return "", "", fmt.Errorf("sse-kms-key-id can only be used with sse=%s", s3.ServerSideEncryptionAwsKms)
+	}
+	return sse, keyID, nil
 }
 
 func (s *s3client) String() string {
 	return fmt.Sprintf("s3://%s/", s.bucket)
 }
 
+func (s *s3client) BucketName() string {
+	return s.bucket
+}
+
+// awsCredentials adapts a credentialRefresher to the credential provider of AWS SDK, which is expired
+// once the credentials are renewed in background.
+type awsCredentials struct {
+	r       *credentialRefresher
+	version int
+}
+
+func (c *awsCredentials) Retrieve() (credentials.Value, error) {
+	cred, version := c.r.current()
+	c.version = version
+	return credentials.Value{
+		AccessKeyID:     cred.AccessKey,
+		SecretAccessKey: cred.SecretKey,
+		SessionToken:    cred.Token,
+		ProviderName:    "CredentialProvider",
+	}, nil
+}
+
+func (c *awsCredentials) IsExpired() bool {
+	cred, version := c.r.current()
+	return version != c.version || !cred.Expiration.IsZero() && time.Now().After(cred.Expiration)
+}
+
+func (s *s3client) SetCredentialProvider(p CredentialProvider) error {
+	r, err := newCredentialRefresher(p, nil)
+	if err != nil {
+		return err
+	}
+	s.creds.stop()
+	s.creds = r
+	cred := credentials.NewCredentials(&awsCredentials{r: r})
+	s.ses.Config.Credentials = cred
+	s.s3.Config.Credentials = cred
+	if s.expressSession != nil {
+		// create the session with the new credentials
+		s.expressSession.Expire()
+	}
+	return nil
+}
+
+// Shutdown stops renewing the credentials from the CredentialProvider.
+func (s *s3client) Shutdown() {
+	s.creds.stop()
+}
+
 func (s *s3client) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
@@ -74,19 +316,99 @@ func (s *s3client) Limits() Limits {
 		MinPartSize:              5 << 20,
 		MaxPartSize:              5 << 30,
 		MaxPartCount:             10000,
+		MaxCopySize:              5 << 30,
+		PartSize:                 s.partSize,
+		UploadConcurrency:        s.uploadConcurrency,
 	}
 }
 
+// cloudwatchEndpoint overrides the endpoint of CloudWatch if it's not empty, the S3 endpoint is not used for it.
+var cloudwatchEndpoint string
+
+// Usage returns the latest daily storage metrics of the bucket in CloudWatch (usage-metrics=cloudwatch), which are
+// about one day behind. The size is summed over all the storage classes.
+func (s *s3client) Usage() (int64, int64, error) {
+	if !s.usageMetrics {
+		return 0, 0, notSupported
+	}
+	cw := cloudwatch.New(s.ses, &aws.Config{Endpoint: aws.String(cloudwatchEndpoint)})
+	bucket := &cloudwatch.Dimension{Name: aws.String("BucketName"), Value: aws.String(s.bucket)}
+	latest := func(name, storageType string) (float64, error) {
+		end := time.Now()
+		out, err := cw.GetMetricStatisticsWithContext(ctx, &cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String("AWS/S3"),
+			MetricName: aws.String(name),
+			Dimensions: []*cloudwatch.Dimension{bucket, {Name: aws.String("StorageType"), Value: aws.String(storageType)}},
+			StartTime:  aws.Time(end.Add(-3 * 24 * time.Hour)),
+			EndTime:    aws.Time(end),
+			Period:     aws.Int64(86400),
+			Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
+		})
+		if err != nil {
+			return 0, err
+		}
+		var value float64
+		var at time.Time
+		for _, p := range out.Datapoints {
+			if t := aws.TimeValue(p.Timestamp); t.After(at) {
+				value, at = aws.Float64Value(p.Average), t
+			}
+		}
+		if at.IsZero() {
+			return 0, fmt.Errorf("no %s of %s (%s) in CloudWatch in the last 3 days", name, s.bucket, storageType)
+		}
+		return value, nil
+	}
+
+	objects, err := latest("NumberOfObjects", "AllStorageTypes")
+	if err != nil {
+		return 0, 0, err
+	}
+	var storageTypes []string
+	err = cw.ListMetricsPagesWithContext(ctx, &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String("BucketSizeBytes"),
+		Dimensions: []*cloudwatch.DimensionFilter{{Name: bucket.Name, Value: bucket.Value}},
+	}, func(page *cloudwatch.ListMetricsOutput, last bool) bool {
+		for _, m := range page.Metrics {
+			for _, d := range m.Dimensions {
+				if aws.StringValue(d.Name) == "StorageType" {
+					storageTypes = append(storageTypes, aws.StringValue(d.Value))
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var total float64
+	for _, st := range storageTypes {
+		size, err := latest("BucketSizeBytes", st)
+		if err != nil {
+			// a storage class may have no data points after it's emptied
+			logger.Debugf("Skip the size of %s: %s", st, err)
+			continue
+		}
+		total += size
+	}
+	return int64(objects), int64(total), nil
+}
+
 func isExists(err error) bool {
 	msg := err.Error()
 	return strings.Contains(msg, s3.ErrCodeBucketAlreadyExists) || strings.Contains(msg, s3.ErrCodeBucketAlreadyOwnedByYou)
 }
 
 func (s *s3client) Create() error {
-	if _, err := s.List("", "", "", 1, true); err == nil {
+	_, err := s.List("", "", "", 1, true)
+	if err == nil {
 		return nil
 	}
-	_, err := s.s3.CreateBucket(&s3.CreateBucketInput{Bucket: &s.bucket})
+	if s.expressSession != nil {
+		return fmt.Errorf("directory bucket %s should be created in advance: %s", s.bucket, err)
+	}
+	_, err = s.s3.CreateBucket(&s3.CreateBucketInput{Bucket: &s.bucket})
 	if err != nil && isExists(err) {
 		err = nil
 	}
@@ -98,6 +420,9 @@ func (s *s3client) Head(key string) (Object, error) {
 		Bucket: &s.bucket,
 		Key:    &key,
 	}
+	if s.checksumAlgo != "" {
+		param.SetChecksumMode(s3.ChecksumModeEnabled)
+	}
 	r, err := s.s3.HeadObject(&param)
 	if err != nil {
 		if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotFound {
@@ -109,12 +434,22 @@ func (s *s3client) Head(key string) (Object, error) {
 	if r.StorageClass != nil {
 		sc = *r.StorageClass
 	}
-	return &obj{
-		key,
-		*r.ContentLength,
-		*r.LastModified,
-		strings.HasSuffix(key, "/"),
-		sc,
+	csAlgo, checksum := s3ChecksumOf(r.ChecksumCRC32, r.ChecksumCRC32C, r.ChecksumSHA1, r.ChecksumSHA256)
+	return &s3Obj{
+		obj{
+			key,
+			*r.ContentLength,
+			*r.LastModified,
+			strings.HasSuffix(key, "/"),
+			sc,
+		},
+		aws.StringValue(r.ServerSideEncryption),
+		aws.StringValue(r.SSEKMSKeyId),
+		aws.StringValue(r.Restore),
+		csAlgo,
+		checksum,
+		aws.StringValue(r.ContentType),
+		parseS3Expiration(aws.StringValue(r.Expiration)),
 	}, nil
 }
 
@@ -134,7 +469,7 @@ func (s *s3client) Get(key string, off, limit int64, getters ...AttrGetter) (io.
 	attrs := applyGetters(getters...)
 	attrs.SetRequestID(reqID)
 	if err != nil {
-		return nil, err
+		return nil, s.checkArchived(key, err)
 	}
 	if off == 0 && limit == -1 {
 		cs := resp.Metadata[checksumAlgr]
@@ -152,48 +487,222 @@ func (s *s3client) Get(key string, off, limit int64, getters ...AttrGetter) (io.
 	return resp.Body, nil
 }
 
+// checkArchived translates the InvalidObjectState error of GetObject into ErrArchived, with the storage
+// class of the object in the message. The storage class alone can't tell whether an object is readable,
+// since a restored object stays in GLACIER or DEEP_ARCHIVE, so it's only checked after Get fails.
+func (s *s3client) checkArchived(key string, err error) error {
+	if e, ok := err.(awserr.Error); !ok || e.Code() != "InvalidObjectState" {
+		return err
+	}
+	sc := "an archive storage class"
+	if o, e := s.Head(key); e == nil {
+		sc = o.StorageClass()
+	}
+	return fmt.Errorf("%w: %s is in %s, restore it first", ErrArchived, key, sc)
+}
+
 func (s *s3client) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	var body io.ReadSeeker
 	if b, ok := in.(io.ReadSeeker); ok {
 		body = b
 	} else {
-		data, err := io.ReadAll(in)
+		threshold := s.putThreshold
+		if threshold <= 0 {
+			threshold = defaultPutThreshold
+		}
+		data, err := io.ReadAll(io.LimitReader(in, threshold+1))
 		if err != nil {
 			return err
 		}
+		if int64(len(data)) > threshold {
+			// the rest may be unbounded, upload it as a stream in parts rather than buffering all of it
+			return Upload(&s3Stream{s, getters}, key, io.MultiReader(bytes.NewReader(data), in), UploadOptions{})
+		}
 		body = bytes.NewReader(data)
 	}
-	mimeType := utils.GuessMimeType(key)
+	attrs := applyGetters(getters...)
 	params := &s3.PutObjectInput{
-		Bucket:      &s.bucket,
-		Key:         &key,
-		Body:        body,
-		ContentType: &mimeType,
+		Bucket: &s.bucket,
+		Key:    &key,
+		Body:   body,
+	}
+	if ct := attrs.contentTypeOf(key, !s.disableContentType); ct != "" {
+		params.ContentType = &ct
+	}
+	if attrs.tagging != "" {
+		params.Tagging = &attrs.tagging
 	}
 	if !s.disableChecksum {
 		checksum := generateChecksum(body)
 		params.Metadata = map[string]*string{checksumAlgr: &checksum}
 	}
+	if s.checksumAlgo != "" {
+		checksum, err := s3Checksum(s.checksumAlgo, body)
+		if err != nil {
+			return err
+		}
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		params.ChecksumAlgorithm = &s.checksumAlgo
+		params.ChecksumCRC32, params.ChecksumCRC32C, params.ChecksumSHA1, params.ChecksumSHA256 = s3ChecksumFields(s.checksumAlgo, checksum)
+	}
 	if s.sc != "" {
 		params.SetStorageClass(s.sc)
 	}
+	if s.sse != "" {
+		params.SetServerSideEncryption(s.sse)
+	}
+	if s.kmsKeyID != "" {
+		params.SetSSEKMSKeyId(s.kmsKeyID)
+	}
 	var reqID string
 	_, err := s.s3.PutObjectWithContext(ctx, params, request.WithGetResponseHeader(s3RequestIDKey, &reqID))
-	attrs := applyGetters(getters...)
 	attrs.SetRequestID(reqID).SetStorageClass(s.sc)
 	return err
 }
 
+// the tag of the objects put with expiry, which are expired by the lifecycle rule of the days in the tag
+const s3ExpiryTag = "juicefs-expire-days"
+
+// the prefix of the IDs of the lifecycle rules to expire the objects put with expiry
+const s3ExpiryRulePrefix = "juicefs-expire-"
+
+// s3ExpiryDays are the days of the lifecycle rules set up by SetupExpiry, the days to expire an object are rounded
+// up to one of them, so the rules are fixed and set up once.
+var s3ExpiryDays = []int64{1, 2, 3, 4, 5, 6, 7, 10, 14, 21, 30, 45, 60, 90, 120, 180, 270, 365, 730, 1095, 1825, 3650}
+
+// PutWithExpiry tags the object with the days to expire, which are expired by the lifecycle rules set up by
+// SetupExpiry. S3 expires the objects in days (at the next midnight in UTC after the days since creation), and
+// the days are rounded up to the ones of the rules, so the object is deleted at a midnight at or after expiry,
+// which is also the expiry returned by Head.
+func (s *s3client) PutWithExpiry(key string, in io.Reader, expiry time.Time, getters ...AttrGetter) error {
+	days := int64(math.Ceil(time.Until(expiry).Hours() / 24))
+	i := sort.Search(len(s3ExpiryDays), func(i int) bool { return s3ExpiryDays[i] >= days })
+	if i == len(s3ExpiryDays) {
+		return fmt.Errorf("expiry of %s is too far: %d days > %d days", key, days, s3ExpiryDays[len(s3ExpiryDays)-1])
+	}
+	tagging := url.Values{s3ExpiryTag: {strconv.FormatInt(s3ExpiryDays[i], 10)}}.Encode()
+	return s.Put(key, in, append(getters, withTagging(tagging))...)
+}
+
+// lifecycleRules returns the lifecycle rules of the bucket, or nil if there is none.
+func (s *s3client) lifecycleRules() ([]*s3.LifecycleRule, error) {
+	resp, err := s.s3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: &s.bucket})
+	if e, ok := err.(awserr.RequestFailure); ok {
+		switch {
+		case e.Code() == "NoSuchLifecycleConfiguration":
+			return nil, nil
+		case e.StatusCode() == http.StatusNotImplemented:
+			return nil, notSupported
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Rules, nil
+}
+
+// putLifecycleRules replaces the lifecycle configuration of the bucket, it's deleted if there is no rule.
+func (s *s3client) putLifecycleRules(rules []*s3.LifecycleRule) error {
+	if len(rules) == 0 {
+		_, err := s.s3.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: &s.bucket})
+		return err
+	}
+	_, err := s.s3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &s.bucket,
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	})
+	return err
+}
+
+func isS3ExpiryRule(r *s3.LifecycleRule) bool {
+	return strings.HasPrefix(aws.StringValue(r.ID), s3ExpiryRulePrefix)
+}
+
+// SetupExpiry adds the rules to expire the objects tagged by PutWithExpiry to the lifecycle of the bucket, the
+// other rules are kept.
+func (s *s3client) SetupExpiry() error {
+	existing, err := s.lifecycleRules()
+	if err != nil {
+		return err
+	}
+	var rules []*s3.LifecycleRule
+	for _, r := range existing {
+		if !isS3ExpiryRule(r) {
+			rules = append(rules, r)
+		}
+	}
+	for _, days := range s3ExpiryDays {
+		value := strconv.FormatInt(days, 10)
+		rules = append(rules, &s3.LifecycleRule{
+			ID:         aws.String(fmt.Sprintf("%s%dd", s3ExpiryRulePrefix, days)),
+			Status:     aws.String(s3.ExpirationStatusEnabled),
+			Filter:     &s3.LifecycleRuleFilter{Tag: &s3.Tag{Key: aws.String(s3ExpiryTag), Value: &value}},
+			Expiration: &s3.LifecycleExpiration{Days: aws.Int64(days)},
+		})
+	}
+	return s.putLifecycleRules(rules)
+}
+
 func (s *s3client) Copy(dst, src string) error {
-	src = s.bucket + "/" + src
+	return s.CopyWithOptions(dst, src, CopyOptions{})
+}
+
+func (s *s3client) CopyWithOptions(dst, src string, opts CopyOptions) error {
+	source := s.bucket + "/" + src
 	params := &s3.CopyObjectInput{
 		Bucket:     &s.bucket,
 		Key:        &dst,
-		CopySource: &src,
+		CopySource: &source,
+	}
+	switch opts.MetadataDirective {
+	case "", MetadataCopy:
+		params.SetMetadataDirective(s3.MetadataDirectiveCopy)
+		params.SetTaggingDirective(s3.TaggingDirectiveCopy)
+	case MetadataReplace:
+		// the checksum in metadata and the content type of the source should be kept
+		r, err := s.s3.HeadObject(&s3.HeadObjectInput{Bucket: &s.bucket, Key: &src})
+		if err != nil {
+			if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotFound {
+				err = os.ErrNotExist
+			}
+			return err
+		}
+		meta := make(map[string]*string, len(opts.Metadata)+1)
+		for k, v := range opts.Metadata {
+			meta[k] = aws.String(v)
+		}
+		if cs := r.Metadata[checksumAlgr]; cs != nil {
+			meta[checksumAlgr] = cs
+		}
+		params.SetMetadataDirective(s3.MetadataDirectiveReplace)
+		params.SetMetadata(meta)
+		if opts.ContentType != "" {
+			params.SetContentType(opts.ContentType)
+		} else if r.ContentType != nil {
+			params.SetContentType(*r.ContentType)
+		}
+		params.SetTaggingDirective(s3.TaggingDirectiveReplace)
+		if len(opts.Tags) > 0 {
+			tags := url.Values{}
+			for k, v := range opts.Tags {
+				tags.Set(k, v)
+			}
+			params.SetTagging(tags.Encode())
+		}
+	default:
+		return fmt.Errorf("invalid metadata directive: %s", opts.MetadataDirective)
 	}
 	if s.sc != "" {
 		params.SetStorageClass(s.sc)
 	}
+	if s.sse != "" {
+		params.SetServerSideEncryption(s.sse)
+	}
+	if s.kmsKeyID != "" {
+		params.SetSSEKMSKeyId(s.kmsKeyID)
+	}
 	_, err := s.s3.CopyObject(params)
 	return err
 }
@@ -214,6 +723,22 @@ func (s *s3client) Delete(key string, getters ...AttrGetter) error {
 }
 
 func (s *s3client) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, prefixes, err := s.ListWithDelimiter(prefix, delimiter, marker, limit)
+	if err != nil || len(prefixes) == 0 {
+		return objs, err
+	}
+	for _, p := range prefixes {
+		objs = append(objs, &obj{p, 0, time.Unix(0, 0), true, ""})
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	return objs, nil
+}
+
+// ListWithDelimiter returns the CommonPrefixes of S3 separately.
+func (s *s3client) ListWithDelimiter(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	if s.expressSession != nil {
+		return s.listExpress(prefix, delimiter, marker, limit)
+	}
 	param := s3.ListObjectsInput{
 		Bucket:       &s.bucket,
 		Prefix:       &prefix,
@@ -226,7 +751,7 @@ func (s *s3client) List(prefix, marker, delimiter string, limit int64, followLin
 	}
 	resp, err := s.s3.ListObjects(&param)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	n := len(resp.Contents)
 	objs := make([]Object, n)
@@ -234,10 +759,10 @@ func (s *s3client) List(prefix, marker, delimiter string, limit int64, followLin
 		o := resp.Contents[i]
 		oKey, err := url.QueryUnescape(*o.Key)
 		if err != nil {
-			return nil, errors.WithMessagef(err, "failed to decode key %s", *o.Key)
+			return nil, nil, errors.WithMessagef(err, "failed to decode key %s", *o.Key)
 		}
 		if !strings.HasPrefix(oKey, prefix) || oKey < marker {
-			return nil, fmt.Errorf("found invalid key %s from List, prefix: %s, marker: %s", oKey, prefix, marker)
+			return nil, nil, fmt.Errorf("found invalid key %s from List, prefix: %s, marker: %s", oKey, prefix, marker)
 		}
 		var sc = DefaultStorageClass
 		if o.StorageClass != nil {
@@ -251,31 +776,66 @@ func (s *s3client) List(prefix, marker, delimiter string, limit int64, followLin
 			sc,
 		}
 	}
-	if delimiter != "" {
-		for _, p := range resp.CommonPrefixes {
-			prefix, err := url.QueryUnescape(*p.Prefix)
-			if err != nil {
-				return nil, errors.WithMessagef(err, "failed to decode commonPrefixes %s", *p.Prefix)
-			}
-			objs = append(objs, &obj{prefix, 0, time.Unix(0, 0), true, ""})
+	var prefixes []string
+	for _, p := range resp.CommonPrefixes {
+		prefix, err := url.QueryUnescape(*p.Prefix)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "failed to decode commonPrefixes %s", *p.Prefix)
 		}
-		sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+		prefixes = append(prefixes, prefix)
 	}
-	return objs, nil
+	return objs, prefixes, nil
 }
 
 func (s *s3client) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	if s.expressSession != nil {
+		return s.listAllExpress(prefix, marker)
+	}
 	return nil, notSupported
 }
 
+// s3Stream uploads the data of Put in parts by Upload, with the attributes given to Put.
+type s3Stream struct {
+	*s3client
+	getters []AttrGetter
+}
+
+func (s *s3Stream) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	return s.s3client.Put(key, in, s.getters...)
+}
+
+func (s *s3Stream) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return s.createMultipartUpload(key, s.getters...)
+}
+
 func (s *s3client) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	return s.createMultipartUpload(key)
+}
+
+func (s *s3client) createMultipartUpload(key string, getters ...AttrGetter) (*MultipartUpload, error) {
 	params := &s3.CreateMultipartUploadInput{
 		Bucket: &s.bucket,
 		Key:    &key,
 	}
+	attrs := applyGetters(getters...)
+	if ct := attrs.contentTypeOf(key, !s.disableContentType); ct != "" {
+		params.SetContentType(ct)
+	}
+	if attrs.tagging != "" {
+		params.SetTagging(attrs.tagging)
+	}
 	if s.sc != "" {
 		params.SetStorageClass(s.sc)
 	}
+	if s.sse != "" {
+		params.SetServerSideEncryption(s.sse)
+	}
+	if s.kmsKeyID != "" {
+		params.SetSSEKMSKeyId(s.kmsKeyID)
+	}
+	if s.checksumAlgo != "" {
+		params.ChecksumAlgorithm = &s.checksumAlgo
+	}
 	resp, err := s.s3.CreateMultipartUpload(params)
 	if err != nil {
 		return nil, err
@@ -292,11 +852,17 @@ func (s *s3client) UploadPart(key string, uploadID string, num int, body []byte)
 		Body:       bytes.NewReader(body),
 		PartNumber: &n,
 	}
+	var checksum string
+	if s.checksumAlgo != "" {
+		checksum, _ = s3Checksum(s.checksumAlgo, bytes.NewReader(body))
+		params.ChecksumAlgorithm = &s.checksumAlgo
+		params.ChecksumCRC32, params.ChecksumCRC32C, params.ChecksumSHA1, params.ChecksumSHA256 = s3ChecksumFields(s.checksumAlgo, checksum)
+	}
 	resp, err := s.s3.UploadPart(params)
 	if err != nil {
 		return nil, err
 	}
-	return &Part{Num: num, ETag: *resp.ETag}, nil
+	return &Part{Num: num, ETag: *resp.ETag, Checksum: checksum}, nil
 }
 
 func (s *s3client) UploadPartCopy(key string, uploadID string, num int, srcKey string, off, size int64) (*Part, error) {
@@ -311,7 +877,9 @@ func (s *s3client) UploadPartCopy(key string, uploadID string, num int, srcKey s
 	if err != nil {
 		return nil, err
 	}
-	return &Part{Num: num, ETag: *resp.CopyPartResult.ETag}, nil
+	r := resp.CopyPartResult
+	_, checksum := s3ChecksumOf(r.ChecksumCRC32, r.ChecksumCRC32C, r.ChecksumSHA1, r.ChecksumSHA256)
+	return &Part{Num: num, ETag: *r.ETag, Checksum: checksum}, nil
 }
 
 func (s *s3client) AbortUpload(key string, uploadID string) {
@@ -328,7 +896,11 @@ func (s *s3client) CompleteUpload(key string, uploadID string, parts []*Part) er
 	for i := range parts {
 		n := new(int64)
 		*n = int64(parts[i].Num)
-		s3Parts = append(s3Parts, &s3.CompletedPart{ETag: &parts[i].ETag, PartNumber: n})
+		part := &s3.CompletedPart{ETag: &parts[i].ETag, PartNumber: n}
+		if parts[i].Checksum != "" {
+			part.ChecksumCRC32, part.ChecksumCRC32C, part.ChecksumSHA1, part.ChecksumSHA256 = s3ChecksumFields(s.checksumAlgo, parts[i].Checksum)
+		}
+		s3Parts = append(s3Parts, part)
 	}
 	params := &s3.CompleteMultipartUploadInput{
 		Bucket:          &s.bucket,
@@ -361,6 +933,155 @@ func (s *s3client) ListUploads(marker string) ([]*PendingPart, string, error) {
 	return parts, nextMarker, nil
 }
 
+// Restore initiates the restore of an archived object (in GLACIER or DEEP_ARCHIVE) for the given days,
+// with the retrieval tier Expedited, Standard or Bulk (empty means Standard). It returns once the request is
+// accepted, or a restore is already in progress, use RestoreStatus of Head to check whether it's done.
+func (s *s3client) Restore(key string, days int, tier string) error {
+	if tier == "" {
+		tier = s3.TierStandard
+	}
+	var valid bool
+	for _, t := range s3.Tier_Values() {
+		if strings.EqualFold(t, tier) {
+			tier, valid = t, true
+		}
+	}
+	if !valid || days <= 0 {
+		return fmt.Errorf("invalid restore of %s: %d days in tier %q", key, days, tier)
+	}
+	_, err := s.s3.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(int64(days)),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+		},
+	})
+	if e, ok := err.(awserr.RequestFailure); ok {
+		switch {
+		case e.Code() == "RestoreAlreadyInProgress":
+			err = nil
+		case e.StatusCode() == http.StatusNotFound:
+			err = ErrNotFound
+		}
+	}
+	return err
+}
+
+// GetNotifications returns the queue, topic and function notifications of the bucket, which are also used by
+// MinIO for the bucket events.
+func (s *s3client) GetNotifications() ([]*Notification, error) {
+	resp, err := s.s3.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{Bucket: &s.bucket})
+	if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotImplemented {
+		return nil, notSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ns []*Notification
+	add := func(id, target *string, events []*string, filter *s3.NotificationConfigurationFilter) {
+		n := &Notification{ID: aws.StringValue(id), Target: aws.StringValue(target), Events: aws.StringValueSlice(events)}
+		if filter != nil && filter.Key != nil {
+			for _, r := range filter.Key.FilterRules {
+				switch strings.ToLower(aws.StringValue(r.Name)) {
+				case "prefix":
+					n.Prefix = aws.StringValue(r.Value)
+				case "suffix":
+					n.Suffix = aws.StringValue(r.Value)
+				}
+			}
+		}
+		ns = append(ns, n)
+	}
+	for _, c := range resp.QueueConfigurations {
+		add(c.Id, c.QueueArn, c.Events, c.Filter)
+	}
+	for _, c := range resp.TopicConfigurations {
+		add(c.Id, c.TopicArn, c.Events, c.Filter)
+	}
+	for _, c := range resp.LambdaFunctionConfigurations {
+		add(c.Id, c.LambdaFunctionArn, c.Events, c.Filter)
+	}
+	return ns, nil
+}
+
+var s3TransitionClasses = make(map[string]string)
+
+func init() {
+	for _, sc := range s3.TransitionStorageClass_Values() {
+		s3TransitionClasses[strings.ToLower(sc)] = sc
+	}
+}
+
+// SetLifecycle replaces the lifecycle configuration of the bucket, the days of transitions and expiration are
+// counted from the creation of objects.
+func (s *s3client) SetLifecycle(rules []LifecycleRule) error {
+	rules, err := normalizeLifecycle(rules, s3TransitionClasses)
+	if err != nil {
+		return err
+	}
+	// the rules of SetupExpiry are kept
+	existing, err := s.lifecycleRules()
+	if err != nil {
+		return err
+	}
+	var conf []*s3.LifecycleRule
+	for _, r := range existing {
+		if isS3ExpiryRule(r) {
+			conf = append(conf, r)
+		}
+	}
+	for _, r := range rules {
+		rule := &s3.LifecycleRule{
+			ID:     aws.String(r.ID),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(r.Prefix)},
+		}
+		for _, t := range r.Transitions {
+			rule.Transitions = append(rule.Transitions, &s3.Transition{Days: aws.Int64(int64(t.Days)), StorageClass: aws.String(t.StorageClass)})
+		}
+		if r.ExpirationDays > 0 {
+			rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(r.ExpirationDays))}
+		}
+		conf = append(conf, rule)
+	}
+	return s.putLifecycleRules(conf)
+}
+
+// GetLifecycle returns the lifecycle rules of the bucket, the transitions and expiration by dates and the rules by
+// tags are ignored.
+func (s *s3client) GetLifecycle() ([]LifecycleRule, error) {
+	existing, err := s.lifecycleRules()
+	if err != nil {
+		return nil, err
+	}
+	var rules []LifecycleRule
+	for _, r := range existing {
+		if f := r.Filter; f != nil && (f.Tag != nil || f.And != nil && len(f.And.Tags) > 0) {
+			continue // the rules by tags (like the ones of PutWithExpiry) can't be represented
+		}
+		rule := LifecycleRule{ID: aws.StringValue(r.ID), Prefix: aws.StringValue(r.Prefix)}
+		if f := r.Filter; f != nil {
+			if f.Prefix != nil {
+				rule.Prefix = *f.Prefix
+			} else if f.And != nil {
+				rule.Prefix = aws.StringValue(f.And.Prefix)
+			}
+		}
+		for _, t := range r.Transitions {
+			if t.Days != nil {
+				rule.Transitions = append(rule.Transitions, LifecycleTransition{int(*t.Days), aws.StringValue(t.StorageClass)})
+			}
+		}
+		sortTransitions(rule.Transitions)
+		if r.Expiration != nil && r.Expiration.Days != nil {
+			rule.ExpirationDays = int(*r.Expiration.Days)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
 func (s *s3client) SetStorageClass(sc string) error {
 	s.sc = sc
 	return nil
@@ -370,7 +1091,9 @@ func autoS3Region(bucketName, accessKey, secretKey string) (string, error) {
 	awsConfig := &aws.Config{
 		HTTPClient: httpClient,
 	}
-	if accessKey != "" {
+	if accessKey == "anonymous" {
+		awsConfig.Credentials = credentials.AnonymousCredentials
+	} else if accessKey != "" {
 		awsConfig.Credentials = credentials.NewStaticCredentials(accessKey, secretKey, "")
 	}
 
@@ -419,6 +1142,10 @@ func parseRegion(endpoint string) string {
 	if strings.HasPrefix(endpoint, "s3-") || strings.HasPrefix(endpoint, "s3.") {
 		endpoint = endpoint[3:]
 	}
+	if strings.HasPrefix(endpoint, "s3express-") {
+		// s3express-[AZ ID].[REGION].amazonaws.com or s3express-control.[REGION].amazonaws.com
+		endpoint = endpoint[strings.IndexByte(endpoint, '.')+1:]
+	}
 	if strings.HasPrefix(endpoint, "dualstack") {
 		endpoint = endpoint[len("dualstack."):]
 	}
@@ -440,6 +1167,43 @@ func defaultPathStyle() bool {
 var oracleCompileRegexp = `.*\.compat.objectstorage\.(.*)\.oraclecloud\.com`
 var OVHCompileRegexp = `^s3\.(\w*)(\.\w*)?\.cloud\.ovh\.net$`
 
+// setS3EndpointVariants uses the FIPS or dual-stack (IPv4 and IPv6) endpoints of AWS S3 if use-fips or use-dualstack
+// is true, it fails if the region doesn't offer the FIPS endpoint.
+func setS3EndpointVariants(cfg *aws.Config, query url.Values, region, ep string) error {
+	var fips, dualStack bool
+	for name, v := range map[string]*bool{"use-fips": &fips, "use-dualstack": &dualStack} {
+		if s := query.Get(name); s != "" {
+			var err error
+			if *v, err = strconv.ParseBool(s); err != nil {
+				return fmt.Errorf("invalid %s %q: %s", name, s, err)
+			}
+		}
+	}
+	if !fips && !dualStack {
+		return nil
+	}
+	if ep != "" {
+		return fmt.Errorf("use-fips and use-dualstack are only supported by the regional endpoints of AWS S3, not %s", ep)
+	}
+	if fips {
+		cfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if dualStack {
+		cfg.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	if fips && S3EndpointResolver == nil {
+		_, err := endpoints.DefaultResolver().EndpointFor(s3.EndpointsID, region, func(o *endpoints.Options) {
+			o.UseFIPSEndpoint = cfg.UseFIPSEndpoint
+			o.UseDualStackEndpoint = cfg.UseDualStackEndpoint
+			o.StrictMatching = true
+		})
+		if err != nil {
+			return fmt.Errorf("FIPS endpoint of S3 is not available in region %s", region)
+		}
+	}
+	return nil
+}
+
 func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		if len(strings.Split(endpoint, ".")) > 1 && !strings.HasSuffix(endpoint, ".amazonaws.com") {
@@ -459,6 +1223,14 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		region     string
 		ep         string
 	)
+	// public buckets can be read without credentials
+	anonymous := strings.EqualFold(uri.Query().Get("anonymous"), "true")
+	if anonymous {
+		if accessKey != "" && accessKey != "anonymous" || secretKey != "" || token != "" {
+			return nil, fmt.Errorf("anonymous access can't be used with credentials")
+		}
+		accessKey = "anonymous"
+	}
 
 	if uri.Path != "" {
 		// [ENDPOINT]/[BUCKET]
@@ -480,7 +1252,11 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		if len(hostParts) == 1 {
 			// take endpoint as bucketname
 			bucketName = hostParts[0]
-			if region, err = autoS3Region(bucketName, accessKey, secretKey); err != nil {
+			if S3EndpointResolver != nil {
+				logger.Debugf("Skip detecting the region of bucket %s with a custom endpoint resolver", bucketName)
+			} else if _, ok := parseS3ExpressBucket(bucketName); ok {
+				logger.Debugf("Skip detecting the region of directory bucket %s, which is not supported", bucketName)
+			} else if region, err = autoS3Region(bucketName, accessKey, secretKey); err != nil {
 				return nil, fmt.Errorf("Can't guess your region for bucket %s: %s", bucketName, err)
 			}
 		} else {
@@ -529,6 +1305,17 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 	if region == "" {
 		region = awsDefaultRegion
 	}
+	// the directory buckets of S3 Express One Zone are accessed through the zonal endpoints
+	azID, express := parseS3ExpressBucket(bucketName)
+	express = express && (ep == "" || strings.Contains(ep, ".amazonaws.com"))
+	if express {
+		if anonymous {
+			return nil, fmt.Errorf("directory bucket %s can't be accessed anonymously", bucketName)
+		}
+		if ep == "" {
+			ep = s3ExpressEndpoint(azID, region)
+		}
+	}
 
 	ssl := strings.ToLower(uri.Scheme) == "https"
 	awsConfig := &aws.Config{
@@ -536,13 +1323,33 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		DisableSSL: aws.Bool(!ssl),
 		HTTPClient: httpClient,
 	}
+	if S3EndpointResolver != nil {
+		awsConfig.EndpointResolver = S3EndpointResolver
+	}
+	var sesOpts session.Options
+	// trust the private certificate authority of on-premises deployments (instead of AWS_CA_BUNDLE)
+	if caCert := uri.Query().Get("ca-cert"); caCert != "" {
+		bundle, err := loadCACert(caCert)
+		if err != nil {
+			return nil, err
+		}
+		sesOpts.CustomCABundle = bytes.NewReader(bundle)
+		// the transport is changed by the session to trust it
+		awsConfig.HTTPClient = copyHTTPClient()
+	}
 
 	disable100Continue := strings.EqualFold(uri.Query().Get("disable-100-continue"), "true")
 	if disable100Continue {
 		logger.Infof("HTTP header 100-Continue is disabled")
 		awsConfig.S3Disable100Continue = aws.Bool(true)
 	}
+	// some S3 compatible stores reject the uploads with Content-MD5 while others require it, the SDK only computes it
+	// for the seekable bodies, so it's set or removed by contentMD5Func if either is asked
 	disableMD5 := strings.EqualFold(uri.Query().Get("disable-content-md5"), "true")
+	requireMD5 := strings.EqualFold(uri.Query().Get("require-content-md5"), "true")
+	if disableMD5 && requireMD5 {
+		return nil, fmt.Errorf("disable-content-md5 and require-content-md5 can't be both true")
+	}
 	if disableMD5 {
 		logger.Infof("HTTP header Content-MD5 is disabled")
 		awsConfig.S3DisableContentMD5Validation = &disableMD5
@@ -551,6 +1358,38 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 	if disableChecksum {
 		logger.Infof("CRC checksum is disabled")
 	}
+	disableContentType := strings.EqualFold(uri.Query().Get("disable-content-type"), "true")
+	sse, kmsKeyID, err := parseSSE(uri.Query())
+	if err != nil {
+		return nil, err
+	}
+	checksumAlgo, err := parseS3ChecksumAlgorithm(uri.Query(), ep == "" || strings.Contains(ep, ".amazonaws.com"))
+	if err != nil {
+		return nil, err
+	}
+	partSize, uploadConcurrency, err := parseUploadOptions(uri.Query(), (&s3client{}).Limits())
+	if err != nil {
+		return nil, err
+	}
+	putThreshold, err := parsePutThreshold(uri.Query(), 5<<30)
+	if err != nil {
+		return nil, err
+	}
+	if err = setS3EndpointVariants(awsConfig, uri.Query(), region, ep); err != nil {
+		return nil, err
+	}
+	var usageMetrics bool
+	switch v := uri.Query().Get("usage-metrics"); v {
+	case "":
+	case "cloudwatch":
+		usageMetrics = true
+	default:
+		return nil, fmt.Errorf("invalid usage-metrics %q, only cloudwatch is supported", v)
+	}
+	requesterPays := strings.EqualFold(uri.Query().Get("requester-pays"), "true")
+	if requesterPays {
+		logger.Infof("Requests are paid by requester")
+	}
 
 	if accessKey == "anonymous" {
 		awsConfig.Credentials = credentials.AnonymousCredentials
@@ -561,13 +1400,40 @@ func newS3(endpoint, accessKey, secretKey, token string) (ObjectStorage, error)
 		awsConfig.Endpoint = aws.String(ep)
 		awsConfig.S3ForcePathStyle = aws.Bool(defaultPathStyle())
 	}
+	if express {
+		awsConfig.S3ForcePathStyle = aws.Bool(false)
+	}
+	// override the detected addressing style, for both AWS and compatible endpoints
+	if v := uri.Query().Get("force-path-style"); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid force-path-style %q: %s", v, err)
+		}
+		awsConfig.S3ForcePathStyle = aws.Bool(pathStyle)
+	}
 
-	ses, err := session.NewSession(awsConfig)
+	sesOpts.Config = *awsConfig
+	ses, err := session.NewSessionWithOptions(sesOpts)
 	if err != nil {
 		return nil, fmt.Errorf("Fail to create aws session: %s", err)
 	}
 	ses.Handlers.Build.PushFront(disableSha256Func)
-	return &s3client{bucket: bucketName, s3: s3.New(ses), ses: ses, disableChecksum: disableChecksum}, nil
+	if disableMD5 || requireMD5 {
+		// the handlers of Build added by the SDK for each request run before Sign
+		ses.Handlers.Sign.PushFront(contentMD5Func(requireMD5))
+	}
+	if requesterPays {
+		ses.Handlers.Build.PushBack(requesterPaysFunc)
+	}
+	client := &s3client{bucket: bucketName, s3: s3.New(ses), ses: ses, disableChecksum: disableChecksum, disableContentType: disableContentType, sse: sse, kmsKeyID: kmsKeyID, checksumAlgo: checksumAlgo,
+		partSize: partSize, uploadConcurrency: uploadConcurrency, putThreshold: putThreshold, usageMetrics: usageMetrics}
+	if express {
+		enableS3Express(client)
+	}
+	if anonymous {
+		return withAnonymous(client), nil
+	}
+	return client, nil
 }
 
 func init() {
@@ -0,0 +1,283 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// the signing name of the directory buckets of S3 Express One Zone
+const s3ExpressService = "s3express"
+
+// [BASE NAME]--[AZ ID]--x-s3, e.g. mybucket--usw2-az1--x-s3
+var s3ExpressBucketRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*--([a-z0-9][a-z0-9-]*-az[0-9]+)--x-s3$`)
+
+// parseS3ExpressBucket returns the ID of the availability zone of a directory bucket of S3 Express One Zone, or
+// false for the general purpose buckets.
+func parseS3ExpressBucket(bucket string) (string, bool) {
+	m := s3ExpressBucketRegexp.FindStringSubmatch(bucket)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// s3ExpressEndpoint returns the zonal endpoint of the directory buckets in the availability zone, which is
+// addressed in virtual-hosted-style.
+func s3ExpressEndpoint(azID, region string) string {
+	return fmt.Sprintf("s3express-%s.%s.amazonaws.com", azID, region)
+}
+
+// s3ExpressSession provides the session credentials created by CreateSession, which expire in 5 minutes.
+type s3ExpressSession struct {
+	credentials.Expiry
+	s *s3client
+}
+
+func (p *s3ExpressSession) Retrieve() (credentials.Value, error) {
+	cred, expiration, err := p.s.createSession()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.SetExpiration(expiration, time.Minute)
+	return cred, nil
+}
+
+// enableS3Express signs the requests to the directory bucket with the session credentials instead of the
+// credentials of the client, which are only used to create the sessions.
+func enableS3Express(s *s3client) {
+	s.expressSession = credentials.NewCredentials(&s3ExpressSession{s: s})
+	s.s3.Handlers.Sign.Swap(v4.SignRequestHandler.Name, request.NamedHandler{Name: "s3express.SignRequestHandler", Fn: s.signExpress})
+}
+
+// createSession creates a session of the directory bucket for both read and write.
+func (s *s3client) createSession() (credentials.Value, time.Time, error) {
+	u, err := url.Parse(s.s3.Endpoint)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	if aws.BoolValue(s.s3.Config.S3ForcePathStyle) {
+		u.Path = "/" + s.bucket
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.RawQuery = "session"
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	req.Header.Set("X-Amz-Create-Session-Mode", "ReadWrite")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sha256.New().Sum(nil)))
+	signer := v4.NewSigner(s.s3.Config.Credentials, func(v *v4.Signer) { v.DisableURIPathEscaping = true })
+	if _, err = signer.Sign(req, nil, s3ExpressService, aws.StringValue(s.s3.Config.Region), time.Now()); err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	client := s.s3.Config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("create session of directory bucket %s: %s %s", s.bucket, resp.Status, body)
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		}
+	}
+	if err = xml.Unmarshal(body, &result); err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("invalid session of directory bucket %s: %s", s.bucket, err)
+	}
+	cred := result.Credentials
+	return credentials.Value{
+		AccessKeyID:     cred.AccessKeyId,
+		SecretAccessKey: cred.SecretAccessKey,
+		SessionToken:    cred.SessionToken,
+		ProviderName:    "S3ExpressSession",
+	}, cred.Expiration, nil
+}
+
+// signExpress signs a request to the directory bucket with the session credentials, the session token is sent
+// in X-Amz-S3session-Token rather than X-Amz-Security-Token.
+func (s *s3client) signExpress(r *request.Request) {
+	cred, err := s.expressSession.Get()
+	if err != nil {
+		r.Error = err
+		return
+	}
+	if r.HTTPRequest.Header.Get("X-Amz-Content-Sha256") == "" {
+		h := sha256.New()
+		if body := r.GetBody(); body != nil {
+			if _, err = io.Copy(h, body); err == nil {
+				_, err = body.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				r.Error = err
+				return
+			}
+		}
+		r.HTTPRequest.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(h.Sum(nil)))
+	}
+	r.HTTPRequest.Header.Set("X-Amz-S3session-Token", cred.SessionToken)
+	signer := v4.NewSigner(credentials.NewStaticCredentials(cred.AccessKeyID, cred.SecretAccessKey, ""),
+		func(v *v4.Signer) { v.DisableURIPathEscaping = true })
+	_, r.Error = signer.Sign(r.HTTPRequest, r.GetBody(), s3ExpressService, aws.StringValue(r.Config.Region), time.Now())
+}
+
+// s3ExpressPrefix returns the prefix to list the directory bucket, which only supports the prefixes ending with
+// a slash.
+func s3ExpressPrefix(prefix string) string {
+	return prefix[:strings.LastIndexByte(prefix, '/')+1]
+}
+
+func (s *s3client) expressObjects(prefix, marker string, contents []*s3.Object) ([]Object, error) {
+	var objs []Object
+	for _, o := range contents {
+		key, err := url.QueryUnescape(*o.Key)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to decode key %s", *o.Key)
+		}
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		sc := DefaultStorageClass
+		if o.StorageClass != nil {
+			sc = *o.StorageClass
+		}
+		objs = append(objs, &obj{key, *o.Size, *o.LastModified, strings.HasSuffix(key, "/"), sc})
+	}
+	return objs, nil
+}
+
+// listExpress lists the directory bucket with ListObjectsV2, which returns the keys out of order and can't
+// continue after a key, so all the pages under prefix are listed and sorted before the first limit ones after
+// marker are returned, which costs the memory of all of them.
+func (s *s3client) listExpress(prefix, delimiter, marker string, limit int64) ([]Object, []string, error) {
+	param := s3.ListObjectsV2Input{
+		Bucket:       &s.bucket,
+		Prefix:       aws.String(s3ExpressPrefix(prefix)),
+		EncodingType: aws.String("url"),
+	}
+	if delimiter != "" {
+		param.Delimiter = &delimiter
+	}
+	var objs []Object
+	var prefixes []string
+	var err error
+	err2 := s.s3.ListObjectsV2Pages(&param, func(page *s3.ListObjectsV2Output, last bool) bool {
+		var batch []Object
+		if batch, err = s.expressObjects(prefix, marker, page.Contents); err != nil {
+			return false
+		}
+		objs = append(objs, batch...)
+		for _, p := range page.CommonPrefixes {
+			var cp string
+			if cp, err = url.QueryUnescape(*p.Prefix); err != nil {
+				err = errors.WithMessagef(err, "failed to decode commonPrefixes %s", *p.Prefix)
+				return false
+			}
+			if strings.HasPrefix(cp, prefix) && cp > marker {
+				prefixes = append(prefixes, cp)
+			}
+		}
+		return true
+	})
+	if err2 != nil {
+		return nil, nil, err2
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	sort.Strings(prefixes)
+	if limit > 0 && int64(len(objs)+len(prefixes)) > limit {
+		// the first limit ones of the objects and prefixes together
+		var i, j int
+		for int64(i+j) < limit {
+			if j == len(prefixes) || i < len(objs) && objs[i].Key() < prefixes[j] {
+				i++
+			} else {
+				j++
+			}
+		}
+		objs, prefixes = objs[:i], prefixes[:j]
+	}
+	return objs, prefixes, nil
+}
+
+// listAllExpress lists all the objects in the directory bucket before returning them in order, which costs the
+// memory of all the objects.
+func (s *s3client) listAllExpress(prefix, marker string) (<-chan Object, error) {
+	param := s3.ListObjectsV2Input{
+		Bucket:       &s.bucket,
+		Prefix:       aws.String(s3ExpressPrefix(prefix)),
+		EncodingType: aws.String("url"),
+	}
+	var objs []Object
+	var err error
+	err2 := s.s3.ListObjectsV2Pages(&param, func(page *s3.ListObjectsV2Output, last bool) bool {
+		var batch []Object
+		if batch, err = s.expressObjects(prefix, marker, page.Contents); err != nil {
+			return false
+		}
+		objs = append(objs, batch...)
+		return true
+	})
+	if err2 != nil {
+		return nil, err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key() < objs[j].Key() })
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		for _, o := range objs {
+			out <- o
+		}
+	}()
+	return out, nil
+}
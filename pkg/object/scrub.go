@@ -0,0 +1,122 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ScrubResult is an object that fails to be verified by Scrub.
+type ScrubResult struct {
+	Key       string
+	Algorithm string // the algorithm of the stored checksum, empty if there is no checksum
+	Expected  string // the stored checksum, base64 encoded
+	Actual    string // the checksum of the content, empty if the object can't be read
+	Err       error  // the error to head or read the object
+}
+
+func (r *ScrubResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: %s", r.Key, r.Err)
+	}
+	return fmt.Sprintf("%s: %s checksum mismatch: expected %s, got %s", r.Key, r.Algorithm, r.Expected, r.Actual)
+}
+
+// Scrub reads all the objects under prefix with concurrent threads, and verifies the content against the checksum
+// stored by the object storage (ObjectWithServerChecksum), which is taken from the listing if there, or Head.
+// Only the objects that fail to be read or don't match their checksums are sent to the returned channel, which is
+// closed after all the objects are verified. The objects without a checksum, or with the checksum of parts, are
+// still read through, so the read errors (including the checksum verified by Get) are reported.
+func Scrub(store ObjectStorage, prefix string, concurrency int) (<-chan ScrubResult, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	objs, err := ListAll(store, prefix, "", true)
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan ScrubResult, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range objs {
+				if o == nil {
+					results <- ScrubResult{Key: prefix, Err: fmt.Errorf("list %s failed", store)}
+					continue
+				}
+				if o.IsDir() {
+					continue
+				}
+				if r := scrubObject(store, o); r != nil {
+					results <- *r
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results, nil
+}
+
+func scrubObject(store ObjectStorage, o Object) *ScrubResult {
+	r := &ScrubResult{Key: o.Key()}
+	co, ok := o.(ObjectWithServerChecksum)
+	if !ok {
+		ho, err := store.Head(r.Key)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		co, _ = ho.(ObjectWithServerChecksum)
+	}
+	if co != nil {
+		r.Algorithm, r.Expected = co.ServerChecksum()
+	}
+	var w io.Writer = io.Discard
+	h := checksumHash(r.Algorithm)
+	// the checksum of multipart objects ("<checksum>-<parts>") is computed from the checksums of parts
+	if h != nil && !strings.Contains(r.Expected, "-") {
+		w = h
+	} else {
+		h = nil
+	}
+	in, err := store.Get(r.Key, 0, -1)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer in.Close()
+	if _, err = io.Copy(w, in); err != nil {
+		r.Err = err
+		return r
+	}
+	if h == nil {
+		return nil
+	}
+	if r.Actual = base64.StdEncoding.EncodeToString(h.Sum(nil)); r.Actual != r.Expected {
+		return r
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+type sumObj struct {
+	Object
+	algo, sum string
+}
+
+func (o *sumObj) ServerChecksum() (string, string) { return o.algo, o.sum }
+
+// storedChecksums returns the checksums in sums from Head, and fails to read the keys in broken.
+type storedChecksums struct {
+	ObjectStorage
+	sums   map[string]string
+	broken map[string]bool
+	heads  atomic.Int32
+}
+
+func (s *storedChecksums) Head(key string) (Object, error) {
+	s.heads.Add(1)
+	o, err := s.ObjectStorage.Head(key)
+	if err != nil || s.sums[key] == "" {
+		return o, err
+	}
+	return &sumObj{o, "SHA256", s.sums[key]}, nil
+}
+
+func (s *storedChecksums) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	if s.broken[key] {
+		return nil, errBroken
+	}
+	return s.ObjectStorage.Get(key, off, limit, getters...)
+}
+
+func sha256Sum(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func TestScrub(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	for _, k := range []string{"a/good", "a/bad", "a/broken", "a/nosum", "a/parts", "b/bad"} {
+		_ = m.Put(k, bytes.NewReader([]byte(k)))
+	}
+	s := &storedChecksums{
+		ObjectStorage: m,
+		sums: map[string]string{
+			"a/good":   sha256Sum("a/good"),
+			"a/bad":    sha256Sum("corrupted"),
+			"a/broken": sha256Sum("a/broken"),
+			"a/parts":  sha256Sum("part") + "-2",
+			"b/bad":    sha256Sum("corrupted"),
+		},
+		broken: map[string]bool{"a/broken": true},
+	}
+	ch, err := Scrub(s, "a/", 3)
+	if err != nil {
+		t.Fatalf("scrub: %s", err)
+	}
+	var results []ScrubResult
+	for r := range ch {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	if len(results) != 2 {
+		t.Fatalf("results: %+v", results)
+	}
+	if r := results[0]; r.Key != "a/bad" || r.Algorithm != "SHA256" || r.Expected != sha256Sum("corrupted") || r.Actual != sha256Sum("a/bad") || r.Err != nil {
+		t.Fatalf("mismatch: %+v", r)
+	}
+	if r := results[1]; r.Key != "a/broken" || r.Err != errBroken || r.Actual != "" {
+		t.Fatalf("read error: %+v", r)
+	}
+	if s.heads.Load() != 5 {
+		t.Fatalf("every object should be checked by head: %d", s.heads.Load())
+	}
+
+	// the checksums in the listing are used without head
+	s.heads.Store(0)
+	ch, _ = Scrub(&listedChecksums{s}, "b/", 0)
+	if r, ok := <-ch; !ok || r.Key != "b/bad" || r.Actual != sha256Sum("b/bad") {
+		t.Fatalf("mismatch in listing: %+v", r)
+	}
+	if _, ok := <-ch; ok || s.heads.Load() != 0 {
+		t.Fatalf("scrub with checksums in listing: %d heads", s.heads.Load())
+	}
+}
+
+// listedChecksums returns the checksums in the listing.
+type listedChecksums struct{ *storedChecksums }
+
+func (l *listedChecksums) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := l.ObjectStorage.List(prefix, marker, delimiter, limit, followLink)
+	for i, o := range objs {
+		objs[i] = &sumObj{o, "SHA256", l.sums[o.Key()]}
+	}
+	return objs, err
+}
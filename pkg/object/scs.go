@@ -46,6 +46,10 @@ func (s *scsClient) String() string {
 	return fmt.Sprintf("scs://%s/", s.bucket)
 }
 
+func (s *scsClient) BucketName() string {
+	return s.bucket
+}
+
 func (s *scsClient) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
@@ -209,7 +209,7 @@ func (f *sftpStore) Put(key string, in io.Reader, getters ...AttrGetter) (err er
 	if err != nil {
 		return err
 	}
-	defer f.putSftpConnection(&c, err)
+	defer func() { f.putSftpConnection(&c, err) }()
 
 	p := f.path(key)
 	if strings.HasSuffix(p, dirSuffix) {
@@ -250,6 +250,10 @@ func (f *sftpStore) Put(key string, in io.Reader, getters ...AttrGetter) (err er
 		return err
 	}
 	if !PutInplace {
+		// replace the existing file atomically if the server supports it (OpenSSH does)
+		if _, ok := c.sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+			return c.sftpClient.PosixRename(tmp, p)
+		}
 		_ = c.sftpClient.Remove(p)
 		return c.sftpClient.Rename(tmp, p)
 	}
@@ -562,5 +566,5 @@ func newSftp(endpoint, username, pass, token string) (ObjectStorage, error) {
 }
 
 func init() {
-	Register("sftp", newSftp)
+	RegisterRawKeys("sftp", newSftp)
 }
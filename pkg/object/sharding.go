@@ -18,6 +18,7 @@ package object
 
 import (
 	"container/heap"
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
@@ -31,6 +32,14 @@ type sharded struct {
 	stores []ObjectStorage
 }
 
+func (s *sharded) WithContext(ctx context.Context) ObjectStorage {
+	stores := make([]ObjectStorage, len(s.stores))
+	for i, o := range s.stores {
+		stores[i] = WithContext(o, ctx)
+	}
+	return &sharded{stores: stores}
+}
+
 func (s *sharded) String() string {
 	return fmt.Sprintf("shard%d://%s", len(s.stores), s.stores[0])
 }
@@ -138,6 +147,10 @@ func ListAll(store ObjectStorage, prefix, marker string, followLink bool) (<-cha
 			logger.Debugf("Continue listing objects from %s marker %q", store, marker)
 			objs, err = store.List(prefix, marker, "", maxResults, followLink)
 			for err != nil {
+				if errors.Is(err, ErrInconsistentListing) {
+					out <- nil
+					return
+				}
 				logger.Warnf("Fail to list: %s, retry again", err.Error())
 				// slow down
 				time.Sleep(time.Millisecond * 100)
@@ -0,0 +1,148 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type sortedListing struct {
+	ObjectStorage
+	window int
+}
+
+// WithSortedListing makes ListAll return the keys in order even if the object storage returns them slightly out
+// of order (across pages), by buffering up to window objects and always emitting the smallest one. Duplicated keys
+// are dropped. It costs the memory of window objects, and a key out of order by more than window objects can't be
+// fixed, the listing fails (a nil object is sent) in that case. It's disabled if window is not positive.
+func WithSortedListing(s ObjectStorage, window int) ObjectStorage {
+	if window <= 0 {
+		return s
+	}
+	return &sortedListing{s, window}
+}
+
+func (s *sortedListing) WithContext(ctx context.Context) ObjectStorage {
+	return &sortedListing{WithContext(s.ObjectStorage, ctx), s.window}
+}
+
+func (s *sortedListing) String() string {
+	return fmt.Sprintf("%s(sorted %d)", s.ObjectStorage, s.window)
+}
+
+// objectHeap is a min-heap of objects by key.
+type objectHeap []Object
+
+func (h objectHeap) Len() int            { return len(h) }
+func (h objectHeap) Less(i, j int) bool  { return h[i].Key() < h[j].Key() }
+func (h objectHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *objectHeap) Push(o interface{}) { *h = append(*h, o.(Object)) }
+func (h *objectHeap) Pop() interface{} {
+	old := *h
+	o := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return o
+}
+
+// listUnsorted lists all the objects after marker with List, without checking the order of keys.
+func (s *sortedListing) listUnsorted(prefix, marker string, followLink bool) (<-chan Object, error) {
+	objs, err := s.ObjectStorage.List(prefix, marker, "", maxResults, followLink)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		for len(objs) > 0 {
+			next := marker
+			for _, o := range objs {
+				out <- o
+				if o.Key() > next {
+					next = o.Key()
+				}
+			}
+			// continue after the last key of the page, or the largest one if it doesn't move forward
+			if last := objs[len(objs)-1].Key(); last > marker {
+				next = last
+			}
+			if next == marker {
+				return
+			}
+			marker = next
+			objs, err = s.ObjectStorage.List(prefix, marker, "", maxResults, followLink)
+			for err != nil {
+				logger.Warnf("Fail to list: %s, retry again", err.Error())
+				time.Sleep(time.Millisecond * 100)
+				objs, err = s.ObjectStorage.List(prefix, marker, "", maxResults, followLink)
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *sortedListing) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	in, err := s.ObjectStorage.ListAll(prefix, marker, followLink)
+	if errors.Is(err, notSupported) {
+		in, err = s.listUnsorted(prefix, marker, followLink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Object, maxResults)
+	go func() {
+		defer close(out)
+		h := make(objectHeap, 0, s.window+1)
+		var last string
+		var first = true
+		emit := func() bool {
+			o := heap.Pop(&h).(Object)
+			if !first && o.Key() == last {
+				return true
+			}
+			if !first && o.Key() < last {
+				logger.Errorf("The key %q is out of order by more than %d objects, after %q", o.Key(), s.window, last)
+				out <- nil
+				return false
+			}
+			out <- o
+			last, first = o.Key(), false
+			return true
+		}
+		for o := range in {
+			if o == nil { // failed
+				out <- nil
+				return
+			}
+			heap.Push(&h, o)
+			if h.Len() > s.window && !emit() {
+				return
+			}
+		}
+		for h.Len() > 0 {
+			if !emit() {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+var _ ObjectStorage = &sortedListing{}
@@ -0,0 +1,116 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// unsortedPages returns small pages with every two keys swapped, so the last key of a page is not the largest one
+// and some keys are returned again in the next page.
+type unsortedPages struct {
+	ObjectStorage
+	pageSize int64
+}
+
+func (u *unsortedPages) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := u.ObjectStorage.List(prefix, marker, delimiter, u.pageSize, followLink)
+	for i := 0; i+1 < len(objs); i += 2 {
+		objs[i], objs[i+1] = objs[i+1], objs[i]
+	}
+	return objs, err
+}
+
+func (u *unsortedPages) ListAll(prefix, marker string, followLink bool) (<-chan Object, error) {
+	return nil, notSupported
+}
+
+func TestSortedListing(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	var expected []Object
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		_ = m.Put(key, bytes.NewReader(nil))
+		expected = append(expected, &obj{key: key})
+	}
+	u := &unsortedPages{m, 7}
+	if ch, err := ListAll(u, "", "", true); err != nil {
+		t.Fatalf("list all: %s", err)
+	} else {
+		var last Object
+		for o := range ch {
+			last = o
+		}
+		if last != nil {
+			t.Fatalf("unsorted listing should fail")
+		}
+	}
+
+	if WithSortedListing(u, 0) != u {
+		t.Fatalf("sorted listing should be disabled with zero window")
+	}
+	s := WithSortedListing(u, 2)
+	if s.String() != "mem:///(sorted 2)" {
+		t.Fatalf("name: %s", s)
+	}
+	ch, err := ListAll(s, "", "", true)
+	if err != nil {
+		t.Fatalf("list all: %s", err)
+	}
+	var objs []Object
+	for o := range ch {
+		if o == nil {
+			t.Fatalf("listing failed after %d objects", len(objs))
+		}
+		objs = append(objs, o)
+	}
+	if listKeys(objs) != listKeys(expected) {
+		t.Fatalf("listed keys: %s", listKeys(objs))
+	}
+	ch, _ = ListAll(s, "", "k090", true)
+	objs = objs[:0]
+	for o := range ch {
+		objs = append(objs, o)
+	}
+	if listKeys(objs) != listKeys(expected[91:]) {
+		t.Fatalf("listed keys after k090: %s", listKeys(objs))
+	}
+
+	// out of order by more than the window
+	u.pageSize = 10
+	ch, _ = ListAll(WithSortedListing(&reversedPages{u}, 3), "", "", true)
+	var failed bool
+	for o := range ch {
+		failed = o == nil
+	}
+	if !failed {
+		t.Fatalf("listing should fail if the keys are out of order beyond the window")
+	}
+}
+
+// reversedPages reverses the pages.
+type reversedPages struct{ *unsortedPages }
+
+func (r *reversedPages) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
+	objs, err := r.ObjectStorage.List(prefix, marker, delimiter, r.pageSize, followLink)
+	for i, j := 0, len(objs)-1; i < j; i, j = i+1, j-1 {
+		objs[i], objs[j] = objs[j], objs[i]
+	}
+	return objs, err
+}
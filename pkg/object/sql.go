@@ -58,31 +58,49 @@ func (s *sqlStore) String() string {
 	return fmt.Sprintf("%s://%s/", driver, s.addr)
 }
 
+// maxBlobSize returns the max size of the data column: mediumblob of MySQL, bytea of PostgreSQL,
+// and the default max length of SQLite.
+func (s *sqlStore) maxBlobSize() int64 {
+	switch s.db.DriverName() {
+	case "mysql":
+		return 16<<20 - 1
+	case "postgres", "pgx":
+		return 1 << 30
+	default:
+		return 1e9
+	}
+}
+
+func (s *sqlStore) Limits() Limits {
+	return Limits{MaxObjectSize: s.maxBlobSize()}
+}
+
 func (s *sqlStore) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
-	var b = blob{Key: []byte(key)}
-	// TODO: range
-	ok, err := s.db.Get(&b)
+	// only the range is read by substr(), which works with the blobs of all the databases
+	data, args := "substr(data, ?)", []interface{}{off + 1}
+	if limit > 0 {
+		data, args = "substr(data, ?, ?)", append(args, limit)
+	}
+	query := fmt.Sprintf("SELECT %s AS data FROM %s WHERE %s = ?", data, s.db.Quote(s.db.TableName(&blob{}, true)), s.db.Quote("key"))
+	rows, err := s.db.Query(append([]interface{}{query}, append(args, []byte(key))...)...)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
+	if len(rows) == 0 {
 		return nil, os.ErrNotExist
 	}
-	if off > int64(len(b.Data)) {
-		off = int64(len(b.Data))
-	}
-	data := b.Data[off:]
-	if limit > 0 && limit < int64(len(data)) {
-		data = data[:limit]
-	}
-	return io.NopCloser(bytes.NewBuffer(data)), nil
+	return io.NopCloser(bytes.NewBuffer(rows[0]["data"])), nil
 }
 
 func (s *sqlStore) Put(key string, in io.Reader, getters ...AttrGetter) error {
-	d, err := io.ReadAll(in)
+	max := s.maxBlobSize()
+	d, err := io.ReadAll(io.LimitReader(in, max+1))
 	if err != nil {
 		return err
 	}
+	if int64(len(d)) > max {
+		return fmt.Errorf("%s is too large for %s: more than %d bytes", key, s, max)
+	}
 	var n int64
 	now := time.Now()
 	b := blob{Key: []byte(key), Data: d, Size: int64(len(d)), Modified: now}
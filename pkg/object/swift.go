@@ -20,13 +20,16 @@
 package object
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,10 +45,33 @@ type swiftOSS struct {
 	container  string
 }
 
+// every segment of Static Large Objects but the last one is at least min_segment_size (1 MiB by default),
+// and a manifest has at most max_manifest_segments (1000 by default).
+const (
+	swiftMinPartSize  = 1 << 20
+	swiftMaxPartCount = 1000
+)
+
 func (s *swiftOSS) String() string {
 	return fmt.Sprintf("swift://%s/", s.container)
 }
 
+func (s *swiftOSS) BucketName() string {
+	return s.container
+}
+
+// Limits of Static Large Objects, the segments are stored in another container, so they are not listed with
+// the objects. A segment (or object) is at most 5 GiB, and a manifest has at most 1000 segments by default.
+func (s *swiftOSS) Limits() Limits {
+	return Limits{
+		IsSupportMultipartUpload: true,
+		MinPartSize:              swiftMinPartSize,
+		MaxPartSize:              5 << 30,
+		MaxPartCount:             swiftMaxPartCount,
+		MaxObjectSize:            swiftMaxPartCount * (5 << 30),
+	}
+}
+
 func (s *swiftOSS) Create() error {
 	// No error is returned if it already exists but the metadata if any will be updated.
 	return s.conn.ContainerCreate(context.Background(), s.container, nil)
@@ -70,14 +96,28 @@ func (s *swiftOSS) Put(key string, in io.Reader, getters ...AttrGetter) error {
 	return err
 }
 
+// Delete removes the object, and the segments of it if it's a Static Large Object.
 func (s *swiftOSS) Delete(key string, getters ...AttrGetter) error {
-	err := s.conn.ObjectDelete(context.Background(), s.container, key)
+	err := s.call("DELETE", s.container, key, url.Values{"multipart-manifest": {"delete"}}, nil)
 	if err != nil && errors.Is(err, swift.ObjectNotFound) {
 		err = nil
 	}
 	return err
 }
 
+func (s *swiftOSS) call(op, container, key string, params url.Values, body io.Reader) error {
+	_, _, err := s.conn.Call(context.Background(), s.storageUrl, swift.RequestOpts{
+		Container:  container,
+		ObjectName: key,
+		Operation:  op,
+		Parameters: params,
+		Body:       body,
+		NoResponse: true,
+		ErrorMap:   map[int]error{http.StatusNotFound: swift.ObjectNotFound},
+	})
+	return err
+}
+
 func (s *swiftOSS) List(prefix, marker, delimiter string, limit int64, followLink bool) ([]Object, error) {
 	if limit > 10000 {
 		limit = 10000
@@ -120,6 +160,64 @@ func (s *swiftOSS) Head(key string) (Object, error) {
 	}, err
 }
 
+// segments is the container of the segments of Static Large Objects, as the Swift CLI does.
+func (s *swiftOSS) segments() string {
+	return s.container + "_segments"
+}
+
+// segmentName returns the name of a segment, which is ordered by part number in the upload.
+func segmentName(key, uploadID string, num int) string {
+	return fmt.Sprintf("%s/%s/%08d", key, uploadID, num)
+}
+
+func (s *swiftOSS) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	if err := s.conn.ContainerCreate(context.Background(), s.segments(), nil); err != nil {
+		return nil, err
+	}
+	uploadID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return &MultipartUpload{UploadID: uploadID, MinPartSize: swiftMinPartSize, MaxCount: swiftMaxPartCount}, nil
+}
+
+func (s *swiftOSS) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	h, err := s.conn.ObjectPut(context.Background(), s.segments(), segmentName(key, uploadID, num), bytes.NewReader(body), true, "", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Part{Num: num, Size: len(body), ETag: h["Etag"]}, nil
+}
+
+func (s *swiftOSS) AbortUpload(key string, uploadID string) {
+	ctx := context.Background()
+	names, err := s.conn.ObjectNamesAll(ctx, s.segments(), &swift.ObjectsOpts{Prefix: key + "/" + uploadID + "/"})
+	if err != nil {
+		logger.Warnf("List segments of %s (%s): %s", key, uploadID, err)
+		return
+	}
+	for _, name := range names {
+		if err = s.conn.ObjectDelete(ctx, s.segments(), name); err != nil && !errors.Is(err, swift.ObjectNotFound) {
+			logger.Warnf("Delete segment %s: %s", name, err)
+		}
+	}
+}
+
+// CompleteUpload writes the manifest of the Static Large Object, which refers to the segments of the parts.
+func (s *swiftOSS) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	type segment struct {
+		Path string `json:"path"`
+		Etag string `json:"etag"`
+		Size int64  `json:"size_bytes"`
+	}
+	manifest := make([]segment, len(parts))
+	for i, p := range parts {
+		manifest[i] = segment{s.segments() + "/" + segmentName(key, uploadID, p.Num), p.ETag, int64(p.Size)}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return s.call("PUT", s.container, key, url.Values{"multipart-manifest": {"put"}}, bytes.NewReader(data))
+}
+
 func newSwiftOSS(endpoint, username, apiKey, token string) (ObjectStorage, error) {
 	if !strings.Contains(endpoint, "://") {
 		endpoint = fmt.Sprintf("http://%s", endpoint)
@@ -45,6 +45,10 @@ func (t *tosClient) String() string {
 	return fmt.Sprintf("tos://%s/", t.bucket)
 }
 
+func (t *tosClient) BucketName() string {
+	return t.bucket
+}
+
 func (t *tosClient) Limits() Limits {
 	return Limits{
 		IsSupportMultipartUpload: true,
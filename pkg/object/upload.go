@@ -0,0 +1,397 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"github.com/juicedata/juicefs/pkg/utils"
+)
+
+const defaultUploadPartSize = 8 << 20
+
+// defaultPutThreshold is the size of the data of unknown length buffered by Put, larger ones are uploaded as a stream.
+const defaultPutThreshold = 32 << 20
+
+type UploadOptions struct {
+	PartSize    int64 // the size of each part, 0 means choosing it from the limits of the object storage
+	Concurrency int   // the number of parts uploaded concurrently, 4 by default
+	MaxRetries  int   // the max retries of each part, 3 by default
+	// CheckpointDir keeps the checkpoints of the failed uploads to resume them, disabled if empty
+	CheckpointDir string
+}
+
+func (o *UploadOptions) partSize(store ObjectStorage, up *MultipartUpload) int64 {
+	limits := store.Limits()
+	size := o.PartSize
+	if size <= 0 {
+		size = limits.PartSize
+	}
+	if size <= 0 {
+		size = defaultUploadPartSize
+	}
+	if min := int64(limits.MinPartSize); size < min {
+		size = min
+	}
+	if min := int64(up.MinPartSize); size < min {
+		size = min
+	}
+	if limits.MaxPartSize > 0 && size > limits.MaxPartSize {
+		size = limits.MaxPartSize
+	}
+	return size
+}
+
+var partSizeRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[BKMGT]?$`)
+
+// parseUploadOptions parses part-size (in MiB if there is no unit) and upload-concurrency in the query of endpoint,
+// which are reported by Limits and used by the uploaders (Upload and sync). The part size is clamped to the limits,
+// and it warns if the largest object that can be uploaded in such parts is smaller than the max object size.
+func parseUploadOptions(query url.Values, limits Limits) (int64, int, error) {
+	var partSize int64
+	if v := query.Get("part-size"); v != "" {
+		if !partSizeRegexp.MatchString(strings.ToUpper(v)) {
+			return 0, 0, fmt.Errorf("invalid part-size %q", v)
+		}
+		partSize = int64(utils.ParseBytesStr("part-size", strings.ToUpper(v), 'M'))
+		if min := int64(limits.MinPartSize); partSize < min {
+			logger.Warnf("part-size %s is smaller than the minimum %d, use the minimum instead", v, min)
+			partSize = min
+		}
+		if max := limits.MaxPartSize; max > 0 && partSize > max {
+			logger.Warnf("part-size %s is larger than the maximum %d, use the maximum instead", v, max)
+			partSize = max
+		}
+		if n := int64(limits.MaxPartCount); n > 0 && limits.MaxObjectSize > 0 && partSize*n < limits.MaxObjectSize {
+			logger.Warnf("Objects larger than %s (%d parts of %s) can't be uploaded from a stream, use a larger part-size for them",
+				humanize.IBytes(uint64(partSize*n)), n, humanize.IBytes(uint64(partSize)))
+		}
+	}
+	var concurrency int
+	if v := query.Get("upload-concurrency"); v != "" {
+		var err error
+		if concurrency, err = strconv.Atoi(v); err != nil || concurrency <= 0 {
+			return 0, 0, fmt.Errorf("invalid upload-concurrency %q", v)
+		}
+	}
+	return partSize, concurrency, nil
+}
+
+// parsePutThreshold parses put-threshold (in MiB if there is no unit) in the query of endpoint. Put buffers the data
+// of unknown length (not an io.ReadSeeker) up to it and puts it in one request, larger ones are uploaded as a stream
+// in parts, so the memory used by Put is bounded. It's limited by the max size of a single put.
+func parsePutThreshold(query url.Values, max int64) (int64, error) {
+	v := query.Get("put-threshold")
+	if v == "" {
+		return defaultPutThreshold, nil
+	}
+	if !partSizeRegexp.MatchString(strings.ToUpper(v)) {
+		return 0, fmt.Errorf("invalid put-threshold %q", v)
+	}
+	threshold := int64(utils.ParseBytesStr("put-threshold", strings.ToUpper(v), 'M'))
+	if threshold <= 0 {
+		return 0, fmt.Errorf("invalid put-threshold %q", v)
+	}
+	if max > 0 && threshold > max {
+		logger.Warnf("put-threshold %s is larger than the maximum %d, use the maximum instead", v, max)
+		threshold = max
+	}
+	return threshold, nil
+}
+
+// readPart reads up to size bytes, it returns io.EOF only if nothing is read.
+func readPart(in io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(in, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+type checkpointPart struct {
+	Part
+	CRC uint32 // CRC32C of the data, to check whether it's changed when resuming
+}
+
+// uploadCheckpoint records the uploaded parts of a multipart upload, to resume it after failure.
+type uploadCheckpoint struct {
+	path     string
+	UploadID string
+	PartSize int64
+	MaxCount int
+	Parts    map[int]*checkpointPart
+}
+
+func checkpointPath(store ObjectStorage, key, dir string) string {
+	h := sha256.Sum256([]byte(store.String() + "\x00" + key))
+	return filepath.Join(dir, hex.EncodeToString(h[:])+".upload")
+}
+
+// loadCheckpoint returns the checkpoint of the upload, or nil if there is none or it's broken.
+func loadCheckpoint(path string) *uploadCheckpoint {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cp uploadCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil || cp.UploadID == "" || cp.PartSize <= 0 {
+		logger.Warnf("Ignore broken checkpoint %s: %v", path, err)
+		return nil
+	}
+	if cp.Parts == nil {
+		cp.Parts = make(map[int]*checkpointPart)
+	}
+	cp.path = path
+	return &cp
+}
+
+func (cp *uploadCheckpoint) save() error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *uploadCheckpoint) remove() {
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Remove checkpoint %s: %s", cp.path, err)
+	}
+}
+
+// uploadExists checks whether the upload is still pending, it returns true if it can't be checked.
+func uploadExists(store ObjectStorage, key, uploadID string) bool {
+	var marker string
+	for {
+		ups, next, err := store.ListUploads(marker)
+		if err != nil {
+			return true
+		}
+		for _, up := range ups {
+			if up.Key == key && up.UploadID == uploadID {
+				return true
+			}
+		}
+		if next == "" || next == marker {
+			return false
+		}
+		marker = next
+	}
+}
+
+// Upload uploads the data as an object by multipart upload, the parts are uploaded concurrently and retried
+// if failed. The upload is aborted if any part fails at the end. It falls back to Put if the data is not
+// larger than one part, or the object storage doesn't support multipart upload.
+//
+// With CheckpointDir, the failed upload is kept (not aborted) with a checkpoint of the uploaded parts, and
+// resumed when the same object is uploaded to the same object storage again, the parts of the same data are not
+// uploaded again. The checkpoint is removed once the upload is completed, or the upload is expired.
+func Upload(store ObjectStorage, key string, in io.Reader, opts UploadOptions) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = store.Limits().UploadConcurrency
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	if !store.Limits().IsSupportMultipartUpload {
+		return store.Put(key, in)
+	}
+	size := opts.partSize(store, &MultipartUpload{})
+	first, err := readPart(in, size)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var cp *uploadCheckpoint
+	var cpPath string
+	if opts.CheckpointDir != "" {
+		cpPath = checkpointPath(store, key, opts.CheckpointDir)
+		if cp = loadCheckpoint(cpPath); cp != nil && !uploadExists(store, key, cp.UploadID) {
+			logger.Infof("Upload %s of %s is expired, start a new one", cp.UploadID, key)
+			cp.remove()
+			cp = nil
+		}
+		if cp == nil {
+			if err = os.MkdirAll(opts.CheckpointDir, 0700); err != nil {
+				return fmt.Errorf("create checkpoint directory: %w", err)
+			}
+		} else {
+			logger.Infof("Resume upload %s of %s with %d parts", cp.UploadID, key, len(cp.Parts))
+		}
+	}
+	if int64(len(first)) < size {
+		if cp != nil {
+			store.AbortUpload(key, cp.UploadID)
+			cp.remove()
+		}
+		return store.Put(key, bytes.NewReader(first))
+	}
+	var up *MultipartUpload
+	var resumed bool
+	if cp != nil {
+		up = &MultipartUpload{UploadID: cp.UploadID, MaxCount: cp.MaxCount}
+		resumed = true
+	} else {
+		up, err = store.CreateMultipartUpload(key)
+		if errors.Is(err, notSupported) {
+			return store.Put(key, io.MultiReader(bytes.NewReader(first), in))
+		} else if err != nil {
+			return err
+		}
+	}
+	partSize := opts.partSize(store, up)
+	if resumed {
+		partSize = cp.PartSize
+	} else if cpPath != "" {
+		cp = &uploadCheckpoint{
+			path:     cpPath,
+			UploadID: up.UploadID,
+			PartSize: partSize,
+			MaxCount: up.MaxCount,
+			Parts:    make(map[int]*checkpointPart),
+		}
+	}
+	var uploaded int // the number of parts uploaded by this call
+	retryAll := func(error) bool { return true }
+
+	var mu sync.Mutex
+	var parts []*Part
+	var firstErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Concurrency)
+	pending := bytes.NewReader(first)
+	for num := 1; !failed(); num++ {
+		if up.MaxCount > 0 && num > up.MaxCount {
+			mu.Lock()
+			firstErr = fmt.Errorf("%s is too large: more than %d parts of %d bytes", key, up.MaxCount, partSize)
+			mu.Unlock()
+			break
+		}
+		body, err := readPart(io.MultiReader(pending, in), partSize)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			mu.Lock()
+			firstErr = err
+			mu.Unlock()
+			break
+		}
+		var crc uint32
+		if cp != nil {
+			crc = crc32.Checksum(body, crc32c)
+			mu.Lock()
+			done := cp.Parts[num]
+			mu.Unlock()
+			if done != nil && done.Size == len(body) && done.CRC == crc {
+				part := done.Part
+				mu.Lock()
+				parts = append(parts, &part)
+				mu.Unlock()
+				if int64(len(body)) < partSize {
+					break
+				}
+				continue
+			}
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(num int, body []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var part *Part
+			err := withRetry(context.Background(), opts.MaxRetries, retryAll, func() (err error) {
+				part, err = store.UploadPart(key, up.UploadID, num, body)
+				return
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("upload part %d of %s: %w", num, key, err)
+				}
+				return
+			}
+			parts = append(parts, part)
+			uploaded++
+			if cp != nil {
+				cp.Parts[num] = &checkpointPart{*part, crc}
+				if err := cp.save(); err != nil {
+					logger.Warnf("Save checkpoint of %s: %s", key, err)
+				}
+			}
+		}(num, body)
+		if int64(len(body)) < partSize {
+			break
+		}
+	}
+	wg.Wait()
+	if firstErr == nil {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].Num < parts[j].Num })
+		firstErr = withRetry(context.Background(), opts.MaxRetries, retryAll, func() error {
+			return store.CompleteUpload(key, up.UploadID, parts)
+		})
+	}
+	if cp != nil {
+		if firstErr == nil {
+			cp.remove()
+		} else if resumed && uploaded == 0 {
+			// the upload may be expired without being noticed by ListUploads
+			logger.Warnf("Resumed upload %s of %s failed: %s, start a new one next time", up.UploadID, key, firstErr)
+			store.AbortUpload(key, up.UploadID)
+			cp.remove()
+		} else if err := cp.save(); err != nil {
+			logger.Warnf("Save checkpoint of %s: %s", key, err)
+		}
+		return firstErr
+	}
+	if firstErr != nil {
+		store.AbortUpload(key, up.UploadID)
+		return firstErr
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// fakeMultipart keeps the parts in memory and fails the upload of the part failPart.
+type fakeMultipart struct {
+	ObjectStorage
+	sync.Mutex
+	parts    map[int][]byte
+	failPart int
+	fails    int
+	puts     int
+	aborted  bool
+	creates  int    // the number of uploads created
+	uploads  int    // the number of parts uploaded
+	uploadID string // the pending upload
+}
+
+func (f *fakeMultipart) Limits() Limits {
+	return Limits{IsSupportMultipartUpload: true, MinPartSize: 1 << 10, MaxPartSize: 1 << 20, MaxPartCount: 100}
+}
+
+func (f *fakeMultipart) Put(key string, in io.Reader, getters ...AttrGetter) error {
+	f.puts++
+	return f.ObjectStorage.Put(key, in, getters...)
+}
+
+func (f *fakeMultipart) CreateMultipartUpload(key string) (*MultipartUpload, error) {
+	f.parts = make(map[int][]byte)
+	f.creates++
+	f.uploadID = fmt.Sprintf("id%d", f.creates)
+	return &MultipartUpload{UploadID: f.uploadID, MinPartSize: 1 << 10, MaxCount: 100}, nil
+}
+
+func (f *fakeMultipart) ListUploads(marker string) ([]*PendingPart, string, error) {
+	if f.uploadID == "" {
+		return nil, "", nil
+	}
+	return []*PendingPart{{Key: "key", UploadID: f.uploadID}}, "", nil
+}
+
+func (f *fakeMultipart) UploadPart(key string, uploadID string, num int, body []byte) (*Part, error) {
+	f.Lock()
+	defer f.Unlock()
+	if num == f.failPart {
+		f.fails++
+		return nil, errors.New("injected failure")
+	}
+	f.parts[num] = append([]byte{}, body...)
+	f.uploads++
+	return &Part{Num: num, Size: len(body)}, nil
+}
+
+func (f *fakeMultipart) AbortUpload(key string, uploadID string) {
+	f.aborted = true
+	f.uploadID = ""
+}
+
+func (f *fakeMultipart) CompleteUpload(key string, uploadID string, parts []*Part) error {
+	if !sort.SliceIsSorted(parts, func(i, j int) bool { return parts[i].Num < parts[j].Num }) {
+		return errors.New("parts are not sorted")
+	}
+	var buf bytes.Buffer
+	for _, p := range parts {
+		buf.Write(f.parts[p.Num])
+	}
+	f.uploadID = ""
+	return f.ObjectStorage.Put(key, &buf)
+}
+
+func TestUpload(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	m, _ := newMem("", "", "", "")
+	f := &fakeMultipart{ObjectStorage: m}
+	data := make([]byte, 10<<10+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	check := func(key string, expected []byte) {
+		in, err := m.Get(key, 0, -1)
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		defer in.Close()
+		if d, _ := io.ReadAll(in); !bytes.Equal(d, expected) {
+			t.Fatalf("content of %s is not expected: %d bytes", key, len(d))
+		}
+	}
+
+	// small object is uploaded by Put
+	if err := Upload(f, "small", bytes.NewReader(data[:100]), UploadOptions{PartSize: 1 << 10}); err != nil || f.puts != 1 {
+		t.Fatalf("upload small object: %v, %d puts", err, f.puts)
+	}
+	check("small", data[:100])
+
+	if err := Upload(f, "large", io.MultiReader(bytes.NewReader(data)), UploadOptions{PartSize: 1 << 10, Concurrency: 3}); err != nil {
+		t.Fatalf("upload: %s", err)
+	}
+	if len(f.parts) != 11 || f.aborted || f.puts != 1 {
+		t.Fatalf("expect 11 parts without abort, got %d parts, aborted %v, %d puts", len(f.parts), f.aborted, f.puts)
+	}
+	check("large", data)
+
+	f.failPart = 5
+	err := Upload(f, "failed", bytes.NewReader(data), UploadOptions{PartSize: 1 << 10, MaxRetries: 2})
+	if err == nil || !f.aborted {
+		t.Fatalf("upload should fail and be aborted: %v, aborted %v", err, f.aborted)
+	}
+	if f.fails != 3 {
+		t.Fatalf("the failed part should be tried 3 times, but got %d", f.fails)
+	}
+	if _, err = m.Head("failed"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("failed upload should not create the object: %v", err)
+	}
+}
+
+func TestUploadCheckpoint(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	m, _ := newMem("", "", "", "")
+	f := &fakeMultipart{ObjectStorage: m}
+	dir := t.TempDir()
+	opts := UploadOptions{PartSize: 1 << 10, Concurrency: 1, MaxRetries: 1, CheckpointDir: dir}
+	data := make([]byte, 10<<10+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	checkpoints := func() int {
+		names, _ := filepath.Glob(filepath.Join(dir, "*.upload"))
+		return len(names)
+	}
+	checkpointed := func() int {
+		if cp := loadCheckpoint(checkpointPath(f, "key", dir)); cp != nil {
+			return len(cp.Parts)
+		}
+		return 0
+	}
+	check := func(expected []byte) {
+		in, err := m.Get("key", 0, -1)
+		if err != nil {
+			t.Fatalf("get: %s", err)
+		}
+		defer in.Close()
+		if d, _ := io.ReadAll(in); !bytes.Equal(d, expected) {
+			t.Fatalf("content is not expected: %d bytes", len(d))
+		}
+	}
+
+	f.failPart = 5
+	if err := Upload(f, "key", bytes.NewReader(data), opts); err == nil || f.aborted || checkpoints() != 1 {
+		t.Fatalf("failed upload should be kept with checkpoint: %v, aborted %v, %d checkpoints", err, f.aborted, checkpoints())
+	}
+	done := checkpointed()
+	if done < 4 {
+		t.Fatalf("the first 4 parts should be checkpointed: %d", done)
+	}
+	f.failPart = 0
+	f.uploads = 0
+	if err := Upload(f, "key", bytes.NewReader(data), opts); err != nil {
+		t.Fatalf("resume upload: %s", err)
+	}
+	if f.creates != 1 || f.uploads != 11-done || checkpoints() != 0 {
+		t.Fatalf("resume should upload the rest %d parts: %d creates, %d parts, %d checkpoints", 11-done, f.creates, f.uploads, checkpoints())
+	}
+	check(data)
+
+	// the data is changed
+	f.failPart = 5
+	_ = Upload(f, "key", bytes.NewReader(data), opts)
+	done = checkpointed()
+	f.failPart = 0
+	f.uploads = 0
+	changed := append([]byte{}, data...)
+	changed[1<<10+1]++ // in the second part
+	if err := Upload(f, "key", bytes.NewReader(changed), opts); err != nil || f.uploads != 11-done+1 {
+		t.Fatalf("resume upload with changed data: %v, %d parts", err, f.uploads)
+	}
+	check(changed)
+
+	// the upload is expired
+	f.failPart = 5
+	_ = Upload(f, "key", bytes.NewReader(data), opts)
+	f.failPart = 0
+	f.uploadID = ""
+	creates := f.creates
+	if err := Upload(f, "key", bytes.NewReader(data), opts); err != nil || f.creates != creates+1 {
+		t.Fatalf("expired upload should start a new one: %v, %d creates", err, f.creates-creates)
+	}
+	check(data)
+
+	// broken checkpoint
+	f.failPart = 5
+	_ = Upload(f, "key", bytes.NewReader(data), opts)
+	names, _ := filepath.Glob(filepath.Join(dir, "*.upload"))
+	_ = os.WriteFile(names[0], []byte("{"), 0600)
+	f.failPart = 0
+	creates = f.creates
+	if err := Upload(f, "key", bytes.NewReader(data), opts); err != nil || f.creates != creates+1 || checkpoints() != 0 {
+		t.Fatalf("broken checkpoint should be ignored: %v, %d creates, %d checkpoints", err, f.creates-creates, checkpoints())
+	}
+	check(data)
+}
+
+// configuredParts reports the part size and concurrency configured for the object storage.
+type configuredParts struct {
+	*fakeMultipart
+	partSize int64
+}
+
+func (c *configuredParts) Limits() Limits {
+	l := c.fakeMultipart.Limits()
+	l.PartSize = c.partSize
+	l.UploadConcurrency = 2
+	return l
+}
+
+func TestUploadOptions(t *testing.T) {
+	limits := (&fakeMultipart{}).Limits()
+	for _, c := range []struct {
+		query       string
+		partSize    int64
+		concurrency int
+	}{
+		{"", 0, 0},
+		{"part-size=2K&upload-concurrency=8", 2 << 10, 8},
+		{"part-size=0.5k", 1 << 10, 0},
+		{"part-size=1", 1 << 20, 0},
+		{"part-size=1G", 1 << 20, 0},
+	} {
+		q, _ := url.ParseQuery(c.query)
+		partSize, concurrency, err := parseUploadOptions(q, limits)
+		if err != nil || partSize != c.partSize || concurrency != c.concurrency {
+			t.Fatalf("parse %q: %d %d %v", c.query, partSize, concurrency, err)
+		}
+	}
+	for _, query := range []string{"part-size=abc", "part-size=-1", "upload-concurrency=0", "upload-concurrency=x"} {
+		q, _ := url.ParseQuery(query)
+		if _, _, err := parseUploadOptions(q, limits); err == nil {
+			t.Fatalf("parse %q should fail", query)
+		}
+	}
+
+	m, _ := newMem("", "", "", "")
+	f := &fakeMultipart{ObjectStorage: m}
+	data := make([]byte, 10<<10+100)
+	if err := Upload(&configuredParts{f, 2 << 10}, "key", bytes.NewReader(data), UploadOptions{}); err != nil {
+		t.Fatalf("upload: %s", err)
+	}
+	if len(f.parts) != 6 || len(f.parts[1]) != 2<<10 {
+		t.Fatalf("object should be uploaded in the configured part size: %d parts", len(f.parts))
+	}
+
+	s, err := newS3("http://127.0.0.1:9000/test?part-size=64&upload-concurrency=8", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	if l := s.Limits(); l.PartSize != 64<<20 || l.UploadConcurrency != 8 {
+		t.Fatalf("limits of s3: %+v", l)
+	}
+	s, err = newWasb("https://test.blob.core.windows.net?part-size=8&upload-concurrency=4", "account", "a2V5", "")
+	if err != nil {
+		t.Fatalf("create wasb: %s", err)
+	}
+	if l := s.Limits(); l.PartSize != 8<<20 || l.UploadConcurrency != 4 {
+		t.Fatalf("limits of wasb: %+v", l)
+	}
+}
+
+func TestPutThreshold(t *testing.T) {
+	for query, threshold := range map[string]int64{"": defaultPutThreshold, "put-threshold=8": 8 << 20, "put-threshold=512K": 512 << 10, "put-threshold=10G": 5 << 30} {
+		q, _ := url.ParseQuery(query)
+		if v, err := parsePutThreshold(q, 5<<30); err != nil || v != threshold {
+			t.Fatalf("parse %q: %d %v", query, v, err)
+		}
+	}
+	for _, query := range []string{"put-threshold=abc", "put-threshold=-1", "put-threshold=0"} {
+		q, _ := url.ParseQuery(query)
+		if _, err := parsePutThreshold(q, 5<<30); err == nil {
+			t.Fatalf("parse %q should fail", query)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// streamPut puts size bytes from a reader of unknown length, and returns the peak of the heap grown during it.
+func streamPut(t *testing.T, s ObjectStorage, size int64) uint64 {
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapAlloc
+	done := make(chan error)
+	go func() {
+		done <- s.Put("big", struct{ io.Reader }{io.LimitReader(zeroReader{}, size)})
+	}()
+	var peak uint64
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("put: %s", err)
+			}
+			return peak
+		case <-time.After(5 * time.Millisecond):
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > base && ms.HeapAlloc-base > peak {
+				peak = ms.HeapAlloc - base
+			}
+		}
+	}
+}
+
+func TestStreamPut(t *testing.T) {
+	const size = 256 << 20
+	const ceiling = 64 << 20
+	var received, puts, parts, completes int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		atomic.AddInt64(&received, n)
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPost && q.Has("uploadId"):
+			atomic.AddInt64(&completes, 1)
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ETag>"1"</ETag></CompleteMultipartUploadResult>`))
+		case r.Method == http.MethodPut && q.Has("partNumber"):
+			atomic.AddInt64(&parts, 1)
+			w.Header().Set("ETag", `"`+q.Get("partNumber")+`"`)
+		case r.Method == http.MethodPut && q.Get("comp") == "block":
+			atomic.AddInt64(&parts, 1)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+			atomic.AddInt64(&completes, 1)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			atomic.AddInt64(&puts, 1)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+	reset := func() {
+		received, puts, parts, completes = 0, 0, 0, 0
+	}
+
+	s, err := newS3(srv.URL+"/test?put-threshold=5&part-size=5&upload-concurrency=2", "ak", "sk", "")
+	if err != nil {
+		t.Fatalf("create s3: %s", err)
+	}
+	s.(*s3client).disableChecksum = true
+	if err = s.Put("small", struct{ io.Reader }{strings.NewReader("small")}); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if puts != 1 || parts != 0 {
+		t.Fatalf("small object should be put in one request: %d puts, %d parts", puts, parts)
+	}
+	threshold := s.(*s3client).putThreshold
+	s.(*s3client).putThreshold = 5
+	reset()
+	if err = s.Put("small", struct{ io.Reader }{strings.NewReader("small")}); err != nil {
+		t.Fatalf("put: %s", err)
+	}
+	if puts != 1 || parts != 0 {
+		t.Fatalf("object of the threshold should be put in one request: %d puts, %d parts", puts, parts)
+	}
+	s.(*s3client).putThreshold = threshold
+	reset()
+	if peak := streamPut(t, s, size); peak > ceiling {
+		t.Fatalf("s3: %d bytes are used to put %d bytes, more than %d", peak, size, ceiling)
+	}
+	if puts != 0 || parts != (size+(5<<20)-1)/(5<<20) || completes != 1 || received < size {
+		t.Fatalf("s3 should upload the stream in parts: %d puts, %d parts, %d completes, %d bytes", puts, parts, completes, received)
+	}
+
+	client, err := azblob.NewClientWithNoCredential(srv.URL, wasbClientOptions(srv.Client()))
+	if err != nil {
+		t.Fatalf("create client: %s", err)
+	}
+	b := &wasb{container: client.ServiceClient().NewContainerClient("test"), azblobCli: client, cName: "test", ctx: ctx,
+		partSize: 4 << 20, uploadConcurrency: 2, putThreshold: 4 << 20}
+	reset()
+	if peak := streamPut(t, b, size); peak > ceiling {
+		t.Fatalf("wasb: %d bytes are used to put %d bytes, more than %d", peak, size, ceiling)
+	}
+	if puts != 0 || parts != size/(4<<20) || completes != 1 || received < size {
+		t.Fatalf("wasb should upload the stream in blocks: %d puts, %d parts, %d completes, %d bytes", puts, parts, completes, received)
+	}
+}
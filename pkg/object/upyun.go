@@ -41,6 +41,10 @@ func (u *up) String() string {
 	return fmt.Sprintf("upyun://%s/", u.c.Bucket)
 }
 
+func (u *up) BucketName() string {
+	return u.c.Bucket
+}
+
 func (u *up) Create() error {
 	return nil
 }
@@ -0,0 +1,150 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// SupportUsage is implemented by the object storages that report the usage from the metrics of the provider,
+// which is much faster than listing, but may be stale.
+type SupportUsage interface {
+	// Usage returns the number and the total size of the objects, or ErrNotSupported if the metrics are not
+	// available (e.g. not configured), then they are counted by listing.
+	Usage() (objects int64, bytes int64, err error)
+}
+
+// Usage returns the number and the total size of the objects in the object storage, from the metrics of the
+// provider if it's supported (SupportUsage), otherwise by listing all the objects (the directories are not
+// counted). The listing is split by the top level directories, which are listed by concurrency threads. It stops
+// once ctx is canceled, with the error of ctx.
+func Usage(ctx context.Context, store ObjectStorage, concurrency int) (objects int64, bytes int64, err error) {
+	store = WithContext(store, ctx)
+	if s, ok := store.(SupportUsage); ok {
+		if objects, bytes, err = s.Usage(); !errors.Is(err, notSupported) {
+			return
+		}
+		logger.Debugf("Usage of %s is not available from the metrics, count it by listing", store)
+	}
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	var dirs []string
+	var marker string
+	for {
+		entries, err := store.List("", marker, "/", maxResults, false)
+		if errors.Is(err, notSupported) {
+			// the whole object storage is listed by one thread
+			return countObjects(ctx, store, "")
+		} else if err != nil {
+			return 0, 0, err
+		}
+		for _, o := range entries {
+			if o.IsDir() {
+				if o.Key() != "" {
+					dirs = append(dirs, o.Key())
+				}
+			} else {
+				objects++
+				bytes += o.Size()
+			}
+		}
+		if len(entries) < maxResults {
+			break
+		}
+		marker = entries[len(entries)-1].Key()
+		if err = ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	var nobjs, nbytes int64
+	sem := make(chan struct{}, concurrency)
+	for _, dir := range dirs {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(dir string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			n, size, err := countObjects(ctx, store, dir)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("count %s: %w", dir, err)
+				}
+				mu.Unlock()
+				return
+			}
+			atomic.AddInt64(&nobjs, n)
+			atomic.AddInt64(&nbytes, size)
+		}(dir)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, 0, firstErr
+	}
+	return objects + nobjs, bytes + nbytes, nil
+}
+
+// countObjects counts the objects under prefix by ListAll.
+func countObjects(ctx context.Context, store ObjectStorage, prefix string) (objects int64, bytes int64, err error) {
+	ch, err := ListAll(store, prefix, "", false)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			go func() {
+				for range ch { // let the listing finish
+				}
+			}()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
+		case o, ok := <-ch:
+			if !ok {
+				return objects, bytes, nil
+			}
+			if o == nil {
+				return 0, 0, fmt.Errorf("list %s failed", prefix)
+			}
+			if !o.IsDir() {
+				objects++
+				bytes += o.Size()
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * JuiceFS, Copyright 2024 Juicedata, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fixedUsage reports the usage from the metrics, or ErrNotSupported if they are not available.
+type fixedUsage struct {
+	ObjectStorage
+	available bool
+}
+
+func (f *fixedUsage) Usage() (int64, int64, error) {
+	if !f.available {
+		return 0, 0, notSupported
+	}
+	return 42, 4200, nil
+}
+
+func TestUsage(t *testing.T) {
+	m, _ := newMem("", "", "", "")
+	var size int64
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("d%d/k%d", i%7, i)
+		if i%10 == 0 {
+			key = fmt.Sprintf("k%d", i)
+		}
+		_ = m.Put(key, bytes.NewReader(make([]byte, i)))
+		size += int64(i)
+	}
+	_ = m.Put("d0/sub/", bytes.NewReader(nil)) // a directory
+	for _, concurrency := range []int{1, 3, 0} {
+		if objects, n, err := Usage(context.Background(), m, concurrency); err != nil || objects != 50 || n != size {
+			t.Fatalf("usage with %d threads: %d objects, %d bytes, %v", concurrency, objects, n, err)
+		}
+	}
+
+	d, _ := newDisk(t.TempDir()+"/", "", "", "")
+	_ = d.Put("a/b/c", bytes.NewReader([]byte("abc")))
+	_ = d.Put("d", bytes.NewReader([]byte("d")))
+	if objects, n, err := Usage(context.Background(), d, 2); err != nil || objects != 2 || n != 4 {
+		t.Fatalf("usage of disk: %d objects, %d bytes, %v", objects, n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Usage(ctx, m, 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("usage should be canceled: %v", err)
+	}
+
+	if objects, n, err := Usage(context.Background(), &fixedUsage{m, true}, 2); err != nil || objects != 42 || n != 4200 {
+		t.Fatalf("usage from the metrics: %d objects, %d bytes, %v", objects, n, err)
+	}
+	if objects, n, err := Usage(context.Background(), &fixedUsage{m, false}, 2); err != nil || objects != 50 || n != size {
+		t.Fatalf("usage should be counted without the metrics: %d objects, %d bytes, %v", objects, n, err)
+	}
+}
+
+func TestS3UsageMetrics(t *testing.T) {
+	var sizes = map[string]string{"StandardStorage": "1000", "StandardIAStorage": "234", "GlacierStorage": ""}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("Dimensions.member.1.Value") != "bucket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.Form.Get("Action") {
+		case "ListMetrics":
+			var metrics string
+			for st := range sizes {
+				metrics += fmt.Sprintf(`<member><Namespace>AWS/S3</Namespace><MetricName>BucketSizeBytes</MetricName><Dimensions>
+<member><Name>StorageType</Name><Value>%s</Value></member><member><Name>BucketName</Name><Value>bucket</Value></member>
+</Dimensions></member>`, st)
+			}
+			fmt.Fprintf(w, `<ListMetricsResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/"><ListMetricsResult><Metrics>%s</Metrics></ListMetricsResult></ListMetricsResponse>`, metrics)
+		case "GetMetricStatistics":
+			var value string
+			switch st := r.Form.Get("Dimensions.member.2.Value"); st {
+			case "AllStorageTypes":
+				value = "17"
+			default:
+				value = sizes[st]
+			}
+			var points string
+			if value != "" {
+				// the latest data point is used
+				points = fmt.Sprintf(`<member><Timestamp>2024-01-01T00:00:00Z</Timestamp><Average>1</Average></member>
+<member><Timestamp>2024-01-02T00:00:00Z</Timestamp><Average>%s</Average></member>`, value)
+			}
+			fmt.Fprintf(w, `<GetMetricStatisticsResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/"><GetMetricStatisticsResult><Datapoints>%s</Datapoints></GetMetricStatisticsResult></GetMetricStatisticsResponse>`, points)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	defer func(ep string) { cloudwatchEndpoint = ep }(cloudwatchEndpoint)
+	cloudwatchEndpoint = srv.URL
+
+	ses := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("ak", "sk", ""),
+		Endpoint:    aws.String("http://s3.invalid"),
+	}))
+	s := &s3client{bucket: "bucket", ses: ses}
+	if _, _, err := s.Usage(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("usage without metrics should not be supported: %v", err)
+	}
+	s.usageMetrics = true
+	if objects, n, err := Usage(context.Background(), WithNormalizedKeys(s), 1); err != nil || objects != 17 || n != 1234 {
+		t.Fatalf("usage from CloudWatch: %d objects, %d bytes, %v", objects, n, err)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/studio-b12/gowebdav"
 )
@@ -35,6 +36,9 @@ import (
 type webdav struct {
 	DefaultObjectStorage
 	endpoint *url.URL
+	user     string
+	passwd   string
+	timeout  time.Duration
 	c        *gowebdav.Client
 }
 
@@ -42,8 +46,17 @@ func (w *webdav) String() string {
 	return fmt.Sprintf("webdav://%s/", w.endpoint.Host)
 }
 
+// Create creates the collection of the endpoint (and its parents) by MKCOL.
 func (w *webdav) Create() error {
-	return nil
+	if w.endpoint.Path == "/" {
+		return nil
+	}
+	root := *w.endpoint
+	root.Path = "/"
+	c := gowebdav.NewClient(root.String(), w.user, w.passwd)
+	c.SetTransport(httpClient.Transport)
+	c.SetTimeout(w.timeout)
+	return c.MkdirAll(w.endpoint.Path, 0)
 }
 
 func (w *webdav) Head(key string) (Object, error) {
@@ -63,70 +76,30 @@ func (w *webdav) Head(key string) (Object, error) {
 	}, nil
 }
 
-// limitedReadCloser wraps a io.ReadCloser and limits the number of bytes that can be read from it.
-type limitedReadCloser struct {
-	rc        io.ReadCloser
-	remaining int
-}
-
-func (l *limitedReadCloser) Read(buf []byte) (int, error) {
-	if l.remaining <= 0 {
-		return 0, io.EOF
-	}
-
-	if len(buf) > l.remaining {
-		buf = buf[0:l.remaining]
-	}
-
-	n, err := l.rc.Read(buf)
-	l.remaining -= n
-
-	return n, err
-}
-
-func (l *limitedReadCloser) Close() error {
-	return l.rc.Close()
-}
-
+// Get reads the range through the client, so it's authenticated by basic or digest auth as the others.
 func (w *webdav) Get(key string, off, limit int64, getters ...AttrGetter) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	var err error
 	if off == 0 && limit <= 0 {
-		return w.c.ReadStream(key)
-	}
-	url := &url.URL{
-		Scheme: w.endpoint.Scheme,
-		User:   w.endpoint.User,
-		Host:   w.endpoint.Host,
-		Path:   path.Join(w.endpoint.Path, key),
-	}
-	req, err := http.NewRequest("GET", url.String(), nil)
-	if err != nil {
-		return nil, err
-	}
-	if limit > 0 {
-		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", off, off+limit-1))
+		r, err = w.c.ReadStream(key)
 	} else {
-		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", off))
-	}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode == http.StatusPartialContent {
-		// server supported partial content, return as-is.
-		return resp.Body, nil
-	}
-
-	// server returned success, but did not support partial content, so we have the whole
-	// stream in rs.Body
-	if resp.StatusCode == 200 {
-		if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, off)); err != nil {
-			return nil, &os.PathError{Op: "ReadStreamRange", Path: key, Err: err}
+		if limit <= 0 {
+			// the client returns nothing for an open range if the server doesn't support Range
+			var info os.FileInfo
+			if info, err = w.c.Stat(key); err == nil {
+				if limit = info.Size() - off; limit <= 0 {
+					return io.NopCloser(strings.NewReader("")), nil
+				}
+			}
+		}
+		if err == nil {
+			r, err = w.c.ReadStreamRange(key, off, limit)
 		}
-		// return a io.ReadCloser that is limited to `length` bytes.
-		return &limitedReadCloser{resp.Body, int(limit)}, nil
 	}
-	_ = resp.Body.Close()
-	return nil, &os.PathError{Op: "ReadStreamRange", Path: key, Err: err}
+	if err != nil && gowebdav.IsErrNotFound(err) {
+		err = os.ErrNotExist
+	}
+	return r, err
 }
 
 func (w *webdav) Put(key string, in io.Reader, getters ...AttrGetter) error {
@@ -242,10 +215,16 @@ func newWebDAV(endpoint, user, passwd, token string) (ObjectStorage, error) {
 	if uri.Path == "" {
 		uri.Path = "/"
 	}
-	uri.User = url.UserPassword(user, passwd)
+	timeout := httpClient.Timeout
+	if v := os.Getenv("WEBDAV_TIMEOUT"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid WEBDAV_TIMEOUT %q: %s", v, err)
+		}
+	}
 	c := gowebdav.NewClient(uri.String(), user, passwd)
 	c.SetTransport(httpClient.Transport)
-	return &webdav{endpoint: uri, c: c}, nil
+	c.SetTimeout(timeout)
+	return &webdav{endpoint: uri, user: user, passwd: passwd, timeout: timeout, c: c}, nil
 }
 
 func init() {
@@ -53,12 +53,15 @@ type Config struct {
 	ManagerAddr    string
 	ListThreads    int
 	ListDepth      int
+	ListWindow     int
+	CheckListing   bool
 	BWLimit        int64
 	NoHTTPS        bool
 	Verbose        bool
 	Quiet          bool
 	CheckAll       bool
 	CheckNew       bool
+	CheckChecksum  bool
 	MaxSize        int64
 	MinSize        int64
 	MaxAge         time.Duration
@@ -147,6 +150,8 @@ func NewConfigFromCli(c *cli.Context) *Config {
 		Threads:        c.Int("threads"),
 		ListThreads:    c.Int("list-threads"),
 		ListDepth:      c.Int("list-depth"),
+		ListWindow:     c.Int("list-window"),
+		CheckListing:   c.Bool("check-listing"),
 		Update:         c.Bool("update"),
 		ForceUpdate:    c.Bool("force-update"),
 		Perms:          c.Bool("perms"),
@@ -172,6 +177,7 @@ func NewConfigFromCli(c *cli.Context) *Config {
 		Quiet:          c.Bool("quiet"),
 		CheckAll:       c.Bool("check-all"),
 		CheckNew:       c.Bool("check-new"),
+		CheckChecksum:  c.Bool("check-checksum"),
 		MaxSize:        int64(utils.ParseBytes(c, "max-size", 'B')),
 		MinSize:        int64(utils.ParseBytes(c, "min-size", 'B')),
 		MaxAge:         utils.Duration(c.String("max-age")),
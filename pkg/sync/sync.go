@@ -18,6 +18,8 @@ package sync
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -326,10 +328,59 @@ func doCheckSum(src, dst object.ObjectStorage, key string, obj object.Object, co
 	return err
 }
 
+// objectMD5 returns the hex encoded MD5 of the object, which is the Content-MD5 stored by the object storage, or
+// calculated by reading the whole object. The ETag is not used, since it's not the MD5 for the objects uploaded
+// by multipart or encrypted by SSE-KMS or SSE-C, and for many object storages.
+func objectMD5(store object.ObjectStorage, key string, o object.Object) (string, error) {
+	if co, ok := o.(object.ObjectWithChecksum); ok {
+		if sum := strings.ToLower(co.ContentMD5()); sum != "" {
+			return sum, nil
+		}
+	}
+	if limiter != nil {
+		limiter.Wait(o.Size())
+	}
+	in, err := store.Get(key, 0, -1)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	h := md5.New()
+	buf := bufPool.Get().(*[]byte)
+	defer bufPool.Put(buf)
+	if _, err = io.CopyBuffer(h, in, *buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compareMD5 compares the MD5 of the objects in source and destination.
+func compareMD5(src, dst object.ObjectStorage, key string, obj object.Object, equal *bool) error {
+	dstObj, err := dst.Head(key)
+	if err != nil {
+		return fmt.Errorf("dest head: %s", err)
+	}
+	srcSum, err := objectMD5(src, key, obj)
+	if err != nil {
+		return fmt.Errorf("src checksum: %s", err)
+	}
+	dstSum, err := objectMD5(dst, key, dstObj)
+	if err != nil {
+		return fmt.Errorf("dest checksum: %s", err)
+	}
+	*equal = srcSum == dstSum
+	return nil
+}
+
 func checkSum(src, dst object.ObjectStorage, key string, obj object.Object, config *Config) (bool, error) {
 	start := time.Now()
 	var equal bool
-	err := try(3, func() error { return doCheckSum(src, dst, key, obj, config, &equal) })
+	err := try(3, func() error {
+		if config.CheckChecksum && !config.CheckAll && !config.CheckNew {
+			return compareMD5(src, dst, key, obj, &equal)
+		}
+		return doCheckSum(src, dst, key, obj, config, &equal)
+	})
 	if err == nil {
 		checked.Increment()
 		checkedBytes.IncrInt64(obj.Size())
@@ -381,7 +432,7 @@ func doCopySingle(src, dst object.ObjectStorage, key string, size int64) error {
 			err = dst.Put(key, in)
 		}
 		if err != nil {
-			if _, e := src.Head(key); os.IsNotExist(e) {
+			if _, e := src.Head(key); errors.Is(e, object.ErrNotFound) {
 				logger.Debugf("Head src %s: %s", key, err)
 				copied.IncrInt64(-1)
 				err = nil
@@ -412,7 +463,7 @@ func doCopySingle0(src, dst object.ObjectStorage, key string, size int64) error
 	} else {
 		in, err = src.Get(key, 0, size)
 		if err != nil {
-			if _, e := src.Head(key); os.IsNotExist(e) {
+			if _, e := src.Head(key); errors.Is(e, object.ErrNotFound) {
 				logger.Debugf("Head src %s: %s", key, err)
 				copied.IncrInt64(-1)
 				err = nil
@@ -469,8 +520,13 @@ func doUploadPart(src, dst object.ObjectStorage, srckey string, off, size int64,
 	return part, nil
 }
 
-func choosePartSize(upload *object.MultipartUpload, size int64) int64 {
-	partSize := int64(upload.MinPartSize)
+// choosePartSize uses the part size configured for the object storage, or the minimum part size of the upload,
+// enlarged if the parts are not enough for the size.
+func choosePartSize(limits object.Limits, upload *object.MultipartUpload, size int64) int64 {
+	partSize := limits.PartSize
+	if partSize < int64(upload.MinPartSize) {
+		partSize = int64(upload.MinPartSize)
+	}
 	if partSize == 0 {
 		partSize = defaultPartSize
 	}
@@ -507,7 +563,7 @@ func doCopyRange(src, dst object.ObjectStorage, key string, off, size int64, upl
 		return nil, fmt.Errorf("range(%d,%d): %s", off, size, err)
 	}
 
-	partSize := choosePartSize(up, size)
+	partSize := choosePartSize(limits, up, size)
 	n := int((size-1)/partSize) + 1
 	logger.Debugf("Copying data of %s (range: %d,%d) as %d parts (size: %d): %s", key, off, size, n, partSize, up.UploadID)
 	parts := make([]*object.Part, n)
@@ -546,14 +602,19 @@ func doCopyRange(src, dst object.ObjectStorage, key string, off, size int64, upl
 
 func doCopyMultiple(src, dst object.ObjectStorage, key string, size int64, upload *object.MultipartUpload) error {
 	limits := dst.Limits()
-	if size > limits.MaxPartSize*int64(upload.MaxCount) {
+	if size > limits.MaxPartSize*int64(upload.MaxCount) || limits.MaxObjectSize > 0 && size > limits.MaxObjectSize {
 		return fmt.Errorf("object size %d is too large to copy", size)
 	}
 
-	partSize := choosePartSize(upload, size)
+	partSize := choosePartSize(limits, upload, size)
 	n := int((size-1)/partSize) + 1
 	logger.Debugf("Copying data of %s as %d parts (size: %d): %s", key, n, partSize, upload.UploadID)
 	abort := make(chan struct{})
+	// the parts of an upload are also limited by upload-concurrency of the object storage
+	var uploading chan struct{}
+	if limits.UploadConcurrency > 0 {
+		uploading = make(chan struct{}, limits.UploadConcurrency)
+	}
 	parts := make([]*object.Part, n)
 	errs := make(chan error, n)
 	var err error
@@ -564,6 +625,10 @@ func doCopyMultiple(src, dst object.ObjectStorage, key string, size int64, uploa
 			if num == n-1 {
 				sz = size - int64(num)*partSize
 			}
+			if uploading != nil {
+				uploading <- struct{}{}
+				defer func() { <-uploading }()
+			}
 			var copyErr error
 			parts[num], copyErr = doCopyRange(src, dst, key, int64(num)*partSize, sz, upload, num, abort)
 			errs <- copyErr
@@ -751,11 +816,20 @@ func deleteFromDst(tasks chan<- object.Object, dstobj object.Object, config *Con
 	return false
 }
 
+// lister returns the object storage to list the keys, which are sorted within the list window and checked if asked.
+func lister(store object.ObjectStorage, config *Config) object.ObjectStorage {
+	store = object.WithSortedListing(store, config.ListWindow)
+	if config.CheckListing {
+		store = object.WithCheckedListing(store)
+	}
+	return store
+}
+
 func startSingleProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, prefix string, config *Config) error {
 	start, end := config.Start, config.End
 	logger.Debugf("maxResults: %d, defaultPartSize: %d, maxBlock: %d", maxResults, defaultPartSize, maxBlock)
 
-	srckeys, err := ListAll(src, prefix, start, end, !config.Links)
+	srckeys, err := ListAll(lister(src, config), prefix, start, end, !config.Links)
 	if err != nil {
 		return fmt.Errorf("list %s: %s", src, err)
 	}
@@ -766,7 +840,7 @@ func startSingleProducer(tasks chan<- object.Object, src, dst object.ObjectStora
 		close(t)
 		dstkeys = t
 	} else {
-		dstkeys, err = ListAll(dst, prefix, start, end, !config.Links)
+		dstkeys, err = ListAll(lister(dst, config), prefix, start, end, !config.Links)
 		if err != nil {
 			return fmt.Errorf("list %s: %s", dst, err)
 		}
@@ -844,7 +918,7 @@ func produce(tasks chan<- object.Object, srckeys, dstkeys <-chan object.Object,
 				skipped.Increment()
 				skippedBytes.IncrInt64(obj.Size())
 				handled.Increment()
-			} else if config.CheckAll { // two objects are likely the same
+			} else if config.CheckAll || config.CheckChecksum && !(obj.IsSymlink() && config.Links) { // two objects are likely the same
 				tasks <- &withSize{obj, markChecksum}
 			} else if config.DeleteSrc {
 				tasks <- &withSize{obj, markDeleteSrc}
@@ -1132,7 +1206,7 @@ func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, pr
 			srckeys <- obj
 			close(srckeys)
 			var dstkeys = make(chan object.Object, 1)
-			if dobj, err := dst.Head(config.Start); err == nil || os.IsNotExist(err) {
+			if dobj, err := dst.Head(config.Start); err == nil || errors.Is(err, object.ErrNotFound) {
 				if dobj != nil {
 					dstkeys <- dobj
 				}
@@ -1142,7 +1216,7 @@ func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, pr
 			} else {
 				logger.Warnf("head %s from %s: %s", config.Start, dst, err)
 			}
-		} else if err != nil && !os.IsNotExist(err) {
+		} else if err != nil && !errors.Is(err, object.ErrNotFound) {
 			logger.Warnf("head %s from %s: %s", config.Start, src, err)
 		}
 	}
@@ -1200,7 +1274,7 @@ func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, pr
 		}
 	}()
 
-	srckeys, err := listCommonPrefix(src, prefix, commonPrefix, !config.Links)
+	srckeys, err := listCommonPrefix(lister(src, config), prefix, commonPrefix, !config.Links)
 	if err == utils.ENOTSUP {
 		return startSingleProducer(tasks, src, dst, prefix, config)
 	} else if err != nil {
@@ -1216,7 +1290,7 @@ func startProducer(tasks chan<- object.Object, src, dst object.ObjectStorage, pr
 		close(t)
 		dstkeys = t
 	} else {
-		dstkeys, err = listCommonPrefix(dst, prefix, dcp, !config.Links)
+		dstkeys, err = listCommonPrefix(lister(dst, config), prefix, dcp, !config.Links)
 		if err == utils.ENOTSUP {
 			return startSingleProducer(tasks, src, dst, prefix, config)
 		} else if err != nil {
@@ -1275,7 +1349,7 @@ func Sync(src, dst object.ObjectStorage, config *Config) error {
 	pending = progress.AddCountSpinner("Pending objects")
 	copied = progress.AddCountSpinner("Copied objects")
 	copiedBytes = progress.AddByteSpinner("Copied bytes")
-	if config.CheckAll || config.CheckNew {
+	if config.CheckAll || config.CheckNew || config.CheckChecksum {
 		checked = progress.AddCountSpinner("Checked objects")
 		checkedBytes = progress.AddByteSpinner("Checked bytes")
 	}
@@ -519,6 +519,69 @@ func TestSyncCheckNewLink(t *testing.T) {
 	}
 }
 
+func TestSyncCheckChecksum(t *testing.T) {
+	a, _ := object.CreateStorage("mem", "a", "", "", "")
+	b, _ := object.CreateStorage("mem", "b", "", "", "")
+	_ = a.Put("same", bytes.NewReader([]byte("same")))
+	_ = b.Put("same", bytes.NewReader([]byte("same")))
+	_ = a.Put("changed", bytes.NewReader([]byte("new!")))
+	_ = b.Put("changed", bytes.NewReader([]byte("old!")))
+
+	config := &Config{
+		Threads: 10,
+		Quiet:   true,
+		Limit:   -1,
+		MaxSize: math.MaxInt64,
+	}
+	read := func(key string) string {
+		in, err := b.Get(key, 0, -1)
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+		defer in.Close()
+		d, _ := io.ReadAll(in)
+		return string(d)
+	}
+	if err := Sync(a, b, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if c := read("changed"); c != "old!" {
+		t.Fatalf("object with the same size should be skipped without checking checksum: %s", c)
+	}
+	config.CheckChecksum = true
+	if err := Sync(a, b, config); err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	if c := read("changed"); c != "new!" {
+		t.Fatalf("changed object should be copied: %s", c)
+	}
+	if copied.Current() != 1 || checked.Current() != 2 {
+		t.Fatalf("expect 1 copied and 2 checked, got %d copied and %d checked", copied.Current(), checked.Current())
+	}
+}
+
+func TestObjectMD5(t *testing.T) {
+	m, _ := object.CreateStorage("mem", "", "", "", "")
+	_ = m.Put("k", bytes.NewReader([]byte("hello")))
+	o, _ := m.Head("k")
+	if sum, err := objectMD5(m, "k", o); err != nil || sum != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatalf("md5 of k: %s %v", sum, err)
+	}
+	// an ETag looking like a MD5 may be not the MD5 of the content
+	etag := &etagObj{o, "0123456789abcdef0123456789abcdef"}
+	if sum, err := objectMD5(m, "k", etag); err != nil || sum != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatalf("md5 of k with an ETag: %s %v", sum, err)
+	}
+}
+
+type etagObj struct {
+	object.Object
+	etag string
+}
+
+func (o *etagObj) ETag() string       { return o.etag }
+func (o *etagObj) ContentMD5() string { return "" }
+
 func TestLimits(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll("/tmp/a/")
@@ -775,3 +838,19 @@ func TestFilterSizeAndAge(t *testing.T) {
 		t.Fatalf("filterKey should fail")
 	}
 }
+
+func TestChoosePartSize(t *testing.T) {
+	up := &object.MultipartUpload{MinPartSize: 5 << 20, MaxCount: 10000}
+	for _, c := range []struct {
+		configured, size, expected int64
+	}{
+		{0, 100 << 20, 5 << 20},
+		{64 << 20, 100 << 20, 64 << 20},
+		{1 << 20, 100 << 20, 5 << 20},
+		{64 << 20, 1 << 40, 105 << 20}, // enlarged to fit in 10000 parts
+	} {
+		if n := choosePartSize(object.Limits{PartSize: c.configured}, up, c.size); n != c.expected {
+			t.Fatalf("part size of %d with %d configured: %d, expect %d", c.size, c.configured, n, c.expected)
+		}
+	}
+}